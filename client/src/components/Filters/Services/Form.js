@@ -6,7 +6,7 @@ import flow from 'lodash/flow';
 
 import { toggleAllServices } from '../../../helpers/helpers';
 import { renderServiceField } from '../../../helpers/form';
-import { FORM_NAME, SERVICES } from '../../../helpers/constants';
+import { FORM_NAME } from '../../../helpers/constants';
 
 const Form = (props) => {
     const {
@@ -16,6 +16,7 @@ const Form = (props) => {
         submitting,
         processing,
         processingSet,
+        services,
     } = props;
 
     return (
@@ -27,7 +28,7 @@ const Form = (props) => {
                             type="button"
                             className="btn btn-secondary btn-block"
                             disabled={processing || processingSet}
-                            onClick={() => toggleAllServices(SERVICES, change, true)}
+                            onClick={() => toggleAllServices(services, change, true)}
                         >
                             <Trans>block_all</Trans>
                         </button>
@@ -37,17 +38,18 @@ const Form = (props) => {
                             type="button"
                             className="btn btn-secondary btn-block"
                             disabled={processing || processingSet}
-                            onClick={() => toggleAllServices(SERVICES, change, false)}
+                            onClick={() => toggleAllServices(services, change, false)}
                         >
                             <Trans>unblock_all</Trans>
                         </button>
                     </div>
                 </div>
                 <div className="services">
-                    {SERVICES.map((service) => (
+                    {services.map((service) => (
                         <Field
                             key={service.id}
                             icon={`service_${service.id}`}
+                            iconSvg={service.icon_svg}
                             name={`blocked_services.${service.id}`}
                             type="checkbox"
                             component={renderServiceField}
@@ -78,6 +80,7 @@ Form.propTypes = {
     submitting: PropTypes.bool.isRequired,
     processing: PropTypes.bool.isRequired,
     processingSet: PropTypes.bool.isRequired,
+    services: PropTypes.array.isRequired,
     t: PropTypes.func.isRequired,
 };
 
@@ -4,7 +4,11 @@ import { useTranslation } from 'react-i18next';
 import { useDispatch, useSelector } from 'react-redux';
 import Form from './Form';
 import Card from '../../ui/Card';
-import { getBlockedServices, setBlockedServices } from '../../../actions/services';
+import {
+    getAllBlockedServices,
+    getBlockedServices,
+    setBlockedServices,
+} from '../../../actions/services';
 import PageTitle from '../../ui/PageTitle';
 
 const getInitialDataForServices = (initial) => (initial ? initial.reduce(
@@ -20,6 +24,7 @@ const Services = () => {
     const services = useSelector((store) => store?.services);
 
     useEffect(() => {
+        dispatch(getAllBlockedServices());
         dispatch(getBlockedServices());
     }, []);
 
@@ -49,7 +54,8 @@ const Services = () => {
                 <div className="form">
                     <Form
                         initialValues={initialValues}
-                        processing={services.processing}
+                        processing={services.processing || services.processingAll}
+                        services={services.allServices}
                         processingSet={services.processingSet}
                         onSubmit={handleSubmit}
                     />
@@ -19,7 +19,7 @@ import {
     renderServiceField,
 } from '../../../helpers/form';
 import { validateClientId, validateRequiredValue } from '../../../helpers/validators';
-import { FORM_NAME, SERVICES } from '../../../helpers/constants';
+import { FORM_NAME } from '../../../helpers/constants';
 import './Service.css';
 
 const settingsCheckboxes = [
@@ -138,6 +138,7 @@ let Form = (props) => {
         processingUpdating,
         invalid,
         tagsOptions,
+        services,
     } = props;
 
     const [activeTabLabel, setActiveTabLabel] = useState('settings');
@@ -180,7 +181,7 @@ let Form = (props) => {
                                 type="button"
                                 className="btn btn-secondary btn-block"
                                 disabled={useGlobalServices}
-                                onClick={() => toggleAllServices(SERVICES, change, true)}
+                                onClick={() => toggleAllServices(services, change, true)}
                             >
                                 <Trans>block_all</Trans>
                             </button>
@@ -190,17 +191,18 @@ let Form = (props) => {
                                 type="button"
                                 className="btn btn-secondary btn-block"
                                 disabled={useGlobalServices}
-                                onClick={() => toggleAllServices(SERVICES, change, false)}
+                                onClick={() => toggleAllServices(services, change, false)}
                             >
                                 <Trans>unblock_all</Trans>
                             </button>
                         </div>
                     </div>
                     <div className="services">
-                        {SERVICES.map((service) => (
+                        {services.map((service) => (
                             <Field
                                 key={service.id}
                                 icon={`service_${service.id}`}
+                                iconSvg={service.icon_svg}
                                 name={`blocked_services.${service.id}`}
                                 type="checkbox"
                                 component={renderServiceField}
@@ -352,6 +354,7 @@ Form.propTypes = {
     processingUpdating: PropTypes.bool.isRequired,
     invalid: PropTypes.bool.isRequired,
     tagsOptions: PropTypes.array.isRequired,
+    services: PropTypes.array.isRequired,
 };
 
 const selector = formValueSelector(FORM_NAME.CLIENT);
@@ -362,6 +365,7 @@ Form = connect((state) => {
     return {
         useGlobalSettings,
         useGlobalServices,
+        services: state.services.allServices,
     };
 })(Form);
 
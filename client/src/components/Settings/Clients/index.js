@@ -11,6 +11,7 @@ class Clients extends Component {
     componentDidMount() {
         this.props.getClients();
         this.props.getStats();
+        this.props.getAllBlockedServices();
     }
 
     render() {
@@ -70,6 +71,7 @@ Clients.propTypes = {
     updateClient: PropTypes.func.isRequired,
     getClients: PropTypes.func.isRequired,
     getStats: PropTypes.func.isRequired,
+    getAllBlockedServices: PropTypes.func.isRequired,
 };
 
 export default withTranslation()(Clients);
@@ -1,6 +1,7 @@
 import { connect } from 'react-redux';
 import { getClients } from '../actions';
 import { getStats } from '../actions/stats';
+import { getAllBlockedServices } from '../actions/services';
 import {
     addClient, updateClient, deleteClient, toggleClientModal,
 } from '../actions/clients';
@@ -19,6 +20,7 @@ const mapStateToProps = (state) => {
 const mapDispatchToProps = {
     getClients,
     getStats,
+    getAllBlockedServices,
     addClient,
     updateClient,
     deleteClient,
@@ -401,6 +401,8 @@ export const FILTERED_STATUS = {
     FILTERED_SAFE_SEARCH: 'FilteredSafeSearch',
     FILTERED_SAFE_BROWSING: 'FilteredSafeBrowsing',
     FILTERED_PARENTAL: 'FilteredParental',
+    FILTERED_THREAT_FEED: 'FilteredThreatFeed',
+    FILTERED_ANSWER_POLICY: 'FilteredAnswerPolicy',
 };
 
 export const RESPONSE_FILTER = {
@@ -535,6 +537,8 @@ export const SPECIAL_FILTER_ID = {
     PARENTAL: -3,
     SAFE_BROWSING: -4,
     SAFE_SEARCH: -5,
+    THREAT_FEED: -6,
+    ANSWER_POLICY: -7,
 };
 
 export const BLOCK_ACTIONS = {
@@ -228,6 +228,7 @@ export const renderServiceField = ({
     disabled,
     modifier,
     icon,
+    iconSvg,
     meta: { touched, error },
 }) => <Fragment>
     <label className={`service custom-switch ${modifier}`}>
@@ -240,9 +241,15 @@ export const renderServiceField = ({
         />
         <span className="service__switch custom-switch-indicator"></span>
         <span className="service__text">{placeholder}</span>
-        <svg className="service__icon">
-            <use xlinkHref={`#${icon}`} />
-        </svg>
+        {iconSvg
+            ? <img
+                className="service__icon"
+                src={`data:image/svg+xml;charset=utf-8,${encodeURIComponent(iconSvg)}`}
+                alt=""
+            />
+            : <svg className="service__icon">
+                <use xlinkHref={`#${icon}`} />
+            </svg>}
     </label>
     {!disabled && touched && error
     && <span className="form__message form__message--error"><Trans>{error}</Trans></span>}
@@ -254,6 +261,7 @@ renderServiceField.propTypes = {
     disabled: PropTypes.bool,
     modifier: PropTypes.string,
     icon: PropTypes.string,
+    iconSvg: PropTypes.string,
     meta: PropTypes.shape({
         touched: PropTypes.bool,
         error: PropTypes.string,
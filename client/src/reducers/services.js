@@ -4,6 +4,14 @@ import * as actions from '../actions/services';
 
 const services = handleActions(
     {
+        [actions.getAllBlockedServicesRequest]: (state) => ({ ...state, processingAll: true }),
+        [actions.getAllBlockedServicesFailure]: (state) => ({ ...state, processingAll: false }),
+        [actions.getAllBlockedServicesSuccess]: (state, { payload }) => ({
+            ...state,
+            allServices: payload.blocked_services,
+            processingAll: false,
+        }),
+
         [actions.getBlockedServicesRequest]: (state) => ({ ...state, processing: true }),
         [actions.getBlockedServicesFailure]: (state) => ({ ...state, processing: false }),
         [actions.getBlockedServicesSuccess]: (state, { payload }) => ({
@@ -21,8 +29,10 @@ const services = handleActions(
     },
     {
         processing: true,
+        processingAll: true,
         processingSet: false,
         list: [],
+        allServices: [],
     },
 );
 
@@ -1,6 +1,42 @@
 package aghnet
 
+import "net"
+
 // CheckOtherDHCP tries to discover another DHCP server in the network.
 func CheckOtherDHCP(ifaceName string) (ok4, ok6 bool, err4, err6 error) {
 	return checkOtherDHCP(ifaceName)
 }
+
+// DHCPOffer is the information about a DHCP server taken from its reply to
+// the discovery message.
+type DHCPOffer struct {
+	// ServerID identifies the server.  It's the address from the server
+	// identifier option for DHCPv4 and the hexadecimal DUID for DHCPv6.
+	ServerID string
+
+	// SrcIP is the source address of the reply.
+	SrcIP net.IP
+
+	// HWAddr is the hardware address of the server.  It's nil if it
+	// couldn't be determined.
+	HWAddr net.HardwareAddr
+
+	// OfferedIP is the address offered to the client.  It's nil if the
+	// server hasn't offered any.
+	OfferedIP net.IP
+
+	// Subnet is the network of the offered address.  It's nil for DHCPv6
+	// and for the DHCPv4 replies without the subnet mask option.
+	Subnet *net.IPNet
+
+	// Router is the first router offered to the client.  It's nil for
+	// DHCPv6.
+	Router net.IP
+}
+
+// FindOtherDHCP discovers the DHCP servers in the network of the interface
+// named ifaceName and returns the offers received from all of them.  Unlike
+// CheckOtherDHCP, it waits for the replies during the whole discovery time.
+func FindOtherDHCP(ifaceName string) (offers4, offers6 []*DHCPOffer, err4, err6 error) {
+	return findOtherDHCP(ifaceName)
+}
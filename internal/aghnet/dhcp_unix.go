@@ -5,6 +5,7 @@ package aghnet
 
 import (
 	"bytes"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"os"
@@ -13,10 +14,14 @@ import (
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
 	"github.com/insomniacslk/dhcp/iana"
+	"github.com/mdlayher/ethernet"
+	"github.com/mdlayher/raw"
 )
 
 // defaultDiscoverTime is the
@@ -31,10 +36,26 @@ func checkOtherDHCP(ifaceName string) (ok4, ok6 bool, err4, err6 error) {
 		return false, false, err4, err6
 	}
 
-	ok4, err4 = checkOtherDHCPv4(iface)
-	ok6, err6 = checkOtherDHCPv6(iface)
+	var offers4, offers6 []*DHCPOffer
+	offers4, err4 = checkOtherDHCPv4(iface, false)
+	offers6, err6 = checkOtherDHCPv6(iface, false)
 
-	return ok4, ok6, err4, err6
+	return len(offers4) > 0, len(offers6) > 0, err4, err6
+}
+
+func findOtherDHCP(ifaceName string) (offers4, offers6 []*DHCPOffer, err4, err6 error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		err = fmt.Errorf("couldn't find interface by name %s: %w", ifaceName, err)
+		err4, err6 = err, err
+
+		return nil, nil, err4, err6
+	}
+
+	offers4, err4 = checkOtherDHCPv4(iface, true)
+	offers6, err6 = checkOtherDHCPv6(iface, true)
+
+	return offers4, offers6, err4, err6
 }
 
 // ifaceIPv4Subnet returns the first suitable IPv4 subnetwork iface has.
@@ -68,11 +89,12 @@ func ifaceIPv4Subnet(iface *net.Interface) (subnet *net.IPNet, err error) {
 }
 
 // checkOtherDHCPv4 sends a DHCP request to the specified network interface, and
-// waits for a response for a period defined by defaultDiscoverTime.
-func checkOtherDHCPv4(iface *net.Interface) (ok bool, err error) {
+// waits for a response for a period defined by defaultDiscoverTime.  If all is
+// true, it collects the responses from all the servers during the period.
+func checkOtherDHCPv4(iface *net.Interface, all bool) (offers []*DHCPOffer, err error) {
 	var subnet *net.IPNet
 	if subnet, err = ifaceIPv4Subnet(iface); err != nil {
-		return false, err
+		return nil, err
 	}
 
 	// Resolve broadcast addr.
@@ -82,21 +104,26 @@ func checkOtherDHCPv4(iface *net.Interface) (ok bool, err error) {
 	}.String()
 	var dstAddr *net.UDPAddr
 	if dstAddr, err = net.ResolveUDPAddr("udp4", dst); err != nil {
-		return false, fmt.Errorf("couldn't resolve UDP address %s: %w", dst, err)
+		return nil, fmt.Errorf("couldn't resolve UDP address %s: %w", dst, err)
 	}
 
 	var hostname string
 	if hostname, err = os.Hostname(); err != nil {
-		return false, fmt.Errorf("couldn't get hostname: %w", err)
+		return nil, fmt.Errorf("couldn't get hostname: %w", err)
 	}
 
-	return discover4(iface, dstAddr, hostname)
+	return discover4(iface, dstAddr, hostname, all)
 }
 
-func discover4(iface *net.Interface, dstAddr *net.UDPAddr, hostname string) (ok bool, err error) {
+func discover4(
+	iface *net.Interface,
+	dstAddr *net.UDPAddr,
+	hostname string,
+	all bool,
+) (offers []*DHCPOffer, err error) {
 	var req *dhcpv4.DHCPv4
 	if req, err = dhcpv4.NewDiscovery(iface.HardwareAddr); err != nil {
-		return false, fmt.Errorf("dhcpv4.NewDiscovery: %w", err)
+		return nil, fmt.Errorf("dhcpv4.NewDiscovery: %w", err)
 	}
 
 	req.Options.Update(dhcpv4.OptClientIdentifier(iface.HardwareAddr))
@@ -113,22 +140,39 @@ func discover4(iface *net.Interface, dstAddr *net.UDPAddr, hostname string) (ok
 	// ignores broadcasted packets when reading.
 	var c net.PacketConn
 	if c, err = listenPacketReusable(iface.Name, "udp4", ":68"); err != nil {
-		return false, fmt.Errorf("couldn't listen on :68: %w", err)
+		return nil, fmt.Errorf("couldn't listen on :68: %w", err)
 	}
 	defer func() { err = errors.WithDeferred(err, c.Close()) }()
 
+	// The replies are read from the raw connection when collecting them from
+	// all the servers, since it's the only way to learn the servers'
+	// hardware addresses.
+	rc, isRaw := c, false
+	if all {
+		var rawConn net.PacketConn
+		rawConn, err = raw.ListenPacket(iface, uint16(ethernet.EtherTypeIPv4), nil)
+		if err != nil {
+			log.Debug("dhcpv4: creating raw connection: %s; hardware addresses are unknown", err)
+		} else {
+			defer func() { err = errors.WithDeferred(err, rawConn.Close()) }()
+
+			rc, isRaw = rawConn, true
+		}
+	}
+
 	// Send to broadcast.
 	if _, err = c.WriteTo(req.ToBytes(), dstAddr); err != nil {
-		return false, fmt.Errorf("couldn't send a packet to %s: %w", dstAddr, err)
+		return nil, fmt.Errorf("couldn't send a packet to %s: %w", dstAddr, err)
 	}
 
-	for {
-		if err = c.SetDeadline(time.Now().Add(defaultDiscoverTime)); err != nil {
-			return false, fmt.Errorf("setting deadline: %w", err)
-		}
+	if err = rc.SetDeadline(time.Now().Add(defaultDiscoverTime)); err != nil {
+		return nil, fmt.Errorf("setting deadline: %w", err)
+	}
 
+	for {
+		var o *DHCPOffer
 		var next bool
-		ok, next, err = tryConn4(req, c, iface)
+		o, next, err = tryConn4(req, rc, iface, isRaw)
 		if next {
 			if err != nil {
 				log.Debug("dhcpv4: trying a connection: %s", err)
@@ -138,39 +182,111 @@ func discover4(iface *net.Interface, dstAddr *net.UDPAddr, hostname string) (ok
 		}
 
 		if err != nil {
-			return false, err
+			return nil, err
+		} else if o == nil {
+			return offers, nil
 		}
 
-		return ok, nil
+		offers = append(offers, o)
+		if !all {
+			return offers, nil
+		}
 	}
 }
 
+// parseFrame4 returns the payload of the DHCPv4 reply carried by the Ethernet
+// frame as well as its source addresses.  ok is false if frame doesn't contain
+// a UDP datagram sent to the DHCPv4 client port.
+func parseFrame4(frame []byte) (payload []byte, srcIP net.IP, srcMAC net.HardwareAddr, ok bool) {
+	pkt := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.DecodeOptions{
+		Lazy:   true,
+		NoCopy: true,
+	})
+
+	eth, _ := pkt.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	ip, _ := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	udp, _ := pkt.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if eth == nil || ip == nil || udp == nil || udp.DstPort != dhcpv4.ClientPort {
+		return nil, nil, nil, false
+	}
+
+	return udp.Payload, ip.SrcIP, eth.SrcMAC, true
+}
+
+// newOffer4 returns the offer made by the DHCPv4 server in resp.
+func newOffer4(resp *dhcpv4.DHCPv4, srcIP net.IP, srcMAC net.HardwareAddr) (o *DHCPOffer) {
+	o = &DHCPOffer{
+		SrcIP:  srcIP,
+		HWAddr: srcMAC,
+	}
+
+	if sid := resp.ServerIdentifier(); sid != nil {
+		o.ServerID = sid.String()
+	} else if srcIP != nil {
+		o.ServerID = srcIP.String()
+	}
+
+	if yiaddr := resp.YourIPAddr; yiaddr != nil && !yiaddr.IsUnspecified() {
+		o.OfferedIP = yiaddr
+		if mask := resp.SubnetMask(); mask != nil {
+			o.Subnet = &net.IPNet{
+				IP:   yiaddr.Mask(mask),
+				Mask: mask,
+			}
+		}
+	}
+
+	if routers := resp.Router(); len(routers) > 0 {
+		o.Router = routers[0]
+	}
+
+	return o
+}
+
 // TODO(a.garipov): Refactor further.  Inspect error handling, remove parameter
 // next, address the TODO, merge with tryConn6, etc.
-func tryConn4(req *dhcpv4.DHCPv4, c net.PacketConn, iface *net.Interface) (ok, next bool, err error) {
+func tryConn4(
+	req *dhcpv4.DHCPv4,
+	c net.PacketConn,
+	iface *net.Interface,
+	isRaw bool,
+) (o *DHCPOffer, next bool, err error) {
 	// TODO: replicate dhclient's behavior of retrying several times with
 	// progressively longer timeouts.
 	log.Tracef("dhcpv4: waiting %v for an answer", defaultDiscoverTime)
 
 	b := make([]byte, 1500)
-	n, _, err := c.ReadFrom(b)
+	n, addr, err := c.ReadFrom(b)
 	if err != nil {
 		if isTimeout(err) {
 			log.Debug("dhcpv4: didn't receive dhcp response")
 
-			return false, false, nil
+			return nil, false, nil
 		}
 
-		return false, false, fmt.Errorf("receiving packet: %w", err)
+		return nil, false, fmt.Errorf("receiving packet: %w", err)
 	}
 
-	log.Tracef("dhcpv4: received packet, %d bytes", n)
+	payload := b[:n]
+	var srcIP net.IP
+	var srcMAC net.HardwareAddr
+	if isRaw {
+		var ok bool
+		payload, srcIP, srcMAC, ok = parseFrame4(payload)
+		if !ok {
+			return nil, true, nil
+		}
+	} else if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		srcIP = udpAddr.IP
+	}
 
-	response, err := dhcpv4.FromBytes(b[:n])
+	log.Tracef("dhcpv4: received packet, %d bytes", len(payload))
+
+	response, err := dhcpv4.FromBytes(payload)
 	if err != nil {
 		log.Debug("dhcpv4: encoding: %s", err)
 
-		return false, true, err
+		return nil, true, err
 	}
 
 	log.Debug("dhcpv4: received message from server: %s", response.Summary())
@@ -183,23 +299,24 @@ func tryConn4(req *dhcpv4.DHCPv4, c net.PacketConn, iface *net.Interface) (ok, n
 
 		log.Debug("dhcpv4: received message from server doesn't match our request")
 
-		return false, true, nil
+		return nil, true, nil
 	}
 
 	log.Tracef("dhcpv4: the packet is from an active dhcp server")
 
-	return true, false, nil
+	return newOffer4(response, srcIP, srcMAC), false, nil
 }
 
 // checkOtherDHCPv6 sends a DHCP request to the specified network interface, and
-// waits for a response for a period defined by defaultDiscoverTime.
-func checkOtherDHCPv6(iface *net.Interface) (ok bool, err error) {
+// waits for a response for a period defined by defaultDiscoverTime.  If all is
+// true, it collects the responses from all the servers during the period.
+func checkOtherDHCPv6(iface *net.Interface, all bool) (offers []*DHCPOffer, err error) {
 	ifaceIPNet, err := IfaceIPAddrs(iface, IPVersion6)
 	if err != nil {
-		return false, fmt.Errorf("getting ipv6 addrs for iface %s: %w", iface.Name, err)
+		return nil, fmt.Errorf("getting ipv6 addrs for iface %s: %w", iface.Name, err)
 	}
 	if len(ifaceIPNet) == 0 {
-		return false, fmt.Errorf("interface %s has no ipv6 addresses", iface.Name)
+		return nil, fmt.Errorf("interface %s has no ipv6 addresses", iface.Name)
 	}
 
 	srcIP := ifaceIPNet[0]
@@ -208,42 +325,53 @@ func checkOtherDHCPv6(iface *net.Interface) (ok bool, err error) {
 
 	udpAddr, err := net.ResolveUDPAddr("udp6", src)
 	if err != nil {
-		return false, fmt.Errorf("dhcpv6: Couldn't resolve UDP address %s: %w", src, err)
+		return nil, fmt.Errorf("dhcpv6: Couldn't resolve UDP address %s: %w", src, err)
 	}
 
 	if !udpAddr.IP.To16().Equal(srcIP) {
-		return false, fmt.Errorf("dhcpv6: Resolved UDP address is not %s: %w", src, err)
+		return nil, fmt.Errorf("dhcpv6: Resolved UDP address is not %s: %w", src, err)
 	}
 
 	dstAddr, err := net.ResolveUDPAddr("udp6", dst)
 	if err != nil {
-		return false, fmt.Errorf("dhcpv6: Couldn't resolve UDP address %s: %w", dst, err)
+		return nil, fmt.Errorf("dhcpv6: Couldn't resolve UDP address %s: %w", dst, err)
 	}
 
-	return discover6(iface, udpAddr, dstAddr)
+	return discover6(iface, udpAddr, dstAddr, all)
 }
 
-func discover6(iface *net.Interface, udpAddr, dstAddr *net.UDPAddr) (ok bool, err error) {
+func discover6(
+	iface *net.Interface,
+	udpAddr *net.UDPAddr,
+	dstAddr *net.UDPAddr,
+	all bool,
+) (offers []*DHCPOffer, err error) {
 	req, err := dhcpv6.NewSolicit(iface.HardwareAddr)
 	if err != nil {
-		return false, fmt.Errorf("dhcpv6: dhcpv6.NewSolicit: %w", err)
+		return nil, fmt.Errorf("dhcpv6: dhcpv6.NewSolicit: %w", err)
 	}
 
 	log.Debug("DHCPv6: Listening to udp6 %+v", udpAddr)
 	c, err := nclient6.NewIPv6UDPConn(iface.Name, dhcpv6.DefaultClientPort)
 	if err != nil {
-		return false, fmt.Errorf("dhcpv6: Couldn't listen on :546: %w", err)
+		return nil, fmt.Errorf("dhcpv6: Couldn't listen on :546: %w", err)
 	}
 	defer func() { err = errors.WithDeferred(err, c.Close()) }()
 
 	_, err = c.WriteTo(req.ToBytes(), dstAddr)
 	if err != nil {
-		return false, fmt.Errorf("dhcpv6: Couldn't send a packet to %s: %w", dstAddr, err)
+		return nil, fmt.Errorf("dhcpv6: Couldn't send a packet to %s: %w", dstAddr, err)
+	}
+
+	err = c.SetDeadline(time.Now().Add(defaultDiscoverTime))
+	if err != nil {
+		return nil, fmt.Errorf("setting deadline: %w", err)
 	}
 
 	for {
+		var o *DHCPOffer
 		var next bool
-		ok, next, err = tryConn6(req, c)
+		o, next, err = tryConn6(req, c)
 		if next {
 			if err != nil {
 				log.Debug("dhcpv6: trying a connection: %s", err)
@@ -253,34 +381,58 @@ func discover6(iface *net.Interface, udpAddr, dstAddr *net.UDPAddr) (ok bool, er
 		}
 
 		if err != nil {
-			return false, err
+			return nil, err
+		} else if o == nil {
+			return offers, nil
+		}
+
+		offers = append(offers, o)
+		if !all {
+			return offers, nil
 		}
+	}
+}
+
+// newOffer6 returns the offer made by the DHCPv6 server in msg.
+func newOffer6(msg *dhcpv6.Message, srcIP net.IP) (o *DHCPOffer) {
+	o = &DHCPOffer{
+		SrcIP: srcIP,
+	}
 
-		return ok, nil
+	if duid := msg.Options.ServerID(); duid != nil {
+		o.ServerID = hex.EncodeToString(duid.ToBytes())
+		if duid.Type == dhcpv6.DUID_LL || duid.Type == dhcpv6.DUID_LLT {
+			o.HWAddr = duid.LinkLayerAddr
+		}
+	} else if srcIP != nil {
+		o.ServerID = srcIP.String()
+	}
+
+	if ia := msg.Options.OneIANA(); ia != nil {
+		if addr := ia.Options.OneAddress(); addr != nil {
+			o.OfferedIP = addr.IPv6Addr
+		}
 	}
+
+	return o
 }
 
 // TODO(a.garipov): See the comment on tryConn4.  Sigh…
-func tryConn6(req *dhcpv6.Message, c net.PacketConn) (ok, next bool, err error) {
+func tryConn6(req *dhcpv6.Message, c net.PacketConn) (o *DHCPOffer, next bool, err error) {
 	// TODO: replicate dhclient's behavior of retrying several times with
 	// progressively longer timeouts.
 	log.Tracef("dhcpv6: waiting %v for an answer", defaultDiscoverTime)
 
 	b := make([]byte, 4096)
-	err = c.SetDeadline(time.Now().Add(defaultDiscoverTime))
-	if err != nil {
-		return false, false, fmt.Errorf("setting deadline: %w", err)
-	}
-
-	n, _, err := c.ReadFrom(b)
+	n, addr, err := c.ReadFrom(b)
 	if err != nil {
 		if isTimeout(err) {
 			log.Debug("dhcpv6: didn't receive dhcp response")
 
-			return false, false, nil
+			return nil, false, nil
 		}
 
-		return false, false, fmt.Errorf("receiving packet: %w", err)
+		return nil, false, fmt.Errorf("receiving packet: %w", err)
 	}
 
 	log.Tracef("dhcpv6: received packet, %d bytes", n)
@@ -289,7 +441,7 @@ func tryConn6(req *dhcpv6.Message, c net.PacketConn) (ok, next bool, err error)
 	if err != nil {
 		log.Debug("dhcpv6: encoding: %s", err)
 
-		return false, true, err
+		return nil, true, err
 	}
 
 	log.Debug("dhcpv6: received message from server: %s", response.Summary())
@@ -299,7 +451,7 @@ func tryConn6(req *dhcpv6.Message, c net.PacketConn) (ok, next bool, err error)
 	if err != nil {
 		log.Debug("dhcpv6: resp.GetInnerMessage(): %s", err)
 
-		return false, true, err
+		return nil, true, err
 	}
 
 	rcid := msg.Options.ClientID()
@@ -310,12 +462,17 @@ func tryConn6(req *dhcpv6.Message, c net.PacketConn) (ok, next bool, err error)
 
 		log.Debug("dhcpv6: received message from server doesn't match our request")
 
-		return false, true, nil
+		return nil, true, nil
 	}
 
 	log.Tracef("dhcpv6: the packet is from an active dhcp server")
 
-	return true, false, nil
+	var srcIP net.IP
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		srcIP = udpAddr.IP
+	}
+
+	return newOffer6(msg, srcIP), false, nil
 }
 
 // isTimeout returns true if err is an operation timeout error from net package.
@@ -0,0 +1,87 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package aghnet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFrame4(t *testing.T) {
+	srvMAC := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	srvIP := net.IP{192, 168, 10, 1}
+
+	resp, err := dhcpv4.New(
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+		dhcpv4.WithYourIP(net.IP{192, 168, 10, 100}),
+		dhcpv4.WithServerIP(srvIP),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(srvIP)),
+		dhcpv4.WithOption(dhcpv4.OptSubnetMask(net.CIDRMask(24, 32))),
+		dhcpv4.WithOption(dhcpv4.OptRouter(srvIP)),
+	)
+	require.NoError(t, err)
+
+	newFrame := func(dstPort layers.UDPPort) (frame []byte) {
+		eth := &layers.Ethernet{
+			SrcMAC:       srvMAC,
+			DstMAC:       layers.EthernetBroadcast,
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ip := &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			SrcIP:    srvIP,
+			DstIP:    net.IPv4bcast,
+			Protocol: layers.IPProtocolUDP,
+		}
+		udp := &layers.UDP{
+			SrcPort: dhcpv4.ServerPort,
+			DstPort: dstPort,
+		}
+		require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+
+		buf := gopacket.NewSerializeBuffer()
+		err = gopacket.SerializeLayers(
+			buf,
+			gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+			eth,
+			ip,
+			udp,
+			gopacket.Payload(resp.ToBytes()),
+		)
+		require.NoError(t, err)
+
+		return buf.Bytes()
+	}
+
+	t.Run("reply", func(t *testing.T) {
+		payload, srcIP, srcMAC, ok := parseFrame4(newFrame(dhcpv4.ClientPort))
+		require.True(t, ok)
+
+		assert.Equal(t, resp.ToBytes(), payload)
+		assert.Equal(t, srvIP, srcIP.To4())
+		assert.Equal(t, srvMAC, srcMAC)
+
+		var got *dhcpv4.DHCPv4
+		got, err = dhcpv4.FromBytes(payload)
+		require.NoError(t, err)
+
+		o := newOffer4(got, srcIP, srcMAC)
+		assert.Equal(t, "192.168.10.1", o.ServerID)
+		assert.Equal(t, "192.168.10.0/24", o.Subnet.String())
+		assert.Equal(t, net.IP{192, 168, 10, 100}, o.OfferedIP.To4())
+		assert.Equal(t, srvIP, o.Router.To4())
+	})
+
+	t.Run("not_reply", func(t *testing.T) {
+		_, _, _, ok := parseFrame4(newFrame(dhcpv4.ServerPort))
+		assert.False(t, ok)
+	})
+}
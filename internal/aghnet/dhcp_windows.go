@@ -11,3 +11,10 @@ func checkOtherDHCP(ifaceName string) (ok4, ok6 bool, err4, err6 error) {
 		aghos.Unsupported("CheckIfOtherDHCPServersPresentV4"),
 		aghos.Unsupported("CheckIfOtherDHCPServersPresentV6")
 }
+
+func findOtherDHCP(_ string) (offers4, offers6 []*DHCPOffer, err4, err6 error) {
+	return nil,
+		nil,
+		aghos.Unsupported("FindOtherDHCPServersV4"),
+		aghos.Unsupported("FindOtherDHCPServersV6")
+}
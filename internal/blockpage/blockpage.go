@@ -0,0 +1,338 @@
+// Package blockpage implements the HTTP and HTTPS server showing the block page
+// for the blocked domain names.
+package blockpage
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/cache"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+const (
+	// recentBlocksSize is the maximum number of the recently blocked
+	// requests remembered by the server.
+	recentBlocksSize = 4096
+
+	// recentBlockTTL is the duration during which the block of a request is
+	// remembered.
+	recentBlockTTL = 1 * time.Hour
+
+	// readTimeout is the timeout for reading the HTTP requests.
+	readTimeout = 10 * time.Second
+)
+
+// Config is the configuration of the block page server.
+type Config struct {
+	// ClientName returns the name of the client with the IP address, if it's
+	// known.
+	ClientName func(ip net.IP) (name string) `yaml:"-"`
+
+	// FilterListName returns the name of the filter list with the ID, if
+	// there is one.
+	FilterListName func(id int64) (name string) `yaml:"-"`
+
+	// OnApprove is called when an administrator approves an unblock request
+	// for host.
+	OnApprove func(host string) (err error) `yaml:"-"`
+
+	// HTTPRegister registers an HTTP handler of the API.
+	HTTPRegister func(method, url string, handler func(http.ResponseWriter, *http.Request)) `yaml:"-"`
+
+	// DataDir is the directory where the certificate authority and the
+	// unblock requests are stored.
+	DataDir string `yaml:"-"`
+
+	// Enabled defines if the blocked requests are answered with the address
+	// of the block page server.
+	Enabled bool `yaml:"enabled"`
+
+	// BindHost is the IPv4 address the server listens on.  The blocked A
+	// requests are answered with it.
+	BindHost net.IP `yaml:"bind_host"`
+
+	// BindHostIPv6 is the IPv6 address the server listens on.  The blocked
+	// AAAA requests are answered with it.  If it's nil, those are answered
+	// with no addresses, so that the clients use IPv4.
+	BindHostIPv6 net.IP `yaml:"bind_host_ipv6"`
+
+	// PortHTTP is the port of the HTTP server.
+	PortHTTP int `yaml:"port_http"`
+
+	// PortHTTPS is the port of the HTTPS server.  The certificates are issued
+	// on the fly by the local certificate authority, which must be trusted
+	// by the clients.  If it's zero, HTTPS is disabled.
+	PortHTTPS int `yaml:"port_https"`
+
+	// UnblockRequests defines if the users may request unblocking of the
+	// domain names from the block page.
+	UnblockRequests bool `yaml:"unblock_requests"`
+}
+
+// validate returns an error if the configuration is invalid.
+func (c *Config) validate() (err error) {
+	switch {
+	case c.BindHost == nil || c.BindHost.To4() == nil || c.BindHost.IsUnspecified():
+		return fmt.Errorf("bind_host: %q is not a specific ipv4 address", c.BindHost)
+	case c.BindHostIPv6 != nil && (c.BindHostIPv6.To4() != nil || c.BindHostIPv6.IsUnspecified()):
+		return fmt.Errorf("bind_host_ipv6: %q is not a specific ipv6 address", c.BindHostIPv6)
+	case c.PortHTTP <= 0 || c.PortHTTP > 0xffff:
+		return fmt.Errorf("port_http: bad port %d", c.PortHTTP)
+	case c.PortHTTPS < 0 || c.PortHTTPS > 0xffff:
+		return fmt.Errorf("port_https: bad port %d", c.PortHTTPS)
+	default:
+		return nil
+	}
+}
+
+// blockInfo is the information about a blocked request shown on the block
+// page.
+type blockInfo struct {
+	// Time is the time of the block.
+	Time time.Time `json:"time"`
+
+	// Reason is the filtering reason.
+	Reason string `json:"reason"`
+
+	// Name is the name of the blocked service, the threat feed, or the
+	// answer policy, if any.
+	Name string `json:"name,omitempty"`
+
+	// Rule is the text of the rule which has blocked the request, if any.
+	Rule string `json:"rule,omitempty"`
+
+	// FilterListID is the ID of the filter list of Rule.
+	FilterListID int64 `json:"filter_list_id"`
+}
+
+// Server is the block page server.
+type Server struct {
+	conf *Config
+
+	// ca is the certificate authority issuing the certificates for the
+	// HTTPS server.  It's nil if HTTPS is disabled.
+	ca *authority
+
+	// recent are the recently blocked requests.  The keys are the client IP
+	// addresses and the hosts, see recentKey.  The values are the JSON
+	// encoded blockInfo.
+	recent cache.Cache
+
+	// reqsLock protects reqs.
+	reqsLock sync.Mutex
+
+	// reqs are the pending unblock requests.
+	reqs []*UnblockRequest
+
+	// srvs are the running HTTP servers.
+	srvs []*http.Server
+}
+
+// New returns a new properly initialized block page server.  conf must not be
+// nil and must not be modified after calling New.
+func New(conf *Config) (s *Server, err error) {
+	err = conf.validate()
+	if err != nil {
+		return nil, fmt.Errorf("block page: %w", err)
+	}
+
+	s = &Server{
+		conf: conf,
+		recent: cache.New(cache.Config{
+			EnableLRU: true,
+			MaxCount:  recentBlocksSize,
+		}),
+	}
+
+	if conf.PortHTTPS != 0 {
+		s.ca, err = loadAuthority(conf.DataDir, conf.BindHost.String())
+		if err != nil {
+			return nil, fmt.Errorf("block page: certificate authority: %w", err)
+		}
+
+		s.ca.allowed = func(name string) (ok bool) { return s.lookup(nil, name) != nil }
+	}
+
+	s.reqs, err = readUnblockRequests(conf.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("block page: unblock requests: %w", err)
+	}
+
+	s.registerHandlers()
+
+	return s, nil
+}
+
+// IPs returns the addresses the blocked requests are answered with.  ipv6 is
+// nil if the server doesn't listen on IPv6.
+func (s *Server) IPs() (ipv4, ipv6 net.IP) {
+	return s.conf.BindHost, s.conf.BindHostIPv6
+}
+
+// recentKey returns the key of the recent block of host for the client with
+// ip.  ip may be nil, in which case the key only identifies the host.
+func recentKey(ip net.IP, host string) (key []byte) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip == nil {
+		return []byte("|" + host)
+	}
+
+	return []byte(ip.String() + "|" + host)
+}
+
+// Record remembers that the request for host from the client with ip has been
+// blocked with res.  ip may be nil.
+func (s *Server) Record(host string, ip net.IP, res *filtering.Result) {
+	info := &blockInfo{
+		Time:   time.Now(),
+		Reason: res.Reason.String(),
+	}
+
+	switch res.Reason {
+	case filtering.FilteredBlockedService:
+		info.Name = res.ServiceName
+	case filtering.FilteredThreatFeed:
+		info.Name = res.ThreatFeed
+	case filtering.FilteredAnswerPolicy:
+		info.Name = res.AnswerPolicy
+	default:
+		// Go on.
+	}
+
+	if len(res.Rules) > 0 {
+		info.Rule, info.FilterListID = res.Rules[0].Text, res.Rules[0].FilterListID
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		// Should not happen, since blockInfo is always encodable.
+		log.Error("block page: encoding block info: %s", err)
+
+		return
+	}
+
+	if ip != nil {
+		s.recent.Set(recentKey(ip, host), data)
+	}
+
+	s.recent.Set(recentKey(nil, host), data)
+}
+
+// lookup returns the information about the recent block of host for the
+// client with ip.  If there is no such block, the latest block of host for any
+// client is returned.  info is nil if host hasn't been blocked recently.
+func (s *Server) lookup(ip net.IP, host string) (info *blockInfo) {
+	data := s.recent.Get(recentKey(ip, host))
+	if data == nil {
+		data = s.recent.Get(recentKey(nil, host))
+		if data == nil {
+			return nil
+		}
+	}
+
+	info = &blockInfo{}
+	err := json.Unmarshal(data, info)
+	if err != nil {
+		log.Error("block page: decoding block info: %s", err)
+
+		return nil
+	}
+
+	if time.Since(info.Time) > recentBlockTTL {
+		return nil
+	}
+
+	return info
+}
+
+// Start starts the HTTP and, if enabled, the HTTPS servers.
+func (s *Server) Start() (err error) {
+	addrs := []net.IP{s.conf.BindHost}
+	if s.conf.BindHostIPv6 != nil {
+		addrs = append(addrs, s.conf.BindHostIPv6)
+	}
+
+	for _, ip := range addrs {
+		err = s.listen(ip, s.conf.PortHTTP, nil)
+		if err != nil {
+			return errors.WithDeferred(err, s.Close())
+		}
+
+		if s.ca == nil {
+			continue
+		}
+
+		err = s.listen(ip, s.conf.PortHTTPS, &tls.Config{
+			GetCertificate: s.ca.getCertificate,
+			MinVersion:     tls.VersionTLS12,
+		})
+		if err != nil {
+			return errors.WithDeferred(err, s.Close())
+		}
+	}
+
+	return nil
+}
+
+// listen starts serving the block page on ip and port.  tlsConf is nil for
+// the plain HTTP server.
+func (s *Server) listen(ip net.IP, port int, tlsConf *tls.Config) (err error) {
+	addr := netutil.JoinHostPort(ip.String(), port)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("block page: listening on %s: %w", addr, err)
+	}
+
+	if tlsConf != nil {
+		l = tls.NewListener(l, tlsConf)
+	}
+
+	srv := &http.Server{
+		Handler:     http.HandlerFunc(s.handlePage),
+		ReadTimeout: readTimeout,
+		ErrorLog:    log.StdLog("blockpage", log.DEBUG),
+	}
+	s.srvs = append(s.srvs, srv)
+
+	go func() {
+		defer log.OnPanic("block page")
+
+		log.Info("block page: listening on %s", addr)
+
+		serr := srv.Serve(l)
+		if !errors.Is(serr, http.ErrServerClosed) {
+			log.Error("block page: serving on %s: %s", addr, serr)
+		}
+	}()
+
+	return nil
+}
+
+// Close stops the servers.
+func (s *Server) Close() (err error) {
+	var errs []error
+	for _, srv := range s.srvs {
+		cerr := srv.Close()
+		if cerr != nil {
+			errs = append(errs, cerr)
+		}
+	}
+
+	s.srvs = nil
+
+	if len(errs) > 0 {
+		return errors.List("closing block page servers", errs...)
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package blockpage
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer returns a new block page server for tests with the HTTPS
+// disabled.
+func newTestServer(t *testing.T, approved *[]string) (s *Server) {
+	t.Helper()
+
+	s, err := New(&Config{
+		ClientName: func(ip net.IP) (name string) {
+			if ip.Equal(net.IP{192, 168, 0, 2}) {
+				return "laptop"
+			}
+
+			return ""
+		},
+		FilterListName: func(id int64) (name string) {
+			if id == 1 {
+				return "Test List"
+			}
+
+			return ""
+		},
+		OnApprove: func(host string) (err error) {
+			*approved = append(*approved, host)
+
+			return nil
+		},
+		DataDir:         t.TempDir(),
+		Enabled:         true,
+		BindHost:        net.IP{192, 168, 0, 1},
+		PortHTTP:        80,
+		UnblockRequests: true,
+	})
+	require.NoError(t, err)
+
+	return s
+}
+
+func TestNew_validate(t *testing.T) {
+	testCases := []struct {
+		conf       *Config
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       &Config{PortHTTP: 80},
+		name:       "no_bind_host",
+		wantErrMsg: `block page: bind_host: "<nil>" is not a specific ipv4 address`,
+	}, {
+		conf:       &Config{BindHost: net.IPv4zero, PortHTTP: 80},
+		name:       "unspecified",
+		wantErrMsg: `block page: bind_host: "0.0.0.0" is not a specific ipv4 address`,
+	}, {
+		conf: &Config{
+			BindHost:     net.IP{192, 168, 0, 1},
+			BindHostIPv6: net.IP{192, 168, 0, 2},
+			PortHTTP:     80,
+		},
+		name:       "bad_ipv6",
+		wantErrMsg: `block page: bind_host_ipv6: "192.168.0.2" is not a specific ipv6 address`,
+	}, {
+		conf:       &Config{BindHost: net.IP{192, 168, 0, 1}},
+		name:       "no_port",
+		wantErrMsg: "block page: port_http: bad port 0",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(tc.conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestServer_handlePage(t *testing.T) {
+	var approved []string
+	s := newTestServer(t, &approved)
+
+	clientIP := net.IP{192, 168, 0, 2}
+	s.Record("blocked.example.", clientIP, &filtering.Result{
+		IsFiltered: true,
+		Reason:     filtering.FilteredBlockList,
+		Rules: []*filtering.ResultRule{{
+			Text:         "||blocked.example^",
+			FilterListID: 1,
+		}},
+	})
+
+	newReq := func(method, path string, body string) (r *http.Request) {
+		r = httptest.NewRequest(method, "http://blocked.example"+path, strings.NewReader(body))
+		r.RemoteAddr = "192.168.0.2:12345"
+		if method == http.MethodPost {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+
+		return r
+	}
+
+	t.Run("page", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.handlePage(w, newReq(http.MethodGet, "/some/path", ""))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		body := w.Body.String()
+		assert.Contains(t, body, "Access to blocked.example is blocked")
+		assert.Contains(t, body, "||blocked.example^")
+		assert.Contains(t, body, "Test List")
+		assert.Contains(t, body, "laptop (192.168.0.2)")
+		assert.Contains(t, body, unblockPath)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := newReq(http.MethodGet, "/", "")
+		r.Host = "other.example"
+		s.handlePage(w, r)
+
+		body := w.Body.String()
+		assert.Contains(t, body, "Access to other.example is blocked")
+		assert.NotContains(t, body, unblockPath)
+	})
+
+	t.Run("unblock_request", func(t *testing.T) {
+		form := url.Values{"comment": []string{"need it for work"}}.Encode()
+
+		w := httptest.NewRecorder()
+		s.handlePage(w, newReq(http.MethodPost, unblockPath, form))
+		assert.Contains(t, w.Body.String(), "Your request has been sent to the administrator.")
+
+		reqs := s.unblockRequests()
+		require.Len(t, reqs, 1)
+
+		assert.Equal(t, "blocked.example", reqs[0].Host)
+		assert.Equal(t, "laptop", reqs[0].ClientName)
+		assert.Equal(t, "need it for work", reqs[0].Comment)
+		assert.Equal(t, "||blocked.example^", reqs[0].Rule)
+
+		// The requests must survive restarts.
+		saved, err := readUnblockRequests(s.conf.DataDir)
+		require.NoError(t, err)
+		require.Len(t, saved, 1)
+
+		assert.Equal(t, reqs[0].ID, saved[0].ID)
+	})
+
+	t.Run("approve", func(t *testing.T) {
+		reqs := s.unblockRequests()
+		require.Len(t, reqs, 1)
+
+		body := bytes.NewBufferString(`{"id":"` + reqs[0].ID + `"}`)
+		w := httptest.NewRecorder()
+		s.handleRequestsApprove(w, httptest.NewRequest(http.MethodPost, "/", body))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		assert.Equal(t, []string{"blocked.example"}, approved)
+		assert.Empty(t, s.unblockRequests())
+	})
+
+	t.Run("reject_unknown", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"id":"unknown"}`)
+		w := httptest.NewRecorder()
+		s.handleRequestsReject(w, httptest.NewRequest(http.MethodPost, "/", body))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestServer_lookup(t *testing.T) {
+	var approved []string
+	s := newTestServer(t, &approved)
+
+	s.Record("Service.Example.", net.IP{192, 168, 0, 2}, &filtering.Result{
+		IsFiltered:  true,
+		Reason:      filtering.FilteredBlockedService,
+		ServiceName: "example",
+		Rules:       []*filtering.ResultRule{{Text: "||service.example^"}},
+	})
+
+	info := s.lookup(net.IP{192, 168, 0, 2}, "service.example")
+	require.NotNil(t, info)
+
+	assert.Equal(t, "example", info.Name)
+	assert.Equal(t, `Blocked service "example"`, describeReason(info))
+
+	// The blocks of the host for the other clients are used when there is
+	// none for the client itself, for example when it uses encrypted DNS.
+	info = s.lookup(net.IP{192, 168, 0, 3}, "service.example")
+	require.NotNil(t, info)
+
+	assert.Nil(t, s.lookup(net.IP{192, 168, 0, 2}, "other.example"))
+}
+
+func TestServer_addUnblockRequest(t *testing.T) {
+	var approved []string
+	s := newTestServer(t, &approved)
+
+	clientIP := net.IP{192, 168, 0, 2}
+	for i := 0; i < maxClientUnblockRequests; i++ {
+		err := s.addUnblockRequest(&UnblockRequest{
+			Host:     fmt.Sprintf("host-%d.example", i),
+			ClientIP: clientIP,
+		})
+		require.NoError(t, err)
+	}
+
+	// Requests for the same host replace each other.
+	err := s.addUnblockRequest(&UnblockRequest{
+		Host:     "host-0.example",
+		ClientIP: clientIP,
+	})
+	require.NoError(t, err)
+
+	err = s.addUnblockRequest(&UnblockRequest{
+		Host:     "other.example",
+		ClientIP: clientIP,
+	})
+	testutil.AssertErrorMsg(t, "too many pending unblock requests from the client", err)
+
+	// The other clients are still able to make requests.
+	err = s.addUnblockRequest(&UnblockRequest{
+		Host:     "other.example",
+		ClientIP: net.IP{192, 168, 0, 3},
+	})
+	require.NoError(t, err)
+
+	assert.Len(t, s.unblockRequests(), maxClientUnblockRequests+1)
+}
@@ -0,0 +1,304 @@
+package blockpage
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/google/renameio/maybe"
+)
+
+const (
+	// caCertFile is the name of the file with the certificate of the
+	// certificate authority within the data directory.
+	caCertFile = "blockpage_ca.pem"
+
+	// caKeyFile is the name of the file with the private key of the
+	// certificate authority within the data directory.
+	caKeyFile = "blockpage_ca.key"
+
+	// caValidity is the validity period of the generated certificate
+	// authority.
+	caValidity = 10 * 365 * 24 * time.Hour
+
+	// leafValidity is the validity period of the issued certificates.
+	leafValidity = 30 * 24 * time.Hour
+
+	// leafRenewal is the period before the expiration of an issued
+	// certificate after which it's issued again.
+	leafRenewal = 24 * time.Hour
+
+	// maxLeafCerts is the maximum number of the issued certificates kept in
+	// memory.
+	maxLeafCerts = 1024
+)
+
+// authority is the local certificate authority issuing the certificates for
+// the blocked domain names.
+type authority struct {
+	// cert is the certificate of the authority.
+	cert *x509.Certificate
+
+	// key is the private key of the authority.
+	key crypto.Signer
+
+	// leafKey is the private key shared by all the issued certificates.
+	leafKey *ecdsa.PrivateKey
+
+	// leavesLock protects leaves.
+	leavesLock sync.Mutex
+
+	// leaves are the issued certificates by the lowercased server names.
+	leaves map[string]*tls.Certificate
+
+	// allowed returns true if a certificate may be issued for the lowercased
+	// server name.  If it's nil, certificates are only issued for
+	// defaultName.
+	allowed func(name string) (ok bool)
+
+	// defaultName is the name used for the clients which don't send the
+	// server name.
+	defaultName string
+}
+
+// loadAuthority loads the certificate authority from dir or generates and
+// saves a new one if there is none.
+func loadAuthority(dir, defaultName string) (ca *authority, err error) {
+	certPath := filepath.Join(dir, caCertFile)
+	keyPath := filepath.Join(dir, caKeyFile)
+
+	certPEM, err := os.ReadFile(certPath)
+	var keyPEM []byte
+	if errors.Is(err, os.ErrNotExist) {
+		certPEM, keyPEM, err = newAuthorityPEM(time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("generating: %w", err)
+		}
+
+		err = writeAuthority(dir, certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Info("block page: generated certificate authority at %s", certPath)
+	} else if err != nil {
+		return nil, fmt.Errorf("reading certificate: %w", err)
+	} else {
+		keyPEM, err = os.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading key: %w", err)
+		}
+	}
+
+	return newAuthority(certPEM, keyPEM, defaultName)
+}
+
+// writeAuthority saves the certificate and the key of the certificate
+// authority into dir.
+func writeAuthority(dir string, certPEM, keyPEM []byte) (err error) {
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating dir: %w", err)
+	}
+
+	err = maybe.WriteFile(filepath.Join(dir, caKeyFile), keyPEM, 0o600)
+	if err != nil {
+		return fmt.Errorf("writing key: %w", err)
+	}
+
+	err = maybe.WriteFile(filepath.Join(dir, caCertFile), certPEM, 0o644)
+	if err != nil {
+		return fmt.Errorf("writing certificate: %w", err)
+	}
+
+	return nil
+}
+
+// newAuthority returns a new certificate authority with the certificate and
+// the key in the PEM format.
+func newAuthority(certPEM, keyPEM []byte, defaultName string) (ca *authority, err error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parsing key pair: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parsing certificate: %w", err)
+	} else if !cert.IsCA {
+		return nil, errors.Error("certificate is not a certificate authority")
+	}
+
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", pair.PrivateKey)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating leaf key: %w", err)
+	}
+
+	return &authority{
+		cert:        cert,
+		key:         key,
+		leafKey:     leafKey,
+		leaves:      map[string]*tls.Certificate{},
+		defaultName: defaultName,
+	}, nil
+}
+
+// newSerial returns a random serial number for a certificate.
+func newSerial() (serial *big.Int, err error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// newAuthorityPEM generates a new certificate authority valid since now and
+// returns its certificate and its private key in the PEM format.
+func newAuthorityPEM(now time.Time) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating key: %w", err)
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating serial: %w", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   "AdGuard Home Block Page CA",
+			Organization: []string{"AdGuard Home"},
+		},
+		NotBefore:             now.Add(-1 * time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding key: %w", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, nil
+}
+
+// certPEM returns the certificate of the authority in the PEM format.
+func (ca *authority) certPEM() (data []byte) {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+// getCertificate returns the certificate for the server name from hello,
+// issuing it if necessary.  It's used as the GetCertificate callback of
+// tls.Config.  Certificates are only issued for the default name and the names
+// allowed by ca.allowed, so that the clients can't make the server sign
+// arbitrary names.
+func (ca *authority) getCertificate(hello *tls.ClientHelloInfo) (cert *tls.Certificate, err error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" {
+		name = ca.defaultName
+	}
+
+	now := time.Now()
+
+	ca.leavesLock.Lock()
+	cert, ok := ca.leaves[name]
+	ca.leavesLock.Unlock()
+
+	if ok && now.Before(cert.Leaf.NotAfter.Add(-leafRenewal)) {
+		return cert, nil
+	}
+
+	if name != ca.defaultName && (ca.allowed == nil || !ca.allowed(name)) {
+		return nil, fmt.Errorf("issuing certificate for %q: name is not blocked recently", name)
+	}
+
+	// Don't sign under the lock, since it's relatively slow.
+	cert, err = ca.issue(name, now)
+	if err != nil {
+		return nil, fmt.Errorf("issuing certificate for %q: %w", name, err)
+	}
+
+	ca.leavesLock.Lock()
+	defer ca.leavesLock.Unlock()
+
+	if len(ca.leaves) >= maxLeafCerts {
+		ca.leaves = map[string]*tls.Certificate{}
+	}
+
+	ca.leaves[name] = cert
+
+	return cert, nil
+}
+
+// issue issues a new certificate for name valid since now.
+func (ca *authority) issue(name string, now time.Time) (cert *tls.Certificate, err error) {
+	serial, err := newSerial()
+	if err != nil {
+		return nil, fmt.Errorf("generating serial: %w", err)
+	}
+
+	notAfter := now.Add(leafValidity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-1 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if ip := net.ParseIP(name); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{name}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, ca.leafKey.Public(), ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("creating certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing certificate: %w", err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  ca.leafKey,
+		Leaf:        leaf,
+	}, nil
+}
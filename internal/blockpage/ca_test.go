@@ -0,0 +1,85 @@
+package blockpage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthority(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, err := loadAuthority(dir, "192.168.0.1")
+	require.NoError(t, err)
+
+	assert.FileExists(t, filepath.Join(dir, caCertFile))
+
+	keyInfo, err := os.Stat(filepath.Join(dir, caKeyFile))
+	require.NoError(t, err)
+
+	assert.Equal(t, os.FileMode(0o600), keyInfo.Mode().Perm())
+
+	t.Run("reload", func(t *testing.T) {
+		var loaded *authority
+		loaded, err = loadAuthority(dir, "192.168.0.1")
+		require.NoError(t, err)
+
+		assert.Equal(t, ca.cert.Raw, loaded.cert.Raw)
+	})
+
+	ca.allowed = func(name string) (ok bool) { return name == "blocked.example" }
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	testCases := []struct {
+		name       string
+		serverName string
+		verifyName string
+	}{{
+		name:       "domain",
+		serverName: "Blocked.Example",
+		verifyName: "blocked.example",
+	}, {
+		name:       "no_sni",
+		serverName: "",
+		verifyName: "192.168.0.1",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var cert *tls.Certificate
+			cert, err = ca.getCertificate(&tls.ClientHelloInfo{ServerName: tc.serverName})
+			require.NoError(t, err)
+
+			_, err = cert.Leaf.Verify(x509.VerifyOptions{
+				DNSName:     tc.verifyName,
+				Roots:       roots,
+				CurrentTime: time.Now(),
+			})
+			assert.NoError(t, err)
+
+			var cached *tls.Certificate
+			cached, err = ca.getCertificate(&tls.ClientHelloInfo{ServerName: tc.serverName})
+			require.NoError(t, err)
+
+			assert.Same(t, cert, cached)
+		})
+	}
+
+	t.Run("not_allowed", func(t *testing.T) {
+		_, err = ca.getCertificate(&tls.ClientHelloInfo{ServerName: "other.example"})
+		testutil.AssertErrorMsg(
+			t,
+			`issuing certificate for "other.example": name is not blocked recently`,
+			err,
+		)
+	})
+}
@@ -0,0 +1,375 @@
+package blockpage
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+const (
+	// unblockPath is the path of the block page form requesting unblocking
+	// of the domain name.  It's unlikely to clash with the paths of the
+	// blocked websites.
+	unblockPath = "/.adguard-home/unblock"
+
+	// maxFormSize is the maximum size of the body of an unblock request.
+	maxFormSize = 4 * 1024
+)
+
+// pageData is the data of the block page template.
+type pageData struct {
+	// Host is the blocked domain name.
+	Host string
+
+	// Client is the name or the IP address of the client.
+	Client string
+
+	// Reason is the human-readable reason of the block.
+	Reason string
+
+	// Rule is the rule which has blocked the request, if any.
+	Rule string
+
+	// FilterList is the name of the filter list of Rule, if any.
+	FilterList string
+
+	// Time is the time of the block.
+	Time string
+
+	// UnblockPath is the path of the unblock form.  It's empty if the
+	// unblock requests are disabled or unblocking isn't possible.
+	UnblockPath string
+
+	// Message is the result of the unblock request, if any.
+	Message string
+}
+
+// pageTmpl is the template of the block page.
+var pageTmpl = template.Must(template.New("blockpage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Access blocked</title>
+<style>
+body { font-family: sans-serif; background: #f5f5f5; color: #333; margin: 0; }
+main { max-width: 40em; margin: 4em auto; padding: 2em; background: #fff; border-radius: 8px; }
+h1 { color: #c23814; margin-top: 0; }
+dt { font-weight: bold; margin-top: 1em; }
+dd { margin: 0.25em 0 0 0; word-break: break-all; }
+code { background: #f0f0f0; padding: 0.1em 0.3em; }
+textarea { width: 100%; box-sizing: border-box; }
+button { margin-top: 0.5em; padding: 0.5em 1em; }
+</style>
+</head>
+<body>
+<main>
+<h1>Access to {{.Host}} is blocked</h1>
+<p>This website has been blocked by AdGuard Home on your network.</p>
+<dl>
+{{- if .Reason}}
+<dt>Reason</dt>
+<dd>{{.Reason}}</dd>
+{{- end}}
+{{- if .Rule}}
+<dt>Rule</dt>
+<dd><code>{{.Rule}}</code></dd>
+{{- end}}
+{{- if .FilterList}}
+<dt>Filter list</dt>
+<dd>{{.FilterList}}</dd>
+{{- end}}
+<dt>Client</dt>
+<dd>{{.Client}}</dd>
+{{- if .Time}}
+<dt>Time</dt>
+<dd>{{.Time}}</dd>
+{{- end}}
+</dl>
+{{- if .Message}}
+<p><strong>{{.Message}}</strong></p>
+{{- else if .UnblockPath}}
+<form method="post" action="{{.UnblockPath}}">
+<p>If you think this website is blocked by mistake, ask the administrator to unblock it.</p>
+<textarea name="comment" rows="3" maxlength="256" placeholder="Comment (optional)"></textarea>
+<button type="submit">Request unblock</button>
+</form>
+{{- end}}
+</main>
+</body>
+</html>
+`))
+
+// describeReason returns the human-readable reason of the block described by
+// info.
+func describeReason(info *blockInfo) (reason string) {
+	switch info.Reason {
+	case filtering.FilteredBlockList.String():
+		return "Blocked by a filtering rule"
+	case filtering.FilteredBlockedService.String():
+		return fmt.Sprintf("Blocked service %q", info.Name)
+	case filtering.FilteredSafeBrowsing.String():
+		return "Known malicious or phishing website"
+	case filtering.FilteredParental.String():
+		return "Blocked by the parental control"
+	case filtering.FilteredThreatFeed.String():
+		return fmt.Sprintf("Listed in the threat feed %q", info.Name)
+	case filtering.FilteredAnswerPolicy.String():
+		return fmt.Sprintf("Blocked by the answer policy %q", info.Name)
+	default:
+		return info.Reason
+	}
+}
+
+// requestClient returns the IP address of the client and the blocked host from
+// the HTTP request r.
+func requestClient(r *http.Request) (ip net.IP, host string) {
+	addr, err := netutil.SplitHost(r.RemoteAddr)
+	if err == nil {
+		ip = net.ParseIP(addr)
+	}
+
+	host, err = netutil.SplitHost(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	return ip, strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// newPageData returns the block page data for host requested by the client
+// with ip.  info may be nil.
+func (s *Server) newPageData(ip net.IP, host string, info *blockInfo) (data *pageData) {
+	data = &pageData{
+		Host:   host,
+		Client: ip.String(),
+	}
+
+	if s.conf.ClientName != nil && ip != nil {
+		if name := s.conf.ClientName(ip); name != "" {
+			data.Client = fmt.Sprintf("%s (%s)", name, ip)
+		}
+	}
+
+	if info == nil {
+		return data
+	}
+
+	data.Reason = describeReason(info)
+	data.Rule = info.Rule
+	data.Time = info.Time.Format(time.RFC1123)
+	if info.Rule != "" {
+		data.FilterList = fmt.Sprintf("filter list %d", info.FilterListID)
+		if s.conf.FilterListName != nil {
+			if name := s.conf.FilterListName(info.FilterListID); name != "" {
+				data.FilterList = name
+			}
+		}
+	}
+
+	if s.conf.UnblockRequests {
+		data.UnblockPath = unblockPath
+	}
+
+	return data
+}
+
+// handlePage handles all requests to the block page server.
+func (s *Server) handlePage(w http.ResponseWriter, r *http.Request) {
+	ip, host := requestClient(r)
+	info := s.lookup(ip, host)
+	data := s.newPageData(ip, host, info)
+
+	if r.URL.Path == unblockPath && s.conf.UnblockRequests {
+		if r.Method != http.MethodPost {
+			http.Error(w, "This request must be POST", http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		data.Message = s.requestUnblock(w, r, ip, host, info)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+
+	err := pageTmpl.Execute(w, data)
+	if err != nil {
+		log.Debug("block page: writing page: %s", err)
+	}
+}
+
+// requestUnblock records the unblock request for host made with r by the client
+// with ip and returns the message for the requester.
+func (s *Server) requestUnblock(
+	w http.ResponseWriter,
+	r *http.Request,
+	ip net.IP,
+	host string,
+	info *blockInfo,
+) (msg string) {
+	if info == nil {
+		return "This website hasn't been blocked recently, so it can't be requested to unblock."
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+	err := r.ParseForm()
+	if err != nil {
+		return "Bad request."
+	}
+
+	comment := strings.TrimSpace(r.PostForm.Get("comment"))
+	if len(comment) > maxCommentLen {
+		comment = comment[:maxCommentLen]
+	}
+
+	req := &UnblockRequest{
+		Time:         time.Now().UTC(),
+		Host:         host,
+		ClientIP:     ip,
+		Reason:       info.Reason,
+		Rule:         info.Rule,
+		FilterListID: info.FilterListID,
+		Comment:      comment,
+	}
+
+	if s.conf.ClientName != nil && ip != nil {
+		req.ClientName = s.conf.ClientName(ip)
+	}
+
+	err = s.addUnblockRequest(req)
+	if err != nil {
+		log.Error("block page: adding unblock request for %q: %s", host, err)
+
+		return "Cannot request unblocking now, please try again later."
+	}
+
+	log.Info("block page: %s requested unblocking of %q", ip, host)
+
+	return "Your request has been sent to the administrator."
+}
+
+// registerHandlers registers the HTTP API handlers.
+func (s *Server) registerHandlers() {
+	if s.conf.HTTPRegister == nil {
+		return
+	}
+
+	s.conf.HTTPRegister(http.MethodGet, "/control/block_page/requests", s.handleRequests)
+	s.conf.HTTPRegister(http.MethodPost, "/control/block_page/requests/approve", s.handleRequestsApprove)
+	s.conf.HTTPRegister(http.MethodPost, "/control/block_page/requests/reject", s.handleRequestsReject)
+	s.conf.HTTPRegister(http.MethodGet, "/control/block_page/ca_cert", s.handleCACert)
+}
+
+// handleRequests is the handler for the GET /control/block_page/requests HTTP
+// API.
+func (s *Server) handleRequests(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(s.unblockRequests())
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "encoding response: %s", err)
+	}
+}
+
+// unblockRequestID is the request body of the HTTP API handling the unblock
+// requests.
+type unblockRequestID struct {
+	ID string `json:"id"`
+}
+
+// decodeRequestID returns the pending unblock request with the ID from the body
+// of r.  It writes the error response and returns nil if there is no such
+// request.
+func (s *Server) decodeRequestID(w http.ResponseWriter, r *http.Request) (req *UnblockRequest) {
+	reqID := &unblockRequestID{}
+	err := json.NewDecoder(r.Body).Decode(reqID)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return nil
+	}
+
+	for _, ur := range s.unblockRequests() {
+		if ur.ID == reqID.ID {
+			return ur
+		}
+	}
+
+	aghhttp.Error(r, w, http.StatusNotFound, "no unblock request with id %q", reqID.ID)
+
+	return nil
+}
+
+// handleRequestsApprove is the handler for the POST
+// /control/block_page/requests/approve HTTP API.
+func (s *Server) handleRequestsApprove(w http.ResponseWriter, r *http.Request) {
+	req := s.decodeRequestID(w, r)
+	if req == nil {
+		return
+	}
+
+	if s.conf.OnApprove != nil {
+		err := s.conf.OnApprove(req.Host)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusInternalServerError, "unblocking %q: %s", req.Host, err)
+
+			return
+		}
+	}
+
+	s.removeRequest(w, r, req)
+}
+
+// handleRequestsReject is the handler for the POST
+// /control/block_page/requests/reject HTTP API.
+func (s *Server) handleRequestsReject(w http.ResponseWriter, r *http.Request) {
+	req := s.decodeRequestID(w, r)
+	if req == nil {
+		return
+	}
+
+	s.removeRequest(w, r, req)
+}
+
+// removeRequest removes the pending unblock request and writes the response.
+func (s *Server) removeRequest(w http.ResponseWriter, r *http.Request, req *UnblockRequest) {
+	_, err := s.removeUnblockRequest(req.ID)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "removing unblock request: %s", err)
+
+		return
+	}
+
+	log.Debug("block page: removed unblock request %s for %q", req.ID, req.Host)
+
+	aghhttp.OK(w)
+}
+
+// handleCACert is the handler for the GET /control/block_page/ca_cert HTTP
+// API.  It returns the certificate of the local certificate authority which
+// the clients should trust.
+func (s *Server) handleCACert(w http.ResponseWriter, r *http.Request) {
+	if s.ca == nil {
+		aghhttp.Error(r, w, http.StatusNotFound, "https is disabled")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+caCertFile+`"`)
+
+	_, err := w.Write(s.ca.certPEM())
+	if err != nil {
+		log.Debug("block page: writing ca certificate: %s", err)
+	}
+}
@@ -0,0 +1,166 @@
+package blockpage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/google/renameio/maybe"
+)
+
+const (
+	// unblockRequestsFile is the name of the file with the pending unblock
+	// requests within the data directory.
+	unblockRequestsFile = "blockpage_requests.json"
+
+	// maxUnblockRequests is the maximum number of the pending unblock
+	// requests.
+	maxUnblockRequests = 100
+
+	// maxClientUnblockRequests is the maximum number of the pending unblock
+	// requests from a single client.
+	maxClientUnblockRequests = 10
+
+	// maxCommentLen is the maximum length of the comment of an unblock
+	// request in bytes.
+	maxCommentLen = 256
+)
+
+// UnblockRequest is a request to unblock a domain name made from the block
+// page.
+type UnblockRequest struct {
+	// Time is the time of the request.
+	Time time.Time `json:"time"`
+
+	// ID is the unique identifier of the request.
+	ID string `json:"id"`
+
+	// Host is the domain name to unblock.
+	Host string `json:"host"`
+
+	// ClientIP is the IP address of the requester.
+	ClientIP net.IP `json:"client_ip"`
+
+	// ClientName is the name of the requester, if it's known.
+	ClientName string `json:"client_name,omitempty"`
+
+	// Reason is the filtering reason of the block.
+	Reason string `json:"reason"`
+
+	// Rule is the rule which has blocked the domain name, if any.
+	Rule string `json:"rule,omitempty"`
+
+	// FilterListID is the ID of the filter list of Rule.
+	FilterListID int64 `json:"filter_list_id"`
+
+	// Comment is the optional comment of the requester.
+	Comment string `json:"comment,omitempty"`
+}
+
+// readUnblockRequests reads the pending unblock requests from the file in
+// dir.  It returns no error if there is no such file.
+func readUnblockRequests(dir string) (reqs []*UnblockRequest, err error) {
+	data, err := os.ReadFile(filepath.Join(dir, unblockRequestsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &reqs)
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	return reqs, nil
+}
+
+// writeUnblockRequestsLocked saves the pending unblock requests.  s.reqsLock
+// is expected to be locked.
+func (s *Server) writeUnblockRequestsLocked() (err error) {
+	data, err := json.Marshal(s.reqs)
+	if err != nil {
+		return fmt.Errorf("encoding: %w", err)
+	}
+
+	err = os.MkdirAll(s.conf.DataDir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating dir: %w", err)
+	}
+
+	return maybe.WriteFile(filepath.Join(s.conf.DataDir, unblockRequestsFile), data, 0o644)
+}
+
+// addUnblockRequest adds a pending unblock request.  Requests for the same
+// host from the same client replace each other.  The number of the pending
+// requests from a single client is limited, so that it can't fill the queue
+// for the others.
+func (s *Server) addUnblockRequest(req *UnblockRequest) (err error) {
+	idData := make([]byte, 8)
+	_, err = rand.Read(idData)
+	if err != nil {
+		return fmt.Errorf("generating id: %w", err)
+	}
+
+	req.ID = hex.EncodeToString(idData)
+
+	s.reqsLock.Lock()
+	defer s.reqsLock.Unlock()
+
+	clientReqs := 0
+	reqs := make([]*UnblockRequest, 0, len(s.reqs)+1)
+	for _, r := range s.reqs {
+		sameClient := r.ClientIP.Equal(req.ClientIP)
+		if r.Host == req.Host && sameClient {
+			continue
+		} else if sameClient {
+			clientReqs++
+		}
+
+		reqs = append(reqs, r)
+	}
+
+	if clientReqs >= maxClientUnblockRequests {
+		return errors.Error("too many pending unblock requests from the client")
+	} else if len(reqs) >= maxUnblockRequests {
+		return errors.Error("too many pending unblock requests")
+	}
+
+	s.reqs = append(reqs, req)
+
+	return s.writeUnblockRequestsLocked()
+}
+
+// removeUnblockRequest removes the pending unblock request with id and returns
+// it.  req is nil if there is no such request.
+func (s *Server) removeUnblockRequest(id string) (req *UnblockRequest, err error) {
+	s.reqsLock.Lock()
+	defer s.reqsLock.Unlock()
+
+	for i, r := range s.reqs {
+		if r.ID == id {
+			s.reqs = append(s.reqs[:i:i], s.reqs[i+1:]...)
+
+			return r, s.writeUnblockRequestsLocked()
+		}
+	}
+
+	return nil, nil
+}
+
+// unblockRequests returns a copy of the pending unblock requests.
+func (s *Server) unblockRequests() (reqs []*UnblockRequest) {
+	s.reqsLock.Lock()
+	defer s.reqsLock.Unlock()
+
+	reqs = make([]*UnblockRequest, len(s.reqs))
+	copy(reqs, s.reqs)
+
+	return reqs
+}
@@ -48,8 +48,14 @@ type dhcpConn struct {
 	srcIP net.IP
 }
 
-// newDHCPConn creates the special connection for DHCP server.
-func (s *v4Server) newDHCPConn(ifi *net.Interface) (c net.PacketConn, err error) {
+// newDHCPConn creates the special connection for DHCP server.  bcastIP is the
+// broadcast address of the served subnet and srcIP is the address of ifi to
+// send the replies from.
+func (s *v4Server) newDHCPConn(
+	ifi *net.Interface,
+	bcastIP net.IP,
+	srcIP net.IP,
+) (c net.PacketConn, err error) {
 	// Create the raw connection.
 	var ucast net.PacketConn
 	if ucast, err = raw.ListenPacket(ifi, uint16(ethernet.EtherTypeIPv4), nil); err != nil {
@@ -73,10 +79,10 @@ func (s *v4Server) newDHCPConn(ifi *net.Interface) (c net.PacketConn, err error)
 
 	return &dhcpConn{
 		udpConn: bcast,
-		bcastIP: s.conf.broadcastIP,
+		bcastIP: bcastIP,
 		rawConn: ucast,
 		srcMAC:  ifi.HardwareAddr,
-		srcIP:   s.conf.dnsIPAddrs[0],
+		srcIP:   srcIP,
 	}, nil
 }
 
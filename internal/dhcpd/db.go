@@ -3,24 +3,28 @@
 package dhcpd
 
 import (
-	"encoding/json"
 	"fmt"
 	"net"
-	"os"
 	"time"
 
-	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
-	"github.com/google/renameio/maybe"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/stringutil"
 )
 
 const dbFilename = "leases.db"
 
 type leaseJSON struct {
-	HWAddr   []byte `json:"mac"`
-	IP       []byte `json:"ip"`
-	Hostname string `json:"host"`
-	Expiry   int64  `json:"exp"`
+	HWAddr   []byte   `json:"mac"`
+	IP       []byte   `json:"ip"`
+	Hostname string   `json:"host"`
+	Options  []string `json:"options,omitempty"`
+	DUID     []byte   `json:"duid,omitempty"`
+	Expiry   int64    `json:"exp"`
+
+	// PrefixLen is the length of the delegated prefix starting at IP.  It's
+	// zero for the address leases.
+	PrefixLen int `json:"prefix_len,omitempty"`
 }
 
 func normalizeIP(ip net.IP) net.IP {
@@ -37,22 +41,15 @@ func (s *Server) dbLoad() (err error) {
 	staticLeases := []*Lease{}
 	v6StaticLeases := []*Lease{}
 	v6DynLeases := []*Lease{}
+	prefixLeases := []*PrefixLease{}
 
-	data, err := os.ReadFile(s.conf.DBFilePath)
+	obj, ok, err := s.journal.load()
 	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return fmt.Errorf("reading db: %w", err)
-		}
-
+		return err
+	} else if !ok {
 		return nil
 	}
 
-	obj := []leaseJSON{}
-	err = json.Unmarshal(data, &obj)
-	if err != nil {
-		return fmt.Errorf("decoding db: %w", err)
-	}
-
 	numLeases := len(obj)
 	for i := range obj {
 		obj[i].IP = normalizeIP(obj[i].IP)
@@ -62,10 +59,31 @@ func (s *Server) dbLoad() (err error) {
 			continue
 		}
 
+		if obj[i].PrefixLen != 0 {
+			if len(obj[i].IP) != 16 || obj[i].PrefixLen > 128 {
+				log.Info("dhcp: invalid prefix: %s/%d", obj[i].IP, obj[i].PrefixLen)
+
+				continue
+			}
+
+			prefixLeases = append(prefixLeases, &PrefixLease{
+				Expiry: time.Unix(obj[i].Expiry, 0),
+				HWAddr: obj[i].HWAddr,
+				Prefix: &net.IPNet{
+					IP:   obj[i].IP,
+					Mask: net.CIDRMask(obj[i].PrefixLen, 128),
+				},
+			})
+
+			continue
+		}
+
 		lease := Lease{
 			HWAddr:   obj[i].HWAddr,
 			IP:       obj[i].IP,
 			Hostname: obj[i].Hostname,
+			Options:  obj[i].Options,
+			DUID:     obj[i].DUID,
 			Expiry:   time.Unix(obj[i].Expiry, 0),
 		}
 
@@ -96,10 +114,22 @@ func (s *Server) dbLoad() (err error) {
 		if err != nil {
 			return fmt.Errorf("resetting dhcpv6 leases: %w", err)
 		}
+
+		err = s.srv6.ResetPrefixLeases(prefixLeases)
+		if err != nil {
+			return fmt.Errorf("resetting dhcpv6 prefix leases: %w", err)
+		}
 	}
 
-	log.Info("dhcp: loaded leases v4:%d  v6:%d  total-read:%d from DB",
-		len(leases4), len(leases6), numLeases)
+	log.Info("dhcp: loaded leases v4:%d  v6:%d  prefixes:%d  total-read:%d from DB",
+		len(leases4), len(leases6), len(prefixLeases), numLeases)
+
+	// Compact the journal once at start so that it doesn't contain any
+	// partially written records.
+	err = s.journal.compact()
+	if err != nil {
+		return fmt.Errorf("compacting db: %w", err)
+	}
 
 	return nil
 }
@@ -111,31 +141,39 @@ func normalizeLeases(staticLeases, dynLeases []*Lease) []*Lease {
 	index := map[string]int{}
 
 	for i, lease := range staticLeases {
-		_, ok := index[lease.HWAddr.String()]
+		_, ok := index[clientKey(lease)]
 		if ok {
 			continue // skip the lease with the same HW address
 		}
-		index[lease.HWAddr.String()] = i
+		index[clientKey(lease)] = i
 		leases = append(leases, lease)
 	}
 
 	for i, lease := range dynLeases {
-		_, ok := index[lease.HWAddr.String()]
+		_, ok := index[clientKey(lease)]
 		if ok {
 			continue // skip the lease with the same HW address
 		}
-		index[lease.HWAddr.String()] = i
+		index[clientKey(lease)] = i
 		leases = append(leases, lease)
 	}
 
 	return leases
 }
 
+// clientKey returns the string identifying the client of l, which is its DUID,
+// if any, or its hardware address.
+func clientKey(l *Lease) (key string) {
+	if len(l.DUID) > 0 {
+		return "duid:" + formatDUID(l.DUID)
+	}
+
+	return l.HWAddr.String()
+}
+
 // Store lease table in DB
 func (s *Server) dbStore() (err error) {
-	// Use an empty slice here as opposed to nil so that it doesn't write
-	// "null" into the database file if leases are empty.
-	leases := []leaseJSON{}
+	leases := []*leaseJSON{}
 
 	leases4 := s.srv4.getLeasesRef()
 	for _, l := range leases4 {
@@ -143,14 +181,7 @@ func (s *Server) dbStore() (err error) {
 			continue
 		}
 
-		lease := leaseJSON{
-			HWAddr:   l.HWAddr,
-			IP:       l.IP,
-			Hostname: l.Hostname,
-			Expiry:   l.Expiry.Unix(),
-		}
-
-		leases = append(leases, lease)
+		leases = append(leases, newLeaseJSON(l))
 	}
 
 	if s.srv6 != nil {
@@ -160,29 +191,45 @@ func (s *Server) dbStore() (err error) {
 				continue
 			}
 
-			lease := leaseJSON{
-				HWAddr:   l.HWAddr,
-				IP:       l.IP,
-				Hostname: l.Hostname,
-				Expiry:   l.Expiry.Unix(),
+			leases = append(leases, newLeaseJSON(l))
+		}
+
+		// The static prefixes are taken from the configuration, so
+		// don't store them.
+		for _, l := range s.srv6.getPrefixLeasesRef() {
+			if l.held || l.IsStatic() {
+				continue
 			}
 
-			leases = append(leases, lease)
+			prefixLen, _ := l.Prefix.Mask.Size()
+			leases = append(leases, &leaseJSON{
+				HWAddr:    netutil.CloneMAC(l.HWAddr),
+				IP:        netutil.CloneIP(l.Prefix.IP),
+				Expiry:    l.Expiry.Unix(),
+				PrefixLen: prefixLen,
+			})
 		}
 	}
 
-	var data []byte
-	data, err = json.Marshal(leases)
-	if err != nil {
-		return fmt.Errorf("encoding db: %w", err)
-	}
-
-	err = maybe.WriteFile(s.conf.DBFilePath, data, 0o644)
+	err = s.journal.store(leases)
 	if err != nil {
-		return fmt.Errorf("writing db: %w", err)
+		return err
 	}
 
-	log.Info("dhcp: stored %d leases in db", len(leases))
+	log.Debug("dhcp: stored %d leases in db", len(leases))
 
 	return nil
 }
+
+// newLeaseJSON returns the database representation of l.  The data is cloned,
+// since the leases may be changed in place.
+func newLeaseJSON(l *Lease) (lj *leaseJSON) {
+	return &leaseJSON{
+		HWAddr:   netutil.CloneMAC(l.HWAddr),
+		IP:       netutil.CloneIP(l.IP),
+		Hostname: l.Hostname,
+		Options:  stringutil.CloneSlice(l.Options),
+		DUID:     cloneBytes(l.DUID),
+		Expiry:   l.Expiry.Unix(),
+	}
+}
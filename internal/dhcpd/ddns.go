@@ -0,0 +1,518 @@
+package dhcpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+)
+
+// Default values for DDNSConfig fields.
+const (
+	defaultDDNSTTL           uint32 = 300
+	defaultDDNSRetries       uint   = 3
+	defaultDDNSTimeout              = 5 * time.Second
+	defaultDDNSResyncIvl            = 1 * time.Minute
+	defaultDDNSRetryBackoff         = 1 * time.Second
+	defaultDDNSTSIGAlgorithm        = dns.HmacSHA256
+	defaultDDNSTSIGFudge     uint16 = 300
+)
+
+// DDNSConfig is the configuration of the dynamic DNS updater which reflects
+// the DHCP leases in an external DNS server using the UPDATE messages.
+//
+// See https://datatracker.ietf.org/doc/html/rfc2136.
+type DDNSConfig struct {
+	// Enabled defines if the dynamic DNS updates are sent.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+
+	// Server is the address of the primary DNS server accepting the
+	// updates, for example "192.168.1.2:53".  The port defaults to 53.
+	Server string `yaml:"server" json:"server"`
+
+	// Zone is the forward zone the A and AAAA records of the leases are
+	// added to, for example "lan.".
+	Zone string `yaml:"zone" json:"zone"`
+
+	// ReverseZones are the zones for PTR records, for example
+	// "168.192.in-addr.arpa.".  PTR records are only updated for the
+	// addresses within one of these zones.
+	ReverseZones []string `yaml:"reverse_zones" json:"reverse_zones"`
+
+	// TSIGKeyName is the name of the TSIG key used to sign the updates.  The
+	// updates aren't signed if it's empty.
+	TSIGKeyName string `yaml:"tsig_key_name" json:"-"`
+
+	// TSIGSecret is the base64-encoded secret of the TSIG key.
+	TSIGSecret string `yaml:"tsig_secret" json:"-"`
+
+	// TSIGAlgorithm is the algorithm of the TSIG key, for example
+	// "hmac-sha256.".  The default is HMAC-SHA256.
+	TSIGAlgorithm string `yaml:"tsig_algorithm" json:"-"`
+
+	// TTL is the time-to-live of the added records, in seconds.
+	TTL uint32 `yaml:"ttl" json:"ttl"`
+
+	// Retries is the number of attempts made to send a single update before
+	// postponing it until the next synchronization.
+	Retries uint `yaml:"retries" json:"retries"`
+
+	// Timeout is the timeout for a single update exchange.
+	Timeout timeutil.Duration `yaml:"timeout" json:"-"`
+}
+
+// ddnsRecord is a single host record reflected in the external server.
+type ddnsRecord struct {
+	// host is the fully-qualified domain name of the lease.
+	host string
+	// ip is the address of the lease.
+	ip net.IP
+}
+
+// ddnsStatus is the current state of the updater.
+type ddnsStatus struct {
+	LastUpdate time.Time `json:"last_update"`
+	LastError  string    `json:"last_error,omitempty"`
+	Records    int       `json:"records"`
+	Pending    int       `json:"pending"`
+	Sent       uint64    `json:"sent"`
+	Failed     uint64    `json:"failed"`
+}
+
+// ddnsExchanger sends an update message and returns the response.
+type ddnsExchanger interface {
+	Exchange(m *dns.Msg, addr string) (r *dns.Msg, rtt time.Duration, err error)
+}
+
+// ddnsUpdater reflects the leases of the DHCP server in an external DNS
+// server.  It only keeps the records for leases with a hostname.
+type ddnsUpdater struct {
+	conf *DDNSConfig
+
+	// exchanger is used to send the updates.  It's *dns.Client in
+	// production.
+	exchanger ddnsExchanger
+
+	// leases returns the current leases of the server.
+	leases func() (leases []*Lease)
+
+	// records contains records already sent to the external server, keyed
+	// by the string representation of the IP address.
+	records map[string]*ddnsRecord
+
+	// trigger signals the worker that the leases have changed.
+	trigger chan struct{}
+
+	// done is closed to stop the worker.  It's nil when the worker isn't
+	// running.
+	done chan struct{}
+
+	// status is the current status of the updater.
+	status ddnsStatus
+
+	// mu protects records, done and status.
+	mu sync.Mutex
+
+	// backoff is the delay between two attempts of the same update.
+	backoff time.Duration
+}
+
+// newDDNSUpdater validates conf, fills the defaults in, and returns a new
+// updater for the leases.
+func newDDNSUpdater(conf *DDNSConfig, leases func() []*Lease) (u *ddnsUpdater, err error) {
+	defer func() { err = errors.Annotate(err, "ddns: %w") }()
+
+	if conf.Zone == "" {
+		return nil, errors.Error("no zone specified")
+	}
+
+	conf.Zone = dns.Fqdn(strings.ToLower(conf.Zone))
+	for i, z := range conf.ReverseZones {
+		conf.ReverseZones[i] = dns.Fqdn(strings.ToLower(z))
+	}
+
+	if _, _, err = net.SplitHostPort(conf.Server); err != nil {
+		conf.Server = netutil.JoinHostPort(conf.Server, 53)
+	}
+
+	if conf.TTL == 0 {
+		conf.TTL = defaultDDNSTTL
+	}
+
+	if conf.Retries == 0 {
+		conf.Retries = defaultDDNSRetries
+	}
+
+	if conf.Timeout.Duration == 0 {
+		conf.Timeout.Duration = defaultDDNSTimeout
+	}
+
+	cli := &dns.Client{
+		Net:     "udp",
+		Timeout: conf.Timeout.Duration,
+	}
+
+	if conf.TSIGKeyName != "" {
+		conf.TSIGKeyName = dns.Fqdn(strings.ToLower(conf.TSIGKeyName))
+		if conf.TSIGAlgorithm == "" {
+			conf.TSIGAlgorithm = defaultDDNSTSIGAlgorithm
+		}
+
+		conf.TSIGAlgorithm = dns.Fqdn(strings.ToLower(conf.TSIGAlgorithm))
+		cli.TsigSecret = map[string]string{conf.TSIGKeyName: conf.TSIGSecret}
+	}
+
+	return &ddnsUpdater{
+		conf:      conf,
+		exchanger: cli,
+		leases:    leases,
+		records:   map[string]*ddnsRecord{},
+		trigger:   make(chan struct{}, 1),
+		backoff:   defaultDDNSRetryBackoff,
+	}, nil
+}
+
+// onLeaseChanged is the OnLeaseChangedT callback of the updater.  It never
+// blocks.
+func (u *ddnsUpdater) onLeaseChanged(flags int) {
+	switch flags {
+	case
+		LeaseChangedAdded,
+		LeaseChangedAddedStatic,
+		LeaseChangedRemovedStatic,
+		// Releases and expirations of the dynamic leases are only signaled
+		// by storing the database.
+		LeaseChangedDBStore:
+		select {
+		case u.trigger <- struct{}{}:
+		default:
+			// Synchronization is already pending.
+		}
+	default:
+		// Go on.  Stopping the DHCP server doesn't remove the leases, so
+		// LeaseChangedRemovedAll doesn't remove the records.
+	}
+}
+
+// removeAll removes all the records sent to the external server.  It's used
+// when the leases are reset explicitly.
+func (u *ddnsUpdater) removeAll() {
+	u.mu.Lock()
+	toDel := make([]*ddnsRecord, 0, len(u.records))
+	for _, rec := range u.records {
+		toDel = append(toDel, rec)
+	}
+	u.status.Pending += len(toDel)
+	u.mu.Unlock()
+
+	for _, rec := range toDel {
+		u.apply(rec, false)
+	}
+}
+
+// start starts the worker if it isn't running yet.  The records are sent again
+// during the first synchronization, since the leases may have changed while the
+// worker was stopped.
+func (u *ddnsUpdater) start() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.done != nil {
+		return
+	}
+
+	u.records = map[string]*ddnsRecord{}
+	u.done = make(chan struct{})
+	go u.run(u.done)
+}
+
+// stop stops the worker if it's running.
+func (u *ddnsUpdater) stop() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.done == nil {
+		return
+	}
+
+	close(u.done)
+	u.done = nil
+}
+
+// run synchronizes the records each time the leases change and periodically,
+// so that expired leases are removed and failed updates are retried.
+func (u *ddnsUpdater) run(done <-chan struct{}) {
+	defer log.OnPanic("ddns")
+
+	ticker := time.NewTicker(defaultDDNSResyncIvl)
+	defer ticker.Stop()
+
+	u.sync()
+	for {
+		select {
+		case <-u.trigger:
+			u.sync()
+		case <-ticker.C:
+			u.sync()
+		case <-done:
+			return
+		}
+	}
+}
+
+// wantRecords returns the records which should be present in the external
+// server according to the current leases.
+func (u *ddnsUpdater) wantRecords() (want map[string]*ddnsRecord) {
+	want = map[string]*ddnsRecord{}
+	for _, l := range u.leases() {
+		if l.Hostname == "" || l.IsBlocklisted() {
+			continue
+		}
+
+		want[l.IP.String()] = &ddnsRecord{
+			host: dns.Fqdn(l.Hostname + "." + u.conf.Zone),
+			ip:   normalizeIP(l.IP),
+		}
+	}
+
+	return want
+}
+
+// sync sends the updates required to make the external server match the
+// current leases.  Failed updates are retried during the next call.
+func (u *ddnsUpdater) sync() {
+	want := u.wantRecords()
+
+	u.mu.Lock()
+	var toDel, toAdd []*ddnsRecord
+	for ipStr, rec := range u.records {
+		if w, ok := want[ipStr]; !ok || w.host != rec.host {
+			toDel = append(toDel, rec)
+		}
+	}
+
+	for ipStr, rec := range want {
+		if have, ok := u.records[ipStr]; !ok || have.host != rec.host {
+			toAdd = append(toAdd, rec)
+		}
+	}
+	u.status.Pending = len(toDel) + len(toAdd)
+	u.mu.Unlock()
+
+	for _, rec := range toDel {
+		u.apply(rec, false)
+	}
+
+	for _, rec := range toAdd {
+		u.apply(rec, true)
+	}
+}
+
+// apply adds or removes rec from the external server and records the result.
+func (u *ddnsUpdater) apply(rec *ddnsRecord, add bool) {
+	err := u.update(rec, add)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.status.Pending--
+	if err != nil {
+		u.status.Failed++
+		u.status.LastError = err.Error()
+		log.Error("ddns: %s", err)
+
+		return
+	}
+
+	u.status.Sent++
+	u.status.LastUpdate = time.Now()
+
+	key := rec.ip.String()
+	if add {
+		u.records[key] = rec
+	} else if have, ok := u.records[key]; ok && have.host == rec.host {
+		delete(u.records, key)
+	}
+}
+
+// update sends the messages adding or removing the address and the pointer
+// records for rec.
+func (u *ddnsUpdater) update(rec *ddnsRecord, add bool) (err error) {
+	action := "removing"
+	if add {
+		action = "adding"
+	}
+
+	defer func() { err = errors.Annotate(err, "%s %s (%s): %w", action, rec.host, rec.ip) }()
+
+	err = u.send(u.addrMsg(rec, add))
+	if err != nil {
+		return fmt.Errorf("address record: %w", err)
+	}
+
+	m := u.ptrMsg(rec, add)
+	if m == nil {
+		return nil
+	}
+
+	err = u.send(m)
+	if err != nil {
+		return fmt.Errorf("pointer record: %w", err)
+	}
+
+	return nil
+}
+
+// addrMsg returns an update message which replaces or removes the A or AAAA
+// record of rec.
+func (u *ddnsUpdater) addrMsg(rec *ddnsRecord, add bool) (m *dns.Msg) {
+	var rr dns.RR
+	hdr := dns.RR_Header{
+		Name:  rec.host,
+		Class: dns.ClassINET,
+		Ttl:   u.conf.TTL,
+	}
+
+	if ip4 := rec.ip.To4(); ip4 != nil {
+		hdr.Rrtype = dns.TypeA
+		rr = &dns.A{Hdr: hdr, A: ip4}
+	} else {
+		hdr.Rrtype = dns.TypeAAAA
+		rr = &dns.AAAA{Hdr: hdr, AAAA: rec.ip}
+	}
+
+	m = &dns.Msg{}
+	m.SetUpdate(u.conf.Zone)
+	if add {
+		m.RemoveRRset([]dns.RR{rr})
+		m.Insert([]dns.RR{rr})
+	} else {
+		m.Remove([]dns.RR{rr})
+	}
+
+	return m
+}
+
+// ptrMsg returns an update message which replaces or removes the PTR record of
+// rec.  m is nil if none of the configured reverse zones contains the address.
+func (u *ddnsUpdater) ptrMsg(rec *ddnsRecord, add bool) (m *dns.Msg) {
+	arpa, err := dns.ReverseAddr(rec.ip.String())
+	if err != nil {
+		// Shouldn't happen, since rec.ip is a valid address.
+		log.Debug("ddns: reversing %s: %s", rec.ip, err)
+
+		return nil
+	}
+
+	zone := ""
+	for _, z := range u.conf.ReverseZones {
+		if dns.IsSubDomain(z, arpa) && len(z) > len(zone) {
+			zone = z
+		}
+	}
+
+	if zone == "" {
+		return nil
+	}
+
+	rr := &dns.PTR{
+		Hdr: dns.RR_Header{
+			Name:   arpa,
+			Rrtype: dns.TypePTR,
+			Class:  dns.ClassINET,
+			Ttl:    u.conf.TTL,
+		},
+		Ptr: rec.host,
+	}
+
+	m = &dns.Msg{}
+	m.SetUpdate(zone)
+	m.RemoveRRset([]dns.RR{rr})
+	if add {
+		m.Insert([]dns.RR{rr})
+	}
+
+	return m
+}
+
+// send signs m if required and sends it to the configured server retrying on
+// failures.
+func (u *ddnsUpdater) send(m *dns.Msg) (err error) {
+	for i := uint(0); i < u.conf.Retries; i++ {
+		if i > 0 {
+			time.Sleep(u.backoff * time.Duration(i))
+		}
+
+		if u.conf.TSIGKeyName != "" {
+			m.SetTsig(u.conf.TSIGKeyName, u.conf.TSIGAlgorithm, defaultDDNSTSIGFudge, time.Now().Unix())
+		}
+
+		var resp *dns.Msg
+		resp, _, err = u.exchanger.Exchange(m, u.conf.Server)
+		if err != nil {
+			log.Debug("ddns: attempt %d: %s", i+1, err)
+
+			continue
+		}
+
+		if resp.Rcode != dns.RcodeSuccess {
+			// The server has refused the update, so there is no reason to
+			// retry it right away.
+			return fmt.Errorf("server responded with %s", dns.RcodeToString[resp.Rcode])
+		}
+
+		return nil
+	}
+
+	return err
+}
+
+// currentStatus returns a copy of the current status of the updater.
+func (u *ddnsUpdater) currentStatus() (st ddnsStatus) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	st = u.status
+	st.Records = len(u.records)
+
+	return st
+}
+
+// ddnsStatusResponse is the response for /control/dhcp/ddns_status endpoint.
+type ddnsStatusResponse struct {
+	*ddnsStatus
+
+	Server  string `json:"server,omitempty"`
+	Zone    string `json:"zone,omitempty"`
+	Enabled bool   `json:"enabled"`
+}
+
+func (s *Server) handleDDNSStatus(w http.ResponseWriter, r *http.Request) {
+	resp := &ddnsStatusResponse{
+		ddnsStatus: &ddnsStatus{},
+	}
+
+	if u := s.ddns; u != nil {
+		st := u.currentStatus()
+		resp.ddnsStatus = &st
+		resp.Server = u.conf.Server
+		resp.Zone = u.conf.Zone
+		resp.Enabled = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "encoding ddns status: %s", err)
+	}
+}
@@ -0,0 +1,268 @@
+package dhcpd
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDDNSServer is a DNS server recording the received update messages.
+type testDDNSServer struct {
+	updates []*dns.Msg
+	rcode   int
+	mu      sync.Mutex
+}
+
+// ServeDNS implements the dns.Handler interface for *testDDNSServer.
+func (s *testDDNSServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := (&dns.Msg{}).SetReply(req)
+
+	s.mu.Lock()
+	if w.TsigStatus() != nil {
+		resp.Rcode = dns.RcodeNotAuth
+	} else {
+		resp.Rcode = s.rcode
+		s.updates = append(s.updates, req)
+	}
+	s.mu.Unlock()
+
+	if t := req.IsTsig(); t != nil {
+		resp.SetTsig(t.Hdr.Name, t.Algorithm, defaultDDNSTSIGFudge, time.Now().Unix())
+	}
+
+	_ = w.WriteMsg(resp)
+}
+
+// received returns the update messages received so far and forgets them.
+func (s *testDDNSServer) received() (updates []*dns.Msg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updates, s.updates = s.updates, nil
+
+	return updates
+}
+
+// startTestDDNSServer starts a UDP DNS server accepting updates signed with
+// the key and returns its address.
+func startTestDDNSServer(t *testing.T, h *testDDNSServer, key, secret string) (addr string) {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn:        pc,
+		Handler:           h,
+		TsigSecret:        map[string]string{key: secret},
+		NotifyStartedFunc: func() { close(started) },
+		// Accept the UPDATE messages, which the default function rejects.
+		MsgAcceptFunc: func(_ dns.Header) (act dns.MsgAcceptAction) {
+			return dns.MsgAccept
+		},
+	}
+
+	go func() { _ = srv.ActivateAndServe() }()
+	<-started
+
+	testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+
+	return pc.LocalAddr().String()
+}
+
+func TestDDNSUpdater_sync(t *testing.T) {
+	const (
+		key    = "dhcp-key."
+		secret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+	)
+
+	h := &testDDNSServer{rcode: dns.RcodeSuccess}
+	addr := startTestDDNSServer(t, h, key, secret)
+
+	leases := []*Lease{{
+		Expiry:   time.Now().Add(time.Hour),
+		Hostname: "host-1",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+		IP:       net.IP{192, 168, 10, 100},
+	}, {
+		Expiry: time.Now().Add(time.Hour),
+		HWAddr: net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xBB},
+		IP:     net.IP{192, 168, 10, 101},
+	}}
+	allLeases := leases
+
+	u, err := newDDNSUpdater(&DDNSConfig{
+		Enabled:      true,
+		Server:       addr,
+		Zone:         "lan",
+		ReverseZones: []string{"10.168.192.in-addr.arpa", "168.192.in-addr.arpa"},
+		TSIGKeyName:  key,
+		TSIGSecret:   secret,
+	}, func() []*Lease { return leases })
+	require.NoError(t, err)
+
+	t.Run("add", func(t *testing.T) {
+		u.sync()
+
+		updates := h.received()
+		require.Len(t, updates, 2)
+
+		addrUpd := updates[0]
+		require.Len(t, addrUpd.Question, 1)
+		assert.Equal(t, "lan.", addrUpd.Question[0].Name)
+		require.Len(t, addrUpd.Ns, 2)
+
+		a, ok := addrUpd.Ns[1].(*dns.A)
+		require.True(t, ok)
+
+		assert.Equal(t, "host-1.lan.", a.Hdr.Name)
+		assert.Equal(t, defaultDDNSTTL, a.Hdr.Ttl)
+		assert.True(t, a.A.Equal(leases[0].IP))
+
+		ptrUpd := updates[1]
+		require.Len(t, ptrUpd.Question, 1)
+		assert.Equal(t, "10.168.192.in-addr.arpa.", ptrUpd.Question[0].Name)
+		require.Len(t, ptrUpd.Ns, 2)
+
+		ptr, ok := ptrUpd.Ns[1].(*dns.PTR)
+		require.True(t, ok)
+
+		assert.Equal(t, "100.10.168.192.in-addr.arpa.", ptr.Hdr.Name)
+		assert.Equal(t, "host-1.lan.", ptr.Ptr)
+
+		st := u.currentStatus()
+		assert.Equal(t, 1, st.Records)
+		assert.Equal(t, uint64(1), st.Sent)
+		assert.Zero(t, st.Failed)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		u.sync()
+
+		assert.Empty(t, h.received())
+	})
+
+	t.Run("server_stopped", func(t *testing.T) {
+		// Stopping the server keeps the leases, so the records are kept as
+		// well.
+		u.onLeaseChanged(LeaseChangedRemovedAll)
+
+		assert.Empty(t, h.received())
+		assert.Equal(t, 1, u.currentStatus().Records)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		leases = leases[1:]
+		u.sync()
+
+		updates := h.received()
+		require.Len(t, updates, 2)
+
+		require.Len(t, updates[0].Ns, 1)
+		a, ok := updates[0].Ns[0].(*dns.A)
+		require.True(t, ok)
+
+		assert.Equal(t, uint16(dns.ClassNONE), a.Hdr.Class)
+		assert.Equal(t, "host-1.lan.", a.Hdr.Name)
+
+		st := u.currentStatus()
+		assert.Zero(t, st.Records)
+		assert.Equal(t, uint64(2), st.Sent)
+	})
+
+	t.Run("remove_all", func(t *testing.T) {
+		leases = allLeases
+		u.sync()
+		require.Len(t, h.received(), 2)
+		require.Equal(t, 1, u.currentStatus().Records)
+
+		u.removeAll()
+
+		updates := h.received()
+		require.Len(t, updates, 2)
+
+		require.Len(t, updates[0].Ns, 1)
+		a, ok := updates[0].Ns[0].(*dns.A)
+		require.True(t, ok)
+
+		assert.Equal(t, uint16(dns.ClassNONE), a.Hdr.Class)
+		assert.Equal(t, "host-1.lan.", a.Hdr.Name)
+
+		st := u.currentStatus()
+		assert.Zero(t, st.Records)
+		assert.Zero(t, st.Pending)
+	})
+}
+
+func TestDDNSUpdater_sync_failed(t *testing.T) {
+	const (
+		key    = "dhcp-key."
+		secret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+	)
+
+	h := &testDDNSServer{rcode: dns.RcodeRefused}
+	addr := startTestDDNSServer(t, h, key, secret)
+
+	leases := []*Lease{{
+		Expiry:   time.Now().Add(time.Hour),
+		Hostname: "host-1",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+		IP:       net.IP{192, 168, 10, 100},
+	}}
+
+	u, err := newDDNSUpdater(&DDNSConfig{
+		Enabled:     true,
+		Server:      addr,
+		Zone:        "lan.",
+		TSIGKeyName: key,
+		TSIGSecret:  "d3Jvbmd3cm9uZ3dyb25nd3Jvbmd3cm9uZw==",
+	}, func() []*Lease { return leases })
+	require.NoError(t, err)
+
+	u.backoff = 0
+
+	u.sync()
+	assert.Empty(t, h.received())
+
+	st := u.currentStatus()
+	assert.Zero(t, st.Records)
+	assert.Equal(t, uint64(1), st.Failed)
+	assert.Contains(t, st.LastError, "bad authentication")
+
+	// Fix the key and make sure the failed update is retried.
+	u.exchanger.(*dns.Client).TsigSecret[key] = secret
+	u.sync()
+	assert.Len(t, h.received(), 1)
+
+	st = u.currentStatus()
+	assert.Zero(t, st.Records)
+	assert.Equal(t, uint64(2), st.Failed)
+	assert.Contains(t, st.LastError, "REFUSED")
+}
+
+func TestNewDDNSUpdater(t *testing.T) {
+	_, err := newDDNSUpdater(&DDNSConfig{Server: "127.0.0.1"}, nil)
+	testutil.AssertErrorMsg(t, "ddns: no zone specified", err)
+
+	conf := &DDNSConfig{
+		Server:      "127.0.0.1",
+		Zone:        "LAN",
+		TSIGKeyName: "key",
+	}
+
+	_, err = newDDNSUpdater(conf, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "127.0.0.1:53", conf.Server)
+	assert.Equal(t, "lan.", conf.Zone)
+	assert.Equal(t, "key.", conf.TSIGKeyName)
+	assert.Equal(t, dns.HmacSHA256, conf.TSIGAlgorithm)
+	assert.Equal(t, defaultDDNSRetries, conf.Retries)
+}
@@ -2,16 +2,20 @@
 package dhcpd
 
 import (
+	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/stringutil"
 )
 
 const (
@@ -34,6 +38,16 @@ type Lease struct {
 	Hostname string           `json:"hostname"`
 	HWAddr   net.HardwareAddr `json:"mac"`
 	IP       net.IP           `json:"ip"`
+
+	// Options are the custom DHCPv4 options of a static lease in the same
+	// format as the options of the server.  These override all other
+	// options.
+	Options []string `json:"options,omitempty"`
+
+	// DUID is the DHCP unique identifier of a static DHCPv6 lease.  If it's
+	// not empty, the lease is only given to the client with this DUID
+	// regardless of its hardware address.
+	DUID []byte `json:"duid,omitempty"`
 }
 
 // Clone returns a deep copy of l.
@@ -47,6 +61,8 @@ func (l *Lease) Clone() (clone *Lease) {
 		Hostname: l.Hostname,
 		HWAddr:   netutil.CloneMAC(l.HWAddr),
 		IP:       netutil.CloneIP(l.IP),
+		Options:  stringutil.CloneSlice(l.Options),
+		DUID:     cloneBytes(l.DUID),
 	}
 }
 
@@ -90,10 +106,12 @@ func (l Lease) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
 		HWAddr string `json:"mac"`
 		Expiry string `json:"expires,omitempty"`
+		DUID   string `json:"duid,omitempty"`
 		lease
 	}{
 		HWAddr: l.HWAddr.String(),
 		Expiry: expiryStr,
+		DUID:   formatDUID(l.DUID),
 		lease:  lease(l),
 	})
 }
@@ -104,6 +122,7 @@ func (l *Lease) UnmarshalJSON(data []byte) (err error) {
 	aux := struct {
 		*lease
 		HWAddr string `json:"mac"`
+		DUID   string `json:"duid"`
 	}{
 		lease: (*lease)(l),
 	}
@@ -111,6 +130,19 @@ func (l *Lease) UnmarshalJSON(data []byte) (err error) {
 		return err
 	}
 
+	if aux.DUID != "" {
+		l.DUID, err = parseDUID(aux.DUID)
+		if err != nil {
+			return fmt.Errorf("couldn't parse DUID: %w", err)
+		}
+
+		// The hardware address is optional for the leases identified
+		// by DUID.
+		if aux.HWAddr == "" {
+			return nil
+		}
+	}
+
 	l.HWAddr, err = net.ParseMAC(aux.HWAddr)
 	if err != nil {
 		return fmt.Errorf("couldn't parse MAC address: %w", err)
@@ -119,6 +151,111 @@ func (l *Lease) UnmarshalJSON(data []byte) (err error) {
 	return nil
 }
 
+// maxDUIDLen is the maximum length of a DUID.  See RFC 8415, section 11.1.
+const maxDUIDLen = 130
+
+// parseDUID parses a DUID in the form of hexadecimal bytes optionally
+// separated by colons, for example "00:03:00:01:aa:bb:cc:dd:ee:ff".
+func parseDUID(s string) (duid []byte, err error) {
+	duid, err = hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	if l := len(duid); l < 3 || l > maxDUIDLen {
+		return nil, fmt.Errorf("bad duid length %d", l)
+	}
+
+	return duid, nil
+}
+
+// formatDUID returns the string representation of duid in the same form as
+// parseDUID accepts.
+func formatDUID(duid []byte) (s string) {
+	// net.HardwareAddr formats the bytes of any length the same way.
+	return net.HardwareAddr(duid).String()
+}
+
+// sameClient returns true if a and b belong to the same client.  The DUIDs
+// are compared if both leases have them, and the hardware addresses are
+// compared otherwise.
+func sameClient(a, b *Lease) (ok bool) {
+	if len(a.DUID) > 0 && len(b.DUID) > 0 {
+		return bytes.Equal(a.DUID, b.DUID)
+	}
+
+	return len(a.HWAddr) > 0 && bytes.Equal(a.HWAddr, b.HWAddr)
+}
+
+// cloneBytes returns a copy of b.  It returns nil if b is nil.
+func cloneBytes(b []byte) (clone []byte) {
+	if b == nil {
+		return nil
+	}
+
+	return append([]byte{}, b...)
+}
+
+// PrefixLease contains the necessary information about an IPv6 prefix
+// delegated to a router.
+type PrefixLease struct {
+	// Expiry is the expiration time of the lease.  The unix timestamp value
+	// of 1 means that this is a static reservation.
+	Expiry time.Time
+
+	// HWAddr is the hardware address of the router.
+	HWAddr net.HardwareAddr
+
+	// Prefix is the delegated prefix.
+	Prefix *net.IPNet
+
+	// held is true if the prefix is only reserved for the router and isn't
+	// committed yet.  Expiry is the end of the reservation then.
+	held bool
+}
+
+// Clone returns a deep copy of l.
+func (l *PrefixLease) Clone() (clone *PrefixLease) {
+	if l == nil {
+		return nil
+	}
+
+	return &PrefixLease{
+		Expiry: l.Expiry,
+		HWAddr: netutil.CloneMAC(l.HWAddr),
+		Prefix: &net.IPNet{
+			IP:   netutil.CloneIP(l.Prefix.IP),
+			Mask: net.IPMask(netutil.CloneIP(net.IP(l.Prefix.Mask))),
+		},
+		held: l.held,
+	}
+}
+
+// IsStatic returns true if the lease is a static reservation.
+func (l *PrefixLease) IsStatic() (ok bool) {
+	return l != nil && l.Expiry.Unix() == leaseExpireStatic
+}
+
+// MarshalJSON implements the json.Marshaler interface for PrefixLease.
+func (l PrefixLease) MarshalJSON() ([]byte, error) {
+	var expiryStr string
+	if !l.IsStatic() {
+		expiryStr = l.Expiry.Format(time.RFC3339)
+	}
+
+	return json.Marshal(&struct {
+		HWAddr string `json:"mac"`
+		Prefix string `json:"prefix"`
+		Expiry string `json:"expires,omitempty"`
+		Static bool   `json:"static"`
+	}{
+		HWAddr: l.HWAddr.String(),
+		Prefix: l.Prefix.String(),
+		Expiry: expiryStr,
+		Static: l.IsStatic(),
+	})
+}
+
 // ServerConfig - DHCP server configuration
 // field ordering is important -- yaml fields will mirror ordering from here
 type ServerConfig struct {
@@ -128,6 +265,21 @@ type ServerConfig struct {
 	Conf4 V4ServerConf `yaml:"dhcpv4"`
 	Conf6 V6ServerConf `yaml:"dhcpv6"`
 
+	// DDNS is the configuration of dynamic DNS updates for the leases.
+	DDNS DDNSConfig `yaml:"ddns"`
+
+	// Hooks are the scripts and webhooks fired on the changes of the
+	// leases.
+	Hooks []*LeaseHookConfig `yaml:"hooks"`
+
+	// Failover is the configuration of the lease synchronization with
+	// another instance.
+	Failover FailoverConfig `yaml:"failover"`
+
+	// Monitor is the configuration of the background monitor of other DHCP
+	// servers in the network.
+	Monitor MonitorConfig `yaml:"monitor"`
+
 	WorkDir    string `yaml:"-"`
 	DBFilePath string `yaml:"-"` // path to DB file
 
@@ -158,6 +310,25 @@ type Server struct {
 
 	conf ServerConfig
 
+	// journal stores the leases on disk.
+	journal *leaseJournal
+
+	// ddns sends the dynamic DNS updates for the leases.  It's nil if the
+	// updates are disabled.
+	ddns *ddnsUpdater
+
+	// hooks runs the hooks on the changes of the leases.  It's nil if there
+	// are no hooks configured.
+	hooks *leaseHooks
+
+	// failover synchronizes the leases with the peer.  It's nil if the
+	// failover is disabled.
+	failover *failover
+
+	// monitor probes the network for other DHCP servers.  It's nil if the
+	// monitor is disabled.
+	monitor *dhcpMonitor
+
 	// Called when the leases DB is modified
 	onLeaseChanged []OnLeaseChangedT
 }
@@ -189,6 +360,7 @@ func Create(conf ServerConfig) (s *Server, err error) {
 	s.conf.HTTPRegister = conf.HTTPRegister
 	s.conf.ConfigModified = conf.ConfigModified
 	s.conf.DBFilePath = filepath.Join(conf.WorkDir, dbFilename)
+	s.journal = newLeaseJournal(s.conf.DBFilePath)
 
 	if !webHandlersRegistered && s.conf.HTTPRegister != nil {
 		if runtime.GOOS == "windows" {
@@ -208,6 +380,18 @@ func Create(conf ServerConfig) (s *Server, err error) {
 		webHandlersRegistered = true
 	}
 
+	if conf.Failover.Enabled {
+		failoverConf := conf.Failover
+		s.failover, err = newFailover(&failoverConf, func() (leases []*Lease) {
+			return s.Leases(LeasesDynamic)
+		}, s.syncLease)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	serves, owns := s.failoverFuncs()
+
 	v4conf := conf.Conf4
 	v4conf.Enabled = s.conf.Enabled
 	if len(v4conf.RangeStart) == 0 {
@@ -216,6 +400,8 @@ func Create(conf ServerConfig) (s *Server, err error) {
 
 	v4conf.InterfaceName = s.conf.InterfaceName
 	v4conf.notify = s.onNotify
+	v4conf.serves = serves
+	v4conf.owns = owns
 	s.srv4, err = v4Create(v4conf)
 	if err != nil {
 		return nil, fmt.Errorf("creating dhcpv4 srv: %w", err)
@@ -223,11 +409,13 @@ func Create(conf ServerConfig) (s *Server, err error) {
 
 	v6conf := conf.Conf6
 	v6conf.Enabled = s.conf.Enabled
-	if len(v6conf.RangeStart) == 0 {
+	if len(v6conf.RangeStart) == 0 && !v6conf.Stateless {
 		v6conf.Enabled = false
 	}
 	v6conf.InterfaceName = s.conf.InterfaceName
 	v6conf.notify = s.onNotify
+	v6conf.serves = serves
+	v6conf.owns = owns
 	s.srv6, err = v6Create(v6conf)
 	if err != nil {
 		return nil, fmt.Errorf("creating dhcpv6 srv: %w", err)
@@ -235,6 +423,10 @@ func Create(conf ServerConfig) (s *Server, err error) {
 
 	s.conf.Conf4 = conf.Conf4
 	s.conf.Conf6 = conf.Conf6
+	s.conf.DDNS = conf.DDNS
+	s.conf.Hooks = conf.Hooks
+	s.conf.Failover = conf.Failover
+	s.conf.Monitor = conf.Monitor
 
 	if s.conf.Enabled && !v4conf.Enabled && !v6conf.Enabled {
 		return nil, fmt.Errorf("neither dhcpv4 nor dhcpv6 srv is configured")
@@ -247,15 +439,72 @@ func Create(conf ServerConfig) (s *Server, err error) {
 		return nil, fmt.Errorf("loading db: %w", err)
 	}
 
+	if s.conf.DDNS.Enabled {
+		ddnsConf := s.conf.DDNS
+		ddnsConf.ReverseZones = append([]string(nil), ddnsConf.ReverseZones...)
+		s.ddns, err = newDDNSUpdater(&ddnsConf, func() (leases []*Lease) {
+			return s.Leases(LeasesAll)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		s.SetOnLeaseChanged(s.ddns.onLeaseChanged)
+	}
+
+	if len(s.conf.Hooks) > 0 {
+		s.hooks, err = newLeaseHooks(s.conf.Hooks, func() (leases []*Lease) {
+			return s.Leases(LeasesAll)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating hooks: %w", err)
+		}
+
+		s.SetOnLeaseChanged(s.hooks.onLeaseChanged)
+	}
+
+	if s.failover != nil {
+		s.SetOnLeaseChanged(s.failover.onLeaseChanged)
+	}
+
+	if s.conf.Monitor.Enabled {
+		s.monitor, err = newDHCPMonitor(&s.conf.Monitor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return s, nil
 }
 
+// failoverFuncs returns the functions limiting the clients served by the
+// DHCP servers and the free addresses they allocate when the failover is
+// enabled.  Both are nil otherwise.
+func (s *Server) failoverFuncs() (serves func(id []byte) (ok bool), owns func(offset, n uint64) (ok bool)) {
+	if s.failover == nil {
+		return nil, nil
+	}
+
+	return s.failover.serves, s.failover.owns
+}
+
 // Enabled returns true when the server is enabled.
 func (s *Server) Enabled() (ok bool) {
 	return s.conf.Enabled
 }
 
-// resetLeases resets all leases in the lease database.
+// UnexpectedServers returns the DHCP servers found in the network which aren't
+// allowed by the configuration of the monitor.
+func (s *Server) UnexpectedServers() (servers []*OtherServer) {
+	if s.monitor == nil {
+		return nil
+	}
+
+	return s.monitor.list(true)
+}
+
+// resetLeases resets all leases in the lease database and removes their
+// records from the external DNS server.
 func (s *Server) resetLeases() (err error) {
 	err = s.srv4.ResetLeases(nil)
 	if err != nil {
@@ -269,6 +518,10 @@ func (s *Server) resetLeases() (err error) {
 		}
 	}
 
+	if s.ddns != nil {
+		s.ddns.removeAll()
+	}
+
 	return s.dbStore()
 }
 
@@ -280,6 +533,20 @@ func (s *Server) onNotify(flags uint32) {
 			log.Error("updating db: %s", err)
 		}
 
+		// Releases and expirations of the leases are only signaled by
+		// storing the database.
+		if s.ddns != nil {
+			s.ddns.onLeaseChanged(int(flags))
+		}
+
+		if s.hooks != nil {
+			s.hooks.onLeaseChanged(int(flags))
+		}
+
+		if s.failover != nil {
+			s.failover.onLeaseChanged(int(flags))
+		}
+
 		return
 	}
 
@@ -305,6 +572,10 @@ func (s *Server) notify(flags int) {
 func (s *Server) WriteDiskConfig(c *ServerConfig) {
 	c.Enabled = s.conf.Enabled
 	c.InterfaceName = s.conf.InterfaceName
+	c.DDNS = s.conf.DDNS
+	c.Hooks = s.conf.Hooks
+	c.Failover = s.conf.Failover
+	c.Monitor = s.conf.Monitor
 	s.srv4.WriteDiskConfig4(&c.Conf4)
 	s.srv6.WriteDiskConfig6(&c.Conf6)
 }
@@ -321,11 +592,46 @@ func (s *Server) Start() (err error) {
 		return err
 	}
 
+	if s.ddns != nil {
+		s.ddns.start()
+	}
+
+	if s.hooks != nil {
+		s.hooks.start()
+	}
+
+	if s.failover != nil {
+		err = s.failover.start()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.monitor != nil {
+		s.monitor.start(s.conf.InterfaceName)
+	}
+
 	return nil
 }
 
 // Stop closes the listening UDP socket
 func (s *Server) Stop() (err error) {
+	if s.ddns != nil {
+		s.ddns.stop()
+	}
+
+	if s.hooks != nil {
+		s.hooks.stop()
+	}
+
+	if s.failover != nil {
+		s.failover.stop()
+	}
+
+	if s.monitor != nil {
+		s.monitor.stop()
+	}
+
 	err = s.srv4.Stop()
 	if err != nil {
 		return err
@@ -336,7 +642,16 @@ func (s *Server) Stop() (err error) {
 		return err
 	}
 
-	return nil
+	return s.journal.close()
+}
+
+// syncLease applies the lease received from the failover peer.
+func (s *Server) syncLease(l *Lease, remove bool) (err error) {
+	if l.IP.To4() != nil {
+		return s.srv4.syncLease(l, remove)
+	}
+
+	return s.srv6.syncLease(l, remove)
 }
 
 // Leases returns the list of active IPv4 and IPv6 DHCP leases.  It's safe for
@@ -4,8 +4,9 @@
 package dhcpd
 
 import (
+	"encoding/json"
 	"net"
-	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -26,10 +27,12 @@ func testNotify(flags uint32) {
 // Leases database store/load.
 func TestDB(t *testing.T) {
 	var err error
+	dbFilePath := filepath.Join(t.TempDir(), dbFilename)
 	s := Server{
 		conf: ServerConfig{
-			DBFilePath: dbFilename,
+			DBFilePath: dbFilePath,
 		},
+		journal: newLeaseJournal(dbFilePath),
 	}
 
 	s.srv4, err = v4Create(V4ServerConf{
@@ -68,8 +71,6 @@ func TestDB(t *testing.T) {
 	err = s.dbStore()
 	require.NoError(t, err)
 
-	testutil.CleanupAndRequireSuccess(t, func() (err error) { return os.Remove(dbFilename) })
-
 	err = s.srv4.ResetLeases(nil)
 	require.NoError(t, err)
 
@@ -137,6 +138,38 @@ func TestNormalizeLeases(t *testing.T) {
 	assert.Equal(t, leases[2].HWAddr, dynLeases[1].HWAddr)
 }
 
+func TestNormalizeLeases_duid(t *testing.T) {
+	staticLeases := []*Lease{{
+		DUID: []byte{0, 4, 1},
+	}, {
+		DUID: []byte{0, 4, 2},
+	}}
+
+	dynLeases := []*Lease{{
+		HWAddr: net.HardwareAddr{1, 2, 3, 4, 5, 6},
+	}}
+
+	leases := normalizeLeases(staticLeases, dynLeases)
+	assert.Len(t, leases, 3)
+}
+
+func TestLease_UnmarshalJSON_duid(t *testing.T) {
+	l := &Lease{}
+	err := json.Unmarshal([]byte(`{"ip":"2001::10","duid":"00:04:01:02:03"}`), l)
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte{0, 4, 1, 2, 3}, l.DUID)
+	assert.Empty(t, l.HWAddr)
+
+	data, err := json.Marshal(l)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(data), `"duid":"00:04:01:02:03"`)
+
+	err = json.Unmarshal([]byte(`{"ip":"2001::10","duid":"00:04"}`), l)
+	testutil.AssertErrorMsg(t, "couldn't parse DUID: bad duid length 2", err)
+}
+
 func TestV4Server_badRange(t *testing.T) {
 	testCases := []struct {
 		name       string
@@ -0,0 +1,887 @@
+package dhcpd
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// Failover roles.
+const (
+	failoverRolePrimary   = "primary"
+	failoverRoleSecondary = "secondary"
+)
+
+// Failover modes.
+const (
+	// failoverModeHotStandby means that the secondary only serves the
+	// clients while the primary is down.
+	failoverModeHotStandby = "hot_standby"
+
+	// failoverModeLoadBalance means that the clients are split between the
+	// peers by the hash of their identifiers.
+	failoverModeLoadBalance = "load_balance"
+)
+
+// Failover states.
+const (
+	// failoverStateNormal means that the peers are connected.
+	failoverStateNormal = "normal"
+
+	// failoverStateInterrupted means that the connection to the peer has
+	// been lost for less than the maximum response delay.
+	failoverStateInterrupted = "communications_interrupted"
+
+	// failoverStatePartnerDown means that the peer is considered down, so
+	// this instance serves all clients.
+	failoverStatePartnerDown = "partner_down"
+)
+
+// Default values for FailoverConfig fields and other failover parameters.
+const (
+	defaultFailoverMaxDelay = 30 * time.Second
+	defaultFailoverMCLT     = 1 * time.Hour
+	defaultFailoverRetryIvl = 5 * time.Second
+	failoverNonceLen        = 32
+	maxFailoverMsgLen       = 64 * 1024
+)
+
+// Types of the failover messages.
+const (
+	failoverMsgHello     = "hello"
+	failoverMsgHeartbeat = "heartbeat"
+	failoverMsgLease     = "lease"
+)
+
+// FailoverConfig is the configuration of the lease synchronization with
+// another AdGuard Home instance serving the same network.
+type FailoverConfig struct {
+	// Enabled defines if the leases are synchronized with the peer.
+	Enabled bool `yaml:"enabled"`
+
+	// Role is either "primary" or "secondary".  The secondary connects to
+	// the primary.
+	Role string `yaml:"role"`
+
+	// Mode is either "hot_standby", in which the secondary only serves the
+	// clients while the primary is down, or "load_balance", in which the
+	// clients are split between the peers by the hash of their identifiers.
+	Mode string `yaml:"mode"`
+
+	// ListenAddress is the TCP address the primary accepts the secondary
+	// on, for example "192.168.1.1:6747".
+	ListenAddress string `yaml:"listen_address"`
+
+	// PeerAddress is the TCP address of the primary the secondary connects
+	// to.
+	PeerAddress string `yaml:"peer_address"`
+
+	// Secret is the secret shared by the peers.  It's used to authenticate
+	// the peers and every message they exchange.
+	Secret string `yaml:"secret"`
+
+	// MaxResponseDelay is the time after which the peer which doesn't
+	// respond is considered down, so that this instance serves all clients.
+	// The default is 30 seconds.
+	MaxResponseDelay timeutil.Duration `yaml:"max_response_delay"`
+
+	// MaxClientLeadTime is the time after entering the partner_down state
+	// after which this instance also allocates the free addresses from the
+	// peer's part of the pool.  The default is one hour.
+	MaxClientLeadTime timeutil.Duration `yaml:"max_client_lead_time"`
+}
+
+// failoverMsg is a single message exchanged by the peers.
+type failoverMsg struct {
+	// Lease is the synchronized lease.  It's only set in the lease
+	// messages.
+	Lease *leaseJSON `json:"lease,omitempty"`
+
+	// Type is the type of the message.
+	Type string `json:"type"`
+
+	// Role and Mode are the role and the mode of the sender.  They're only
+	// set in the hello messages.
+	Role string `json:"role,omitempty"`
+	Mode string `json:"mode,omitempty"`
+
+	// Nonce is the random data used to derive the keys of the session.  It's
+	// only set in the hello messages.
+	Nonce []byte `json:"nonce,omitempty"`
+
+	// Remove is true if the lease has been removed by the sender.
+	Remove bool `json:"remove,omitempty"`
+}
+
+// failoverConn is an established connection to the peer.  Each message, except
+// the hello ones, is followed by the HMAC-SHA256 of its sequence number and
+// its data, computed with the key of the sending peer.
+type failoverConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	// sendKey and recvKey are the keys of the sent and received messages.
+	// They're nil until the hello messages are exchanged.
+	sendKey []byte
+	recvKey []byte
+
+	// mu protects sendSeq and the writes to conn.
+	mu sync.Mutex
+
+	sendSeq uint64
+	recvSeq uint64
+	timeout time.Duration
+}
+
+// newFailoverConn returns a new connection to the peer over conn.
+func newFailoverConn(conn net.Conn, timeout time.Duration) (c *failoverConn) {
+	return &failoverConn{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		timeout: timeout,
+	}
+}
+
+// messageMAC returns the authentication code of the message data with the
+// sequence number seq.
+func messageMAC(key []byte, seq uint64, data []byte) (sum []byte) {
+	h := hmac.New(sha256.New, key)
+
+	var seqData [8]byte
+	binary.BigEndian.PutUint64(seqData[:], seq)
+	_, _ = h.Write(seqData[:])
+	_, _ = h.Write(data)
+
+	return h.Sum(nil)
+}
+
+// write sends msg to the peer.  It's safe for concurrent use.
+func (c *failoverConn) write(msg *failoverMsg) (err error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("encoding message: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	frame := make([]byte, 4, 4+len(data)+sha256.Size)
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	frame = append(frame, data...)
+	if c.sendKey != nil {
+		frame = append(frame, messageMAC(c.sendKey, c.sendSeq, data)...)
+		c.sendSeq++
+	}
+
+	err = c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return fmt.Errorf("setting deadline: %w", err)
+	}
+
+	_, err = c.conn.Write(frame)
+
+	return err
+}
+
+// read receives the next message from the peer.  It's not safe for concurrent
+// use.
+func (c *failoverConn) read() (msg *failoverMsg, err error) {
+	err = c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return nil, fmt.Errorf("setting deadline: %w", err)
+	}
+
+	var hdr [4]byte
+	_, err = io.ReadFull(c.r, hdr[:])
+	if err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxFailoverMsgLen {
+		return nil, fmt.Errorf("message too long: %d bytes", n)
+	}
+
+	macLen := 0
+	if c.recvKey != nil {
+		macLen = sha256.Size
+	}
+
+	buf := make([]byte, int(n)+macLen)
+	_, err = io.ReadFull(c.r, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	data := buf[:n]
+	if c.recvKey != nil {
+		if !hmac.Equal(buf[n:], messageMAC(c.recvKey, c.recvSeq, data)) {
+			return nil, errors.Error("bad message authentication code")
+		}
+
+		c.recvSeq++
+	}
+
+	msg = &failoverMsg{}
+	err = json.Unmarshal(data, msg)
+	if err != nil {
+		return nil, fmt.Errorf("decoding message: %w", err)
+	}
+
+	return msg, nil
+}
+
+// failover synchronizes the dynamic leases with the peer and decides which of
+// the peers serves a client.
+type failover struct {
+	conf *FailoverConfig
+
+	// leases returns the current leases of the server.
+	leases func() (leases []*Lease)
+
+	// apply applies the lease received from the peer.
+	apply func(l *Lease, remove bool) (err error)
+
+	// trigger signals the worker that the leases have changed.
+	trigger chan struct{}
+
+	// done is closed to stop the workers.  It's nil when the workers aren't
+	// running.
+	done chan struct{}
+
+	// listener accepts the connections from the secondary.  It's only set
+	// for the primary.
+	listener net.Listener
+
+	// conn is the current connection to the peer.  It's nil if the peers
+	// aren't connected.
+	conn *failoverConn
+
+	// lastSeen is the time of the last message from the peer or the time
+	// the failover has been started.
+	lastSeen time.Time
+
+	// known are the leases the peer is known to have, keyed by the string
+	// representation of the IP address.  It's nil if the peers aren't
+	// connected.
+	known map[string]*Lease
+
+	// secret is the shared secret.
+	secret []byte
+
+	// mu protects done, listener, conn, and lastSeen.
+	mu sync.Mutex
+
+	// syncMu protects known.
+	syncMu sync.Mutex
+
+	// maxDelay is the maximum response delay of the peer.
+	maxDelay time.Duration
+
+	// mclt is the maximum client lead time.
+	mclt time.Duration
+}
+
+// newFailover validates conf and returns a new failover for the leases.
+func newFailover(
+	conf *FailoverConfig,
+	leases func() []*Lease,
+	apply func(l *Lease, remove bool) (err error),
+) (f *failover, err error) {
+	defer func() { err = errors.Annotate(err, "failover: %w") }()
+
+	switch conf.Role {
+	case failoverRolePrimary:
+		if conf.ListenAddress == "" {
+			return nil, errors.Error("primary requires listen_address")
+		}
+	case failoverRoleSecondary:
+		if conf.PeerAddress == "" {
+			return nil, errors.Error("secondary requires peer_address")
+		}
+	default:
+		return nil, fmt.Errorf("bad role %q", conf.Role)
+	}
+
+	switch conf.Mode {
+	case failoverModeHotStandby, failoverModeLoadBalance:
+		// Go on.
+	default:
+		return nil, fmt.Errorf("bad mode %q", conf.Mode)
+	}
+
+	if conf.Secret == "" {
+		return nil, errors.Error("no secret specified")
+	}
+
+	f = &failover{
+		conf:     conf,
+		leases:   leases,
+		apply:    apply,
+		trigger:  make(chan struct{}, 1),
+		secret:   []byte(conf.Secret),
+		maxDelay: conf.MaxResponseDelay.Duration,
+		mclt:     conf.MaxClientLeadTime.Duration,
+	}
+
+	if f.maxDelay <= 0 {
+		f.maxDelay = defaultFailoverMaxDelay
+	}
+
+	if f.mclt <= 0 {
+		f.mclt = defaultFailoverMCLT
+	}
+
+	return f, nil
+}
+
+// peerRole returns the role of the peer.
+func (f *failover) peerRole() (role string) {
+	if f.conf.Role == failoverRolePrimary {
+		return failoverRoleSecondary
+	}
+
+	return failoverRolePrimary
+}
+
+// onLeaseChanged is the OnLeaseChangedT callback of the failover.  It never
+// blocks, so it's also safe to call it within locked sections.
+func (f *failover) onLeaseChanged(_ int) {
+	select {
+	case f.trigger <- struct{}{}:
+	default:
+		// Synchronization is already pending.
+	}
+}
+
+// state returns the current state of the failover and the time the peer has
+// been last seen.
+func (f *failover) state() (st string, lastSeen time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case f.conn != nil:
+		return failoverStateNormal, f.lastSeen
+	case time.Since(f.lastSeen) < f.maxDelay:
+		return failoverStateInterrupted, f.lastSeen
+	default:
+		return failoverStatePartnerDown, f.lastSeen
+	}
+}
+
+// serves returns true if this instance should answer the client with the
+// identifier, a hardware address or a DUID.
+func (f *failover) serves(id []byte) (ok bool) {
+	if st, _ := f.state(); st == failoverStatePartnerDown {
+		return true
+	}
+
+	primary := f.conf.Role == failoverRolePrimary
+	if f.conf.Mode == failoverModeHotStandby {
+		return primary
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write(id)
+
+	return primary == (h.Sum32()%2 == 0)
+}
+
+// owns returns true if this instance may allocate the free address with the
+// offset within a pool of n addresses.  In the load balancing mode the primary
+// owns the first half of the pool and the secondary owns the second one, so
+// that the peers never offer the same address to different clients.  In the
+// hot standby mode the primary owns the whole pool.  The peer's part is only
+// used after the peer has been down for the maximum client lead time, since
+// the peer might still be serving its clients.
+func (f *failover) owns(offset, n uint64) (ok bool) {
+	primary := f.conf.Role == failoverRolePrimary
+	if f.conf.Mode == failoverModeHotStandby {
+		ok = primary
+	} else {
+		ok = primary == (offset < (n+1)/2)
+	}
+
+	if ok {
+		return true
+	}
+
+	st, lastSeen := f.state()
+
+	return st == failoverStatePartnerDown && time.Since(lastSeen) >= f.maxDelay+f.mclt
+}
+
+// start starts the workers if they aren't running yet.
+func (f *failover) start() (err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.done != nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	if f.conf.Role == failoverRolePrimary {
+		f.listener, err = net.Listen("tcp", f.conf.ListenAddress)
+		if err != nil {
+			return fmt.Errorf("failover: listening: %w", err)
+		}
+
+		go f.accept(f.listener, done)
+	} else {
+		go f.dial(done)
+	}
+
+	f.done = done
+	f.lastSeen = time.Now()
+	go f.sync(done)
+
+	return nil
+}
+
+// stop stops the workers and closes the connections if they're running.
+func (f *failover) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.done == nil {
+		return
+	}
+
+	close(f.done)
+	f.done = nil
+
+	if f.listener != nil {
+		log.OnCloserError(f.listener, log.DEBUG)
+		f.listener = nil
+	}
+
+	if f.conn != nil {
+		log.OnCloserError(f.conn.conn, log.DEBUG)
+		f.conn = nil
+	}
+}
+
+// accept accepts the connections from the secondary.
+func (f *failover) accept(l net.Listener, done <-chan struct{}) {
+	defer log.OnPanic("dhcp failover")
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			log.Error("dhcp failover: accepting: %s", err)
+
+			continue
+		}
+
+		go f.handle(conn, done)
+	}
+}
+
+// dial connects to the primary and reconnects after the connection is lost.
+func (f *failover) dial(done <-chan struct{}) {
+	defer log.OnPanic("dhcp failover")
+
+	for {
+		conn, err := net.DialTimeout("tcp", f.conf.PeerAddress, f.maxDelay)
+		if err != nil {
+			log.Debug("dhcp failover: connecting to %s: %s", f.conf.PeerAddress, err)
+		} else {
+			f.handle(conn, done)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(defaultFailoverRetryIvl):
+			// Go on.
+		}
+	}
+}
+
+// handle authenticates the peer on conn and receives its messages until the
+// connection is closed.
+func (f *failover) handle(conn net.Conn, done <-chan struct{}) {
+	defer log.OnPanic("dhcp failover")
+	defer log.OnCloserError(conn, log.DEBUG)
+
+	addr := conn.RemoteAddr()
+	c, err := f.handshake(conn)
+	if err != nil {
+		log.Error("dhcp failover: handshake with %s: %s", addr, err)
+
+		return
+	}
+
+	if !f.setConn(c, done) {
+		return
+	}
+	defer f.resetConn(c)
+
+	log.Info("dhcp failover: connected to %s", addr)
+
+	err = f.sendAll(c)
+	for err == nil {
+		var msg *failoverMsg
+		msg, err = c.read()
+		if err == nil {
+			f.receive(msg)
+		}
+	}
+
+	select {
+	case <-done:
+		log.Debug("dhcp failover: disconnected from %s: %s", addr, err)
+	default:
+		log.Info("dhcp failover: disconnected from %s: %s", addr, err)
+	}
+}
+
+// handshake exchanges the hello messages with the peer, derives the keys of
+// the session, and checks that the peer knows the secret.
+func (f *failover) handshake(conn net.Conn) (c *failoverConn, err error) {
+	c = newFailoverConn(conn, f.maxDelay)
+
+	nonce := make([]byte, failoverNonceLen)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+
+	err = c.write(&failoverMsg{
+		Type:  failoverMsgHello,
+		Role:  f.conf.Role,
+		Mode:  f.conf.Mode,
+		Nonce: nonce,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sending hello: %w", err)
+	}
+
+	hello, err := c.read()
+	if err != nil {
+		return nil, fmt.Errorf("receiving hello: %w", err)
+	} else if hello.Type != failoverMsgHello {
+		return nil, fmt.Errorf("unexpected message %q", hello.Type)
+	} else if hello.Role != f.peerRole() {
+		return nil, fmt.Errorf("peer role is %q, want %q", hello.Role, f.peerRole())
+	} else if hello.Mode != f.conf.Mode {
+		return nil, fmt.Errorf("peer mode is %q, want %q", hello.Mode, f.conf.Mode)
+	} else if len(hello.Nonce) != failoverNonceLen {
+		return nil, fmt.Errorf("bad nonce length %d", len(hello.Nonce))
+	}
+
+	primaryNonce, secondaryNonce := nonce, hello.Nonce
+	if f.conf.Role == failoverRoleSecondary {
+		primaryNonce, secondaryNonce = secondaryNonce, primaryNonce
+	}
+
+	c.sendKey = f.sessionKey(f.conf.Role, primaryNonce, secondaryNonce)
+	c.recvKey = f.sessionKey(f.peerRole(), primaryNonce, secondaryNonce)
+
+	// The first authenticated message proves that the peer knows the
+	// secret before any lease is exchanged.
+	err = c.write(&failoverMsg{Type: failoverMsgHeartbeat})
+	if err != nil {
+		return nil, fmt.Errorf("sending heartbeat: %w", err)
+	}
+
+	_, err = c.read()
+	if err != nil {
+		return nil, fmt.Errorf("authenticating peer: %w", err)
+	}
+
+	return c, nil
+}
+
+// sessionKey returns the key of the messages sent by the peer with role within
+// the session started with the nonces.
+func (f *failover) sessionKey(role string, primaryNonce, secondaryNonce []byte) (key []byte) {
+	h := hmac.New(sha256.New, f.secret)
+	_, _ = h.Write([]byte(role))
+	_, _ = h.Write(primaryNonce)
+	_, _ = h.Write(secondaryNonce)
+
+	return h.Sum(nil)
+}
+
+// setConn makes c the current connection, closing the previous one.  It
+// returns false if the failover has been stopped.
+func (f *failover) setConn(c *failoverConn, done <-chan struct{}) (ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	select {
+	case <-done:
+		return false
+	default:
+	}
+
+	if f.conn != nil {
+		log.OnCloserError(f.conn.conn, log.DEBUG)
+	}
+
+	f.conn = c
+	f.lastSeen = time.Now()
+
+	return true
+}
+
+// resetConn forgets c if it's still the current connection.
+func (f *failover) resetConn(c *failoverConn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.conn == c {
+		f.conn = nil
+		f.lastSeen = time.Now()
+	}
+}
+
+// currentConn returns the current connection to the peer, if any.
+func (f *failover) currentConn() (c *failoverConn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.conn
+}
+
+// receive handles the message from the peer.
+func (f *failover) receive(msg *failoverMsg) {
+	f.mu.Lock()
+	f.lastSeen = time.Now()
+	f.mu.Unlock()
+
+	if msg.Type != failoverMsgLease || msg.Lease == nil {
+		return
+	}
+
+	l := leaseFromJSON(msg.Lease)
+	key := l.IP.String()
+
+	// Remember the lease of the peer even if it isn't applied, so that the
+	// newer local lease is sent back to it.
+	f.syncMu.Lock()
+	if f.known != nil {
+		if msg.Remove {
+			delete(f.known, key)
+		} else {
+			f.known[key] = l.Clone()
+		}
+	}
+	f.syncMu.Unlock()
+
+	err := f.apply(l, msg.Remove)
+	if err != nil {
+		log.Debug("dhcp failover: applying lease for %s: %s", l.IP, err)
+	}
+}
+
+// sync sends the changes of the leases to the peer and keeps the connection
+// alive.
+func (f *failover) sync(done <-chan struct{}) {
+	defer log.OnPanic("dhcp failover")
+
+	ticker := time.NewTicker(f.maxDelay / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.trigger:
+			f.sendChanges(false)
+		case <-ticker.C:
+			f.sendChanges(true)
+		case <-done:
+			return
+		}
+	}
+}
+
+// syncedLeases returns the current leases which are synchronized with the
+// peer, keyed by the string representation of the IP address.  Only the
+// dynamic leases are synchronized, since the static ones are configured on
+// each peer.
+func (f *failover) syncedLeases() (leases map[string]*Lease) {
+	now := time.Now()
+	leases = map[string]*Lease{}
+	for _, l := range f.leases() {
+		if l.IsStatic() || l.IsBlocklisted() || !l.Expiry.After(now) {
+			continue
+		}
+
+		leases[l.IP.String()] = l
+	}
+
+	return leases
+}
+
+// sendAll sends all synchronized leases to the peer over the new connection c.
+func (f *failover) sendAll(c *failoverConn) (err error) {
+	cur := f.syncedLeases()
+
+	f.syncMu.Lock()
+	f.known = cur
+	f.syncMu.Unlock()
+
+	for _, l := range cur {
+		err = c.write(&failoverMsg{
+			Type:  failoverMsgLease,
+			Lease: newLeaseJSON(l),
+		})
+		if err != nil {
+			return fmt.Errorf("sending lease: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// sendChanges sends the leases changed since the previous call to the peer.
+// If heartbeat is true, the heartbeat message is sent as well.
+func (f *failover) sendChanges(heartbeat bool) {
+	c := f.currentConn()
+	if c == nil {
+		return
+	}
+
+	msgs := f.changes()
+	if heartbeat {
+		msgs = append(msgs, &failoverMsg{Type: failoverMsgHeartbeat})
+	}
+
+	for _, msg := range msgs {
+		err := c.write(msg)
+		if err != nil {
+			log.Debug("dhcp failover: sending %s: %s", msg.Type, err)
+
+			// Close the connection, so that the leases are sent again
+			// after reconnecting.
+			log.OnCloserError(c.conn, log.DEBUG)
+
+			return
+		}
+	}
+}
+
+// changes returns the messages for the leases changed since the previous call
+// and updates the known leases.
+func (f *failover) changes() (msgs []*failoverMsg) {
+	cur := f.syncedLeases()
+
+	f.syncMu.Lock()
+	defer f.syncMu.Unlock()
+
+	if f.known == nil {
+		return nil
+	}
+
+	for key, l := range cur {
+		k, ok := f.known[key]
+		if ok &&
+			sameClient(k, l) &&
+			k.Expiry.Unix() == l.Expiry.Unix() &&
+			k.Hostname == l.Hostname {
+			continue
+		}
+
+		msgs = append(msgs, &failoverMsg{
+			Type:  failoverMsgLease,
+			Lease: newLeaseJSON(l),
+		})
+	}
+
+	// The leases which have disappeared before their expiration have been
+	// released.  The expired ones expire on the peer as well.
+	now := time.Now()
+	for key, k := range f.known {
+		if _, ok := cur[key]; !ok && k.Expiry.After(now) {
+			msgs = append(msgs, &failoverMsg{
+				Type:   failoverMsgLease,
+				Lease:  newLeaseJSON(k),
+				Remove: true,
+			})
+		}
+	}
+
+	f.known = cur
+
+	return msgs
+}
+
+// peerLeaseApplies returns true if l, received from the failover peer, should
+// replace cur, the local lease with the same IP address, or remove it if
+// remove is true.  cur may be nil.  Static leases are never replaced, and
+// dynamic ones are only replaced by the leases expiring later.  The times are
+// compared with the precision of a second, since the peers exchange them so.
+func peerLeaseApplies(cur, l *Lease, remove bool) (ok bool) {
+	if remove {
+		return cur != nil &&
+			!cur.IsStatic() &&
+			sameClient(cur, l) &&
+			cur.Expiry.Unix() <= l.Expiry.Unix()
+	}
+
+	return cur == nil || (!cur.IsStatic() && l.Expiry.Unix() > cur.Expiry.Unix())
+}
+
+// leaseFromJSON returns the lease represented by lj.
+func leaseFromJSON(lj *leaseJSON) (l *Lease) {
+	return &Lease{
+		HWAddr:   lj.HWAddr,
+		IP:       normalizeIP(lj.IP),
+		Hostname: lj.Hostname,
+		DUID:     lj.DUID,
+		Expiry:   time.Unix(lj.Expiry, 0),
+	}
+}
+
+// failoverStatusResponse is the response for the failover status HTTP API.
+type failoverStatusResponse struct {
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+	Role     string     `json:"role,omitempty"`
+	Mode     string     `json:"mode,omitempty"`
+	State    string     `json:"state,omitempty"`
+	Enabled  bool       `json:"enabled"`
+}
+
+// handleFailoverStatus is the handler for the GET
+// /control/dhcp/failover_status HTTP API.
+func (s *Server) handleFailoverStatus(w http.ResponseWriter, r *http.Request) {
+	resp := &failoverStatusResponse{}
+	if f := s.failover; f != nil {
+		st, lastSeen := f.state()
+		resp.Enabled = true
+		resp.Role = f.conf.Role
+		resp.Mode = f.conf.Mode
+		resp.State = st
+		if !lastSeen.IsZero() {
+			resp.LastSeen = &lastSeen
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "encoding failover status: %s", err)
+	}
+}
@@ -0,0 +1,348 @@
+package dhcpd
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testLeaseStore is a lease storage of a failover peer.
+type testLeaseStore struct {
+	f      *failover
+	leases map[string]*Lease
+	mu     sync.Mutex
+}
+
+// newTestLeaseStore returns a new storage and the failover with conf using it.
+func newTestLeaseStore(t *testing.T, conf *FailoverConfig) (s *testLeaseStore) {
+	t.Helper()
+
+	s = &testLeaseStore{
+		leases: map[string]*Lease{},
+	}
+
+	var err error
+	s.f, err = newFailover(conf, s.list, s.apply)
+	require.NoError(t, err)
+
+	return s
+}
+
+// list returns the leases of the storage.
+func (s *testLeaseStore) list() (leases []*Lease) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, l := range s.leases {
+		leases = append(leases, l.Clone())
+	}
+
+	return leases
+}
+
+// apply applies the lease received from the peer.
+func (s *testLeaseStore) apply(l *Lease, remove bool) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := l.IP.String()
+	if !peerLeaseApplies(s.leases[key], l, remove) {
+		return nil
+	}
+
+	if remove {
+		delete(s.leases, key)
+	} else {
+		s.leases[key] = l
+	}
+
+	s.f.onLeaseChanged(LeaseChangedDBStore)
+
+	return nil
+}
+
+// set adds or, if l is nil, removes the lease with ip and notifies the
+// failover.
+func (s *testLeaseStore) set(ip string, l *Lease) {
+	s.mu.Lock()
+	if l == nil {
+		delete(s.leases, ip)
+	} else {
+		s.leases[ip] = l
+	}
+	s.mu.Unlock()
+
+	s.f.onLeaseChanged(LeaseChangedDBStore)
+}
+
+// has returns true if the storage has the lease with ip expiring at exp.
+func (s *testLeaseStore) has(ip string, exp time.Time) (ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.leases[ip]
+
+	return ok && l.Expiry.Unix() == exp.Unix()
+}
+
+// newTestFailoverConf returns a new configuration of the peer with role.
+func newTestFailoverConf(role, secret string) (conf *FailoverConfig) {
+	return &FailoverConfig{
+		Enabled:       true,
+		Role:          role,
+		Mode:          failoverModeHotStandby,
+		ListenAddress: "127.0.0.1:0",
+		PeerAddress:   "127.0.0.1:0",
+		Secret:        secret,
+	}
+}
+
+func TestNewFailover(t *testing.T) {
+	testCases := []struct {
+		conf       *FailoverConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       newTestFailoverConf(failoverRolePrimary, "secret"),
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf:       newTestFailoverConf("tertiary", "secret"),
+		name:       "bad_role",
+		wantErrMsg: `failover: bad role "tertiary"`,
+	}, {
+		conf: &FailoverConfig{
+			Role:   failoverRolePrimary,
+			Mode:   failoverModeHotStandby,
+			Secret: "secret",
+		},
+		name:       "no_listen_address",
+		wantErrMsg: "failover: primary requires listen_address",
+	}, {
+		conf: &FailoverConfig{
+			Role:   failoverRoleSecondary,
+			Mode:   failoverModeHotStandby,
+			Secret: "secret",
+		},
+		name:       "no_peer_address",
+		wantErrMsg: "failover: secondary requires peer_address",
+	}, {
+		conf: &FailoverConfig{
+			Role:          failoverRolePrimary,
+			Mode:          "active_active",
+			ListenAddress: "127.0.0.1:0",
+			Secret:        "secret",
+		},
+		name:       "bad_mode",
+		wantErrMsg: `failover: bad mode "active_active"`,
+	}, {
+		conf:       newTestFailoverConf(failoverRolePrimary, ""),
+		name:       "no_secret",
+		wantErrMsg: "failover: no secret specified",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newFailover(tc.conf, nil, nil)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestFailover_serves(t *testing.T) {
+	primary := newTestLeaseStore(t, newTestFailoverConf(failoverRolePrimary, "secret")).f
+	secondary := newTestLeaseStore(t, newTestFailoverConf(failoverRoleSecondary, "secret")).f
+
+	ids := [][]byte{{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}, {0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAB}}
+
+	t.Run("partner_down", func(t *testing.T) {
+		for _, id := range ids {
+			assert.True(t, primary.serves(id))
+			assert.True(t, secondary.serves(id))
+		}
+	})
+
+	primary.conn, secondary.conn = &failoverConn{}, &failoverConn{}
+
+	t.Run("hot_standby", func(t *testing.T) {
+		for _, id := range ids {
+			assert.True(t, primary.serves(id))
+			assert.False(t, secondary.serves(id))
+		}
+	})
+
+	primary.conf.Mode, secondary.conf.Mode = failoverModeLoadBalance, failoverModeLoadBalance
+
+	t.Run("load_balance", func(t *testing.T) {
+		served := map[bool]int{}
+		for _, id := range ids {
+			p, s := primary.serves(id), secondary.serves(id)
+			assert.NotEqual(t, p, s)
+
+			served[p]++
+		}
+
+		assert.Equal(t, map[bool]int{true: 1, false: 1}, served)
+	})
+}
+
+func TestFailover_sync(t *testing.T) {
+	primary := newTestLeaseStore(t, newTestFailoverConf(failoverRolePrimary, "secret"))
+	require.NoError(t, primary.f.start())
+	t.Cleanup(primary.f.stop)
+
+	secondaryConf := newTestFailoverConf(failoverRoleSecondary, "secret")
+	secondaryConf.PeerAddress = primary.f.listener.Addr().String()
+	secondary := newTestLeaseStore(t, secondaryConf)
+
+	exp := time.Now().Add(time.Hour)
+	primary.set("192.168.10.1", &Lease{
+		Expiry: exp,
+		HWAddr: net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x01},
+		IP:     net.IP{192, 168, 10, 1},
+	})
+
+	require.NoError(t, secondary.f.start())
+	t.Cleanup(secondary.f.stop)
+
+	connected := func() bool {
+		st, _ := secondary.f.state()
+
+		return st == failoverStateNormal
+	}
+	require.Eventually(t, connected, 5*time.Second, 10*time.Millisecond)
+
+	// The leases existing before the connection are sent as well.
+	require.Eventually(t, func() bool {
+		return secondary.has("192.168.10.1", exp)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	renewed := exp.Add(time.Hour)
+	secondary.set("192.168.10.1", &Lease{
+		Expiry: renewed,
+		HWAddr: net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x01},
+		IP:     net.IP{192, 168, 10, 1},
+	})
+	require.Eventually(t, func() bool {
+		return primary.has("192.168.10.1", renewed)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	primary.set("192.168.10.1", nil)
+	require.Eventually(t, func() bool {
+		return len(secondary.list()) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestFailover_handshake(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	primary := newTestLeaseStore(t, newTestFailoverConf(failoverRolePrimary, "secret")).f
+
+	errCh := make(chan error, 1)
+	go func() {
+		conn, aerr := l.Accept()
+		if aerr != nil {
+			errCh <- aerr
+
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		_, aerr = primary.handshake(conn)
+		errCh <- aerr
+	}()
+
+	secondary := newTestLeaseStore(t, newTestFailoverConf(failoverRoleSecondary, "wrong")).f
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	_, err = secondary.handshake(conn)
+	testutil.AssertErrorMsg(t, "authenticating peer: bad message authentication code", err)
+
+	err = <-errCh
+	testutil.AssertErrorMsg(t, "authenticating peer: bad message authentication code", err)
+}
+
+func TestPeerLeaseApplies(t *testing.T) {
+	now := time.Unix(1_600_000_000, 0)
+	newLease := func(b byte, exp time.Time) (l *Lease) {
+		return &Lease{
+			Expiry: exp,
+			HWAddr: net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, b},
+			IP:     net.IP{192, 168, 10, 1},
+		}
+	}
+
+	static := newLease(1, time.Unix(leaseExpireStatic, 0))
+
+	testCases := []struct {
+		cur    *Lease
+		l      *Lease
+		name   string
+		remove bool
+		want   bool
+	}{{
+		cur:    nil,
+		l:      newLease(1, now),
+		name:   "new",
+		remove: false,
+		want:   true,
+	}, {
+		cur:    newLease(1, now),
+		l:      newLease(1, now.Add(time.Hour)),
+		name:   "renewed",
+		remove: false,
+		want:   true,
+	}, {
+		cur:    newLease(1, now.Add(time.Hour)),
+		l:      newLease(2, now),
+		name:   "older",
+		remove: false,
+		want:   false,
+	}, {
+		cur:    static,
+		l:      newLease(1, now),
+		name:   "static",
+		remove: false,
+		want:   false,
+	}, {
+		cur:    newLease(1, now.Add(500*time.Millisecond)),
+		l:      newLease(1, now),
+		name:   "remove",
+		remove: true,
+		want:   true,
+	}, {
+		cur:    newLease(1, now.Add(time.Hour)),
+		l:      newLease(1, now),
+		name:   "remove_renewed",
+		remove: true,
+		want:   false,
+	}, {
+		cur:    newLease(2, now),
+		l:      newLease(1, now),
+		name:   "remove_other_client",
+		remove: true,
+		want:   false,
+	}, {
+		cur:    static,
+		l:      newLease(1, now),
+		name:   "remove_static",
+		remove: true,
+		want:   false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, peerLeaseApplies(tc.cur, tc.l, tc.remove))
+		})
+	}
+}
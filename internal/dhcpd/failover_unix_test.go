@@ -0,0 +1,161 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package dhcpd
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailover_owns(t *testing.T) {
+	primaryConf := newTestFailoverConf(failoverRolePrimary, "secret")
+	primaryConf.Mode = failoverModeLoadBalance
+	primary := newTestLeaseStore(t, primaryConf).f
+
+	secondaryConf := newTestFailoverConf(failoverRoleSecondary, "secret")
+	secondaryConf.Mode = failoverModeLoadBalance
+	secondary := newTestLeaseStore(t, secondaryConf).f
+
+	primary.conn, secondary.conn = &failoverConn{}, &failoverConn{}
+
+	// newPeer returns a new DHCPv4 server which allocates the addresses as
+	// the peer f.
+	newPeer := func(f *failover) (s *v4Server) {
+		conf := defaultV4ServerConf()
+		conf.serves, conf.owns = f.serves, f.owns
+
+		srv, err := v4Create(conf)
+		require.NoError(t, err)
+
+		return srv.(*v4Server)
+	}
+
+	// allocate allocates the addresses for the clients served by s and sends
+	// them to ips.
+	allocate := func(s *v4Server, ips chan<- string, wg *sync.WaitGroup) {
+		defer wg.Done()
+
+		sc := s.scopes[0]
+		for i := 0; i < 100; i++ {
+			mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, byte(i)}
+			if !s.conf.serves(mac) {
+				continue
+			}
+
+			s.leasesLock.Lock()
+			l, err := s.allocateLease(sc, mac)
+			s.leasesLock.Unlock()
+			if !assert.NoError(t, err) || l == nil {
+				continue
+			}
+
+			ips <- l.IP.String()
+		}
+	}
+
+	t.Run("concurrent", func(t *testing.T) {
+		ps, ss := newPeer(primary), newPeer(secondary)
+
+		ips := make(chan string, 200)
+		wg := &sync.WaitGroup{}
+		wg.Add(2)
+		go allocate(ps, ips, wg)
+		go allocate(ss, ips, wg)
+		wg.Wait()
+		close(ips)
+
+		seen := map[string]bool{}
+		for ip := range ips {
+			assert.False(t, seen[ip], "address %s is allocated twice", ip)
+
+			seen[ip] = true
+		}
+
+		assert.NotEmpty(t, seen)
+	})
+
+	const n = 100
+
+	t.Run("normal", func(t *testing.T) {
+		assert.True(t, primary.owns(0, n))
+		assert.False(t, primary.owns(n-1, n))
+		assert.False(t, secondary.owns(0, n))
+		assert.True(t, secondary.owns(n-1, n))
+	})
+
+	primary.conn = nil
+
+	t.Run("partner_down", func(t *testing.T) {
+		primary.lastSeen = time.Now().Add(-primary.maxDelay - time.Second)
+		require.True(t, primary.serves([]byte{0xAA}))
+		require.True(t, primary.serves([]byte{0xAB}))
+
+		assert.False(t, primary.owns(n-1, n))
+
+		primary.lastSeen = time.Now().Add(-primary.maxDelay - primary.mclt)
+
+		assert.True(t, primary.owns(n-1, n))
+	})
+}
+
+func TestServer_handleDHCPSetConfigV4_failover(t *testing.T) {
+	conf := newTestFailoverConf(failoverRolePrimary, "secret")
+	conf.Mode = failoverModeLoadBalance
+	primary := newTestLeaseStore(t, conf).f
+	primary.conn = &failoverConn{}
+
+	v4Conf := defaultV4ServerConf()
+	v4Conf.serves, v4Conf.owns = primary.serves, primary.owns
+	srv4, err := v4Create(v4Conf)
+	require.NoError(t, err)
+
+	s := &Server{
+		srv4:     srv4,
+		failover: primary,
+	}
+
+	reconf, _, err := s.handleDHCPSetConfigV4(&dhcpServerConfigJSON{
+		V4: &v4ServerConfJSON{
+			GatewayIP:     v4Conf.GatewayIP,
+			SubnetMask:    v4Conf.SubnetMask,
+			RangeStart:    v4Conf.RangeStart,
+			RangeEnd:      v4Conf.RangeEnd,
+			LeaseDuration: 3600,
+		},
+		Enabled: nbTrue,
+	})
+	require.NoError(t, err)
+
+	s4 := reconf.(*v4Server)
+	require.NotNil(t, s4.conf.owns)
+
+	// The primary only allocates the first half of the pool.
+	sc := s4.scopes[0]
+	n := sc.ipRange.len()
+	for i := 0; i < 100; i++ {
+		mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, byte(i)}
+		if !s4.conf.serves(mac) {
+			continue
+		}
+
+		s4.leasesLock.Lock()
+		l, lErr := s4.allocateLease(sc, mac)
+		s4.leasesLock.Unlock()
+		require.NoError(t, lErr)
+
+		if l == nil {
+			break
+		}
+
+		offset, ok := sc.ipRange.offset(l.IP)
+		require.True(t, ok)
+
+		assert.Less(t, offset, (n+1)/2, "address %s", l.IP)
+	}
+}
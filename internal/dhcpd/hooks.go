@@ -0,0 +1,481 @@
+package dhcpd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// LeaseEvent is the type of a change of a lease passed to the hooks.
+type LeaseEvent string
+
+// LeaseEvent values.
+const (
+	// LeaseEventAdd means that the address has been leased to a client,
+	// either dynamically or statically.
+	LeaseEventAdd LeaseEvent = "add"
+
+	// LeaseEventRenew means that the client has extended its lease.
+	LeaseEventRenew LeaseEvent = "renew"
+
+	// LeaseEventRelease means that the lease has been removed before its
+	// expiration, for example released by the client or removed by the
+	// administrator.
+	LeaseEventRelease LeaseEvent = "release"
+
+	// LeaseEventExpire means that the lease has expired.
+	LeaseEventExpire LeaseEvent = "expire"
+)
+
+// Default values of the hooks.
+const (
+	defaultHookTimeout    = 10 * time.Second
+	defaultHooksCheckIvl  = 30 * time.Second
+	defaultHooksQueueSize = 256
+)
+
+// LeaseHookConfig is the configuration of a hook fired on the changes of the
+// leases.  Exactly one of Command and URL must be set.
+type LeaseHookConfig struct {
+	// Events are the events the hook is fired on.  If it's empty, the hook
+	// is fired on all events.
+	Events []LeaseEvent `yaml:"events"`
+
+	// Command is the path to the executable to run.  The event and the
+	// lease are passed in the environment variables prefixed with
+	// "ADGUARD_HOME_".
+	Command string `yaml:"command"`
+
+	// Args are the arguments of Command.
+	Args []string `yaml:"args"`
+
+	// URL is the address to send the event and the lease to, encoded as a
+	// JSON object in the body of a POST request.
+	URL string `yaml:"url"`
+
+	// Timeout is the time limit for a single run of the hook.  The default
+	// is 10 seconds.
+	Timeout timeutil.Duration `yaml:"timeout"`
+}
+
+// leaseHookMsg is a single event passed to the hooks.
+type leaseHookMsg struct {
+	Lease *Lease
+	Time  time.Time
+	Event LeaseEvent
+}
+
+// hookLeaseJSON is the JSON representation of a lease passed to the webhooks.
+type hookLeaseJSON struct {
+	HWAddr   string `json:"mac"`
+	DUID     string `json:"duid,omitempty"`
+	IP       net.IP `json:"ip"`
+	Hostname string `json:"hostname"`
+	Expiry   string `json:"expires,omitempty"`
+	Static   bool   `json:"static"`
+}
+
+// MarshalJSON implements the json.Marshaler interface for *leaseHookMsg.
+func (msg *leaseHookMsg) MarshalJSON() (b []byte, err error) {
+	l := msg.Lease
+
+	var expires string
+	if !l.IsStatic() {
+		expires = l.Expiry.Format(time.RFC3339)
+	}
+
+	return json.Marshal(&struct {
+		Lease *hookLeaseJSON `json:"lease"`
+		Time  time.Time      `json:"time"`
+		Event LeaseEvent     `json:"event"`
+	}{
+		Lease: &hookLeaseJSON{
+			HWAddr:   l.HWAddr.String(),
+			DUID:     formatDUID(l.DUID),
+			IP:       l.IP,
+			Hostname: l.Hostname,
+			Expiry:   expires,
+			Static:   l.IsStatic(),
+		},
+		Time:  msg.Time,
+		Event: msg.Event,
+	})
+}
+
+// leaseHook is a validated hook.
+type leaseHook struct {
+	conf    *LeaseHookConfig
+	events  *stringutil.Set
+	timeout time.Duration
+}
+
+// fires returns true if h is fired on ev.
+func (h *leaseHook) fires(ev LeaseEvent) (ok bool) {
+	return h.events.Len() == 0 || h.events.Has(string(ev))
+}
+
+// leaseState is the state of a lease known to the hooks.
+type leaseState struct {
+	lease   *Lease
+	expired bool
+}
+
+// leaseHooks detects the changes of the leases and runs the hooks for them.
+// The changes are detected by comparing the current leases with the previous
+// ones, so that the DHCP servers don't need to report each change separately.
+type leaseHooks struct {
+	// cli is used to send the webhooks.
+	cli *http.Client
+
+	// leases returns the current leases of the server.
+	leases func() (leases []*Lease)
+
+	// known are the leases as of the last check, keyed by the string
+	// representation of the IP address.  It's nil until the first check.
+	known map[string]*leaseState
+
+	// trigger signals the worker that the leases have changed.
+	trigger chan struct{}
+
+	// queue contains the messages waiting to be passed to the hooks.
+	queue chan *leaseHookMsg
+
+	// done is closed to stop the workers.  It's nil when the workers aren't
+	// running.
+	done chan struct{}
+
+	// now returns the current time.  It's time.Now in production.
+	now func() (t time.Time)
+
+	// run executes the hook for the message.
+	run func(ctx context.Context, h *leaseHook, msg *leaseHookMsg) (err error)
+
+	// hooks are the configured hooks.
+	hooks []*leaseHook
+
+	// mu protects done.
+	mu sync.Mutex
+}
+
+// newLeaseHooks validates confs, fills the defaults in, and returns the hooks
+// for the leases.
+func newLeaseHooks(confs []*LeaseHookConfig, leases func() []*Lease) (lh *leaseHooks, err error) {
+	lh = &leaseHooks{
+		cli:     &http.Client{},
+		leases:  leases,
+		trigger: make(chan struct{}, 1),
+		queue:   make(chan *leaseHookMsg, defaultHooksQueueSize),
+		now:     time.Now,
+	}
+	lh.run = lh.exec
+
+	for i, c := range confs {
+		var h *leaseHook
+		h, err = newLeaseHook(c)
+		if err != nil {
+			return nil, fmt.Errorf("hook at index %d: %w", i, err)
+		}
+
+		lh.hooks = append(lh.hooks, h)
+	}
+
+	return lh, nil
+}
+
+// newLeaseHook validates c and returns a new hook.
+func newLeaseHook(c *LeaseHookConfig) (h *leaseHook, err error) {
+	if c == nil {
+		return nil, errors.Error("nil hook")
+	} else if (c.Command == "") == (c.URL == "") {
+		return nil, errors.Error("exactly one of command and url must be set")
+	}
+
+	if c.URL != "" {
+		var u *url.URL
+		u, err = url.Parse(c.URL)
+		if err != nil {
+			return nil, err
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("bad url scheme %q", u.Scheme)
+		}
+	}
+
+	h = &leaseHook{
+		conf:    c,
+		events:  stringutil.NewSet(),
+		timeout: c.Timeout.Duration,
+	}
+
+	for _, ev := range c.Events {
+		switch ev {
+		case LeaseEventAdd, LeaseEventRenew, LeaseEventRelease, LeaseEventExpire:
+			h.events.Add(string(ev))
+		default:
+			return nil, fmt.Errorf("unknown event %q", ev)
+		}
+	}
+
+	if h.timeout <= 0 {
+		h.timeout = defaultHookTimeout
+	}
+
+	return h, nil
+}
+
+// onLeaseChanged is the OnLeaseChangedT callback of the hooks.  It never
+// blocks, so it's also safe to call it within locked sections.
+func (lh *leaseHooks) onLeaseChanged(_ int) {
+	select {
+	case lh.trigger <- struct{}{}:
+	default:
+		// The check is already pending.
+	}
+}
+
+// start starts the workers if they aren't running yet.  The leases existing
+// at start don't fire the hooks.
+func (lh *leaseHooks) start() {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+
+	if lh.done != nil {
+		return
+	}
+
+	lh.done = make(chan struct{})
+	go lh.watch(lh.done)
+	go lh.runQueue(lh.done)
+}
+
+// stop stops the workers if they're running.  The queued messages are
+// dropped.
+func (lh *leaseHooks) stop() {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+
+	if lh.done == nil {
+		return
+	}
+
+	close(lh.done)
+	lh.done = nil
+}
+
+// watch checks the leases each time they change and periodically, so that
+// the expired leases are detected.
+func (lh *leaseHooks) watch(done <-chan struct{}) {
+	defer log.OnPanic("dhcp hooks")
+
+	ticker := time.NewTicker(defaultHooksCheckIvl)
+	defer ticker.Stop()
+
+	lh.check()
+	for {
+		select {
+		case <-lh.trigger:
+			lh.check()
+		case <-ticker.C:
+			lh.check()
+		case <-done:
+			return
+		}
+	}
+}
+
+// check compares the current leases with the known ones and queues the
+// messages for the changes.  The first call only remembers the leases.
+func (lh *leaseHooks) check() {
+	msgs := lh.diff()
+	for _, msg := range msgs {
+		select {
+		case lh.queue <- msg:
+		default:
+			log.Error("dhcp hooks: queue is full, dropping %s of %s", msg.Event, msg.Lease.IP)
+		}
+	}
+}
+
+// diff returns the messages for the changes of the leases since the previous
+// call and updates the known leases.  The messages are nil during the first
+// call.
+func (lh *leaseHooks) diff() (msgs []*leaseHookMsg) {
+	now := lh.now()
+	cur := map[string]*leaseState{}
+	for _, l := range lh.leases() {
+		// Skip the offered but not yet acknowledged leases.
+		if l.Expiry.Unix() == 0 || l.IsBlocklisted() {
+			continue
+		}
+
+		cur[l.IP.String()] = &leaseState{
+			lease:   l,
+			expired: !l.IsStatic() && !l.Expiry.After(now),
+		}
+	}
+
+	prev := lh.known
+	lh.known = cur
+	if prev == nil {
+		return nil
+	}
+
+	newMsg := func(ev LeaseEvent, l *Lease) {
+		msgs = append(msgs, &leaseHookMsg{
+			Lease: l,
+			Time:  now,
+			Event: ev,
+		})
+	}
+
+	for key, p := range prev {
+		if c, ok := cur[key]; ok && sameClient(p.lease, c.lease) {
+			continue
+		}
+
+		if p.expired || (!p.lease.IsStatic() && !p.lease.Expiry.After(now)) {
+			if !p.expired {
+				newMsg(LeaseEventExpire, p.lease)
+			}
+		} else {
+			newMsg(LeaseEventRelease, p.lease)
+		}
+	}
+
+	for key, c := range cur {
+		p, ok := prev[key]
+		switch {
+		case !ok || !sameClient(p.lease, c.lease):
+			if !c.expired {
+				newMsg(LeaseEventAdd, c.lease)
+			}
+		case c.expired && !p.expired:
+			newMsg(LeaseEventExpire, c.lease)
+		case !c.expired && c.lease.Expiry.After(p.lease.Expiry):
+			newMsg(LeaseEventRenew, c.lease)
+		}
+	}
+
+	return msgs
+}
+
+// runQueue passes the queued messages to the hooks one by one, so that the
+// hooks receive them in order.
+func (lh *leaseHooks) runQueue(done <-chan struct{}) {
+	defer log.OnPanic("dhcp hooks")
+
+	for {
+		select {
+		case msg := <-lh.queue:
+			lh.fire(msg)
+		case <-done:
+			return
+		}
+	}
+}
+
+// fire runs all the hooks fired on msg.
+func (lh *leaseHooks) fire(msg *leaseHookMsg) {
+	for _, h := range lh.hooks {
+		if !h.fires(msg.Event) {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+		err := lh.run(ctx, h, msg)
+		cancel()
+		if err != nil {
+			log.Error("dhcp hooks: %s of %s: %s", msg.Event, msg.Lease.IP, err)
+		}
+	}
+}
+
+// exec runs the command or sends the webhook of h for msg.
+func (lh *leaseHooks) exec(ctx context.Context, h *leaseHook, msg *leaseHookMsg) (err error) {
+	if h.conf.URL != "" {
+		return lh.send(ctx, h.conf.URL, msg)
+	}
+
+	cmd := exec.CommandContext(ctx, h.conf.Command, h.conf.Args...)
+	cmd.Env = append(os.Environ(), hookEnv(msg)...)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running %s: %w: %s", h.conf.Command, err, truncateOutput(out))
+	}
+
+	return nil
+}
+
+// send posts msg to the webhook at u.
+func (lh *leaseHooks) send(ctx context.Context, u string, msg *leaseHookMsg) (err error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("encoding message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := lh.cli.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// hookEnv returns the environment variables describing msg.
+func hookEnv(msg *leaseHookMsg) (env []string) {
+	l := msg.Lease
+
+	var expires string
+	if !l.IsStatic() {
+		expires = l.Expiry.Format(time.RFC3339)
+	}
+
+	return []string{
+		"ADGUARD_HOME_EVENT=" + string(msg.Event),
+		"ADGUARD_HOME_LEASE_MAC=" + l.HWAddr.String(),
+		"ADGUARD_HOME_LEASE_DUID=" + formatDUID(l.DUID),
+		"ADGUARD_HOME_LEASE_IP=" + l.IP.String(),
+		"ADGUARD_HOME_LEASE_HOSTNAME=" + l.Hostname,
+		"ADGUARD_HOME_LEASE_EXPIRES=" + expires,
+		"ADGUARD_HOME_LEASE_STATIC=" + strconv.FormatBool(l.IsStatic()),
+	}
+}
+
+// maxHookOutputLen is the maximum length of the output of a failed command
+// included into the error.
+const maxHookOutputLen = 256
+
+// truncateOutput returns out truncated to maxHookOutputLen.
+func truncateOutput(out []byte) (s string) {
+	if len(out) > maxHookOutputLen {
+		out = out[:maxHookOutputLen]
+	}
+
+	return string(bytes.TrimSpace(out))
+}
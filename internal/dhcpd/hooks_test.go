@@ -0,0 +1,182 @@
+package dhcpd
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLeaseHook(t *testing.T) {
+	testCases := []struct {
+		conf       *LeaseHookConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       &LeaseHookConfig{Command: "/bin/true"},
+		name:       "command",
+		wantErrMsg: "",
+	}, {
+		conf:       &LeaseHookConfig{URL: "https://example.com/hook"},
+		name:       "url",
+		wantErrMsg: "",
+	}, {
+		conf:       &LeaseHookConfig{},
+		name:       "none",
+		wantErrMsg: "exactly one of command and url must be set",
+	}, {
+		conf: &LeaseHookConfig{
+			Command: "/bin/true",
+			URL:     "https://example.com/hook",
+		},
+		name:       "both",
+		wantErrMsg: "exactly one of command and url must be set",
+	}, {
+		conf:       &LeaseHookConfig{URL: "ftp://example.com/hook"},
+		name:       "bad_scheme",
+		wantErrMsg: `bad url scheme "ftp"`,
+	}, {
+		conf: &LeaseHookConfig{
+			Events:  []LeaseEvent{"bad"},
+			Command: "/bin/true",
+		},
+		name:       "bad_event",
+		wantErrMsg: `unknown event "bad"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := newLeaseHook(tc.conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if tc.wantErrMsg != "" {
+				return
+			}
+
+			assert.Equal(t, defaultHookTimeout, h.timeout)
+		})
+	}
+}
+
+func TestLeaseHooks_diff(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	newLease := func(b byte, expiry time.Time) (l *Lease) {
+		return &Lease{
+			Expiry:   expiry,
+			Hostname: "host",
+			HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, b},
+			IP:       net.IP{192, 168, 10, b},
+		}
+	}
+
+	var leases []*Lease
+	lh, err := newLeaseHooks(nil, func() []*Lease { return leases })
+	require.NoError(t, err)
+
+	lh.now = func() (t time.Time) { return now }
+
+	events := func() (evs []string) {
+		for _, msg := range lh.diff() {
+			evs = append(evs, string(msg.Event)+" "+msg.Lease.IP.String())
+		}
+
+		return evs
+	}
+
+	leases = []*Lease{newLease(1, now.Add(time.Hour))}
+	require.Empty(t, events())
+
+	leases = []*Lease{
+		newLease(1, now.Add(time.Hour)),
+		newLease(2, now.Add(time.Hour)),
+		newLease(3, time.Unix(0, 0)),
+	}
+	assert.Equal(t, []string{"add 192.168.10.2"}, events())
+
+	leases = []*Lease{
+		newLease(1, now.Add(2*time.Hour)),
+		newLease(2, now.Add(time.Hour)),
+	}
+	assert.Equal(t, []string{"renew 192.168.10.1"}, events())
+
+	leases = []*Lease{newLease(2, now.Add(time.Hour))}
+	assert.Equal(t, []string{"release 192.168.10.1"}, events())
+
+	now = now.Add(time.Hour)
+	leases = nil
+	assert.Equal(t, []string{"expire 192.168.10.2"}, events())
+
+	// The address reused by another client.
+	leases = []*Lease{newLease(4, now.Add(time.Hour))}
+	leases[0].IP = net.IP{192, 168, 10, 1}
+	require.Equal(t, []string{"add 192.168.10.1"}, events())
+
+	leases = []*Lease{newLease(5, now.Add(time.Hour))}
+	leases[0].IP = net.IP{192, 168, 10, 1}
+	assert.ElementsMatch(t, []string{"release 192.168.10.1", "add 192.168.10.1"}, events())
+}
+
+func TestLeaseHooks_webhook(t *testing.T) {
+	msgs := make(chan map[string]interface{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := map[string]interface{}{}
+		err := json.NewDecoder(r.Body).Decode(&msg)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		msgs <- msg
+	}))
+	t.Cleanup(srv.Close)
+
+	conf := &LeaseHookConfig{
+		Events: []LeaseEvent{LeaseEventAdd},
+		URL:    srv.URL,
+	}
+
+	lh, err := newLeaseHooks([]*LeaseHookConfig{conf}, func() []*Lease { return nil })
+	require.NoError(t, err)
+
+	l := &Lease{
+		Expiry:   time.Unix(leaseExpireStatic, 0),
+		Hostname: "host",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+		IP:       net.IP{192, 168, 10, 1},
+	}
+
+	lh.fire(&leaseHookMsg{Lease: l, Event: LeaseEventRelease})
+	require.Empty(t, msgs)
+
+	lh.fire(&leaseHookMsg{Lease: l, Event: LeaseEventAdd})
+	require.Len(t, msgs, 1)
+
+	msg := <-msgs
+	assert.Equal(t, "add", msg["event"])
+
+	lease, ok := msg["lease"].(map[string]interface{})
+	require.True(t, ok)
+
+	assert.Equal(t, "aa:aa:aa:aa:aa:aa", lease["mac"])
+	assert.Equal(t, "192.168.10.1", lease["ip"])
+	assert.Equal(t, "host", lease["hostname"])
+	assert.Equal(t, true, lease["static"])
+
+	t.Run("bad_status", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		t.Cleanup(failing.Close)
+
+		err = lh.send(context.Background(), failing.URL, &leaseHookMsg{Lease: l, Event: LeaseEventAdd})
+		testutil.AssertErrorMsg(t, "unexpected status 500 Internal Server Error", err)
+	})
+}
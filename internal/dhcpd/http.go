@@ -23,6 +23,10 @@ type v4ServerConfJSON struct {
 	RangeStart    net.IP `json:"range_start"`
 	RangeEnd      net.IP `json:"range_end"`
 	LeaseDuration uint32 `json:"lease_duration"`
+
+	// Scopes are the additional address pools.  If nil, the currently
+	// configured ones are kept.
+	Scopes []*V4Scope `json:"scopes"`
 }
 
 func v4JSONToServerConf(j *v4ServerConfJSON) V4ServerConf {
@@ -36,6 +40,7 @@ func v4JSONToServerConf(j *v4ServerConfJSON) V4ServerConf {
 		RangeStart:    j.RangeStart,
 		RangeEnd:      j.RangeEnd,
 		LeaseDuration: j.LeaseDuration,
+		Scopes:        j.Scopes,
 	}
 }
 
@@ -62,7 +67,16 @@ type dhcpStatusResponse struct {
 	V6           V6ServerConf `json:"v6"`
 	Leases       []*Lease     `json:"leases"`
 	StaticLeases []*Lease     `json:"static_leases"`
-	Enabled      bool         `json:"enabled"`
+	// DelegatedPrefixes are the IPv6 prefixes delegated to the downstream
+	// routers, including the static ones.
+	DelegatedPrefixes []*PrefixLease `json:"delegated_prefixes"`
+	// Metrics are the counters of the DHCPv4 messages and the utilization
+	// of the address pools.
+	Metrics *dhcpMetrics `json:"metrics,omitempty"`
+	// OtherServers are the DHCP servers found in the network by the
+	// monitor.
+	OtherServers []*OtherServer `json:"other_servers,omitempty"`
+	Enabled      bool           `json:"enabled"`
 }
 
 func (s *Server) handleDHCPStatus(w http.ResponseWriter, r *http.Request) {
@@ -78,6 +92,11 @@ func (s *Server) handleDHCPStatus(w http.ResponseWriter, r *http.Request) {
 
 	status.Leases = s.Leases(LeasesDynamic)
 	status.StaticLeases = s.Leases(LeasesStatic)
+	status.DelegatedPrefixes = s.srv6.GetPrefixLeases()
+	status.Metrics = s.srv4.metrics()
+	if s.monitor != nil {
+		status.OtherServers = s.monitor.list(false)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	err := json.NewEncoder(w).Encode(status)
@@ -167,8 +186,15 @@ func (s *Server) handleDHCPSetConfigV4(
 	c4 := V4ServerConf{}
 	s.srv4.WriteDiskConfig4(&c4)
 	v4Conf.notify = c4.notify
+	v4Conf.serves, v4Conf.owns = s.failoverFuncs()
 	v4Conf.ICMPTimeout = c4.ICMPTimeout
+	v4Conf.PoolAlertThreshold = c4.PoolAlertThreshold
 	v4Conf.Options = c4.Options
+	v4Conf.ClassOptions = c4.ClassOptions
+	v4Conf.PXE = c4.PXE
+	if v4Conf.Scopes == nil {
+		v4Conf.Scopes = c4.Scopes
+	}
 
 	srv4, err = v4Create(v4Conf)
 
@@ -184,7 +210,7 @@ func (s *Server) handleDHCPSetConfigV6(
 
 	v6Conf := v6JSONToServerConf(conf.V6)
 	v6Conf.Enabled = conf.Enabled == nbTrue
-	if len(v6Conf.RangeStart) == 0 {
+	if len(v6Conf.RangeStart) == 0 && !s.conf.Conf6.Stateless {
 		v6Conf.Enabled = false
 	}
 
@@ -195,9 +221,19 @@ func (s *Server) handleDHCPSetConfigV6(
 	v6Conf.RASLAACOnly = s.conf.Conf6.RASLAACOnly
 	v6Conf.RAAllowSLAAC = s.conf.Conf6.RAAllowSLAAC
 
+	// The prefix delegation is also only configured in the config file.
+	v6Conf.PDPrefix = s.conf.Conf6.PDPrefix
+	v6Conf.PDPrefixLen = s.conf.Conf6.PDPrefixLen
+	v6Conf.PDStaticPrefixes = s.conf.Conf6.PDStaticPrefixes
+
+	// As well as the stateless mode and the domain search list.
+	v6Conf.Stateless = s.conf.Conf6.Stateless
+	v6Conf.DomainSearch = s.conf.Conf6.DomainSearch
+
 	enabled = v6Conf.Enabled
 	v6Conf.InterfaceName = conf.InterfaceName
 	v6Conf.notify = s.onNotify
+	v6Conf.serves, v6Conf.owns = s.failoverFuncs()
 
 	srv6, err = v6Create(v6Conf)
 
@@ -499,6 +535,12 @@ func (s *Server) handleDHCPAddStaticLease(w http.ResponseWriter, r *http.Request
 	ip4 := l.IP.To4()
 
 	if ip4 == nil {
+		if len(l.Options) > 0 {
+			aghhttp.Error(r, w, http.StatusBadRequest, "options are only supported for ipv4")
+
+			return
+		}
+
 		l.IP = l.IP.To16()
 
 		err = s.srv6.AddStaticLease(l)
@@ -509,6 +551,12 @@ func (s *Server) handleDHCPAddStaticLease(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if len(l.DUID) > 0 {
+		aghhttp.Error(r, w, http.StatusBadRequest, "duid is only supported for ipv6")
+
+		return
+	}
+
 	l.IP = ip4
 	err = s.srv4.AddStaticLease(l)
 	if err != nil {
@@ -570,13 +618,21 @@ func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = os.Remove(s.conf.DBFilePath)
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		log.Error("dhcp: removing db: %s", err)
+	err = s.journal.remove()
+	if err != nil {
+		log.Error("dhcp: %s", err)
+	}
+
+	if s.ddns != nil {
+		s.ddns.removeAll()
 	}
 
 	oldconf := s.conf
 	s.conf = ServerConfig{
+		DDNS:           oldconf.DDNS,
+		Hooks:          oldconf.Hooks,
+		Failover:       oldconf.Failover,
+		Monitor:        oldconf.Monitor,
 		WorkDir:        oldconf.WorkDir,
 		HTTPRegister:   oldconf.HTTPRegister,
 		ConfigModified: oldconf.ConfigModified,
@@ -618,6 +674,8 @@ func (s *Server) registerHandlers() {
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/remove_static_lease", s.handleDHCPRemoveStaticLease)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/reset", s.handleReset)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/reset_leases", s.handleResetLeases)
+	s.conf.HTTPRegister(http.MethodGet, "/control/dhcp/ddns_status", s.handleDDNSStatus)
+	s.conf.HTTPRegister(http.MethodGet, "/control/dhcp/failover_status", s.handleFailoverStatus)
 }
 
 // jsonError is a generic JSON error response.
@@ -659,4 +717,6 @@ func (s *Server) registerNotImplementedHandlers() {
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/remove_static_lease", h)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/reset", h)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/reset_leases", h)
+	s.conf.HTTPRegister(http.MethodGet, "/control/dhcp/ddns_status", h)
+	s.conf.HTTPRegister(http.MethodGet, "/control/dhcp/failover_status", h)
 }
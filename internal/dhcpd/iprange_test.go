@@ -147,3 +147,13 @@ func TestIPRange_Offset(t *testing.T) {
 		})
 	}
 }
+
+func TestIPRange_Len(t *testing.T) {
+	r, err := newIPRange(net.IP{0, 0, 0, 1}, net.IP{0, 0, 0, 5})
+	require.NoError(t, err)
+
+	assert.Equal(t, uint64(5), r.len())
+
+	r = nil
+	assert.Zero(t, r.len())
+}
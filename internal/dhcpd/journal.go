@@ -0,0 +1,451 @@
+package dhcpd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/google/renameio/maybe"
+)
+
+// journalSuffix is the suffix appended to the name of the lease database file
+// to get the name of the lease journal file.
+const journalSuffix = ".journal"
+
+// journalMinCompactRecords is the minimum number of records in the journal
+// after which it's compacted into the snapshot.
+const journalMinCompactRecords = 1024
+
+// maxJournalRecordLen is the maximum length of a single journal record.
+const maxJournalRecordLen = 64 * 1024
+
+// journalRecord is a single record of the lease journal.  Lease is nil if the
+// lease with IP and PrefixLen has been removed.
+type journalRecord struct {
+	Lease     *leaseJSON `json:"lease,omitempty"`
+	IP        net.IP     `json:"ip"`
+	PrefixLen int        `json:"prefix_len,omitempty"`
+}
+
+// leaseJournal is the persistent lease storage.  It consists of the snapshot,
+// which is a JSON array of leases in the format of the previous versions, and
+// the journal, which contains the changes made after the snapshot has been
+// written, one JSON record per line.  Each append to the journal is flushed to
+// disk before returning, and the journal is periodically compacted into the
+// snapshot.
+type leaseJournal struct {
+	// mu protects all fields below.
+	mu *sync.Mutex
+
+	// file is the journal file opened for appending.  It's nil until the
+	// first append.
+	file *os.File
+
+	// stored are the leases as of the last write, by their keys.
+	stored map[string]*leaseJSON
+
+	// snapshotPath is the path to the snapshot file.
+	snapshotPath string
+
+	// path is the path to the journal file.
+	path string
+
+	// order are the keys of stored in the order of addition.
+	order []string
+
+	// records is the number of records in the journal.
+	records int
+
+	// needsCompact is true if the journal file may contain a partially
+	// written record, so it must be rewritten before appending.
+	needsCompact bool
+}
+
+// newLeaseJournal returns a new lease journal using the snapshot file at
+// snapshotPath.
+func newLeaseJournal(snapshotPath string) (j *leaseJournal) {
+	return &leaseJournal{
+		mu:           &sync.Mutex{},
+		stored:       map[string]*leaseJSON{},
+		snapshotPath: snapshotPath,
+		path:         snapshotPath + journalSuffix,
+	}
+}
+
+// leaseKey returns the key of the lease with ip within the journal.
+// prefixLen is only non-zero for the delegated prefixes, so that these don't
+// collide with the addresses.
+func leaseKey(ip net.IP, prefixLen int) (key string) {
+	key = string(normalizeIP(ip))
+	if prefixLen != 0 {
+		key = fmt.Sprintf("%s/%d", key, prefixLen)
+	}
+
+	return key
+}
+
+// equal returns true if l and other describe the same lease.
+func (l *leaseJSON) equal(other *leaseJSON) (ok bool) {
+	if l.Hostname != other.Hostname ||
+		l.Expiry != other.Expiry ||
+		l.PrefixLen != other.PrefixLen ||
+		!bytes.Equal(l.HWAddr, other.HWAddr) ||
+		!bytes.Equal(l.IP, other.IP) ||
+		!bytes.Equal(l.DUID, other.DUID) ||
+		len(l.Options) != len(other.Options) {
+		return false
+	}
+
+	for i, o := range l.Options {
+		if o != other.Options[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// load reads the snapshot and replays the journal over it.  ok is false if
+// neither the snapshot nor the journal exist.
+func (j *leaseJournal) load() (leases []*leaseJSON, ok bool, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.stored, j.order, j.records, j.needsCompact = map[string]*leaseJSON{}, nil, 0, false
+
+	data, err := os.ReadFile(j.snapshotPath)
+	if err == nil {
+		ok = true
+
+		var snapshot []*leaseJSON
+		err = json.Unmarshal(data, &snapshot)
+		if err != nil {
+			return nil, false, fmt.Errorf("decoding db: %w", err)
+		}
+
+		for _, l := range snapshot {
+			j.put(l)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, fmt.Errorf("reading db: %w", err)
+	}
+
+	replayed, err := j.replay()
+	if err != nil {
+		return nil, false, fmt.Errorf("replaying journal: %w", err)
+	}
+
+	ok = ok || replayed
+
+	leases = make([]*leaseJSON, 0, len(j.stored))
+	for _, key := range j.order {
+		leases = append(leases, j.stored[key])
+	}
+
+	return leases, ok, nil
+}
+
+// replay applies the records of the journal file to the stored leases.  ok is
+// false if the journal file doesn't exist.  j.mu is expected to be locked.
+func (j *leaseJournal) replay() (ok bool, err error) {
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxJournalRecordLen)
+
+	for line := 1; sc.Scan(); line++ {
+		rec := &journalRecord{}
+		err = json.Unmarshal(sc.Bytes(), rec)
+		if err != nil {
+			// Most probably, the record has been partially written
+			// before a crash.  Skip it, since it hasn't been
+			// acknowledged.
+			log.Info("dhcp: journal: skipping record at line %d: %s", line, err)
+			j.needsCompact = true
+
+			continue
+		}
+
+		j.records++
+		if rec.Lease != nil {
+			j.put(rec.Lease)
+		} else {
+			j.del(leaseKey(rec.IP, rec.PrefixLen))
+		}
+	}
+
+	return true, sc.Err()
+}
+
+// put adds or replaces l in the stored leases.  j.mu is expected to be locked.
+func (j *leaseJournal) put(l *leaseJSON) {
+	key := leaseKey(l.IP, l.PrefixLen)
+	if _, ok := j.stored[key]; !ok {
+		j.order = append(j.order, key)
+	}
+
+	j.stored[key] = l
+}
+
+// del removes the lease with key from the stored leases.  j.mu is expected to
+// be locked.
+func (j *leaseJournal) del(key string) {
+	if _, ok := j.stored[key]; !ok {
+		return
+	}
+
+	delete(j.stored, key)
+	for i, k := range j.order {
+		if k == key {
+			j.order = append(j.order[:i], j.order[i+1:]...)
+
+			break
+		}
+	}
+}
+
+// store persists leases as the current state of the database by appending
+// the differences with the previously stored state to the journal.  It
+// compacts the journal if it has grown too large.
+func (j *leaseJournal) store(leases []*leaseJSON) (err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var recs []*journalRecord
+	current := make(map[string]*leaseJSON, len(leases))
+	for _, l := range leases {
+		key := leaseKey(l.IP, l.PrefixLen)
+		current[key] = l
+		if prev, ok := j.stored[key]; !ok || !prev.equal(l) {
+			recs = append(recs, &journalRecord{Lease: l, IP: l.IP, PrefixLen: l.PrefixLen})
+		}
+	}
+
+	for _, key := range j.order {
+		if _, ok := current[key]; !ok {
+			prev := j.stored[key]
+			recs = append(recs, &journalRecord{IP: prev.IP, PrefixLen: prev.PrefixLen})
+		}
+	}
+
+	if len(recs) == 0 {
+		return nil
+	}
+
+	for _, rec := range recs {
+		if rec.Lease != nil {
+			j.put(rec.Lease)
+		} else {
+			j.del(leaseKey(rec.IP, rec.PrefixLen))
+		}
+	}
+
+	limit := 2 * len(j.stored)
+	if limit < journalMinCompactRecords {
+		limit = journalMinCompactRecords
+	}
+
+	if j.needsCompact || j.records+len(recs) > limit {
+		return j.compactLocked()
+	}
+
+	err = j.append(recs)
+	if err != nil {
+		// The stored leases are already updated, so make sure that
+		// the next write persists all of them.
+		j.needsCompact = true
+	}
+
+	return err
+}
+
+// append writes recs to the end of the journal and flushes it to disk.  j.mu
+// is expected to be locked.
+func (j *leaseJournal) append(recs []*journalRecord) (err error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, rec := range recs {
+		// Encode adds the newline after each record.
+		err = enc.Encode(rec)
+		if err != nil {
+			return fmt.Errorf("encoding journal record: %w", err)
+		}
+	}
+
+	if j.file == nil {
+		j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("opening journal: %w", err)
+		}
+	}
+
+	_, err = j.file.Write(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+
+	err = j.file.Sync()
+	if err != nil {
+		return fmt.Errorf("syncing journal: %w", err)
+	}
+
+	j.records += len(recs)
+
+	return nil
+}
+
+// compact writes the stored leases into the snapshot and truncates the
+// journal.  It's a no-op if the journal is empty.
+func (j *leaseJournal) compact() (err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.records == 0 && !j.needsCompact {
+		return nil
+	}
+
+	return j.compactLocked()
+}
+
+// compactLocked writes the stored leases into the snapshot and truncates the
+// journal.  j.mu is expected to be locked.
+func (j *leaseJournal) compactLocked() (err error) {
+	// Use an empty slice here as opposed to nil so that it doesn't write
+	// "null" into the database file if leases are empty.
+	leases := make([]*leaseJSON, 0, len(j.stored))
+	for _, key := range j.order {
+		leases = append(leases, j.stored[key])
+	}
+
+	data, err := json.Marshal(leases)
+	if err != nil {
+		return fmt.Errorf("encoding db: %w", err)
+	}
+
+	// Replaying the journal over the new snapshot results in the same
+	// leases, so a crash before the truncation below loses nothing.
+	err = maybe.WriteFile(j.snapshotPath, data, 0o644)
+	if err != nil {
+		return fmt.Errorf("writing db: %w", err)
+	}
+
+	syncDir(filepath.Dir(j.snapshotPath))
+
+	err = j.truncate()
+	if err != nil {
+		return err
+	}
+
+	log.Debug("dhcp: journal: compacted %d records into %d leases", j.records, len(leases))
+
+	j.records, j.needsCompact = 0, false
+
+	return nil
+}
+
+// truncate removes all records from the journal file.  j.mu is expected to be
+// locked.
+func (j *leaseJournal) truncate() (err error) {
+	if j.file == nil {
+		err = os.Truncate(j.path, 0)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+	} else {
+		err = j.file.Truncate(0)
+		if err == nil {
+			err = j.file.Sync()
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("truncating journal: %w", err)
+	}
+
+	return nil
+}
+
+// close closes the journal file, if it's open.  The file is opened again on
+// the next append.
+func (j *leaseJournal) close() (err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.closeFile()
+}
+
+// closeFile closes the journal file, if it's open.  j.mu is expected to be
+// locked.
+func (j *leaseJournal) closeFile() (err error) {
+	if j.file == nil {
+		return nil
+	}
+
+	err = j.file.Close()
+	j.file = nil
+	if err != nil {
+		return fmt.Errorf("closing journal: %w", err)
+	}
+
+	return nil
+}
+
+// remove removes both the snapshot and the journal files and forgets the
+// stored leases.
+func (j *leaseJournal) remove() (err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var errs []error
+	if err = j.closeFile(); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, p := range []string{j.snapshotPath, j.path} {
+		if err = os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	j.stored, j.order, j.records, j.needsCompact = map[string]*leaseJSON{}, nil, 0, false
+
+	if len(errs) > 0 {
+		return errors.List("removing db", errs...)
+	}
+
+	return nil
+}
+
+// syncDir flushes the entries of the directory to disk so that the renamed
+// files survive a power loss.  Not all systems support that, so the errors are
+// only logged.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		log.Debug("dhcp: journal: opening dir: %s", err)
+
+		return
+	}
+	defer func() {
+		if err = d.Close(); err != nil {
+			log.Debug("dhcp: journal: closing dir: %s", err)
+		}
+	}()
+
+	if err = d.Sync(); err != nil {
+		log.Debug("dhcp: journal: syncing dir: %s", err)
+	}
+}
@@ -0,0 +1,170 @@
+package dhcpd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLeaseJSON returns a new lease with the last byte of IP set to b.
+func newTestLeaseJSON(b byte, host string) (l *leaseJSON) {
+	return &leaseJSON{
+		HWAddr:   []byte{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, b},
+		IP:       []byte{192, 168, 10, b},
+		Hostname: host,
+		Expiry:   leaseExpireStatic,
+	}
+}
+
+// journalLines returns the number of records in the journal file of j.
+func journalLines(t *testing.T, j *leaseJournal) (n int) {
+	t.Helper()
+
+	data, err := os.ReadFile(j.path)
+	require.NoError(t, err)
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		n++
+	}
+
+	return n
+}
+
+func TestLeaseJournal(t *testing.T) {
+	snapshotPath := filepath.Join(t.TempDir(), dbFilename)
+
+	// Write the database in the format of the previous versions.
+	data, err := json.Marshal([]*leaseJSON{newTestLeaseJSON(1, "host-1")})
+	require.NoError(t, err)
+
+	err = os.WriteFile(snapshotPath, data, 0o644)
+	require.NoError(t, err)
+
+	j := newLeaseJournal(snapshotPath)
+
+	t.Run("migrate", func(t *testing.T) {
+		leases, ok, lerr := j.load()
+		require.NoError(t, lerr)
+		require.True(t, ok)
+		require.Len(t, leases, 1)
+
+		assert.Equal(t, "host-1", leases[0].Hostname)
+	})
+
+	t.Run("append", func(t *testing.T) {
+		err = j.store([]*leaseJSON{
+			newTestLeaseJSON(1, "host-1"),
+			newTestLeaseJSON(2, "host-2"),
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, journalLines(t, j))
+
+		err = j.store([]*leaseJSON{newTestLeaseJSON(2, "host-2-new")})
+		require.NoError(t, err)
+
+		// One update and one removal.
+		assert.Equal(t, 3, journalLines(t, j))
+
+		// The snapshot itself is untouched.
+		var snapshot []*leaseJSON
+		data, err = os.ReadFile(snapshotPath)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &snapshot))
+
+		assert.Len(t, snapshot, 1)
+	})
+
+	t.Run("torn_record", func(t *testing.T) {
+		f, ferr := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0o644)
+		require.NoError(t, ferr)
+
+		_, err = f.Write([]byte(`{"lease":{"mac":`))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		loaded := newLeaseJournal(snapshotPath)
+		leases, ok, lerr := loaded.load()
+		require.NoError(t, lerr)
+		require.True(t, ok)
+		require.Len(t, leases, 1)
+
+		assert.Equal(t, "host-2-new", leases[0].Hostname)
+		assert.True(t, loaded.needsCompact)
+
+		require.NoError(t, loaded.compact())
+
+		assert.Zero(t, journalLines(t, loaded))
+
+		leases, _, lerr = newLeaseJournal(snapshotPath).load()
+		require.NoError(t, lerr)
+		require.Len(t, leases, 1)
+
+		assert.Equal(t, net.IP{192, 168, 10, 2}, net.IP(leases[0].IP))
+	})
+
+	t.Run("compact", func(t *testing.T) {
+		var l *leaseJSON
+		for i := 0; i <= journalMinCompactRecords; i++ {
+			l = newTestLeaseJSON(3, "host-3")
+			l.Expiry = int64(i + 2)
+			err = j.store([]*leaseJSON{l})
+			require.NoError(t, err)
+		}
+
+		assert.Less(t, journalLines(t, j), journalMinCompactRecords)
+
+		leases, _, lerr := newLeaseJournal(snapshotPath).load()
+		require.NoError(t, lerr)
+		require.Len(t, leases, 1)
+
+		assert.Equal(t, l.Expiry, leases[0].Expiry)
+	})
+
+	t.Run("prefix", func(t *testing.T) {
+		addr := newTestLeaseJSON(4, "host-4")
+		addr.IP = net.ParseIP("2001:db8::")
+
+		prefix := newTestLeaseJSON(5, "")
+		prefix.IP, prefix.PrefixLen = net.ParseIP("2001:db8::"), 56
+
+		err = j.store([]*leaseJSON{addr, prefix})
+		require.NoError(t, err)
+
+		err = j.store([]*leaseJSON{prefix})
+		require.NoError(t, err)
+
+		leases, _, lerr := newLeaseJournal(snapshotPath).load()
+		require.NoError(t, lerr)
+		require.Len(t, leases, 1)
+
+		assert.Equal(t, 56, leases[0].PrefixLen)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		require.NoError(t, j.close())
+		assert.Nil(t, j.file)
+
+		// The journal is opened again on the next append.
+		err = j.store([]*leaseJSON{newTestLeaseJSON(6, "host-6")})
+		require.NoError(t, err)
+		assert.NotNil(t, j.file)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		require.NoError(t, j.remove())
+
+		_, ok, lerr := newLeaseJournal(snapshotPath).load()
+		require.NoError(t, lerr)
+
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,49 @@
+package dhcpd
+
+// defaultPoolAlertThreshold is the default utilization of an address pool, in
+// percent, after which the alert is emitted.
+const defaultPoolAlertThreshold = 90
+
+// dhcpMetrics are the counters of the DHCPv4 messages and the utilization of
+// the address pools.
+type dhcpMetrics struct {
+	// Pools is the utilization of the address pools.  The first one is the
+	// default pool.
+	Pools []*poolMetrics `json:"pools"`
+
+	// Discover, Request, Decline, and Release are the numbers of the
+	// received messages.
+	Discover uint64 `json:"discover"`
+	Request  uint64 `json:"request"`
+	Decline  uint64 `json:"decline"`
+	Release  uint64 `json:"release"`
+
+	// Offer, Ack, and Nak are the numbers of the sent messages.
+	Offer uint64 `json:"offer"`
+	Ack   uint64 `json:"ack"`
+	Nak   uint64 `json:"nak"`
+
+	// Conflicts is the number of the addresses found in use by the ICMP
+	// check before offering them.
+	Conflicts uint64 `json:"conflicts"`
+}
+
+// poolMetrics is the utilization of an address pool.
+type poolMetrics struct {
+	// Scope is the name of the scope of the pool.  It's empty for the
+	// default pool.
+	Scope string `json:"scope"`
+
+	// Size is the number of addresses in the pool.
+	Size uint64 `json:"size"`
+
+	// Used is the number of the leased and the temporarily blocked
+	// addresses in the pool.
+	Used uint64 `json:"used"`
+
+	// Utilization is Used relative to Size, in percent.
+	Utilization float64 `json:"utilization"`
+
+	// Alert is true if Utilization has reached the alert threshold.
+	Alert bool `json:"alert"`
+}
@@ -0,0 +1,375 @@
+package dhcpd
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+const (
+	// defaultMonitorIvl is the default interval between the probes for
+	// other DHCP servers.
+	defaultMonitorIvl = 10 * time.Minute
+
+	// minMonitorIvl is the minimum interval between the probes.  A single
+	// probe already takes several seconds.
+	minMonitorIvl = time.Minute
+
+	// monitorStaleProbes is the number of probes after which a server which
+	// hasn't replied is forgotten.
+	monitorStaleProbes = 3
+)
+
+// MonitorConfig is the configuration of the background monitor of other DHCP
+// servers in the network of the configured interface.
+type MonitorConfig struct {
+	// Enabled defines if the network is probed for other DHCP servers
+	// while the DHCP server is running.
+	Enabled bool `yaml:"enabled"`
+
+	// Interval is the time between the probes.  The default is 10 minutes.
+	Interval timeutil.Duration `yaml:"interval"`
+
+	// AllowedServers are the server identifiers, IP addresses, and hardware
+	// addresses of the DHCP servers expected in the network.  Such servers
+	// are recorded but don't raise an alert.
+	AllowedServers []string `yaml:"allowed_servers"`
+}
+
+// OtherServer is a DHCP server found in the network by the monitor.
+type OtherServer struct {
+	// FirstSeen is the time of the first offer from the server.
+	FirstSeen time.Time `json:"first_seen"`
+
+	// LastSeen is the time of the latest offer from the server.
+	LastSeen time.Time `json:"last_seen"`
+
+	// ServerID identifies the server.  It's the address from the server
+	// identifier option for DHCPv4 and the hexadecimal DUID for DHCPv6.
+	ServerID string `json:"server_id"`
+
+	// IP is the source address of the offer.
+	IP string `json:"ip"`
+
+	// HWAddr is the hardware address of the server, if known.
+	HWAddr string `json:"mac,omitempty"`
+
+	// OfferedIP is the address the server has offered.
+	OfferedIP string `json:"offered_ip,omitempty"`
+
+	// OfferedRange is the network of the offered address in the CIDR
+	// notation.  It's only known for DHCPv4.
+	OfferedRange string `json:"offered_range,omitempty"`
+
+	// Router is the default gateway the server has offered.  It's only
+	// known for DHCPv4.
+	Router string `json:"router,omitempty"`
+
+	// Version is the version of the DHCP protocol, either 4 or 6.
+	Version int `json:"version"`
+
+	// Unexpected is true if the server isn't one of the allowed servers.
+	Unexpected bool `json:"unexpected"`
+}
+
+// clone returns a deep copy of srv.
+func (srv *OtherServer) clone() (c *OtherServer) {
+	cp := *srv
+
+	return &cp
+}
+
+// ownAddrs are the addresses of the network interface the monitor probes.  The
+// offers from these addresses are made by this server and are ignored.
+type ownAddrs struct {
+	hwAddr net.HardwareAddr
+	ips    []net.IP
+}
+
+// has returns true if o is made by this server.
+func (own *ownAddrs) has(o *aghnet.DHCPOffer) (ok bool) {
+	if len(own.hwAddr) > 0 && o.HWAddr.String() == own.hwAddr.String() {
+		return true
+	}
+
+	for _, ip := range own.ips {
+		if ip.Equal(o.SrcIP) || ip.String() == o.ServerID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dhcpMonitor periodically probes the network for other DHCP servers and
+// records their offers.
+type dhcpMonitor struct {
+	// allowed are the normalized addresses and identifiers of the expected
+	// servers.
+	allowed *stringutil.Set
+
+	// find probes the network of the interface for DHCP servers.
+	find func(ifaceName string) (offers4, offers6 []*aghnet.DHCPOffer, err4, err6 error)
+
+	// own returns the addresses of the interface.
+	own func(ifaceName string) (own *ownAddrs, err error)
+
+	// now returns the current time.
+	now func() (t time.Time)
+
+	// servers are the servers found so far by their versions and
+	// identifiers.
+	servers map[string]*OtherServer
+
+	// done is closed to stop the worker.  It's nil if the worker isn't
+	// running.
+	done chan struct{}
+
+	// ivl is the interval between the probes.
+	ivl time.Duration
+
+	// mu protects servers and done.
+	mu sync.Mutex
+}
+
+// newDHCPMonitor returns a new properly initialized monitor.
+func newDHCPMonitor(conf *MonitorConfig) (m *dhcpMonitor, err error) {
+	ivl := conf.Interval.Duration
+	if ivl == 0 {
+		ivl = defaultMonitorIvl
+	} else if ivl < minMonitorIvl {
+		return nil, fmt.Errorf("monitor: interval %s is less than %s", ivl, minMonitorIvl)
+	}
+
+	allowed := stringutil.NewSet()
+	for _, a := range conf.AllowedServers {
+		allowed.Add(normalizeServerAddr(a))
+	}
+
+	return &dhcpMonitor{
+		allowed: allowed,
+		find:    aghnet.FindOtherDHCP,
+		own:     ifaceOwnAddrs,
+		now:     time.Now,
+		servers: map[string]*OtherServer{},
+		ivl:     ivl,
+	}, nil
+}
+
+// normalizeServerAddr returns the canonical form of the address or the
+// identifier of a server to compare them.
+func normalizeServerAddr(addr string) (norm string) {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String()
+	} else if mac, err := net.ParseMAC(addr); err == nil {
+		return mac.String()
+	}
+
+	return strings.ToLower(addr)
+}
+
+// ifaceOwnAddrs returns the addresses of the network interface named
+// ifaceName.
+func ifaceOwnAddrs(ifaceName string) (own *ownAddrs, err error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, fmt.Errorf("finding interface %s: %w", ifaceName, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("getting addresses of %s: %w", ifaceName, err)
+	}
+
+	own = &ownAddrs{
+		hwAddr: iface.HardwareAddr,
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok {
+			own.ips = append(own.ips, ipNet.IP)
+		}
+	}
+
+	return own, nil
+}
+
+// start starts the worker probing the network of the interface named
+// ifaceName if it isn't running yet.
+func (m *dhcpMonitor) start(ifaceName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.done != nil {
+		return
+	}
+
+	m.done = make(chan struct{})
+	go m.run(ifaceName, m.done)
+}
+
+// stop stops the worker if it's running.
+func (m *dhcpMonitor) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.done == nil {
+		return
+	}
+
+	close(m.done)
+	m.done = nil
+}
+
+// run probes the network periodically.
+func (m *dhcpMonitor) run(ifaceName string, done <-chan struct{}) {
+	defer log.OnPanic("dhcp monitor")
+
+	ticker := time.NewTicker(m.ivl)
+	defer ticker.Stop()
+
+	m.probe(ifaceName)
+	for {
+		select {
+		case <-ticker.C:
+			m.probe(ifaceName)
+		case <-done:
+			return
+		}
+	}
+}
+
+// probe discovers the DHCP servers in the network of the interface named
+// ifaceName and records their offers.
+func (m *dhcpMonitor) probe(ifaceName string) {
+	own, err := m.own(ifaceName)
+	if err != nil {
+		log.Debug("dhcp monitor: %s", err)
+
+		return
+	}
+
+	offers4, offers6, err4, err6 := m.find(ifaceName)
+	if err4 != nil {
+		log.Debug("dhcp monitor: probing dhcpv4: %s", err4)
+	}
+
+	if err6 != nil {
+		log.Debug("dhcp monitor: probing dhcpv6: %s", err6)
+	}
+
+	m.record(own, offers4, offers6)
+}
+
+// record adds the offers not made by own to the found servers and forgets the
+// servers which haven't made any offer for a while.
+func (m *dhcpMonitor) record(own *ownAddrs, offers4, offers6 []*aghnet.DHCPOffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for _, o := range offers4 {
+		m.recordOffer(now, own, o, 4)
+	}
+
+	for _, o := range offers6 {
+		m.recordOffer(now, own, o, 6)
+	}
+
+	for key, srv := range m.servers {
+		if now.Sub(srv.LastSeen) > monitorStaleProbes*m.ivl {
+			log.Debug("dhcp monitor: forgetting dhcpv%d server %s", srv.Version, srv.ServerID)
+
+			delete(m.servers, key)
+		}
+	}
+}
+
+// recordOffer adds the offer made by a DHCPv4 or DHCPv6 server depending on
+// version.  m.mu is expected to be locked.
+func (m *dhcpMonitor) recordOffer(now time.Time, own *ownAddrs, o *aghnet.DHCPOffer, version int) {
+	if own.has(o) {
+		return
+	}
+
+	key := fmt.Sprintf("%d %s", version, o.ServerID)
+	srv, ok := m.servers[key]
+	if !ok {
+		srv = &OtherServer{
+			FirstSeen:  now,
+			ServerID:   o.ServerID,
+			Version:    version,
+			Unexpected: !m.isAllowed(o),
+		}
+		m.servers[key] = srv
+
+		if srv.Unexpected {
+			log.Info(
+				"dhcp monitor: warning: unexpected dhcpv%d server %s at %s offers %s",
+				version,
+				o.ServerID,
+				o.SrcIP,
+				o.OfferedIP,
+			)
+		}
+	}
+
+	srv.LastSeen = now
+	srv.IP = o.SrcIP.String()
+	if o.HWAddr != nil {
+		srv.HWAddr = o.HWAddr.String()
+	}
+
+	srv.OfferedIP, srv.OfferedRange, srv.Router = "", "", ""
+	if o.OfferedIP != nil {
+		srv.OfferedIP = o.OfferedIP.String()
+	}
+
+	if o.Subnet != nil {
+		srv.OfferedRange = o.Subnet.String()
+	}
+
+	if o.Router != nil {
+		srv.Router = o.Router.String()
+	}
+}
+
+// isAllowed returns true if the server which has made o is expected.
+func (m *dhcpMonitor) isAllowed(o *aghnet.DHCPOffer) (ok bool) {
+	ok = m.allowed.Has(normalizeServerAddr(o.ServerID))
+	ok = ok || (o.SrcIP != nil && m.allowed.Has(o.SrcIP.String()))
+	ok = ok || (o.HWAddr != nil && m.allowed.Has(o.HWAddr.String()))
+
+	return ok
+}
+
+// list returns the copies of the found servers sorted by version and
+// identifier.  If unexpected is true, only the unexpected servers are
+// returned.
+func (m *dhcpMonitor) list(unexpected bool) (servers []*OtherServer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, srv := range m.servers {
+		if !unexpected || srv.Unexpected {
+			servers = append(servers, srv.clone())
+		}
+	}
+
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].Version != servers[j].Version {
+			return servers[i].Version < servers[j].Version
+		}
+
+		return servers[i].ServerID < servers[j].ServerID
+	})
+
+	return servers
+}
@@ -0,0 +1,89 @@
+package dhcpd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDHCPMonitor(t *testing.T) {
+	m, err := newDHCPMonitor(&MonitorConfig{Enabled: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, defaultMonitorIvl, m.ivl)
+
+	_, err = newDHCPMonitor(&MonitorConfig{
+		Enabled:  true,
+		Interval: timeutil.Duration{Duration: time.Second},
+	})
+	testutil.AssertErrorMsg(t, "monitor: interval 1s is less than 1m0s", err)
+}
+
+func TestDHCPMonitor_record(t *testing.T) {
+	m, err := newDHCPMonitor(&MonitorConfig{
+		Enabled:        true,
+		AllowedServers: []string{"AA:AA:AA:AA:AA:02"},
+	})
+	require.NoError(t, err)
+
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() (t time.Time) { return now }
+
+	own := &ownAddrs{
+		hwAddr: net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x01},
+		ips:    []net.IP{{192, 168, 10, 1}},
+	}
+	newOffer := func(b byte) (o *aghnet.DHCPOffer) {
+		ip := net.IP{192, 168, 10, b}
+
+		return &aghnet.DHCPOffer{
+			ServerID:  ip.String(),
+			SrcIP:     ip,
+			HWAddr:    net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, b},
+			OfferedIP: net.IP{192, 168, 10, 100},
+			Subnet: &net.IPNet{
+				IP:   net.IP{192, 168, 10, 0},
+				Mask: net.CIDRMask(24, 32),
+			},
+			Router: ip,
+		}
+	}
+
+	m.record(own, []*aghnet.DHCPOffer{newOffer(1), newOffer(2), newOffer(3)}, nil)
+
+	servers := m.list(false)
+	require.Len(t, servers, 2)
+
+	assert.Equal(t, &OtherServer{
+		FirstSeen:    now,
+		LastSeen:     now,
+		ServerID:     "192.168.10.2",
+		IP:           "192.168.10.2",
+		HWAddr:       "aa:aa:aa:aa:aa:02",
+		OfferedIP:    "192.168.10.100",
+		OfferedRange: "192.168.10.0/24",
+		Router:       "192.168.10.2",
+		Version:      4,
+		Unexpected:   false,
+	}, servers[0])
+	assert.Equal(t, "192.168.10.3", servers[1].ServerID)
+	assert.True(t, servers[1].Unexpected)
+
+	unexpected := m.list(true)
+	require.Len(t, unexpected, 1)
+
+	assert.Equal(t, "192.168.10.3", unexpected[0].ServerID)
+
+	// The server which has stopped replying is forgotten.
+	now = now.Add(monitorStaleProbes*defaultMonitorIvl + time.Second)
+	m.record(own, []*aghnet.DHCPOffer{newOffer(2)}, nil)
+
+	assert.Empty(t, m.list(true))
+	assert.Len(t, m.list(false), 1)
+}
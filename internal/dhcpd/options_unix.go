@@ -4,6 +4,7 @@
 package dhcpd
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"net"
@@ -13,6 +14,7 @@ import (
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/stringutil"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 )
 
@@ -190,3 +192,89 @@ func prepareOptions(conf V4ServerConf) (opts dhcpv4.Options) {
 
 	return opts
 }
+
+// parseDHCPOptions parses the options from their string representations.
+func parseDHCPOptions(strs []string) (opts dhcpv4.Options, err error) {
+	opts = dhcpv4.Options{}
+	for i, o := range strs {
+		var opt dhcpv4.Option
+		opt, err = parseDHCPOption(o)
+		if err != nil {
+			return nil, fmt.Errorf("option at index %d: %w", i, err)
+		}
+
+		opts.Update(opt)
+	}
+
+	return opts, nil
+}
+
+// v4ClassOptions are the parsed options for a class of DHCPv4 clients.
+type v4ClassOptions struct {
+	// options are the options to return to the clients of the class.
+	options dhcpv4.Options
+
+	// vendorClass is the prefix of the vendor class identifier.
+	vendorClass string
+
+	// userClass is the user class.
+	userClass string
+
+	// hwAddrs are the hardware addresses of the clients.
+	hwAddrs []net.HardwareAddr
+}
+
+// newV4ClassOptions validates conf and returns the parsed class options.
+func newV4ClassOptions(conf *V4ClassOptions) (c *v4ClassOptions, err error) {
+	if conf == nil {
+		return nil, errors.Error("nil class")
+	} else if len(conf.HWAddrs) == 0 && conf.VendorClass == "" && conf.UserClass == "" {
+		return nil, errors.Error("no criteria")
+	}
+
+	c = &v4ClassOptions{
+		vendorClass: conf.VendorClass,
+		userClass:   conf.UserClass,
+	}
+
+	for i, s := range conf.HWAddrs {
+		var mac net.HardwareAddr
+		mac, err = net.ParseMAC(s)
+		if err != nil {
+			return nil, fmt.Errorf("hardware address at index %d: %w", i, err)
+		}
+
+		c.hwAddrs = append(c.hwAddrs, mac)
+	}
+
+	c.options, err = parseDHCPOptions(conf.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// matches returns true if the client sending req belongs to the class.
+func (c *v4ClassOptions) matches(req *dhcpv4.DHCPv4) (ok bool) {
+	if len(c.hwAddrs) > 0 && !containsMAC(c.hwAddrs, req.ClientHWAddr) {
+		return false
+	}
+
+	if c.vendorClass != "" && !strings.HasPrefix(req.ClassIdentifier(), c.vendorClass) {
+		return false
+	}
+
+	return c.userClass == "" || stringutil.InSlice(req.UserClass(), c.userClass)
+}
+
+// containsMAC returns true if macs contains mac.
+func containsMAC(macs []net.HardwareAddr, mac net.HardwareAddr) (ok bool) {
+	for _, m := range macs {
+		if bytes.Equal(m, mac) {
+			return true
+		}
+	}
+
+	return false
+}
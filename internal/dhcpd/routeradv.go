@@ -9,6 +9,7 @@ import (
 
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/insomniacslk/dhcp/rfc1035label"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv6"
 )
@@ -16,8 +17,10 @@ import (
 type raCtx struct {
 	raAllowSLAAC     bool   // send RA packets without MO flags
 	raSLAACOnly      bool   // send RA packets with MO flags
+	stateless        bool   // send RA packets with O flag only
 	ipAddr           net.IP // source IP address (link-local-unicast)
 	dnsIPAddr        net.IP // IP address for DNS Server option
+	domainSearchList []string
 	prefixIPAddr     net.IP // IP address for Prefix option
 	ifaceName        string
 	iface            *net.Interface
@@ -34,6 +37,7 @@ type icmpv6RA struct {
 	prefixLen                   int
 	sourceLinkLayerAddress      net.HardwareAddr
 	recursiveDNSServer          net.IP
+	domainSearchList            []string
 	mtu                         uint32
 }
 
@@ -98,6 +102,12 @@ func hwAddrToLinkLayerAddr(hwa net.HardwareAddr) (lla []byte, err error) {
 //     Reserved[2]
 //     Lifetime[4]
 //     Addresses of IPv6 Recursive DNS Servers[16]
+//   Option=DNS Search List(31), if there are any domains:
+//     Type[1]
+//     Length * 8bytes[1]
+//     Reserved[2]
+//     Lifetime[4]
+//     Domain Names of DNS Search List[N], padded with zeros
 func createICMPv6RAPacket(params icmpv6RA) (data []byte, err error) {
 	var lla []byte
 	lla, err = hwAddrToLinkLayerAddr(params.sourceLinkLayerAddress)
@@ -105,9 +115,11 @@ func createICMPv6RAPacket(params icmpv6RA) (data []byte, err error) {
 		return nil, fmt.Errorf("converting source link layer address: %w", err)
 	}
 
+	dnssl := dnsslOption(params.domainSearchList)
+
 	// TODO(a.garipov): Don't use a magic constant here.  Refactor the code
 	// and make all constants named instead of all those comments..
-	data = make([]byte, 82+len(lla))
+	data = make([]byte, 82+len(lla), 82+len(lla)+len(dnssl))
 	i := 0
 
 	// ICMPv6:
@@ -189,7 +201,34 @@ func createICMPv6RAPacket(params icmpv6RA) (data []byte, err error) {
 	i += 4
 	copy(data[i:], params.recursiveDNSServer) // Addresses of IPv6 Recursive DNS Servers[16]
 
-	return data, nil
+	return append(data, dnssl...), nil
+}
+
+// dnsslOption returns the DNS Search List option of a router advertisement
+// containing names.  opt is nil if names are empty.
+//
+// See https://datatracker.ietf.org/doc/html/rfc8106#section-5.2.
+func dnsslOption(names []string) (opt []byte) {
+	if len(names) == 0 {
+		return nil
+	}
+
+	encoded := (&rfc1035label.Labels{Labels: names}).ToBytes()
+
+	// The option is padded with zeros to a multiple of 8 bytes.
+	l := 8 + len(encoded)
+	l += (8 - l%8) % 8
+
+	opt = make([]byte, l)
+	opt[0] = 31          // Type
+	opt[1] = byte(l / 8) // Length
+
+	// Reserved[2] are left zero.
+
+	binary.BigEndian.PutUint32(opt[4:], 3600) // Lifetime[4]
+	copy(opt[8:], encoded)                    // Domain Names of DNS Search List[N]
+
+	return opt
 }
 
 // Init - initialize RA module
@@ -204,11 +243,12 @@ func (ra *raCtx) Init() (err error) {
 		ra.ipAddr, ra.dnsIPAddr)
 
 	params := icmpv6RA{
-		managedAddressConfiguration: !ra.raSLAACOnly,
+		managedAddressConfiguration: !ra.raSLAACOnly && !ra.stateless,
 		otherConfiguration:          !ra.raSLAACOnly,
 		mtu:                         uint32(ra.iface.MTU),
 		prefixLen:                   64,
 		recursiveDNSServer:          ra.dnsIPAddr,
+		domainSearchList:            ra.domainSearchList,
 		sourceLinkLayerAddress:      ra.iface.HardwareAddr,
 	}
 	params.prefix = make([]byte, 16)
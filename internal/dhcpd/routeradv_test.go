@@ -36,3 +36,17 @@ func TestCreateICMPv6RAPacket(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, wantData, gotData)
 }
+
+func TestDNSSLOption(t *testing.T) {
+	assert.Nil(t, dnsslOption(nil))
+
+	// The 18 bytes of the domains are padded to 24.
+	wantData := []byte{
+		0x1f, 0x04, 0x00, 0x00, 0x00, 0x00, 0x0e, 0x10,
+		0x03, 'l', 'a', 'n', 0x00, 0x07, 'e', 'x',
+		'a', 'm', 'p', 'l', 'e', 0x03, 'o', 'r',
+		'g', 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	assert.Equal(t, wantData, dnsslOption([]string{"lan", "example.org"}))
+}
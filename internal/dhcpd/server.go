@@ -29,6 +29,23 @@ type DHCPServer interface {
 	Stop() (err error)
 
 	getLeasesRef() []*Lease
+
+	// ResetPrefixLeases resets the delegated prefixes.
+	ResetPrefixLeases(leases []*PrefixLease) (err error)
+	// GetPrefixLeases returns deep clones of the current delegated
+	// prefixes.
+	GetPrefixLeases() (leases []*PrefixLease)
+
+	getPrefixLeasesRef() []*PrefixLease
+
+	// syncLease applies the dynamic lease received from the failover peer.
+	// If remove is true, the lease is removed instead.
+	syncLease(l *Lease, remove bool) (err error)
+
+	// metrics returns the counters of the messages and the utilization of
+	// the address pools.  It returns nil if the server doesn't collect
+	// them.
+	metrics() (m *dhcpMetrics)
 }
 
 // V4ServerConf - server configuration
@@ -53,6 +70,10 @@ type V4ServerConf struct {
 	// 0: disable
 	ICMPTimeout uint32 `yaml:"icmp_timeout_msec" json:"-"`
 
+	// PoolAlertThreshold is the utilization of an address pool, in percent,
+	// after which an alert is emitted.  The default is 90.
+	PoolAlertThreshold uint8 `yaml:"pool_alert_threshold" json:"-"`
+
 	// Custom Options.
 	//
 	// Option with arbitrary hexadecimal data:
@@ -63,6 +84,19 @@ type V4ServerConf struct {
 	//     DEC_CODE ip IP_ADDR
 	Options []string `yaml:"options" json:"-"`
 
+	// ClassOptions are the custom options for the clients matching by their
+	// hardware addresses, vendor class identifiers, or user classes.  These
+	// override the options above and the options of the scopes, and are
+	// applied in the order of appearance.
+	ClassOptions []*V4ClassOptions `yaml:"class_options" json:"-"`
+
+	// PXE is the configuration of the network boot of the clients.
+	PXE V4PXEConf `yaml:"pxe" json:"-"`
+
+	// Scopes are the additional address pools served along with the one
+	// configured above.
+	Scopes []*V4Scope `yaml:"scopes" json:"scopes"`
+
 	ipRange *ipRange
 
 	leaseTime  time.Duration // the time during which a dynamic lease is considered valid
@@ -79,6 +113,103 @@ type V4ServerConf struct {
 	// TODO(a.garipov): This is utter madness and must be refactored.  It
 	// just begs for deadlock bugs and other nastiness.
 	notify func(uint32)
+
+	// serves returns true if the server should answer the client with the
+	// hardware address.  If it's nil, all clients are answered.
+	serves func(id []byte) (ok bool)
+
+	// owns returns true if the server may allocate the free address with the
+	// offset within a pool of n addresses.  If it's nil, all addresses may be
+	// allocated.
+	owns func(offset, n uint64) (ok bool)
+}
+
+// V4Scope is the configuration of an additional DHCPv4 address pool.  A scope
+// serves either the clients directly connected to the network interface named
+// InterfaceName or, if it's empty, the clients behind the DHCP relay agents.
+type V4Scope struct {
+	// Name is the unique name of the scope.
+	Name string `yaml:"name" json:"name"`
+
+	// InterfaceName is the name of the network interface to serve the scope
+	// on.  It must differ from the interface of the server itself.
+	InterfaceName string `yaml:"interface_name" json:"interface_name"`
+
+	// RelayAddrs are the addresses of the relay agents, as they appear in the
+	// giaddr field of the requests, which serve the scope.  The relayed
+	// requests not matching any scope by the relay address are matched by the
+	// subnet containing the giaddr or the address from the link selection
+	// suboption of the relay agent information option.
+	RelayAddrs []net.IP `yaml:"relay_addrs" json:"relay_addrs"`
+
+	// CircuitIDs are the values of the agent circuit ID suboption of the relay
+	// agent information option, also known as option 82, which select the
+	// scope.  These take precedence over RelayAddrs.
+	CircuitIDs []string `yaml:"circuit_ids" json:"circuit_ids"`
+
+	GatewayIP  net.IP `yaml:"gateway_ip" json:"gateway_ip"`
+	SubnetMask net.IP `yaml:"subnet_mask" json:"subnet_mask"`
+	RangeStart net.IP `yaml:"range_start" json:"range_start"`
+	RangeEnd   net.IP `yaml:"range_end" json:"range_end"`
+
+	// LeaseDuration is the lease duration in seconds.  If it's zero, the
+	// lease duration of the server is used.
+	LeaseDuration uint32 `yaml:"lease_duration" json:"lease_duration"`
+
+	// DNSServers are the addresses to return to the clients of the scope as
+	// the DNS server addresses.  If it's empty, the addresses of the server
+	// itself are returned.
+	DNSServers []net.IP `yaml:"dns_servers" json:"dns_servers"`
+
+	// Options are the custom options of the scope in the same format as the
+	// options of the server.  These override the options of the server.
+	Options []string `yaml:"options" json:"options"`
+}
+
+// V4ClassOptions are the custom DHCPv4 options for a class of clients.  A
+// client belongs to the class if it matches all of the non-empty criteria.
+type V4ClassOptions struct {
+	// HWAddrs are the hardware addresses of the clients.
+	HWAddrs []string `yaml:"hw_addrs"`
+
+	// VendorClass is the prefix of the vendor class identifier, option 60, of
+	// the clients, for example "PXEClient".
+	VendorClass string `yaml:"vendor_class"`
+
+	// UserClass is one of the user classes, option 77, of the clients.
+	UserClass string `yaml:"user_class"`
+
+	// Options are the options for the class in the same format as the
+	// options of the server.
+	Options []string `yaml:"options"`
+}
+
+// V4PXEConf is the configuration of the network boot of DHCPv4 clients.  It's
+// only applied to the clients identifying themselves as PXE clients by the
+// vendor class identifier or the client system architecture option.
+type V4PXEConf struct {
+	// Enabled defines if the network boot is enabled.
+	Enabled bool `yaml:"enabled"`
+
+	// NextServer is the address of the boot server to put into the siaddr
+	// field.
+	NextServer net.IP `yaml:"next_server"`
+
+	// ServerName is the name of the boot server to put into the sname field
+	// and the TFTP server name option, option 66.  If it's empty, the option
+	// contains NextServer.
+	ServerName string `yaml:"server_name"`
+
+	// BootFile is the name of the boot file to put into the file field and
+	// the boot file name option, option 67, if there is no boot file for the
+	// architecture of the client.
+	BootFile string `yaml:"boot_file"`
+
+	// ArchBootFiles are the names of the boot files by the client system
+	// architecture, option 93.  The keys are either the decimal architecture
+	// types from RFC 4578 or one of "bios", "efi_ia32", "efi_x64",
+	// "efi_arm32", and "efi_arm64".
+	ArchBootFiles map[string]string `yaml:"arch_boot_files"`
 }
 
 // V6ServerConf - server configuration
@@ -95,10 +226,52 @@ type V6ServerConf struct {
 	RASLAACOnly  bool `yaml:"ra_slaac_only" json:"-"`  // send ICMPv6.RA packets without MO flags
 	RAAllowSLAAC bool `yaml:"ra_allow_slaac" json:"-"` // send ICMPv6.RA packets with MO flags
 
+	// Stateless defines if the server only answers the information requests
+	// with the DNS options, leaving the address configuration to SLAAC.  See
+	// RFC 8415, section 6.1.  RangeStart is only required in this mode for
+	// sending the router advertisements.
+	Stateless bool `yaml:"stateless" json:"-"`
+
+	// DomainSearch are the domain names to return in the domain search list
+	// option and in the DNSSL option of the router advertisements.
+	DomainSearch []string `yaml:"domain_search" json:"-"`
+
+	// PDPrefix is the pool of the prefixes to delegate to the downstream
+	// routers, for example "2001:db8:100::/48".  If it's empty, the prefix
+	// delegation is disabled.
+	PDPrefix string `yaml:"pd_prefix" json:"pd_prefix"`
+
+	// PDPrefixLen is the length of the delegated prefixes.  If it's zero,
+	// defaultPDPrefixLen is used.
+	PDPrefixLen int `yaml:"pd_prefix_len" json:"pd_prefix_len"`
+
+	// PDStaticPrefixes are the prefixes reserved for the routers with the
+	// specified hardware addresses.
+	PDStaticPrefixes []*V6PrefixReservation `yaml:"pd_static_prefixes" json:"-"`
+
 	ipStart    net.IP        // starting IP address for dynamic leases
 	leaseTime  time.Duration // the time during which a dynamic lease is considered valid
 	dnsIPAddrs []net.IP      // IPv6 addresses to return to DHCP clients as DNS server addresses
 
 	// Server calls this function when leases data changes
 	notify func(uint32)
+
+	// serves returns true if the server should answer the client with the
+	// DUID.  If it's nil, all clients are answered.
+	serves func(id []byte) (ok bool)
+
+	// owns returns true if the server may allocate the free address with the
+	// offset within a pool of n addresses.  If it's nil, all addresses may be
+	// allocated.
+	owns func(offset, n uint64) (ok bool)
+}
+
+// V6PrefixReservation is a static delegation of an IPv6 prefix.
+type V6PrefixReservation struct {
+	// HWAddr is the hardware address of the router.
+	HWAddr string `yaml:"hw_addr"`
+
+	// Prefix is the prefix delegated to the router, for example
+	// "2001:db8:100:ff00::/56".  It may be outside of the delegation pool.
+	Prefix string `yaml:"prefix"`
 }
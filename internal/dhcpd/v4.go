@@ -28,11 +28,21 @@ import (
 // TODO(a.garipov): Think about unifying this and v6Server.
 type v4Server struct {
 	conf V4ServerConf
-	srv  *server4.Server
 
-	// leasedOffsets contains offsets from conf.ipRange.start that have been
-	// leased.
-	leasedOffsets *bitSet
+	// srvs are the servers listening on the interface of the server and on the
+	// interfaces of the scopes.
+	srvs []*server4.Server
+
+	// scopes are the address pools of the server.  The first one is the
+	// default scope created from conf.
+	scopes []*v4Scope
+
+	// classOptions are the custom options for the classes of clients.
+	classOptions []*v4ClassOptions
+
+	// pxe is the network boot configuration.  It's nil if the network boot
+	// is disabled.
+	pxe *v4PXE
 
 	// leaseHosts is the set of all hostnames of all known DHCP clients.
 	leaseHosts *stringutil.Set
@@ -40,11 +50,15 @@ type v4Server struct {
 	// leases contains all dynamic and static leases.
 	leases []*Lease
 
-	// leasesLock protects leases, leaseHosts, and leasedOffsets.
+	// leasesLock protects leases, leaseHosts, and the leased offsets and the
+	// alerts of the scopes.
 	leasesLock sync.Mutex
 
-	// options holds predefined DHCP options to return to clients.
-	options dhcpv4.Options
+	// counters are the counters of the messages.  The pools aren't set.
+	counters dhcpMetrics
+
+	// countersLock protects counters.
+	countersLock sync.Mutex
 }
 
 // WriteDiskConfig4 - write configuration
@@ -110,7 +124,9 @@ func (s *v4Server) ResetLeases(leases []*Lease) (err error) {
 		return
 	}
 
-	s.leasedOffsets = newBitSet()
+	for _, sc := range s.scopes {
+		sc.leasedOffsets = newBitSet()
+	}
 	s.leaseHosts = stringutil.NewSet()
 	s.leases = nil
 
@@ -140,6 +156,22 @@ func (s *v4Server) getLeasesRef() []*Lease {
 	return s.leases
 }
 
+// ResetPrefixLeases implements the DHCPServer interface for *v4Server.  It's
+// a no-op, since there is no prefix delegation in DHCPv4.
+func (s *v4Server) ResetPrefixLeases(_ []*PrefixLease) (err error) {
+	return nil
+}
+
+// GetPrefixLeases implements the DHCPServer interface for *v4Server.
+func (s *v4Server) GetPrefixLeases() (leases []*PrefixLease) {
+	return []*PrefixLease{}
+}
+
+// getPrefixLeasesRef implements the DHCPServer interface for *v4Server.
+func (s *v4Server) getPrefixLeasesRef() []*PrefixLease {
+	return nil
+}
+
 // isBlocklisted returns true if this lease holds a blocklisted IP.
 //
 // TODO(a.garipov): Make a method of *Lease?
@@ -217,10 +249,10 @@ func (s *v4Server) FindMACbyIP(ip net.IP) net.HardwareAddr {
 const defaultHwAddrLen = 6
 
 // Add the specified IP to the black list for a time period
-func (s *v4Server) blocklistLease(l *Lease) {
+func (s *v4Server) blocklistLease(sc *v4Scope, l *Lease) {
 	l.HWAddr = make(net.HardwareAddr, defaultHwAddrLen)
 	l.Hostname = ""
-	l.Expiry = time.Now().Add(s.conf.leaseTime)
+	l.Expiry = time.Now().Add(sc.leaseTime)
 }
 
 // rmLeaseByIndex removes a lease by its index in the leases slice.
@@ -236,10 +268,11 @@ func (s *v4Server) rmLeaseByIndex(i int) {
 	l := s.leases[i]
 	s.leases = append(s.leases[:i], s.leases[i+1:]...)
 
-	r := s.conf.ipRange
-	offset, ok := r.offset(l.IP)
-	if ok {
-		s.leasedOffsets.set(offset, false)
+	if sc := s.scopeByIP(l.IP); sc != nil {
+		offset, ok := sc.ipRange.offset(l.IP)
+		if ok {
+			sc.leasedOffsets.set(offset, false)
+		}
 	}
 
 	s.leaseHosts.Del(l.Hostname)
@@ -289,21 +322,24 @@ func (s *v4Server) rmDynamicLease(lease *Lease) (err error) {
 
 // addLease adds a dynamic or static lease.
 func (s *v4Server) addLease(l *Lease) (err error) {
-	r := s.conf.ipRange
-	offset, inOffset := r.offset(l.IP)
-
-	if l.IsStatic() {
-		// TODO(a.garipov, d.seregin): Subnet can be nil when dhcp server is
-		// disabled.
-		if sn := s.conf.subnet; !sn.Contains(l.IP) {
-			return fmt.Errorf("subnet %s does not contain the ip %q", sn, l.IP)
+	sc := s.scopeByIP(l.IP)
+	if sc == nil {
+		if l.IsStatic() {
+			return fmt.Errorf("subnet %s does not contain the ip %q", s.conf.subnet, l.IP)
 		}
-	} else if !inOffset {
+
+		return fmt.Errorf("lease %s (%s) out of range, not adding", l.IP, l.HWAddr)
+	}
+
+	offset, inOffset := sc.ipRange.offset(l.IP)
+	if !l.IsStatic() && !inOffset {
 		return fmt.Errorf("lease %s (%s) out of range, not adding", l.IP, l.HWAddr)
 	}
 
 	s.leases = append(s.leases, l)
-	s.leasedOffsets.set(offset, true)
+	if inOffset {
+		sc.leasedOffsets.set(offset, true)
+	}
 
 	if l.Hostname != "" {
 		s.leaseHosts.Add(l.Hostname)
@@ -348,6 +384,11 @@ func (s *v4Server) AddStaticLease(l *Lease) (err error) {
 		return err
 	}
 
+	_, err = parseDHCPOptions(l.Options)
+	if err != nil {
+		return fmt.Errorf("validating options: %w", err)
+	}
+
 	if hostname := l.Hostname; hostname != "" {
 		hostname, err = normalizeHostname(hostname)
 		if err != nil {
@@ -430,6 +471,64 @@ func (s *v4Server) RemoveStaticLease(l *Lease) (err error) {
 	return nil
 }
 
+// syncLease implements the DHCPServer interface for *v4Server.  It is safe for
+// concurrent use.
+func (s *v4Server) syncLease(l *Lease, remove bool) (err error) {
+	defer func() { err = errors.Annotate(err, "dhcpv4: syncing lease: %w") }()
+
+	ip4 := l.IP.To4()
+	if ip4 == nil {
+		return fmt.Errorf("invalid IP")
+	}
+
+	l.IP = ip4
+
+	changed, err := func() (changed bool, err error) {
+		s.leasesLock.Lock()
+		defer s.leasesLock.Unlock()
+
+		i := -1
+		var cur *Lease
+		for j, sl := range s.leases {
+			if sl.IP.Equal(l.IP) {
+				i, cur = j, sl
+
+				break
+			}
+		}
+
+		if !peerLeaseApplies(cur, l, remove) {
+			return false, nil
+		}
+
+		if remove {
+			s.rmLeaseByIndex(i)
+		} else {
+			err = s.rmDynamicLease(l)
+			if err != nil {
+				return false, err
+			}
+
+			l.Hostname = s.validHostnameForClient(l.Hostname, l.IP)
+			err = s.addLease(l)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		s.conf.notify(LeaseChangedDBStore)
+
+		return true, nil
+	}()
+	if err != nil {
+		return err
+	} else if changed && !remove {
+		s.conf.notify(LeaseChangedAdded)
+	}
+
+	return nil
+}
+
 // addrAvailable sends an ICP request to the specified IP address.  It returns
 // true if the remote host doesn't reply, which probably means that the IP
 // address is available.
@@ -475,10 +574,10 @@ func (s *v4Server) addrAvailable(target net.IP) (avail bool) {
 	return true
 }
 
-// findLease finds a lease by its MAC-address.
-func (s *v4Server) findLease(mac net.HardwareAddr) (l *Lease) {
+// findLease finds a lease within sc by its MAC-address.
+func (s *v4Server) findLease(sc *v4Scope, mac net.HardwareAddr) (l *Lease) {
 	for _, l = range s.leases {
-		if bytes.Equal(mac, l.HWAddr) {
+		if bytes.Equal(mac, l.HWAddr) && sc.subnet.Contains(l.IP) {
 			return l
 		}
 	}
@@ -486,9 +585,15 @@ func (s *v4Server) findLease(mac net.HardwareAddr) (l *Lease) {
 	return nil
 }
 
-// nextIP generates a new free IP.
-func (s *v4Server) nextIP() (ip net.IP) {
-	r := s.conf.ipRange
+// owns returns true if the server may allocate the address with the offset
+// within the range of sc.
+func (s *v4Server) owns(sc *v4Scope, offset uint64) (ok bool) {
+	return s.conf.owns == nil || s.conf.owns(offset, sc.ipRange.len())
+}
+
+// nextIP generates a new free IP within sc.
+func (s *v4Server) nextIP(sc *v4Scope) (ip net.IP) {
+	r := sc.ipRange
 	ip = r.find(func(next net.IP) (ok bool) {
 		offset, ok := r.offset(next)
 		if !ok {
@@ -496,17 +601,22 @@ func (s *v4Server) nextIP() (ip net.IP) {
 			return false
 		}
 
-		return !s.leasedOffsets.isSet(offset)
+		return !sc.leasedOffsets.isSet(offset) && s.owns(sc, offset)
 	})
 
 	return ip.To4()
 }
 
-// Find an expired lease and return its index or -1
-func (s *v4Server) findExpiredLease() int {
+// Find an expired lease within sc and return its index or -1
+func (s *v4Server) findExpiredLease(sc *v4Scope) int {
 	now := time.Now()
 	for i, lease := range s.leases {
-		if !lease.IsStatic() && lease.Expiry.Before(now) {
+		if lease.IsStatic() || !lease.Expiry.Before(now) || !sc.subnet.Contains(lease.IP) {
+			continue
+		}
+
+		offset, ok := sc.ipRange.offset(lease.IP)
+		if !ok || s.owns(sc, offset) {
 			return i
 		}
 	}
@@ -514,18 +624,18 @@ func (s *v4Server) findExpiredLease() int {
 	return -1
 }
 
-// reserveLease reserves a lease for a client by its MAC-address.  It returns
-// nil if it couldn't allocate a new lease.
-func (s *v4Server) reserveLease(mac net.HardwareAddr) (l *Lease, err error) {
+// reserveLease reserves a lease within sc for a client by its MAC-address.  It
+// returns nil if it couldn't allocate a new lease.
+func (s *v4Server) reserveLease(sc *v4Scope, mac net.HardwareAddr) (l *Lease, err error) {
 	l = &Lease{
 		HWAddr: make([]byte, len(mac)),
 	}
 
 	copy(l.HWAddr, mac)
 
-	l.IP = s.nextIP()
+	l.IP = s.nextIP(sc)
 	if l.IP == nil {
-		i := s.findExpiredLease()
+		i := s.findExpiredLease(sc)
 		if i < 0 {
 			return nil, nil
 		}
@@ -543,8 +653,8 @@ func (s *v4Server) reserveLease(mac net.HardwareAddr) (l *Lease, err error) {
 	return l, nil
 }
 
-func (s *v4Server) commitLease(l *Lease) {
-	l.Expiry = time.Now().Add(s.conf.leaseTime)
+func (s *v4Server) commitLease(sc *v4Scope, l *Lease) {
+	l.Expiry = time.Now().Add(sc.leaseTime)
 
 	func() {
 		s.leasesLock.Lock()
@@ -555,16 +665,18 @@ func (s *v4Server) commitLease(l *Lease) {
 		if l.Hostname != "" {
 			s.leaseHosts.Add(l.Hostname)
 		}
+
+		s.checkPoolUsage(sc)
 	}()
 
 	s.conf.notify(LeaseChangedAdded)
 }
 
-// allocateLease allocates a new lease for the MAC address.  If there are no IP
-// addresses left, both l and err are nil.
-func (s *v4Server) allocateLease(mac net.HardwareAddr) (l *Lease, err error) {
+// allocateLease allocates a new lease within sc for the MAC address.  If there
+// are no IP addresses left, both l and err are nil.
+func (s *v4Server) allocateLease(sc *v4Scope, mac net.HardwareAddr) (l *Lease, err error) {
 	for {
-		l, err = s.reserveLease(mac)
+		l, err = s.reserveLease(sc, mac)
 		if err != nil {
 			return nil, fmt.Errorf("reserving a lease: %w", err)
 		} else if l == nil {
@@ -575,12 +687,17 @@ func (s *v4Server) allocateLease(mac net.HardwareAddr) (l *Lease, err error) {
 			return l, nil
 		}
 
-		s.blocklistLease(l)
+		s.countersLock.Lock()
+		s.counters.Conflicts++
+		s.countersLock.Unlock()
+
+		s.blocklistLease(sc, l)
+		s.checkPoolUsage(sc)
 	}
 }
 
 // processDiscover is the handler for the DHCP Discover request.
-func (s *v4Server) processDiscover(req, resp *dhcpv4.DHCPv4) (l *Lease, err error) {
+func (s *v4Server) processDiscover(sc *v4Scope, req, resp *dhcpv4.DHCPv4) (l *Lease, err error) {
 	mac := req.ClientHWAddr
 
 	defer s.conf.notify(LeaseChangedDBStore)
@@ -588,7 +705,7 @@ func (s *v4Server) processDiscover(req, resp *dhcpv4.DHCPv4) (l *Lease, err erro
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
-	l = s.findLease(mac)
+	l = s.findLease(sc, mac)
 	if l != nil {
 		reqIP := req.RequestedIPAddress()
 		if len(reqIP) != 0 && !reqIP.Equal(l.IP) {
@@ -600,11 +717,12 @@ func (s *v4Server) processDiscover(req, resp *dhcpv4.DHCPv4) (l *Lease, err erro
 		return l, nil
 	}
 
-	l, err = s.allocateLease(mac)
+	l, err = s.allocateLease(sc, mac)
 	if err != nil {
 		return nil, err
 	} else if l == nil {
-		log.Debug("dhcpv4: no more ip addresses")
+		log.Debug("dhcpv4: %s: no more ip addresses", sc)
+		s.checkPoolUsage(sc)
 
 		return nil, nil
 	}
@@ -643,15 +761,19 @@ func (o *optFQDN) ToBytes() []byte {
 	return b
 }
 
-// checkLease checks if the pair of mac and ip is already leased.  The mismatch
-// is true when the existing lease has the same hardware address but differs in
-// its IP address.
-func (s *v4Server) checkLease(mac net.HardwareAddr, ip net.IP) (lease *Lease, mismatch bool) {
+// checkLease checks if the pair of mac and ip is already leased within sc.
+// The mismatch is true when the existing lease has the same hardware address
+// but differs in its IP address.
+func (s *v4Server) checkLease(
+	sc *v4Scope,
+	mac net.HardwareAddr,
+	ip net.IP,
+) (lease *Lease, mismatch bool) {
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
 	for _, l := range s.leases {
-		if !bytes.Equal(l.HWAddr, mac) {
+		if !bytes.Equal(l.HWAddr, mac) || !sc.subnet.Contains(l.IP) {
 			continue
 		}
 
@@ -671,7 +793,11 @@ func (s *v4Server) checkLease(mac net.HardwareAddr, ip net.IP) (lease *Lease, mi
 }
 
 // processRequest is the handler for the DHCP Request request.
-func (s *v4Server) processRequest(req, resp *dhcpv4.DHCPv4) (lease *Lease, needsReply bool) {
+func (s *v4Server) processRequest(
+	sc *v4Scope,
+	req *dhcpv4.DHCPv4,
+	resp *dhcpv4.DHCPv4,
+) (lease *Lease, needsReply bool) {
 	mac := req.ClientHWAddr
 	reqIP := req.RequestedIPAddress()
 	if reqIP == nil {
@@ -679,7 +805,7 @@ func (s *v4Server) processRequest(req, resp *dhcpv4.DHCPv4) (lease *Lease, needs
 	}
 
 	sid := req.ServerIdentifier()
-	if len(sid) != 0 && !sid.Equal(s.conf.dnsIPAddrs[0]) {
+	if len(sid) != 0 && !sid.Equal(s.serverIP(sc)) {
 		log.Debug("dhcpv4: bad OptionServerIdentifier in req msg for %s", mac)
 
 		return nil, false
@@ -692,7 +818,7 @@ func (s *v4Server) processRequest(req, resp *dhcpv4.DHCPv4) (lease *Lease, needs
 	}
 
 	var mismatch bool
-	if lease, mismatch = s.checkLease(mac, reqIP); mismatch {
+	if lease, mismatch = s.checkLease(sc, mac, reqIP); mismatch {
 		return nil, true
 	}
 
@@ -712,7 +838,7 @@ func (s *v4Server) processRequest(req, resp *dhcpv4.DHCPv4) (lease *Lease, needs
 			lease.Hostname = hostname
 		}
 
-		s.commitLease(lease)
+		s.commitLease(sc, lease)
 	} else if lease.Hostname != "" {
 		o := &optFQDN{
 			name: lease.Hostname,
@@ -731,7 +857,7 @@ func (s *v4Server) processRequest(req, resp *dhcpv4.DHCPv4) (lease *Lease, needs
 }
 
 // processRequest is the handler for the DHCP Decline request.
-func (s *v4Server) processDecline(req, resp *dhcpv4.DHCPv4) (err error) {
+func (s *v4Server) processDecline(sc *v4Scope, req, resp *dhcpv4.DHCPv4) (err error) {
 	s.conf.notify(LeaseChangedDBStore)
 
 	s.leasesLock.Lock()
@@ -763,7 +889,7 @@ func (s *v4Server) processDecline(req, resp *dhcpv4.DHCPv4) (err error) {
 		return fmt.Errorf("removing old lease for %s: %w", mac, err)
 	}
 
-	newLease, err := s.allocateLease(mac)
+	newLease, err := s.allocateLease(sc, mac)
 	if err != nil {
 		return fmt.Errorf("allocating new lease for %s: %w", mac, err)
 	} else if newLease == nil {
@@ -776,7 +902,7 @@ func (s *v4Server) processDecline(req, resp *dhcpv4.DHCPv4) (err error) {
 	}
 
 	newLease.Hostname = oldLease.Hostname
-	newLease.Expiry = time.Now().Add(s.conf.leaseTime)
+	newLease.Expiry = time.Now().Add(sc.leaseTime)
 
 	err = s.addLease(newLease)
 	if err != nil {
@@ -831,23 +957,32 @@ func (s *v4Server) processRelease(req, resp *dhcpv4.DHCPv4) (err error) {
 	return nil
 }
 
-// Find a lease associated with MAC and prepare response
+// Find a lease associated with MAC and prepare response.  recv is the scope of
+// the interface req has been received on.
 // Return 1: OK
 // Return 0: error; reply with Nak
 // Return -1: error; don't reply
-func (s *v4Server) process(req, resp *dhcpv4.DHCPv4) int {
+func (s *v4Server) process(recv *v4Scope, req, resp *dhcpv4.DHCPv4) (rc int) {
+	s.countReceived(req.MessageType())
+	defer func() { s.countSent(rc, resp.MessageType()) }()
+
 	var err error
 
+	sc := s.scopeFor(recv, req)
+	if sc == nil {
+		return -1
+	}
+
 	// Include server's identifier option since any reply should contain it.
 	//
 	// See https://datatracker.ietf.org/doc/html/rfc2131#page-29.
-	resp.UpdateOption(dhcpv4.OptServerIdentifier(s.conf.dnsIPAddrs[0]))
+	resp.UpdateOption(dhcpv4.OptServerIdentifier(s.serverIP(sc)))
 
 	// TODO(a.garipov): Refactor this into handlers.
 	var l *Lease
 	switch req.MessageType() {
 	case dhcpv4.MessageTypeDiscover:
-		l, err = s.processDiscover(req, resp)
+		l, err = s.processDiscover(sc, req, resp)
 		if err != nil {
 			log.Error("dhcpv4: processing discover: %s", err)
 
@@ -859,7 +994,7 @@ func (s *v4Server) process(req, resp *dhcpv4.DHCPv4) int {
 		}
 	case dhcpv4.MessageTypeRequest:
 		var toReply bool
-		l, toReply = s.processRequest(req, resp)
+		l, toReply = s.processRequest(sc, req, resp)
 		if l == nil {
 			if toReply {
 				return 0
@@ -867,7 +1002,7 @@ func (s *v4Server) process(req, resp *dhcpv4.DHCPv4) int {
 			return -1 // drop packet
 		}
 	case dhcpv4.MessageTypeDecline:
-		err = s.processDecline(req, resp)
+		err = s.processDecline(sc, req, resp)
 		if err != nil {
 			log.Error("dhcpv4: processing decline: %s", err)
 
@@ -890,15 +1025,16 @@ func (s *v4Server) process(req, resp *dhcpv4.DHCPv4) int {
 	// messages replied for DHCPREQUEST.
 	//
 	// TODO(e.burkov):  Inspect why this is always set to configured value.
-	resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(s.conf.leaseTime))
+	resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(sc.leaseTime))
 
 	// Update values for each explicitly configured parameter requested by
 	// client.
 	//
 	// See https://datatracker.ietf.org/doc/html/rfc2131#section-4.3.1.
+	configured := s.clientOptions(sc, req, l)
 	requested := req.ParameterRequestList()
 	for _, code := range requested {
-		if configured := s.options; configured.Has(code) {
+		if configured.Has(code) {
 			resp.UpdateOption(dhcpv4.OptGeneric(code, configured.Get(code)))
 		}
 	}
@@ -906,17 +1042,170 @@ func (s *v4Server) process(req, resp *dhcpv4.DHCPv4) int {
 	// not assigned yet since its value is set after server's creating.
 	if requested.Has(dhcpv4.OptionDomainNameServer) &&
 		!resp.Options.Has(dhcpv4.OptionDomainNameServer) {
-		resp.UpdateOption(dhcpv4.OptDNS(s.conf.dnsIPAddrs...))
+		resp.UpdateOption(dhcpv4.OptDNS(s.dnsServers(sc)...))
+	}
+
+	if s.pxe != nil {
+		s.pxe.apply(req, resp)
 	}
 
 	return 1
 }
 
+// countReceived increments the counter of the received messages of type mt.
+func (s *v4Server) countReceived(mt dhcpv4.MessageType) {
+	s.countersLock.Lock()
+	defer s.countersLock.Unlock()
+
+	switch mt {
+	case dhcpv4.MessageTypeDiscover:
+		s.counters.Discover++
+	case dhcpv4.MessageTypeRequest:
+		s.counters.Request++
+	case dhcpv4.MessageTypeDecline:
+		s.counters.Decline++
+	case dhcpv4.MessageTypeRelease:
+		s.counters.Release++
+	}
+}
+
+// countSent increments the counter of the sent messages for the result of
+// process, rc, and the type of the response, mt.
+func (s *v4Server) countSent(rc int, mt dhcpv4.MessageType) {
+	s.countersLock.Lock()
+	defer s.countersLock.Unlock()
+
+	switch {
+	case rc < 0:
+		// Not replied.
+	case rc == 0:
+		s.counters.Nak++
+	case mt == dhcpv4.MessageTypeOffer:
+		s.counters.Offer++
+	case mt == dhcpv4.MessageTypeAck:
+		s.counters.Ack++
+	}
+}
+
+// poolMetrics returns the utilization of the address pool of sc.
+// s.leasesLock is expected to be locked.
+func (s *v4Server) poolMetrics(sc *v4Scope, now time.Time) (m *poolMetrics) {
+	m = &poolMetrics{
+		Scope: sc.name,
+		Size:  sc.ipRange.len(),
+		Alert: sc.alerted,
+	}
+
+	for _, l := range s.leases {
+		if (l.IsStatic() || l.Expiry.After(now)) && sc.ipRange.contains(l.IP) {
+			m.Used++
+		}
+	}
+
+	if m.Size > 0 {
+		m.Utilization = float64(m.Used) * 100 / float64(m.Size)
+	}
+
+	return m
+}
+
+// checkPoolUsage logs an error when the utilization of the address pool of sc
+// reaches the alert threshold, and a notice when it goes below the threshold
+// again.  The alert is also reported by the status HTTP API.  s.leasesLock is
+// expected to be locked.
+func (s *v4Server) checkPoolUsage(sc *v4Scope) {
+	m := s.poolMetrics(sc, time.Now())
+	alert := m.Utilization >= float64(s.conf.PoolAlertThreshold)
+	if alert == sc.alerted {
+		return
+	}
+
+	sc.alerted = alert
+	if alert {
+		log.Error(
+			"dhcpv4: %s is %.0f%% full, %d of %d addresses are in use",
+			sc,
+			m.Utilization,
+			m.Used,
+			m.Size,
+		)
+	} else {
+		log.Info("dhcpv4: %s is %.0f%% full, below the alert threshold", sc, m.Utilization)
+	}
+}
+
+// metrics implements the DHCPServer interface for *v4Server.  It is safe for
+// concurrent use.
+func (s *v4Server) metrics() (m *dhcpMetrics) {
+	s.countersLock.Lock()
+	counters := s.counters
+	s.countersLock.Unlock()
+
+	m = &counters
+	m.Pools = []*poolMetrics{}
+
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	now := time.Now()
+	for _, sc := range s.scopes {
+		m.Pools = append(m.Pools, s.poolMetrics(sc, now))
+	}
+
+	return m
+}
+
+// clientOptions returns the options for the client sending req within sc.  l
+// is the lease of the client, if any.  The options of the matching classes
+// override the options of sc, and the options of the static lease override
+// both.
+func (s *v4Server) clientOptions(sc *v4Scope, req *dhcpv4.DHCPv4, l *Lease) (opts dhcpv4.Options) {
+	var overrides []dhcpv4.Options
+	for _, c := range s.classOptions {
+		if c.matches(req) {
+			overrides = append(overrides, c.options)
+		}
+	}
+
+	if l.IsStatic() && len(l.Options) > 0 {
+		leaseOpts, err := parseDHCPOptions(l.Options)
+		if err != nil {
+			// Shouldn't happen, since the options are validated when the
+			// lease is added.
+			log.Error("dhcpv4: static lease for %s: %s", l.HWAddr, err)
+		} else {
+			overrides = append(overrides, leaseOpts)
+		}
+	}
+
+	if len(overrides) == 0 {
+		return sc.options
+	}
+
+	opts = dhcpv4.Options{}
+	for code, data := range sc.options {
+		opts[code] = data
+	}
+
+	for _, o := range overrides {
+		for code, data := range o {
+			opts[code] = data
+		}
+	}
+
+	return opts
+}
+
 // client(0.0.0.0:68) -> (Request:ClientMAC,Type=Discover,ClientID,ReqIP,HostName) -> server(255.255.255.255:67)
 // client(255.255.255.255:68) <- (Reply:YourIP,ClientMAC,Type=Offer,ServerID,SubnetMask,LeaseTime) <- server(<IP>:67)
 // client(0.0.0.0:68) -> (Request:ClientMAC,Type=Request,ClientID,ReqIP||ClientIP,HostName,ServerID,ParamReqList) -> server(255.255.255.255:67)
 // client(255.255.255.255:68) <- (Reply:YourIP,ClientMAC,Type=ACK,ServerID,SubnetMask,LeaseTime) <- server(<IP>:67)
-func (s *v4Server) packetHandler(conn net.PacketConn, peer net.Addr, req *dhcpv4.DHCPv4) {
+func (s *v4Server) packetHandler(
+	recv *v4Scope,
+	conn net.PacketConn,
+	peer net.Addr,
+	req *dhcpv4.DHCPv4,
+) {
 	log.Debug("dhcpv4: received message: %s", req.Summary())
 
 	switch req.MessageType() {
@@ -945,7 +1234,13 @@ func (s *v4Server) packetHandler(conn net.PacketConn, peer net.Addr, req *dhcpv4
 		return
 	}
 
-	r := s.process(req, resp)
+	if !s.serves(req) {
+		log.Debug("dhcpv4: %s is served by the failover peer", req.ClientHWAddr)
+
+		return
+	}
+
+	r := s.process(recv, req, resp)
 	if r < 0 {
 		return
 	} else if r == 0 {
@@ -955,6 +1250,21 @@ func (s *v4Server) packetHandler(conn net.PacketConn, peer net.Addr, req *dhcpv4
 	s.send(peer, conn, req, resp)
 }
 
+// serves returns true if the server should answer req.  The messages addressed
+// to a particular server are always answered by it.
+func (s *v4Server) serves(req *dhcpv4.DHCPv4) (ok bool) {
+	if s.conf.serves == nil || len(req.ServerIdentifier()) != 0 {
+		return true
+	}
+
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest:
+		return s.conf.serves(req.ClientHWAddr)
+	default:
+		return true
+	}
+}
+
 // send writes resp for peer to conn considering the req's parameters according
 // to RFC-2131.
 //
@@ -1010,52 +1320,92 @@ func (s *v4Server) Start() (err error) {
 		return nil
 	}
 
-	ifaceName := s.conf.InterfaceName
-	iface, err := net.InterfaceByName(ifaceName)
+	log.Debug("dhcpv4: starting...")
+
+	dnsIPAddrs, err := s.listen(s.conf.InterfaceName, s.scopes[0], s.conf.broadcastIP)
 	if err != nil {
-		return fmt.Errorf("finding interface %s by name: %w", ifaceName, err)
+		return err
+	} else if len(dnsIPAddrs) == 0 {
+		// No available IP addresses which may appear later.
+		return nil
 	}
 
-	log.Debug("dhcpv4: starting...")
+	s.conf.dnsIPAddrs = dnsIPAddrs
+
+	for _, sc := range s.scopes[1:] {
+		if sc.ifaceName == "" {
+			continue
+		}
+
+		sc.ifaceAddrs, err = s.listen(sc.ifaceName, sc, sc.broadcastIP)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sc, err)
+		} else if len(sc.ifaceAddrs) == 0 {
+			log.Info("dhcpv4: %s: no ip addresses on interface %s", sc, sc.ifaceName)
+		}
+	}
+
+	// Signal to the clients containers in packages home and dnsforward that
+	// it should reload the DHCP clients.
+	s.conf.notify(LeaseChangedAdded)
+
+	return nil
+}
+
+// listen starts serving the requests received on the interface with ifaceName
+// within the recv scope.  ifaceAddrs are the IPv4 addresses of the interface,
+// if there are none, listen doesn't start the server.
+func (s *v4Server) listen(
+	ifaceName string,
+	recv *v4Scope,
+	bcastIP net.IP,
+) (ifaceAddrs []net.IP, err error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, fmt.Errorf("finding interface %s by name: %w", ifaceName, err)
+	}
 
-	dnsIPAddrs, err := aghnet.IfaceDNSIPAddrs(
+	ifaceAddrs, err = aghnet.IfaceDNSIPAddrs(
 		iface,
 		aghnet.IPVersion4,
 		defaultMaxAttempts,
 		defaultBackoff,
 	)
 	if err != nil {
-		return fmt.Errorf("interface %s: %w", ifaceName, err)
+		return nil, fmt.Errorf("interface %s: %w", ifaceName, err)
 	}
 
-	if len(dnsIPAddrs) == 0 {
-		// No available IP addresses which may appear later.
-		return nil
+	if len(ifaceAddrs) == 0 {
+		return nil, nil
 	}
 
-	s.conf.dnsIPAddrs = dnsIPAddrs
-
 	var c net.PacketConn
-	if c, err = s.newDHCPConn(iface); err != nil {
-		return err
+	if c, err = s.newDHCPConn(iface, bcastIP, ifaceAddrs[0]); err != nil {
+		return nil, err
 	}
 
-	s.srv, err = server4.NewServer(
+	handler := func(conn net.PacketConn, peer net.Addr, req *dhcpv4.DHCPv4) {
+		s.packetHandler(recv, conn, peer, req)
+	}
+
+	srv, err := server4.NewServer(
 		iface.Name,
 		nil,
-		s.packetHandler,
+		handler,
 		server4.WithConn(c),
 		server4.WithDebugLogger(),
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	log.Info("dhcpv4: listening")
+	s.srvs = append(s.srvs, srv)
+
+	log.Info("dhcpv4: listening on %s", iface.Name)
 
 	go func() {
-		if serr := s.srv.Serve(); errors.Is(serr, net.ErrClosed) {
-			log.Info("dhcpv4: server is closed")
+		if serr := srv.Serve(); errors.Is(serr, net.ErrClosed) {
+			log.Info("dhcpv4: server on %s is closed", iface.Name)
 
 			return
 		} else if serr != nil {
@@ -1063,31 +1413,34 @@ func (s *v4Server) Start() (err error) {
 		}
 	}()
 
-	// Signal to the clients containers in packages home and dnsforward that
-	// it should reload the DHCP clients.
-	s.conf.notify(LeaseChangedAdded)
-
-	return nil
+	return ifaceAddrs, nil
 }
 
 // Stop - stop server
 func (s *v4Server) Stop() (err error) {
-	if s.srv == nil {
+	if len(s.srvs) == 0 {
 		return
 	}
 
 	log.Debug("dhcpv4: stopping")
-	err = s.srv.Close()
-	if err != nil {
-		return fmt.Errorf("closing dhcpv4 srv: %w", err)
+
+	var errs []error
+	for _, srv := range s.srvs {
+		if cerr := srv.Close(); cerr != nil {
+			errs = append(errs, cerr)
+		}
+	}
+
+	s.srvs = nil
+
+	if len(errs) > 0 {
+		return errors.List("closing dhcpv4 srv", errs...)
 	}
 
 	// Signal to the clients containers in packages home and dnsforward that
 	// it should remove all DHCP clients.
 	s.conf.notify(LeaseChangedRemovedAll)
 
-	s.srv = nil
-
 	return nil
 }
 
@@ -1103,55 +1456,27 @@ func v4Create(conf V4ServerConf) (srv DHCPServer, err error) {
 		return s, nil
 	}
 
-	var routerIP net.IP
-	routerIP, err = tryTo4(s.conf.GatewayIP)
+	s.conf.subnet, s.conf.ipRange, err = newV4Subnet(
+		conf.GatewayIP,
+		conf.SubnetMask,
+		conf.RangeStart,
+		conf.RangeEnd,
+	)
 	if err != nil {
 		return s, fmt.Errorf("dhcpv4: %w", err)
 	}
 
-	if s.conf.SubnetMask == nil {
-		return s, fmt.Errorf("dhcpv4: invalid subnet mask: %v", s.conf.SubnetMask)
-	}
-
-	subnetMask := make([]byte, 4)
-	copy(subnetMask, s.conf.SubnetMask.To4())
-
-	s.conf.subnet = &net.IPNet{
-		IP:   routerIP,
-		Mask: subnetMask,
-	}
 	s.conf.broadcastIP = aghnet.BroadcastFromIPNet(s.conf.subnet)
 
-	s.conf.ipRange, err = newIPRange(conf.RangeStart, conf.RangeEnd)
-	if err != nil {
-		return s, fmt.Errorf("dhcpv4: %w", err)
-	}
-
-	if s.conf.ipRange.contains(routerIP) {
-		return s, fmt.Errorf("dhcpv4: gateway ip %v in the ip range: %v-%v",
-			routerIP,
-			conf.RangeStart,
-			conf.RangeEnd,
-		)
-	}
-
-	if !s.conf.subnet.Contains(conf.RangeStart) {
-		return s, fmt.Errorf("dhcpv4: range start %v is outside network %v",
-			conf.RangeStart,
-			s.conf.subnet,
+	if conf.PoolAlertThreshold == 0 {
+		s.conf.PoolAlertThreshold = defaultPoolAlertThreshold
+	} else if conf.PoolAlertThreshold > 100 {
+		return s, fmt.Errorf(
+			"dhcpv4: pool_alert_threshold %d must be in range [1..100]",
+			conf.PoolAlertThreshold,
 		)
 	}
 
-	if !s.conf.subnet.Contains(conf.RangeEnd) {
-		return s, fmt.Errorf("dhcpv4: range end %v is outside network %v",
-			conf.RangeEnd,
-			s.conf.subnet,
-		)
-	}
-
-	// TODO(a.garipov, d.seregin): Check that every lease is inside the IPRange.
-	s.leasedOffsets = newBitSet()
-
 	if conf.LeaseDuration == 0 {
 		s.conf.leaseTime = timeutil.Day
 		s.conf.LeaseDuration = uint32(s.conf.leaseTime.Seconds())
@@ -1159,7 +1484,26 @@ func v4Create(conf V4ServerConf) (srv DHCPServer, err error) {
 		s.conf.leaseTime = time.Second * time.Duration(conf.LeaseDuration)
 	}
 
-	s.options = prepareOptions(s.conf)
+	// TODO(a.garipov, d.seregin): Check that every lease is inside the IPRange.
+	err = s.initScopes()
+	if err != nil {
+		return s, fmt.Errorf("dhcpv4: %w", err)
+	}
+
+	for i, conf := range s.conf.ClassOptions {
+		var c *v4ClassOptions
+		c, err = newV4ClassOptions(conf)
+		if err != nil {
+			return s, fmt.Errorf("dhcpv4: class options at index %d: %w", i, err)
+		}
+
+		s.classOptions = append(s.classOptions, c)
+	}
+
+	s.pxe, err = newV4PXE(&s.conf.PXE)
+	if err != nil {
+		return s, fmt.Errorf("dhcpv4: %w", err)
+	}
 
 	return s, nil
 }
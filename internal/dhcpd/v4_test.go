@@ -7,10 +7,12 @@ import (
 	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/AdguardTeam/golibs/stringutil"
 	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
 	"github.com/mdlayher/raw"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -176,7 +178,7 @@ func TestV4Server_Process_optionsPriority(t *testing.T) {
 		resp, err = dhcpv4.NewReplyFromRequest(req)
 		require.NoError(t, err)
 
-		res := s.process(req, resp)
+		res := s.process(s.scopes[0], req, resp)
 		require.Equal(t, 1, res)
 
 		o := resp.GetOneOption(dhcpv4.OptionDomainNameServer)
@@ -230,7 +232,7 @@ func TestV4StaticLease_Get(t *testing.T) {
 		resp, err = dhcpv4.NewReplyFromRequest(req)
 		require.NoError(t, err)
 
-		assert.Equal(t, 1, s.process(req, resp))
+		assert.Equal(t, 1, s.process(s.scopes[0], req, resp))
 	})
 
 	// Don't continue if we got any errors in the previous subtest.
@@ -253,7 +255,7 @@ func TestV4StaticLease_Get(t *testing.T) {
 		resp, err = dhcpv4.NewReplyFromRequest(req)
 		require.NoError(t, err)
 
-		assert.Equal(t, 1, s.process(req, resp))
+		assert.Equal(t, 1, s.process(s.scopes[0], req, resp))
 	})
 
 	require.NoError(t, err)
@@ -311,7 +313,7 @@ func TestV4DynamicLease_Get(t *testing.T) {
 		resp, err = dhcpv4.NewReplyFromRequest(req)
 		require.NoError(t, err)
 
-		assert.Equal(t, 1, s.process(req, resp))
+		assert.Equal(t, 1, s.process(s.scopes[0], req, resp))
 	})
 
 	// Don't continue if we got any errors in the previous subtest.
@@ -345,7 +347,7 @@ func TestV4DynamicLease_Get(t *testing.T) {
 		resp, err = dhcpv4.NewReplyFromRequest(req)
 		require.NoError(t, err)
 
-		assert.Equal(t, 1, s.process(req, resp))
+		assert.Equal(t, 1, s.process(s.scopes[0], req, resp))
 	})
 
 	require.NoError(t, err)
@@ -556,3 +558,535 @@ func TestV4Server_Send(t *testing.T) {
 		assert.True(t, resp.IsBroadcast())
 	})
 }
+
+func TestV4Server_Process_scopes(t *testing.T) {
+	conf := defaultV4ServerConf()
+	conf.Scopes = []*V4Scope{{
+		Name:          "vlan20",
+		RelayAddrs:    []net.IP{{10, 0, 0, 1}},
+		GatewayIP:     net.IP{192, 168, 20, 1},
+		SubnetMask:    net.IP{255, 255, 255, 0},
+		RangeStart:    net.IP{192, 168, 20, 100},
+		RangeEnd:      net.IP{192, 168, 20, 200},
+		LeaseDuration: 3600,
+		DNSServers:    []net.IP{{192, 168, 20, 1}},
+	}, {
+		Name:       "vlan30",
+		CircuitIDs: []string{"eth0/30"},
+		GatewayIP:  net.IP{192, 168, 30, 1},
+		SubnetMask: net.IP{255, 255, 255, 0},
+		RangeStart: net.IP{192, 168, 30, 100},
+		RangeEnd:   net.IP{192, 168, 30, 200},
+	}}
+
+	sIface, err := v4Create(conf)
+	require.NoError(t, err)
+
+	s, ok := sIface.(*v4Server)
+	require.True(t, ok)
+	require.Len(t, s.scopes, 3)
+
+	s.conf.dnsIPAddrs = []net.IP{{192, 168, 10, 1}}
+
+	testCases := []struct {
+		name      string
+		giaddr    net.IP
+		relayOpts []dhcpv4.Option
+		wantIP    net.IP
+		wantDNS   net.IP
+		wantLease time.Duration
+	}{{
+		name:      "direct",
+		giaddr:    nil,
+		relayOpts: nil,
+		wantIP:    net.IP{192, 168, 10, 100},
+		wantDNS:   net.IP{192, 168, 10, 1},
+		wantLease: s.conf.leaseTime,
+	}, {
+		name:      "relay_addr",
+		giaddr:    net.IP{10, 0, 0, 1},
+		relayOpts: nil,
+		wantIP:    net.IP{192, 168, 20, 100},
+		wantDNS:   net.IP{192, 168, 20, 1},
+		wantLease: time.Hour,
+	}, {
+		name:   "circuit_id",
+		giaddr: net.IP{10, 0, 0, 1},
+		relayOpts: []dhcpv4.Option{
+			dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0/30")),
+		},
+		wantIP:    net.IP{192, 168, 30, 100},
+		wantDNS:   net.IP{192, 168, 10, 1},
+		wantLease: s.conf.leaseTime,
+	}, {
+		name:      "giaddr_subnet",
+		giaddr:    net.IP{192, 168, 30, 1},
+		relayOpts: nil,
+		wantIP:    net.IP{192, 168, 30, 101},
+		wantDNS:   net.IP{192, 168, 10, 1},
+		wantLease: s.conf.leaseTime,
+	}, {
+		name:   "link_selection",
+		giaddr: net.IP{10, 0, 0, 2},
+		relayOpts: []dhcpv4.Option{
+			dhcpv4.OptGeneric(dhcpv4.LinkSelectionSubOption, []byte{192, 168, 20, 0}),
+		},
+		wantIP:    net.IP{192, 168, 20, 101},
+		wantDNS:   net.IP{192, 168, 20, 1},
+		wantLease: time.Hour,
+	}}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, byte(i)}
+			modifiers := []dhcpv4.Modifier{
+				dhcpv4.WithRequestedOptions(dhcpv4.OptionDomainNameServer),
+				dhcpv4.WithGatewayIP(tc.giaddr),
+			}
+			if tc.relayOpts != nil {
+				modifiers = append(modifiers, dhcpv4.WithOption(
+					dhcpv4.OptRelayAgentInfo(tc.relayOpts...),
+				))
+			}
+
+			req, rerr := dhcpv4.NewDiscovery(mac, modifiers...)
+			require.NoError(t, rerr)
+
+			resp, rerr := dhcpv4.NewReplyFromRequest(req)
+			require.NoError(t, rerr)
+
+			require.Equal(t, 1, s.process(s.scopes[0], req, resp))
+
+			assert.Equal(t, dhcpv4.MessageTypeOffer, resp.MessageType())
+			assert.Equal(t, tc.wantIP, resp.YourIPAddr)
+			assert.Equal(t, tc.wantLease, resp.IPAddressLeaseTime(0))
+
+			dnsAddrs := resp.DNS()
+			require.Len(t, dnsAddrs, 1)
+
+			assert.True(t, tc.wantDNS.Equal(dnsAddrs[0]))
+
+			if tc.relayOpts != nil {
+				assert.NotNil(t, resp.RelayAgentInfo())
+			}
+		})
+	}
+
+	t.Run("unknown_relay", func(t *testing.T) {
+		mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xFF}
+		req, rerr := dhcpv4.NewDiscovery(mac, dhcpv4.WithGatewayIP(net.IP{10, 0, 0, 3}))
+		require.NoError(t, rerr)
+
+		resp, rerr := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, rerr)
+
+		assert.Equal(t, -1, s.process(s.scopes[0], req, resp))
+	})
+
+	t.Run("static_lease", func(t *testing.T) {
+		l := &Lease{
+			HWAddr: net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBB},
+			IP:     net.IP{192, 168, 20, 50},
+		}
+
+		require.NoError(t, s.AddStaticLease(l))
+
+		l = &Lease{
+			HWAddr: net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBC},
+			IP:     net.IP{192, 168, 40, 50},
+		}
+
+		testutil.AssertErrorMsg(
+			t,
+			"dhcpv4: adding static lease: adding static lease for 192.168.40.50 "+
+				"(bb:bb:bb:bb:bb:bc): subnet 192.168.10.1/24 does not contain the ip "+
+				`"192.168.40.50"`,
+			s.AddStaticLease(l),
+		)
+	})
+}
+
+func TestV4Create_scopes(t *testing.T) {
+	newScope := func(name string, gatewayIP net.IP) (sc *V4Scope) {
+		return &V4Scope{
+			Name:       name,
+			GatewayIP:  gatewayIP,
+			SubnetMask: net.IP{255, 255, 255, 0},
+			RangeStart: net.IP{gatewayIP[0], gatewayIP[1], gatewayIP[2], 100},
+			RangeEnd:   net.IP{gatewayIP[0], gatewayIP[1], gatewayIP[2], 200},
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		scopes     []*V4Scope
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		scopes: []*V4Scope{
+			newScope("a", net.IP{192, 168, 20, 1}),
+			newScope("b", net.IP{192, 168, 30, 1}),
+		},
+	}, {
+		name:       "empty_name",
+		wantErrMsg: `dhcpv4: scope "": empty name`,
+		scopes:     []*V4Scope{newScope("", net.IP{192, 168, 20, 1})},
+	}, {
+		name:       "duplicate_name",
+		wantErrMsg: `dhcpv4: duplicate scope name "a"`,
+		scopes: []*V4Scope{
+			newScope("a", net.IP{192, 168, 20, 1}),
+			newScope("a", net.IP{192, 168, 30, 1}),
+		},
+	}, {
+		name: "overlap",
+		wantErrMsg: `dhcpv4: scope "a": network 192.168.10.2/24 overlaps ` +
+			`with default scope`,
+		scopes: []*V4Scope{newScope("a", net.IP{192, 168, 10, 2})},
+	}, {
+		name: "bad_range",
+		wantErrMsg: `dhcpv4: scope "a": range start 192.168.30.100 is outside ` +
+			`network 192.168.20.1/24`,
+		scopes: []*V4Scope{{
+			Name:       "a",
+			GatewayIP:  net.IP{192, 168, 20, 1},
+			SubnetMask: net.IP{255, 255, 255, 0},
+			RangeStart: net.IP{192, 168, 30, 100},
+			RangeEnd:   net.IP{192, 168, 30, 200},
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := defaultV4ServerConf()
+			conf.Scopes = tc.scopes
+
+			_, err := v4Create(conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestV4Server_Process_classOptions(t *testing.T) {
+	const (
+		ntpCode  = 42
+		tftpCode = 66
+	)
+
+	conf := defaultV4ServerConf()
+	conf.Options = []string{"42 ip 192.168.10.1"}
+	conf.ClassOptions = []*V4ClassOptions{{
+		VendorClass: "Phone",
+		Options:     []string{"42 ip 192.168.10.2", "66 text phones.example"},
+	}, {
+		UserClass: "lab",
+		Options:   []string{"66 text lab.example"},
+	}}
+
+	sIface, err := v4Create(conf)
+	require.NoError(t, err)
+
+	s, ok := sIface.(*v4Server)
+	require.True(t, ok)
+
+	s.conf.dnsIPAddrs = []net.IP{{192, 168, 10, 1}}
+
+	staticMAC := net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBB}
+	err = s.AddStaticLease(&Lease{
+		HWAddr:  staticMAC,
+		IP:      net.IP{192, 168, 10, 50},
+		Options: []string{"66 text static.example"},
+	})
+	require.NoError(t, err)
+
+	userClass := dhcpv4.OptGeneric(
+		dhcpv4.OptionUserClassInformation,
+		append([]byte{3}, "lab"...),
+	)
+
+	testCases := []struct {
+		name     string
+		mac      net.HardwareAddr
+		opts     []dhcpv4.Option
+		wantNTP  net.IP
+		wantTFTP string
+	}{{
+		name:     "default",
+		mac:      net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x01},
+		opts:     nil,
+		wantNTP:  net.IP{192, 168, 10, 1},
+		wantTFTP: "",
+	}, {
+		name:     "vendor_class",
+		mac:      net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x02},
+		opts:     []dhcpv4.Option{dhcpv4.OptClassIdentifier("Phone-X100")},
+		wantNTP:  net.IP{192, 168, 10, 2},
+		wantTFTP: "phones.example",
+	}, {
+		name: "both_classes",
+		mac:  net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x03},
+		opts: []dhcpv4.Option{
+			dhcpv4.OptClassIdentifier("Phone-X100"),
+			userClass,
+		},
+		wantNTP:  net.IP{192, 168, 10, 2},
+		wantTFTP: "lab.example",
+	}, {
+		name:     "static_lease",
+		mac:      staticMAC,
+		opts:     []dhcpv4.Option{userClass},
+		wantNTP:  net.IP{192, 168, 10, 1},
+		wantTFTP: "static.example",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			modifiers := []dhcpv4.Modifier{
+				dhcpv4.WithRequestedOptions(
+					dhcpv4.GenericOptionCode(ntpCode),
+					dhcpv4.GenericOptionCode(tftpCode),
+				),
+			}
+			for _, o := range tc.opts {
+				modifiers = append(modifiers, dhcpv4.WithOption(o))
+			}
+
+			req, rerr := dhcpv4.NewDiscovery(tc.mac, modifiers...)
+			require.NoError(t, rerr)
+
+			resp, rerr := dhcpv4.NewReplyFromRequest(req)
+			require.NoError(t, rerr)
+
+			require.Equal(t, 1, s.process(s.scopes[0], req, resp))
+
+			ntp := resp.Options.Get(dhcpv4.GenericOptionCode(ntpCode))
+			assert.Equal(t, []byte(tc.wantNTP), ntp)
+			assert.Equal(t, tc.wantTFTP, resp.TFTPServerName())
+		})
+	}
+
+	t.Run("bad_static_options", func(t *testing.T) {
+		err = s.AddStaticLease(&Lease{
+			HWAddr:  net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBC},
+			IP:      net.IP{192, 168, 10, 51},
+			Options: []string{"66 bad data"},
+		})
+		testutil.AssertErrorMsg(
+			t,
+			"dhcpv4: adding static lease: validating options: option at index 0: "+
+				`invalid option string "66 bad data": unknown option type "bad"`,
+			err,
+		)
+	})
+}
+
+func TestV4Server_Process_pxe(t *testing.T) {
+	conf := defaultV4ServerConf()
+	conf.PXE = V4PXEConf{
+		Enabled:    true,
+		NextServer: net.IP{192, 168, 10, 2},
+		BootFile:   "pxelinux.0",
+		ArchBootFiles: map[string]string{
+			"efi_x64": "bootx64.efi",
+			"11":      "bootaa64.efi",
+		},
+	}
+
+	sIface, err := v4Create(conf)
+	require.NoError(t, err)
+
+	s, ok := sIface.(*v4Server)
+	require.True(t, ok)
+
+	s.conf.dnsIPAddrs = []net.IP{{192, 168, 10, 1}}
+
+	testCases := []struct {
+		name           string
+		opts           []dhcpv4.Option
+		wantNextServer net.IP
+		wantFile       string
+		wantVendor     string
+	}{{
+		name:           "not_pxe",
+		opts:           nil,
+		wantNextServer: net.IPv4zero,
+		wantFile:       "",
+		wantVendor:     "",
+	}, {
+		name:           "bios",
+		opts:           []dhcpv4.Option{dhcpv4.OptClassIdentifier("PXEClient:Arch:00000")},
+		wantNextServer: net.IP{192, 168, 10, 2},
+		wantFile:       "pxelinux.0",
+		wantVendor:     "PXEClient",
+	}, {
+		name: "uefi",
+		opts: []dhcpv4.Option{
+			dhcpv4.OptClassIdentifier("PXEClient:Arch:00007"),
+			dhcpv4.OptClientArch(iana.EFI_X86_64),
+		},
+		wantNextServer: net.IP{192, 168, 10, 2},
+		wantFile:       "bootx64.efi",
+		wantVendor:     "PXEClient",
+	}, {
+		name:           "arch_only",
+		opts:           []dhcpv4.Option{dhcpv4.OptClientArch(iana.EFI_ARM64)},
+		wantNextServer: net.IP{192, 168, 10, 2},
+		wantFile:       "bootaa64.efi",
+		wantVendor:     "",
+	}}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, byte(i)}
+			modifiers := []dhcpv4.Modifier{
+				dhcpv4.WithRequestedOptions(
+					dhcpv4.OptionTFTPServerName,
+					dhcpv4.OptionBootfileName,
+				),
+			}
+			for _, o := range tc.opts {
+				modifiers = append(modifiers, dhcpv4.WithOption(o))
+			}
+
+			req, rerr := dhcpv4.NewDiscovery(mac, modifiers...)
+			require.NoError(t, rerr)
+
+			resp, rerr := dhcpv4.NewReplyFromRequest(req)
+			require.NoError(t, rerr)
+
+			require.Equal(t, 1, s.process(s.scopes[0], req, resp))
+
+			assert.True(t, tc.wantNextServer.Equal(resp.ServerIPAddr))
+			assert.Equal(t, tc.wantFile, resp.BootFileName)
+			assert.Equal(t, tc.wantFile, resp.BootFileNameOption())
+			assert.Equal(t, tc.wantVendor, resp.ClassIdentifier())
+
+			if tc.wantFile != "" {
+				assert.Equal(t, "192.168.10.2", resp.TFTPServerName())
+			}
+		})
+	}
+}
+
+func TestNewV4PXE(t *testing.T) {
+	testCases := []struct {
+		conf       V4PXEConf
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       V4PXEConf{Enabled: false},
+		name:       "disabled",
+		wantErrMsg: "",
+	}, {
+		conf: V4PXEConf{
+			Enabled:       true,
+			ArchBootFiles: map[string]string{"bios": "pxelinux.0"},
+		},
+		name:       "arch_only",
+		wantErrMsg: "",
+	}, {
+		conf:       V4PXEConf{Enabled: true},
+		name:       "no_files",
+		wantErrMsg: "pxe: no boot files",
+	}, {
+		conf: V4PXEConf{
+			Enabled:       true,
+			ArchBootFiles: map[string]string{"amiga": "boot"},
+		},
+		name:       "bad_arch",
+		wantErrMsg: `pxe: bad architecture "amiga"`,
+	}, {
+		conf: V4PXEConf{
+			Enabled:    true,
+			NextServer: net.ParseIP("::1"),
+			BootFile:   "pxelinux.0",
+		},
+		name:       "bad_next_server",
+		wantErrMsg: "pxe: next server: ::1 is not an IPv4 address",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newV4PXE(&tc.conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestV4Server_Process_metrics(t *testing.T) {
+	conf := defaultV4ServerConf()
+	conf.RangeEnd = net.IP{192, 168, 10, 103}
+	conf.PoolAlertThreshold = 50
+
+	sIface, err := v4Create(conf)
+	require.NoError(t, err)
+
+	s, ok := sIface.(*v4Server)
+	require.True(t, ok)
+
+	s.conf.dnsIPAddrs = []net.IP{{192, 168, 10, 1}}
+
+	lease := func(mac net.HardwareAddr) {
+		t.Helper()
+
+		req, rerr := dhcpv4.NewDiscovery(mac)
+		require.NoError(t, rerr)
+
+		resp, rerr := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, rerr)
+		require.Equal(t, 1, s.process(s.scopes[0], req, resp))
+
+		req, rerr = dhcpv4.NewRequestFromOffer(resp)
+		require.NoError(t, rerr)
+
+		resp, rerr = dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, rerr)
+		require.Equal(t, 1, s.process(s.scopes[0], req, resp))
+	}
+
+	lease(net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x01})
+
+	m := s.metrics()
+	require.Len(t, m.Pools, 1)
+
+	assert.Equal(t, &poolMetrics{
+		Size:        4,
+		Used:        1,
+		Utilization: 25,
+		Alert:       false,
+	}, m.Pools[0])
+
+	lease(net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x02})
+
+	// Request an address which isn't leased to the client.
+	req, err := dhcpv4.NewDiscovery(
+		net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x03},
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.IP{192, 168, 10, 100})),
+	)
+	require.NoError(t, err)
+
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	require.Equal(t, 0, s.process(s.scopes[0], req, resp))
+
+	m = s.metrics()
+	require.Len(t, m.Pools, 1)
+
+	assert.Equal(t, &poolMetrics{
+		Size:        4,
+		Used:        2,
+		Utilization: 50,
+		Alert:       true,
+	}, m.Pools[0])
+
+	m.Pools = nil
+	assert.Equal(t, &dhcpMetrics{
+		Discover: 2,
+		Request:  3,
+		Offer:    2,
+		Ack:      2,
+		Nak:      1,
+	}, m)
+}
@@ -0,0 +1,150 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package dhcpd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// pxeVendorClass is the prefix of the vendor class identifier of PXE clients.
+const pxeVendorClass = "PXEClient"
+
+// pxeArchAliases are the names of the commonly used client system
+// architectures.  Both type 7 and type 9 are used by x64 UEFI firmware.
+//
+// See https://www.iana.org/assignments/dhcpv6-parameters.
+var pxeArchAliases = map[string][]iana.Arch{
+	"bios":      {iana.INTEL_X86PC},
+	"efi_ia32":  {iana.EFI_IA32},
+	"efi_x64":   {iana.EFI_X86_64, iana.EFI_BC},
+	"efi_arm32": {iana.EFI_ARM32},
+	"efi_arm64": {iana.EFI_ARM64},
+}
+
+// v4PXE is the validated network boot configuration.
+type v4PXE struct {
+	// archBootFiles are the boot file names by the client architecture.
+	archBootFiles map[iana.Arch]string
+
+	// nextServer is the address of the boot server.
+	nextServer net.IP
+
+	// serverName is the name of the boot server.
+	serverName string
+
+	// bootFile is the default boot file name.
+	bootFile string
+}
+
+// newV4PXE validates conf and returns the network boot configuration.  p is
+// nil if the network boot is disabled.
+func newV4PXE(conf *V4PXEConf) (p *v4PXE, err error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+
+	defer func() { err = errors.Annotate(err, "pxe: %w") }()
+
+	p = &v4PXE{
+		archBootFiles: make(map[iana.Arch]string, len(conf.ArchBootFiles)),
+		serverName:    conf.ServerName,
+		bootFile:      conf.BootFile,
+	}
+
+	if conf.NextServer != nil {
+		p.nextServer, err = tryTo4(conf.NextServer)
+		if err != nil {
+			return nil, fmt.Errorf("next server: %w", err)
+		}
+	}
+
+	for key, file := range conf.ArchBootFiles {
+		archs, ok := pxeArchAliases[key]
+		if !ok {
+			var arch uint64
+			arch, err = strconv.ParseUint(key, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("bad architecture %q", key)
+			}
+
+			archs = []iana.Arch{iana.Arch(arch)}
+		}
+
+		for _, arch := range archs {
+			p.archBootFiles[arch] = file
+		}
+	}
+
+	if p.bootFile == "" && len(p.archBootFiles) == 0 {
+		return nil, errors.Error("no boot files")
+	}
+
+	return p, nil
+}
+
+// isPXERequest returns true if req is sent by a PXE client.
+func isPXERequest(req *dhcpv4.DHCPv4) (ok bool) {
+	return strings.HasPrefix(req.ClassIdentifier(), pxeVendorClass) ||
+		req.Options.Has(dhcpv4.OptionClientSystemArchitectureType)
+}
+
+// bootFileFor returns the boot file name for the client sending req.
+func (p *v4PXE) bootFileFor(req *dhcpv4.DHCPv4) (file string) {
+	for _, arch := range req.ClientArch() {
+		if file, ok := p.archBootFiles[arch]; ok {
+			return file
+		}
+	}
+
+	return p.bootFile
+}
+
+// apply sets the network boot parameters of resp if req is sent by a PXE
+// client.  The explicitly configured options of resp are kept.
+func (p *v4PXE) apply(req, resp *dhcpv4.DHCPv4) {
+	if !isPXERequest(req) {
+		return
+	}
+
+	serverName := p.serverName
+	if p.nextServer != nil {
+		resp.ServerIPAddr = netutil.CloneIP(p.nextServer)
+		if serverName == "" {
+			serverName = p.nextServer.String()
+		}
+	}
+
+	resp.ServerHostName = p.serverName
+
+	file := p.bootFileFor(req)
+	resp.BootFileName = file
+
+	requested := req.ParameterRequestList()
+	if serverName != "" &&
+		requested.Has(dhcpv4.OptionTFTPServerName) &&
+		!resp.Options.Has(dhcpv4.OptionTFTPServerName) {
+		resp.UpdateOption(dhcpv4.OptTFTPServerName(serverName))
+	}
+
+	if file != "" &&
+		requested.Has(dhcpv4.OptionBootfileName) &&
+		!resp.Options.Has(dhcpv4.OptionBootfileName) {
+		resp.UpdateOption(dhcpv4.OptBootFileName(file))
+	}
+
+	// PXE clients ignore the offers without the vendor class identifier.
+	//
+	// See the section 2.4 of the PXE specification version 2.1.
+	if strings.HasPrefix(req.ClassIdentifier(), pxeVendorClass) {
+		resp.UpdateOption(dhcpv4.OptClassIdentifier(pxeVendorClass))
+	}
+}
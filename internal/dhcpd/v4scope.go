@@ -0,0 +1,302 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package dhcpd
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// v4Scope is a DHCPv4 address pool with its own subnet, lease time, and
+// options.
+type v4Scope struct {
+	// leasedOffsets contains offsets from ipRange.start that have been
+	// leased.
+	leasedOffsets *bitSet
+
+	// circuitIDs is the set of the agent circuit IDs selecting the scope.
+	circuitIDs *stringutil.Set
+
+	// subnet is the subnet of the scope.  The IP is the IP of the gateway.
+	subnet *net.IPNet
+
+	// ipRange is the range of the dynamic leases of the scope.
+	ipRange *ipRange
+
+	// options holds predefined DHCP options to return to the clients of the
+	// scope.
+	options dhcpv4.Options
+
+	// name is the name of the scope.  It's empty for the default scope.
+	name string
+
+	// ifaceName is the name of the network interface the scope is served on.
+	// It's empty for the default scope and the relayed ones.
+	ifaceName string
+
+	// relayAddrs are the addresses of the relay agents serving the scope.
+	relayAddrs []net.IP
+
+	// dnsIPAddrs are the explicitly configured addresses of the DNS servers
+	// to return to the clients of the scope.
+	dnsIPAddrs []net.IP
+
+	// ifaceAddrs are the IPv4 addresses of the network interface the scope is
+	// served on.  It's nil for the scopes served on the interface of the
+	// server.
+	ifaceAddrs []net.IP
+
+	// broadcastIP is the broadcasting address of the subnet.
+	broadcastIP net.IP
+
+	// leaseTime is the time during which a dynamic lease is considered valid.
+	leaseTime time.Duration
+
+	// alerted is true if the utilization of the scope has reached the alert
+	// threshold.
+	alerted bool
+}
+
+// String implements the fmt.Stringer interface for *v4Scope.
+func (sc *v4Scope) String() (s string) {
+	if sc.name == "" {
+		return "default scope"
+	}
+
+	return fmt.Sprintf("scope %q", sc.name)
+}
+
+// newV4Subnet validates the addresses of an address pool and returns its
+// subnet and dynamic range.
+func newV4Subnet(
+	gatewayIP net.IP,
+	subnetMask net.IP,
+	rangeStart net.IP,
+	rangeEnd net.IP,
+) (subnet *net.IPNet, r *ipRange, err error) {
+	routerIP, err := tryTo4(gatewayIP)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if subnetMask == nil {
+		return nil, nil, fmt.Errorf("invalid subnet mask: %v", subnetMask)
+	}
+
+	mask := make([]byte, 4)
+	copy(mask, subnetMask.To4())
+
+	subnet = &net.IPNet{
+		IP:   routerIP,
+		Mask: mask,
+	}
+
+	r, err = newIPRange(rangeStart, rangeEnd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if r.contains(routerIP) {
+		return nil, nil, fmt.Errorf("gateway ip %v in the ip range: %v-%v",
+			routerIP,
+			rangeStart,
+			rangeEnd,
+		)
+	}
+
+	if !subnet.Contains(rangeStart) {
+		return nil, nil, fmt.Errorf("range start %v is outside network %v", rangeStart, subnet)
+	}
+
+	if !subnet.Contains(rangeEnd) {
+		return nil, nil, fmt.Errorf("range end %v is outside network %v", rangeEnd, subnet)
+	}
+
+	return subnet, r, nil
+}
+
+// newV4Scope validates conf and creates a new scope.  srvConf is the validated
+// configuration of the server.
+func newV4Scope(conf *V4Scope, srvConf *V4ServerConf) (sc *v4Scope, err error) {
+	defer func() { err = errors.Annotate(err, "scope %q: %w", conf.Name) }()
+
+	if conf.Name == "" {
+		return nil, errors.Error("empty name")
+	} else if conf.InterfaceName != "" && conf.InterfaceName == srvConf.InterfaceName {
+		return nil, fmt.Errorf("interface %s is already served", conf.InterfaceName)
+	}
+
+	sc = &v4Scope{
+		leasedOffsets: newBitSet(),
+		circuitIDs:    stringutil.NewSet(conf.CircuitIDs...),
+		name:          conf.Name,
+		ifaceName:     conf.InterfaceName,
+		leaseTime:     srvConf.leaseTime,
+	}
+
+	sc.subnet, sc.ipRange, err = newV4Subnet(
+		conf.GatewayIP,
+		conf.SubnetMask,
+		conf.RangeStart,
+		conf.RangeEnd,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sc.broadcastIP = aghnet.BroadcastFromIPNet(sc.subnet)
+
+	for i, ip := range conf.RelayAddrs {
+		var ip4 net.IP
+		ip4, err = tryTo4(ip)
+		if err != nil {
+			return nil, fmt.Errorf("relay address at index %d: %w", i, err)
+		}
+
+		sc.relayAddrs = append(sc.relayAddrs, ip4)
+	}
+
+	for i, ip := range conf.DNSServers {
+		var ip4 net.IP
+		ip4, err = tryTo4(ip)
+		if err != nil {
+			return nil, fmt.Errorf("dns server at index %d: %w", i, err)
+		}
+
+		sc.dnsIPAddrs = append(sc.dnsIPAddrs, ip4)
+	}
+
+	if conf.LeaseDuration != 0 {
+		sc.leaseTime = time.Second * time.Duration(conf.LeaseDuration)
+	}
+
+	sc.options = prepareOptions(V4ServerConf{
+		Options: append(append([]string{}, srvConf.Options...), conf.Options...),
+		subnet:  sc.subnet,
+	})
+
+	return sc, nil
+}
+
+// initScopes creates the scopes of the server.  The default scope, created
+// from the configuration of the server itself, is always the first one.
+func (s *v4Server) initScopes() (err error) {
+	s.scopes = []*v4Scope{{
+		leasedOffsets: newBitSet(),
+		circuitIDs:    stringutil.NewSet(),
+		subnet:        s.conf.subnet,
+		ipRange:       s.conf.ipRange,
+		options:       prepareOptions(s.conf),
+		broadcastIP:   s.conf.broadcastIP,
+		leaseTime:     s.conf.leaseTime,
+	}}
+
+	names := stringutil.NewSet()
+	for i, conf := range s.conf.Scopes {
+		if conf == nil {
+			return fmt.Errorf("scope at index %d is nil", i)
+		} else if names.Has(conf.Name) {
+			return fmt.Errorf("duplicate scope name %q", conf.Name)
+		}
+
+		var sc *v4Scope
+		sc, err = newV4Scope(conf, &s.conf)
+		if err != nil {
+			return err
+		}
+
+		for _, other := range s.scopes {
+			if other.subnet.Contains(sc.subnet.IP) || sc.subnet.Contains(other.subnet.IP) {
+				return fmt.Errorf("%s: network %v overlaps with %s", sc, sc.subnet, other)
+			}
+		}
+
+		names.Add(conf.Name)
+		s.scopes = append(s.scopes, sc)
+	}
+
+	return nil
+}
+
+// scopeByIP returns the scope which subnet contains ip.  sc is nil if there is
+// no such scope.
+func (s *v4Server) scopeByIP(ip net.IP) (sc *v4Scope) {
+	for _, sc = range s.scopes {
+		if sc.subnet.Contains(ip) {
+			return sc
+		}
+	}
+
+	return nil
+}
+
+// scopeFor returns the scope to serve req with.  recv is the scope of the
+// interface req has been received on.  sc is nil if req is relayed and there
+// is no scope for it.
+func (s *v4Server) scopeFor(recv *v4Scope, req *dhcpv4.DHCPv4) (sc *v4Scope) {
+	giaddr := req.GatewayIPAddr
+	if giaddr == nil || giaddr.IsUnspecified() {
+		return recv
+	}
+
+	linkAddr := giaddr
+	if rai := req.RelayAgentInfo(); rai != nil {
+		if cid := rai.Get(dhcpv4.AgentCircuitIDSubOption); len(cid) > 0 {
+			for _, sc = range s.scopes {
+				if sc.circuitIDs.Has(string(cid)) {
+					return sc
+				}
+			}
+		}
+
+		// See RFC 3527.
+		if ls := rai.Get(dhcpv4.LinkSelectionSubOption); len(ls) == net.IPv4len {
+			linkAddr = ls
+		}
+	}
+
+	for _, sc = range s.scopes {
+		for _, addr := range sc.relayAddrs {
+			if addr.Equal(giaddr) {
+				return sc
+			}
+		}
+	}
+
+	sc = s.scopeByIP(linkAddr)
+	if sc == nil {
+		log.Debug("dhcpv4: no scope for relay %s and link %s", giaddr, linkAddr)
+	}
+
+	return sc
+}
+
+// serverIP returns the address of the server to use as the server identifier
+// within sc.
+func (s *v4Server) serverIP(sc *v4Scope) (ip net.IP) {
+	if len(sc.ifaceAddrs) > 0 {
+		return sc.ifaceAddrs[0]
+	}
+
+	return s.conf.dnsIPAddrs[0]
+}
+
+// dnsServers returns the addresses of the DNS servers for the clients of sc.
+func (s *v4Server) dnsServers(sc *v4Scope) (ips []net.IP) {
+	switch {
+	case len(sc.dnsIPAddrs) > 0:
+		return sc.dnsIPAddrs
+	case len(sc.ifaceAddrs) > 0:
+		return sc.ifaceAddrs
+	default:
+		return s.conf.dnsIPAddrs
+	}
+}
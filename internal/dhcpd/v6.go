@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,6 +19,7 @@ import (
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/insomniacslk/dhcp/dhcpv6/server6"
 	"github.com/insomniacslk/dhcp/iana"
+	"github.com/insomniacslk/dhcp/rfc1035label"
 )
 
 const valueIAID = "ADGH" // value for IANA.ID
@@ -34,6 +36,14 @@ type v6Server struct {
 
 	ra raCtx // RA module
 
+	// pd is the prefix delegation pool.  It's nil if the prefix delegation
+	// is disabled.
+	pd *v6PDPool
+
+	// prefixLeases are the delegated prefixes.  These are protected by
+	// leasesLock.
+	prefixLeases []*PrefixLease
+
 	conf V6ServerConf
 }
 
@@ -144,7 +154,7 @@ func (s *v6Server) rmDynamicLease(lease *Lease) (err error) {
 	for i := 0; i < len(s.leases); i++ {
 		l := s.leases[i]
 
-		if bytes.Equal(l.HWAddr, lease.HWAddr) {
+		if sameClient(l, lease) {
 			if l.Expiry.Unix() == leaseExpireStatic {
 				return fmt.Errorf("static lease already exists")
 			}
@@ -177,7 +187,7 @@ func (s *v6Server) AddStaticLease(l *Lease) (err error) {
 		return fmt.Errorf("invalid IP")
 	}
 
-	err = netutil.ValidateMAC(l.HWAddr)
+	err = validateLeaseID(l)
 	if err != nil {
 		return fmt.Errorf("validating lease: %w", err)
 	}
@@ -209,7 +219,7 @@ func (s *v6Server) RemoveStaticLease(l *Lease) (err error) {
 		return fmt.Errorf("invalid IP")
 	}
 
-	err = netutil.ValidateMAC(l.HWAddr)
+	err = validateLeaseID(l)
 	if err != nil {
 		return fmt.Errorf("validating lease: %w", err)
 	}
@@ -226,6 +236,79 @@ func (s *v6Server) RemoveStaticLease(l *Lease) (err error) {
 	return nil
 }
 
+// metrics implements the DHCPServer interface for *v6Server.  The DHCPv6 server
+// doesn't collect any metrics.
+func (s *v6Server) metrics() (m *dhcpMetrics) {
+	return nil
+}
+
+// syncLease implements the DHCPServer interface for *v6Server.  It is safe for
+// concurrent use.
+func (s *v6Server) syncLease(l *Lease, remove bool) (err error) {
+	defer func() { err = errors.Annotate(err, "dhcpv6: syncing lease: %w") }()
+
+	if len(l.IP) != net.IPv6len || l.IP.To4() != nil {
+		return fmt.Errorf("invalid IP")
+	} else if !ip6InRange(s.conf.ipStart, l.IP) {
+		return fmt.Errorf("ip %s is not within current ip range", l.IP)
+	}
+
+	changed, err := func() (changed bool, err error) {
+		s.leasesLock.Lock()
+		defer s.leasesLock.Unlock()
+
+		i := -1
+		var cur *Lease
+		for j, sl := range s.leases {
+			if sl.IP.Equal(l.IP) {
+				i, cur = j, sl
+
+				break
+			}
+		}
+
+		if !peerLeaseApplies(cur, l, remove) {
+			return false, nil
+		}
+
+		if remove {
+			s.leaseRemoveSwapByIndex(i)
+		} else {
+			err = s.rmDynamicLease(l)
+			if err != nil {
+				return false, err
+			}
+
+			s.addLease(l)
+		}
+
+		s.conf.notify(LeaseChangedDBStore)
+
+		return true, nil
+	}()
+	if err != nil {
+		return err
+	} else if changed && !remove {
+		s.conf.notify(LeaseChangedAdded)
+	}
+
+	return nil
+}
+
+// validateLeaseID returns an error if l has neither a valid DUID nor a valid
+// hardware address.
+func validateLeaseID(l *Lease) (err error) {
+	if len(l.DUID) == 0 {
+		return netutil.ValidateMAC(l.HWAddr)
+	} else if len(l.DUID) > maxDUIDLen {
+		return fmt.Errorf("bad duid length %d", len(l.DUID))
+	} else if len(l.HWAddr) == 0 {
+		return nil
+	}
+
+	return netutil.ValidateMAC(l.HWAddr)
+}
+
 // Add a lease
 func (s *v6Server) addLease(l *Lease) {
 	s.leases = append(s.leases, l)
@@ -238,6 +321,7 @@ func (s *v6Server) rmLease(lease *Lease) (err error) {
 	for i, l := range s.leases {
 		if net.IP.Equal(l.IP, lease.IP) {
 			if !bytes.Equal(l.HWAddr, lease.HWAddr) ||
+				!bytes.Equal(l.DUID, lease.DUID) ||
 				l.Hostname != lease.Hostname {
 				return fmt.Errorf("lease not found")
 			}
@@ -251,16 +335,31 @@ func (s *v6Server) rmLease(lease *Lease) (err error) {
 	return fmt.Errorf("lease not found")
 }
 
-// Find lease by MAC
-func (s *v6Server) findLease(mac net.HardwareAddr) *Lease {
+// findLease returns the lease of the client with duid or, if there is no such
+// lease, the one with mac.  The leases identified by DUID are never matched by
+// the hardware address.
+func (s *v6Server) findLease(duid []byte, mac net.HardwareAddr) *Lease {
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
-	for i := range s.leases {
-		if bytes.Equal(mac, s.leases[i].HWAddr) {
-			return s.leases[i]
+	if len(duid) > 0 {
+		for _, l := range s.leases {
+			if bytes.Equal(duid, l.DUID) {
+				return l
+			}
+		}
+	}
+
+	if mac == nil {
+		return nil
+	}
+
+	for _, l := range s.leases {
+		if len(l.DUID) == 0 && bytes.Equal(mac, l.HWAddr) {
+			return l
 		}
 	}
+
 	return nil
 }
 
@@ -269,13 +368,25 @@ func (s *v6Server) findExpiredLease() int {
 	now := time.Now().Unix()
 	for i, lease := range s.leases {
 		if lease.Expiry.Unix() != leaseExpireStatic &&
-			lease.Expiry.Unix() <= now {
+			lease.Expiry.Unix() <= now &&
+			s.owns(lease.IP) {
 			return i
 		}
 	}
 	return -1
 }
 
+// owns returns true if the server may allocate ip.  The addresses outside of
+// the pool are always considered owned.
+func (s *v6Server) owns(ip net.IP) (ok bool) {
+	start := s.conf.ipStart
+	if s.conf.owns == nil || len(ip) != net.IPv6len || !ip6InRange(start, ip) {
+		return true
+	}
+
+	return s.conf.owns(uint64(ip[15]-start[15]), uint64(256-int(start[15])))
+}
+
 // Get next free IP
 func (s *v6Server) findFreeIP() net.IP {
 	for i := s.conf.ipStart[15]; ; i++ {
@@ -283,7 +394,9 @@ func (s *v6Server) findFreeIP() net.IP {
 			ip := make([]byte, 16)
 			copy(ip, s.conf.ipStart)
 			ip[15] = i
-			return ip
+			if s.owns(ip) {
+				return ip
+			}
 		}
 		if i == 0xff {
 			break
@@ -415,31 +528,103 @@ func (s *v6Server) commitLease(msg *dhcpv6.Message, lease *Lease) time.Duration
 // Find a lease associated with MAC and prepare response
 func (s *v6Server) process(msg *dhcpv6.Message, req, resp dhcpv6.DHCPv6) bool {
 	switch msg.Type() {
+	case dhcpv6.MessageTypeInformationRequest:
+		s.addDNSOptions(msg, resp)
+
+		return true
 	case dhcpv6.MessageTypeSolicit,
 		dhcpv6.MessageTypeRequest,
 		dhcpv6.MessageTypeConfirm,
 		dhcpv6.MessageTypeRenew,
 		dhcpv6.MessageTypeRebind:
-		// continue
+		if s.conf.Stateless {
+			return false
+		}
 
+		// continue
 	default:
 		return false
 	}
 
+	var duid []byte
+	if cid := msg.Options.ClientID(); cid != nil {
+		duid = cid.ToBytes()
+	}
+
+	// The clients with DUIDs not based on the link-layer address may still
+	// have static leases identified by DUID.
 	mac, err := dhcpv6.ExtractMAC(req)
 	if err != nil {
 		log.Debug("dhcpv6: dhcpv6.ExtractMAC: %s", err)
 
+		mac = nil
+	}
+
+	// Routers may only ask for the prefix, while the other clients are
+	// always given an address.
+	wantPD := s.pd != nil && mac != nil && msg.Options.OneIAPD() != nil
+	var ok bool
+	if !wantPD || msg.Options.OneIANA() != nil {
+		ok = s.processIANA(msg, duid, mac, resp)
+	}
+
+	if wantPD && msg.Type() != dhcpv6.MessageTypeConfirm {
+		s.processIAPD(msg, mac, resp)
+		ok = true
+	}
+
+	if !ok {
 		return false
 	}
 
-	lease := s.findLease(mac)
+	s.addDNSOptions(msg, resp)
+
+	fqdn := msg.GetOneOption(dhcpv6.OptionFQDN)
+	if fqdn != nil {
+		resp.AddOption(fqdn)
+	}
+
+	resp.AddOption(&dhcpv6.OptStatusCode{
+		StatusCode:    iana.StatusSuccess,
+		StatusMessage: "success",
+	})
+
+	return true
+}
+
+// addDNSOptions adds the DNS options requested by msg to resp.
+func (s *v6Server) addDNSOptions(msg *dhcpv6.Message, resp dhcpv6.DHCPv6) {
+	if msg.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer) {
+		resp.UpdateOption(dhcpv6.OptDNS(s.conf.dnsIPAddrs...))
+	}
+
+	if len(s.conf.DomainSearch) > 0 && msg.IsOptionRequested(dhcpv6.OptionDomainSearchList) {
+		resp.UpdateOption(dhcpv6.OptDomainSearchList(&rfc1035label.Labels{
+			Labels: s.conf.DomainSearch,
+		}))
+	}
+}
+
+// processIANA finds or reserves the address lease for the client with duid or
+// mac and adds the IA_NA option to resp.  It returns false if the request
+// should be ignored.
+func (s *v6Server) processIANA(
+	msg *dhcpv6.Message,
+	duid []byte,
+	mac net.HardwareAddr,
+	resp dhcpv6.DHCPv6,
+) (ok bool) {
+	lease := s.findLease(duid, mac)
 	if lease == nil {
 		log.Debug("dhcpv6: no lease for: %s", mac)
 
 		switch msg.Type() {
 
 		case dhcpv6.MessageTypeSolicit:
+			if mac == nil {
+				return false
+			}
+
 			lease = s.reserveLease(mac)
 			if lease == nil {
 				return false
@@ -450,7 +635,7 @@ func (s *v6Server) process(msg *dhcpv6.Message, req, resp dhcpv6.DHCPv6) bool {
 		}
 	}
 
-	err = s.checkIA(msg, lease)
+	err := s.checkIA(msg, lease)
 	if err != nil {
 		log.Debug("dhcpv6: %s", err)
 
@@ -479,20 +664,23 @@ func (s *v6Server) process(msg *dhcpv6.Message, req, resp dhcpv6.DHCPv6) bool {
 	}
 	resp.AddOption(oia)
 
-	if msg.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer) {
-		resp.UpdateOption(dhcpv6.OptDNS(s.conf.dnsIPAddrs...))
+	return true
+}
+
+// newInfoReply returns a reply to the information request msg.  Unlike
+// dhcpv6.NewReplyFromMessage, it doesn't require the client identifier, which
+// is optional in the information requests.
+func newInfoReply(msg *dhcpv6.Message) (resp *dhcpv6.Message) {
+	resp = &dhcpv6.Message{
+		MessageType:   dhcpv6.MessageTypeReply,
+		TransactionID: msg.TransactionID,
 	}
 
-	fqdn := msg.GetOneOption(dhcpv6.OptionFQDN)
-	if fqdn != nil {
-		resp.AddOption(fqdn)
+	if cid := msg.GetOneOption(dhcpv6.OptionClientID); cid != nil {
+		resp.AddOption(cid)
 	}
 
-	resp.AddOption(&dhcpv6.OptStatusCode{
-		StatusCode:    iana.StatusSuccess,
-		StatusMessage: "success",
-	})
-	return true
+	return resp
 }
 
 // 1.
@@ -517,9 +705,19 @@ func (s *v6Server) packetHandler(conn net.PacketConn, peer net.Addr, req dhcpv6.
 
 	log.Debug("dhcpv6: received: %s", req.Summary())
 
-	err = s.checkCID(msg)
-	if err != nil {
-		log.Debug("%s", err)
+	// The client identifier is optional in the information requests.  See
+	// RFC 8415, section 16.12.
+	if msg.Type() != dhcpv6.MessageTypeInformationRequest {
+		err = s.checkCID(msg)
+		if err != nil {
+			log.Debug("%s", err)
+			return
+		}
+	}
+
+	if s.conf.Stateless && msg.Type() != dhcpv6.MessageTypeInformationRequest {
+		log.Debug("dhcpv6: stateless mode: ignoring %s", msg.Type())
+
 		return
 	}
 
@@ -529,6 +727,12 @@ func (s *v6Server) packetHandler(conn net.PacketConn, peer net.Addr, req dhcpv6.
 		return
 	}
 
+	if !s.serves(msg) {
+		log.Debug("dhcpv6: %s is served by the failover peer", msg.Options.ClientID())
+
+		return
+	}
+
 	var resp dhcpv6.DHCPv6
 
 	switch msg.Type() {
@@ -540,12 +744,13 @@ func (s *v6Server) packetHandler(conn net.PacketConn, peer net.Addr, req dhcpv6.
 		}
 
 		resp, err = dhcpv6.NewReplyFromMessage(msg)
+	case dhcpv6.MessageTypeInformationRequest:
+		resp = newInfoReply(msg)
 	case dhcpv6.MessageTypeRequest,
 		dhcpv6.MessageTypeConfirm,
 		dhcpv6.MessageTypeRenew,
 		dhcpv6.MessageTypeRebind,
-		dhcpv6.MessageTypeRelease,
-		dhcpv6.MessageTypeInformationRequest:
+		dhcpv6.MessageTypeRelease:
 		resp, err = dhcpv6.NewReplyFromMessage(msg)
 	default:
 		log.Error("dhcpv6: message type %d not supported", msg.Type())
@@ -572,6 +777,24 @@ func (s *v6Server) packetHandler(conn net.PacketConn, peer net.Addr, req dhcpv6.
 	}
 }
 
+// serves returns true if the server should answer msg.  The messages addressed
+// to a particular server are always answered by it.
+func (s *v6Server) serves(msg *dhcpv6.Message) (ok bool) {
+	if s.conf.serves == nil {
+		return true
+	}
+
+	switch msg.Type() {
+	case
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeConfirm,
+		dhcpv6.MessageTypeRebind:
+		return s.conf.serves(msg.Options.ClientID().ToBytes())
+	default:
+		return true
+	}
+}
+
 // initialize RA module
 func (s *v6Server) initRA(iface *net.Interface) error {
 	// choose the source IP address - should be link-local-unicast
@@ -585,7 +808,9 @@ func (s *v6Server) initRA(iface *net.Interface) error {
 
 	s.ra.raAllowSLAAC = s.conf.RAAllowSLAAC
 	s.ra.raSLAACOnly = s.conf.RASLAACOnly
+	s.ra.stateless = s.conf.Stateless
 	s.ra.dnsIPAddr = s.ra.ipAddr
+	s.ra.domainSearchList = s.conf.DomainSearch
 	s.ra.prefixIPAddr = s.conf.ipStart
 	s.ra.ifaceName = s.conf.InterfaceName
 	s.ra.iface = iface
@@ -693,8 +918,38 @@ func (s *v6Server) Stop() (err error) {
 	return nil
 }
 
+// validateStateless returns an error if the stateless mode conflicts with the
+// other settings.
+func (s *v6Server) validateStateless() (err error) {
+	if !s.conf.Stateless {
+		return nil
+	} else if s.conf.RASLAACOnly {
+		return errors.Error("stateless mode requires dhcpv6 server, but ra_slaac_only is set")
+	} else if s.conf.PDPrefix != "" {
+		return errors.Error("prefix delegation is not supported in stateless mode")
+	}
+
+	return nil
+}
+
+// normalizeDomainSearch validates the domain names and returns them without
+// the trailing dots.
+func normalizeDomainSearch(names []string) (norm []string, err error) {
+	for i, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		err = netutil.ValidateDomainName(name)
+		if err != nil {
+			return nil, fmt.Errorf("at index %d: %w", i, err)
+		}
+
+		norm = append(norm, name)
+	}
+
+	return norm, nil
+}
+
 // Create DHCPv6 server
-func v6Create(conf V6ServerConf) (DHCPServer, error) {
+func v6Create(conf V6ServerConf) (srv DHCPServer, err error) {
 	s := &v6Server{}
 	s.conf = conf
 
@@ -702,11 +957,24 @@ func v6Create(conf V6ServerConf) (DHCPServer, error) {
 		return s, nil
 	}
 
+	err = s.validateStateless()
+	if err != nil {
+		return s, fmt.Errorf("dhcpv6: %w", err)
+	}
+
+	// In the stateless mode, the range is only used as the prefix of the
+	// router advertisements.
 	s.conf.ipStart = conf.RangeStart
-	if s.conf.ipStart == nil || s.conf.ipStart.To16() == nil {
+	needsRange := !conf.Stateless || conf.RAAllowSLAAC
+	if needsRange && (s.conf.ipStart == nil || s.conf.ipStart.To16() == nil) {
 		return s, fmt.Errorf("dhcpv6: invalid range-start IP: %s", conf.RangeStart)
 	}
 
+	s.conf.DomainSearch, err = normalizeDomainSearch(conf.DomainSearch)
+	if err != nil {
+		return s, fmt.Errorf("dhcpv6: domain search: %w", err)
+	}
+
 	if conf.LeaseDuration == 0 {
 		s.conf.leaseTime = timeutil.Day
 		s.conf.LeaseDuration = uint32(s.conf.leaseTime.Seconds())
@@ -714,5 +982,15 @@ func v6Create(conf V6ServerConf) (DHCPServer, error) {
 		s.conf.leaseTime = time.Second * time.Duration(conf.LeaseDuration)
 	}
 
+	s.pd, err = newV6PDPool(&s.conf)
+	if err != nil {
+		return s, fmt.Errorf("dhcpv6: %w", err)
+	}
+
+	if s.pd != nil {
+		s.conf.PDPrefixLen = s.pd.prefixLen
+		_ = s.ResetPrefixLeases(nil)
+	}
+
 	return s, nil
 }
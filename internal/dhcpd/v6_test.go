@@ -6,7 +6,9 @@ package dhcpd
 import (
 	"net"
 	"testing"
+	"time"
 
+	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/insomniacslk/dhcp/iana"
 	"github.com/stretchr/testify/assert"
@@ -309,3 +311,427 @@ func TestIP6InRange(t *testing.T) {
 		})
 	}
 }
+
+func TestV6Server_Process_prefixDelegation(t *testing.T) {
+	sIface, err := v6Create(V6ServerConf{
+		Enabled:     true,
+		RangeStart:  net.ParseIP("2001::2"),
+		PDPrefix:    "2001:db8:100::/48",
+		PDPrefixLen: 56,
+		PDStaticPrefixes: []*V6PrefixReservation{{
+			HWAddr: "bb:bb:bb:bb:bb:bb",
+			Prefix: "2001:db8:100::/56",
+		}},
+		notify: notify6,
+	})
+	require.NoError(t, err)
+
+	s, ok := sIface.(*v6Server)
+	require.True(t, ok)
+
+	s.conf.dnsIPAddrs = []net.IP{net.ParseIP("2000::1")}
+	s.sid = dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LLT,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+	}
+
+	iaid := [4]byte{1, 2, 3, 4}
+	exchange := func(
+		t *testing.T,
+		mac net.HardwareAddr,
+		mods ...dhcpv6.Modifier,
+	) (resp *dhcpv6.Message) {
+		t.Helper()
+
+		req, rerr := dhcpv6.NewSolicit(mac, mods...)
+		require.NoError(t, rerr)
+
+		var msg *dhcpv6.Message
+		msg, rerr = req.GetInnerMessage()
+		require.NoError(t, rerr)
+
+		resp, rerr = dhcpv6.NewAdvertiseFromSolicit(msg)
+		require.NoError(t, rerr)
+
+		require.True(t, s.process(msg, req, resp))
+		resp.AddOption(dhcpv6.OptServerID(s.sid))
+
+		req, rerr = dhcpv6.NewRequestFromAdvertise(resp)
+		require.NoError(t, rerr)
+
+		msg, rerr = req.GetInnerMessage()
+		require.NoError(t, rerr)
+
+		resp, rerr = dhcpv6.NewReplyFromMessage(msg)
+		require.NoError(t, rerr)
+
+		require.True(t, s.process(msg, req, resp))
+
+		return resp
+	}
+
+	prefixOf := func(t *testing.T, resp *dhcpv6.Message) (p string) {
+		t.Helper()
+
+		iapd := resp.Options.OneIAPD()
+		require.NotNil(t, iapd)
+
+		assert.Equal(t, iaid, iapd.IaId)
+
+		prefixes := iapd.Options.Prefixes()
+		require.Len(t, prefixes, 1)
+
+		assert.Equal(t, s.conf.leaseTime, prefixes[0].ValidLifetime)
+
+		return prefixes[0].Prefix.String()
+	}
+
+	t.Run("dynamic", func(t *testing.T) {
+		mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+		resp := exchange(t, mac, dhcpv6.WithIAPD(iaid))
+
+		// The first prefix of the pool is reserved.
+		assert.Equal(t, "2001:db8:100:100::/56", prefixOf(t, resp))
+		assert.NotNil(t, resp.Options.OneIANA())
+	})
+
+	t.Run("static", func(t *testing.T) {
+		mac := net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBB}
+		resp := exchange(t, mac, dhcpv6.WithIAPD(iaid))
+
+		assert.Equal(t, "2001:db8:100::/56", prefixOf(t, resp))
+	})
+
+	t.Run("renew_no_binding", func(t *testing.T) {
+		mac := net.HardwareAddr{0xCC, 0xCC, 0xCC, 0xCC, 0xCC, 0xCC}
+		req, rerr := dhcpv6.NewMessage(dhcpv6.WithIAPD(iaid))
+		require.NoError(t, rerr)
+
+		req.MessageType = dhcpv6.MessageTypeRenew
+		req.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{
+			Type:          dhcpv6.DUID_LL,
+			HwType:        iana.HWTypeEthernet,
+			LinkLayerAddr: mac,
+		}))
+
+		resp, rerr := dhcpv6.NewReplyFromMessage(req)
+		require.NoError(t, rerr)
+
+		require.True(t, s.process(req, req, resp))
+
+		iapd := resp.Options.OneIAPD()
+		require.NotNil(t, iapd)
+		require.NotNil(t, iapd.Options.Status())
+
+		assert.Equal(t, iana.StatusNoBinding, iapd.Options.Status().StatusCode)
+		assert.Nil(t, resp.Options.OneIANA())
+	})
+
+	t.Run("leases", func(t *testing.T) {
+		ls := s.GetPrefixLeases()
+		require.Len(t, ls, 2)
+
+		assert.True(t, ls[0].IsStatic())
+		assert.Equal(t, "2001:db8:100::/56", ls[0].Prefix.String())
+
+		assert.False(t, ls[1].IsStatic())
+		assert.Equal(t, "2001:db8:100:100::/56", ls[1].Prefix.String())
+	})
+}
+
+func TestV6Server_reservePrefix(t *testing.T) {
+	sIface, err := v6Create(V6ServerConf{
+		Enabled:     true,
+		RangeStart:  net.ParseIP("2001::2"),
+		PDPrefix:    "2001:db8:100::/55",
+		PDPrefixLen: 56,
+		notify:      notify6,
+	})
+	require.NoError(t, err)
+
+	s, ok := sIface.(*v6Server)
+	require.True(t, ok)
+
+	macA := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	macB := net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBB}
+	macC := net.HardwareAddr{0xCC, 0xCC, 0xCC, 0xCC, 0xCC, 0xCC}
+
+	s.leasesLock.Lock()
+	la := s.reservePrefix(macA)
+	require.NotNil(t, la)
+
+	lb := s.reservePrefix(macB)
+	require.NotNil(t, lb)
+
+	assert.NotEqual(t, la.Prefix.String(), lb.Prefix.String())
+
+	// The unexpired reservations of the other routers aren't reused.
+	assert.Nil(t, s.reservePrefix(macC))
+
+	la.Expiry = time.Now().Add(-time.Second)
+
+	lc := s.reservePrefix(macC)
+	require.NotNil(t, lc)
+
+	s.leasesLock.Unlock()
+
+	assert.Equal(t, la.Prefix.String(), lc.Prefix.String())
+	assert.Equal(t, macC, lc.HWAddr)
+
+	// The reservations aren't reported as leases.
+	assert.Empty(t, s.GetPrefixLeases())
+}
+
+func TestNewV6PDPool(t *testing.T) {
+	testCases := []struct {
+		name       string
+		conf       *V6ServerConf
+		wantErrMsg string
+		wantSize   uint32
+	}{{
+		name:       "disabled",
+		conf:       &V6ServerConf{},
+		wantErrMsg: "",
+		wantSize:   0,
+	}, {
+		name:       "default_len",
+		conf:       &V6ServerConf{PDPrefix: "2001:db8::/48"},
+		wantErrMsg: "",
+		wantSize:   256,
+	}, {
+		name:       "not_ipv6",
+		conf:       &V6ServerConf{PDPrefix: "192.168.0.0/16"},
+		wantErrMsg: "pd prefix 192.168.0.0/16 is not ipv6",
+		wantSize:   0,
+	}, {
+		name: "bad_len",
+		conf: &V6ServerConf{
+			PDPrefix:    "2001:db8::/48",
+			PDPrefixLen: 48,
+		},
+		wantErrMsg: "pd prefix len 48 must be in range [49..64]",
+		wantSize:   0,
+	}, {
+		name:       "too_large",
+		conf:       &V6ServerConf{PDPrefix: "2001:db8::/32"},
+		wantErrMsg: "pd prefix 2001:db8::/32 contains more than 2^16 prefixes of len 56",
+		wantSize:   0,
+	}, {
+		name: "static_without_pool",
+		conf: &V6ServerConf{
+			PDStaticPrefixes: []*V6PrefixReservation{{
+				HWAddr: "aa:aa:aa:aa:aa:aa",
+				Prefix: "2001:db8::/56",
+			}},
+		},
+		wantErrMsg: "static prefixes require pd_prefix",
+		wantSize:   0,
+	}, {
+		name: "static_overlap",
+		conf: &V6ServerConf{
+			PDPrefix: "2001:db8::/48",
+			PDStaticPrefixes: []*V6PrefixReservation{{
+				HWAddr: "aa:aa:aa:aa:aa:aa",
+				Prefix: "2001:db8::/56",
+			}, {
+				HWAddr: "bb:bb:bb:bb:bb:bb",
+				Prefix: "2001:db8::/60",
+			}},
+		},
+		wantErrMsg: "static prefix 2001:db8::/60 overlaps with 2001:db8::/56",
+		wantSize:   0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := newV6PDPool(tc.conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if tc.wantSize == 0 {
+				assert.Nil(t, p)
+
+				return
+			}
+
+			require.NotNil(t, p)
+
+			assert.Equal(t, tc.wantSize, p.size)
+			assert.Equal(t, "2001:db8:0:ff00::/56", p.prefix(p.size-1).String())
+		})
+	}
+}
+
+func TestV6Server_Process_duid(t *testing.T) {
+	sIface, err := v6Create(V6ServerConf{
+		Enabled:    true,
+		RangeStart: net.ParseIP("2001::2"),
+		notify:     notify6,
+	})
+	require.NoError(t, err)
+
+	s, ok := sIface.(*v6Server)
+	require.True(t, ok)
+
+	s.conf.dnsIPAddrs = []net.IP{net.ParseIP("2000::1")}
+	s.sid = dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LLT,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+	}
+
+	// DUID-UUID doesn't contain the hardware address.
+	duid := dhcpv6.Duid{
+		Type: dhcpv6.DUID_UUID,
+		Uuid: []byte{
+			0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+			0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10,
+		},
+	}
+
+	l := &Lease{
+		IP:   net.ParseIP("2001::10"),
+		DUID: duid.ToBytes(),
+	}
+	err = s.AddStaticLease(l)
+	require.NoError(t, err)
+
+	t.Run("static", func(t *testing.T) {
+		req, rerr := dhcpv6.NewSolicit(net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBB})
+		require.NoError(t, rerr)
+
+		req.UpdateOption(dhcpv6.OptClientID(duid))
+
+		resp, rerr := dhcpv6.NewAdvertiseFromSolicit(req)
+		require.NoError(t, rerr)
+
+		require.True(t, s.process(req, req, resp))
+
+		oia := resp.Options.OneIANA()
+		require.NotNil(t, oia)
+		require.NotNil(t, oia.Options.OneAddress())
+
+		assert.Equal(t, l.IP, oia.Options.OneAddress().IPv6Addr)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		req, rerr := dhcpv6.NewSolicit(net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBB})
+		require.NoError(t, rerr)
+
+		other := duid
+		other.Uuid = append([]byte{0xFF}, duid.Uuid[1:]...)
+		req.UpdateOption(dhcpv6.OptClientID(other))
+
+		resp, rerr := dhcpv6.NewAdvertiseFromSolicit(req)
+		require.NoError(t, rerr)
+
+		assert.False(t, s.process(req, req, resp))
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		err = s.RemoveStaticLease(&Lease{
+			IP:   net.ParseIP("2001::10"),
+			DUID: duid.ToBytes(),
+		})
+		require.NoError(t, err)
+
+		assert.Empty(t, s.GetLeases(LeasesStatic))
+	})
+}
+
+func TestV6Server_Process_stateless(t *testing.T) {
+	sIface, err := v6Create(V6ServerConf{
+		Enabled:      true,
+		Stateless:    true,
+		DomainSearch: []string{"lan.", "example.org"},
+		notify:       notify6,
+	})
+	require.NoError(t, err)
+
+	s, ok := sIface.(*v6Server)
+	require.True(t, ok)
+
+	dnsAddr := net.ParseIP("2000::1")
+	s.conf.dnsIPAddrs = []net.IP{dnsAddr}
+
+	t.Run("information_request", func(t *testing.T) {
+		req, rerr := dhcpv6.NewMessage(dhcpv6.WithRequestedOptions(
+			dhcpv6.OptionDNSRecursiveNameServer,
+			dhcpv6.OptionDomainSearchList,
+		))
+		require.NoError(t, rerr)
+
+		req.MessageType = dhcpv6.MessageTypeInformationRequest
+
+		// The client identifier is optional in the information requests.
+		require.Nil(t, req.Options.ClientID())
+
+		resp := newInfoReply(req)
+		require.True(t, s.process(req, req, resp))
+
+		assert.Equal(t, []net.IP{dnsAddr}, resp.Options.DNS())
+
+		labels := resp.Options.DomainSearchList()
+		require.NotNil(t, labels)
+
+		assert.Equal(t, []string{"lan", "example.org"}, labels.Labels)
+		assert.Nil(t, resp.Options.OneIANA())
+	})
+
+	t.Run("solicit", func(t *testing.T) {
+		req, rerr := dhcpv6.NewSolicit(net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA})
+		require.NoError(t, rerr)
+
+		resp, rerr := dhcpv6.NewAdvertiseFromSolicit(req)
+		require.NoError(t, rerr)
+
+		assert.False(t, s.process(req, req, resp))
+	})
+}
+
+func TestV6Create_stateless(t *testing.T) {
+	testCases := []struct {
+		name       string
+		conf       V6ServerConf
+		wantErrMsg string
+	}{{
+		name: "no_range",
+		conf: V6ServerConf{
+			Enabled:   true,
+			Stateless: true,
+		},
+		wantErrMsg: "",
+	}, {
+		name: "ra_without_range",
+		conf: V6ServerConf{
+			Enabled:      true,
+			Stateless:    true,
+			RAAllowSLAAC: true,
+		},
+		wantErrMsg: "dhcpv6: invalid range-start IP: <nil>",
+	}, {
+		name: "ra_slaac_only",
+		conf: V6ServerConf{
+			Enabled:     true,
+			Stateless:   true,
+			RASLAACOnly: true,
+		},
+		wantErrMsg: "dhcpv6: stateless mode requires dhcpv6 server, but ra_slaac_only is set",
+	}, {
+		name: "bad_domain",
+		conf: V6ServerConf{
+			Enabled:      true,
+			Stateless:    true,
+			DomainSearch: []string{"bad domain"},
+		},
+		wantErrMsg: `dhcpv6: domain search: at index 0: ` +
+			`bad domain name "bad domain": ` +
+			`bad domain name label "bad domain": bad domain name label rune ' '`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := v6Create(tc.conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
@@ -0,0 +1,401 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package dhcpd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// defaultPDPrefixLen is the default length of the delegated prefixes.
+const defaultPDPrefixLen = 56
+
+// maxPDPrefixLen is the maximum length of the delegated prefixes, since
+// the downstream networks must be able to use SLAAC.
+const maxPDPrefixLen = 64
+
+// maxPDPoolBits is the maximum number of bits distinguishing the prefixes
+// within the delegation pool.
+const maxPDPoolBits = 16
+
+// prefixHoldTime is the time a prefix reserved for a router in response to
+// a Solicit message isn't offered to the other routers.
+const prefixHoldTime = 1 * time.Minute
+
+// v6PDPool is the pool of the IPv6 prefixes to delegate to the downstream
+// routers.
+type v6PDPool struct {
+	// pool is the prefix containing all the dynamically delegated prefixes.
+	pool *net.IPNet
+
+	// static are the static reservations.
+	static []*PrefixLease
+
+	// prefixLen is the length of the delegated prefixes.
+	prefixLen int
+
+	// size is the number of the prefixes within pool.
+	size uint32
+}
+
+// newV6PDPool validates the prefix delegation settings of conf and returns a
+// new pool.  p is nil if the prefix delegation is disabled.
+func newV6PDPool(conf *V6ServerConf) (p *v6PDPool, err error) {
+	if conf.PDPrefix == "" {
+		if len(conf.PDStaticPrefixes) > 0 {
+			return nil, errors.Error("static prefixes require pd_prefix")
+		}
+
+		return nil, nil
+	}
+
+	_, pool, err := net.ParseCIDR(conf.PDPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("pd prefix: %w", err)
+	} else if pool.IP.To4() != nil {
+		return nil, fmt.Errorf("pd prefix %s is not ipv6", pool)
+	}
+
+	poolLen, _ := pool.Mask.Size()
+	prefixLen := conf.PDPrefixLen
+	if prefixLen == 0 {
+		prefixLen = defaultPDPrefixLen
+	}
+
+	if prefixLen <= poolLen || prefixLen > maxPDPrefixLen {
+		return nil, fmt.Errorf(
+			"pd prefix len %d must be in range [%d..%d]",
+			prefixLen,
+			poolLen+1,
+			maxPDPrefixLen,
+		)
+	} else if prefixLen-poolLen > maxPDPoolBits {
+		return nil, fmt.Errorf(
+			"pd prefix %s contains more than 2^%d prefixes of len %d",
+			pool,
+			maxPDPoolBits,
+			prefixLen,
+		)
+	}
+
+	p = &v6PDPool{
+		pool:      pool,
+		prefixLen: prefixLen,
+		size:      1 << (prefixLen - poolLen),
+	}
+
+	for i, r := range conf.PDStaticPrefixes {
+		var l *PrefixLease
+		l, err = newStaticPrefixLease(r)
+		if err != nil {
+			return nil, fmt.Errorf("static prefix at index %d: %w", i, err)
+		}
+
+		for _, other := range p.static {
+			if bytes.Equal(l.HWAddr, other.HWAddr) {
+				return nil, fmt.Errorf("duplicate static prefix for %s", l.HWAddr)
+			} else if prefixesOverlap(l.Prefix, other.Prefix) {
+				return nil, fmt.Errorf("static prefix %s overlaps with %s", l.Prefix, other.Prefix)
+			}
+		}
+
+		p.static = append(p.static, l)
+	}
+
+	return p, nil
+}
+
+// newStaticPrefixLease validates r and returns the static lease for it.
+func newStaticPrefixLease(r *V6PrefixReservation) (l *PrefixLease, err error) {
+	if r == nil {
+		return nil, errors.Error("nil reservation")
+	}
+
+	mac, err := net.ParseMAC(r.HWAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = netutil.ValidateMAC(mac)
+	if err != nil {
+		return nil, err
+	}
+
+	_, prefix, err := net.ParseCIDR(r.Prefix)
+	if err != nil {
+		return nil, err
+	} else if prefix.IP.To4() != nil {
+		return nil, fmt.Errorf("prefix %s is not ipv6", prefix)
+	}
+
+	return &PrefixLease{
+		Expiry: time.Unix(leaseExpireStatic, 0),
+		HWAddr: mac,
+		Prefix: prefix,
+	}, nil
+}
+
+// prefixesOverlap returns true if either of the prefixes contains the other
+// one.
+func prefixesOverlap(a, b *net.IPNet) (ok bool) {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// prefix returns the i-th prefix of the pool.  i must be less than p.size.
+func (p *v6PDPool) prefix(i uint32) (prefix *net.IPNet) {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, p.pool.IP.To16())
+
+	hi := binary.BigEndian.Uint64(ip[:8])
+	lo := binary.BigEndian.Uint64(ip[8:])
+
+	// The bits of the pool corresponding to i are zero, so OR-ing is enough.
+	shift := uint(net.IPv6len*8 - p.prefixLen)
+	if shift >= 64 {
+		hi |= uint64(i) << (shift - 64)
+	} else {
+		lo |= uint64(i) << shift
+		hi |= uint64(i) >> (64 - shift)
+	}
+
+	binary.BigEndian.PutUint64(ip[:8], hi)
+	binary.BigEndian.PutUint64(ip[8:], lo)
+
+	return &net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(p.prefixLen, net.IPv6len*8),
+	}
+}
+
+// contains returns true if prefix is one of the prefixes of the pool.
+func (p *v6PDPool) contains(prefix *net.IPNet) (ok bool) {
+	ones, _ := prefix.Mask.Size()
+
+	return ones == p.prefixLen && p.pool.Contains(prefix.IP)
+}
+
+// ResetPrefixLeases resets the delegated prefixes.  The static reservations
+// are taken from the configuration, so the static leases are skipped.
+func (s *v6Server) ResetPrefixLeases(leases []*PrefixLease) (err error) {
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	s.prefixLeases = nil
+	if s.pd == nil {
+		return nil
+	}
+
+	for _, l := range s.pd.static {
+		s.prefixLeases = append(s.prefixLeases, l.Clone())
+	}
+
+	for _, l := range leases {
+		if l.IsStatic() {
+			continue
+		} else if !s.pd.contains(l.Prefix) {
+			log.Debug("dhcpv6: skipping a prefix %s: not within current pool", l.Prefix)
+
+			continue
+		} else if s.findPrefixLease(l.HWAddr) != nil || s.prefixUsed(l.Prefix) {
+			log.Debug("dhcpv6: skipping a prefix %s: conflicts with another one", l.Prefix)
+
+			continue
+		}
+
+		s.prefixLeases = append(s.prefixLeases, l)
+	}
+
+	return nil
+}
+
+// GetPrefixLeases returns the list of the currently delegated prefixes.  It is
+// safe for concurrent use.
+func (s *v6Server) GetPrefixLeases() (leases []*PrefixLease) {
+	leases = []*PrefixLease{}
+
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	for _, l := range s.prefixLeases {
+		if !l.held {
+			leases = append(leases, l.Clone())
+		}
+	}
+
+	return leases
+}
+
+// getPrefixLeasesRef returns the actual delegated prefixes slice.  For
+// internal use only.
+func (s *v6Server) getPrefixLeasesRef() []*PrefixLease {
+	return s.prefixLeases
+}
+
+// findPrefixLease returns the delegated prefix of the router with mac.
+// s.leasesLock is expected to be locked.
+func (s *v6Server) findPrefixLease(mac net.HardwareAddr) (l *PrefixLease) {
+	for _, l = range s.prefixLeases {
+		if bytes.Equal(mac, l.HWAddr) {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// prefixUsed returns true if prefix overlaps with any of the delegated
+// prefixes, including the expired ones.  s.leasesLock is expected to be
+// locked.
+func (s *v6Server) prefixUsed(prefix *net.IPNet) (ok bool) {
+	for _, l := range s.prefixLeases {
+		if prefixesOverlap(prefix, l.Prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reservePrefix reserves a prefix from the pool for the router with mac.  The
+// reserved lease is held for prefixHoldTime and isn't stored in the database
+// until it's committed.  l is nil if the pool is exhausted.  s.leasesLock is
+// expected to be locked.
+func (s *v6Server) reservePrefix(mac net.HardwareAddr) (l *PrefixLease) {
+	now := time.Now()
+	for i := uint32(0); i < s.pd.size; i++ {
+		prefix := s.pd.prefix(i)
+		if s.prefixUsed(prefix) {
+			continue
+		}
+
+		l = &PrefixLease{
+			Expiry: now.Add(prefixHoldTime),
+			HWAddr: netutil.CloneMAC(mac),
+			Prefix: prefix,
+			held:   true,
+		}
+		s.prefixLeases = append(s.prefixLeases, l)
+		log.Debug("dhcpv6: reserved prefix %s for %s", prefix, mac)
+
+		return l
+	}
+
+	// Reuse the expired leases and the expired reservations of the other
+	// routers.  The unexpired reservations are skipped, since the routers
+	// might still request them.
+	for _, l = range s.prefixLeases {
+		if !l.IsStatic() && !l.Expiry.After(now) {
+			l.HWAddr = netutil.CloneMAC(mac)
+			l.Expiry = now.Add(prefixHoldTime)
+			l.held = true
+
+			return l
+		}
+	}
+
+	return nil
+}
+
+// processIAPD delegates a prefix to the router with mac and adds the IA_PD
+// option to resp.
+func (s *v6Server) processIAPD(msg *dhcpv6.Message, mac net.HardwareAddr, resp dhcpv6.DHCPv6) {
+	riapd := msg.Options.OneIAPD()
+	iapd := &dhcpv6.OptIAPD{
+		IaId: riapd.IaId,
+	}
+
+	lifetime, committed, status := s.commitPrefix(msg, mac, iapd)
+	if status == iana.StatusSuccess {
+		iapd.T1 = lifetime / 2
+		iapd.T2 = time.Duration(float32(lifetime) / 1.5)
+	} else {
+		iapd.Options.Add(&dhcpv6.OptStatusCode{
+			StatusCode:    status,
+			StatusMessage: status.String(),
+		})
+	}
+
+	resp.AddOption(iapd)
+
+	if committed {
+		s.conf.notify(LeaseChangedAdded)
+	}
+}
+
+// commitPrefix finds or reserves the prefix for the router with mac, stores
+// it in the database if necessary, and adds it to iapd.  committed is true if
+// the lease has been stored.
+func (s *v6Server) commitPrefix(
+	msg *dhcpv6.Message,
+	mac net.HardwareAddr,
+	iapd *dhcpv6.OptIAPD,
+) (lifetime time.Duration, committed bool, status iana.StatusCode) {
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	l := s.findPrefixLease(mac)
+	if l == nil {
+		switch msg.Type() {
+		case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest:
+			l = s.reservePrefix(mac)
+			if l == nil {
+				log.Info("dhcpv6: no free prefixes for %s", mac)
+
+				return 0, false, iana.StatusNoPrefixAvail
+			}
+		default:
+			return 0, false, iana.StatusNoBinding
+		}
+	}
+
+	lifetime = s.conf.leaseTime
+	switch msg.Type() {
+	case dhcpv6.MessageTypeRequest:
+		committed = s.commitPrefixLease(l)
+	case dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRebind:
+		committed = s.commitPrefixLease(l)
+
+		// Tell the router to stop using the prefixes it shouldn't
+		// have.  See RFC 8415, section 18.3.4.
+		for _, rp := range msg.Options.OneIAPD().Options.Prefixes() {
+			if rp.Prefix != nil && rp.Prefix.String() != l.Prefix.String() {
+				iapd.Options.Add(&dhcpv6.OptIAPrefix{
+					Prefix: rp.Prefix,
+				})
+			}
+		}
+	}
+
+	iapd.Options.Add(&dhcpv6.OptIAPrefix{
+		PreferredLifetime: lifetime,
+		ValidLifetime:     lifetime,
+		Prefix:            l.Prefix,
+	})
+
+	return lifetime, committed, iana.StatusSuccess
+}
+
+// commitPrefixLease extends l and stores it in the database.  ok is false if l
+// is static and doesn't need storing.  s.leasesLock is expected to be locked.
+func (s *v6Server) commitPrefixLease(l *PrefixLease) (ok bool) {
+	if l.IsStatic() {
+		return false
+	}
+
+	l.Expiry = time.Now().Add(s.conf.leaseTime)
+	l.held = false
+	s.conf.notify(LeaseChangedDBStore)
+
+	return true
+}
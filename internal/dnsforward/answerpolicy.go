@@ -0,0 +1,270 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
+)
+
+// RebindingPolicyName is the name of the answer policy of the DNS rebinding
+// protection.
+const RebindingPolicyName = "rebinding_protection"
+
+// rebindingNets are the networks the addresses from which aren't allowed in
+// the answers for public domain names when the DNS rebinding protection is
+// enabled.
+var rebindingNets = []string{
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// AnswerPolicyConfig is the configuration of a policy blocking the responses
+// by the contents of their answers.
+type AnswerPolicyConfig struct {
+	// Name is the name of the policy.  It's reported in the query log.
+	Name string `yaml:"name"`
+
+	// Networks are the IP addresses and the CIDR networks.  The responses
+	// containing addresses from them are blocked.
+	Networks []string `yaml:"networks"`
+
+	// CNAMEs are the domain names.  The responses which CNAME chains contain
+	// these names or their subdomains are blocked.
+	CNAMEs []string `yaml:"cnames"`
+
+	// Exemptions are the domain names the requests for which, along with
+	// their subdomains, aren't checked by the policy.
+	Exemptions []string `yaml:"exemptions"`
+}
+
+// answerPolicy is a policy blocking the responses by their answers.
+type answerPolicy struct {
+	// cnames are the blocked canonical names.  It's never nil.
+	cnames *stringutil.Set
+
+	// exemptions are the domain names exempt from the policy.  It's never
+	// nil.
+	exemptions *stringutil.Set
+
+	// name is the name of the policy.
+	name string
+
+	// nets are the blocked networks.
+	nets []*net.IPNet
+
+	// publicOnly is true if the policy is only applied to the public domain
+	// names, that is the ones that aren't single-label and aren't within
+	// the domain of DHCP leases.
+	publicOnly bool
+}
+
+// newAnswerPolicy returns a new properly initialized answer policy.
+func newAnswerPolicy(conf *AnswerPolicyConfig) (p *answerPolicy, err error) {
+	if conf.Name == "" {
+		return nil, errors.Error("no name")
+	}
+
+	p = &answerPolicy{
+		cnames:     stringutil.NewSet(),
+		exemptions: stringutil.NewSet(),
+		name:       conf.Name,
+	}
+
+	for _, n := range conf.Networks {
+		var ipNet *net.IPNet
+		ipNet, err = parseNetwork(n)
+		if err != nil {
+			return nil, err
+		}
+
+		p.nets = append(p.nets, ipNet)
+	}
+
+	for _, c := range conf.CNAMEs {
+		p.cnames.Add(normalizeDomain(c))
+	}
+
+	for _, e := range conf.Exemptions {
+		p.exemptions.Add(normalizeDomain(e))
+	}
+
+	return p, nil
+}
+
+// parseNetwork parses s as either a CIDR network or a single IP address.
+func parseNetwork(s string) (n *net.IPNet, err error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("bad network %q", s)
+		}
+
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	_, n, err = net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("bad network %q: %w", s, err)
+	}
+
+	return n, nil
+}
+
+// normalizeDomain returns the lowercased domain name without the trailing dot.
+func normalizeDomain(name string) (norm string) {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// matchDomain returns the name from set that is either host or one of its
+// parent domains, if there is one.
+func matchDomain(set *stringutil.Set, host string) (name string, ok bool) {
+	host = normalizeDomain(host)
+	for host != "" {
+		if set.Has(host) {
+			return host, true
+		}
+
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			break
+		}
+
+		host = host[i+1:]
+	}
+
+	return "", false
+}
+
+// newAnswerPolicies returns the answer policies from the configuration.  The
+// policy of the DNS rebinding protection, if enabled, goes first.
+func newAnswerPolicies(
+	rebinding bool,
+	rebindingAllowed []string,
+	confs []*AnswerPolicyConfig,
+) (ps []*answerPolicy, err error) {
+	if rebinding {
+		var p *answerPolicy
+		p, err = newAnswerPolicy(&AnswerPolicyConfig{
+			Name:       RebindingPolicyName,
+			Networks:   rebindingNets,
+			Exemptions: rebindingAllowed,
+		})
+		if err != nil {
+			// Don't wrap the error, since it's informative enough as is.
+			return nil, err
+		}
+
+		p.publicOnly = true
+		ps = append(ps, p)
+	}
+
+	names := stringutil.NewSet(RebindingPolicyName)
+	for i, c := range confs {
+		if c == nil {
+			return nil, fmt.Errorf("answer policy at index %d: no policy", i)
+		} else if names.Has(c.Name) {
+			return nil, fmt.Errorf("answer policy at index %d: duplicate name %q", i, c.Name)
+		}
+
+		var p *answerPolicy
+		p, err = newAnswerPolicy(c)
+		if err != nil {
+			return nil, fmt.Errorf("answer policy at index %d: %w", i, err)
+		}
+
+		names.Add(c.Name)
+		ps = append(ps, p)
+	}
+
+	return ps, nil
+}
+
+// match returns the rule of p that matches the answer to the request for host,
+// if any.  localSuffix is the domain of DHCP leases with a leading dot.
+func (p *answerPolicy) match(host, localSuffix string, ans []dns.RR) (rule *filtering.ResultRule) {
+	host = normalizeDomain(host)
+	if _, ok := matchDomain(p.exemptions, host); ok {
+		return nil
+	}
+
+	if p.publicOnly &&
+		(!strings.Contains(host, ".") || strings.HasSuffix(host+".", localSuffix)) {
+		return nil
+	}
+
+	for _, rr := range ans {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			if name, found := matchDomain(p.cnames, cname.Target); found {
+				return &filtering.ResultRule{
+					Text:         name,
+					FilterListID: filtering.AnswerPolicyListID,
+				}
+			}
+
+			continue
+		}
+
+		ip := ipFromRR(rr)
+		if ip == nil || ip.IsUnspecified() {
+			// Don't match the answers of the upstreams that block the
+			// request themselves.
+			continue
+		}
+
+		for _, n := range p.nets {
+			if n.Contains(ip) {
+				return &filtering.ResultRule{
+					Text:         n.String(),
+					IP:           ip,
+					FilterListID: filtering.AnswerPolicyListID,
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkAnswerPolicies checks the response in dctx against the answer policies
+// and returns the filtering result if the response must be blocked.
+func (s *Server) checkAnswerPolicies(dctx *dnsContext) (res *filtering.Result) {
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	pctx := dctx.proxyCtx
+	host := pctx.Req.Question[0].Name
+	for _, p := range s.answerPolicies {
+		rule := p.match(host, s.localDomainSuffix, pctx.Res.Answer)
+		if rule == nil {
+			continue
+		}
+
+		log.Debug("dns: answer policy %q: matched %q for %s", p.name, rule.Text, host)
+
+		return &filtering.Result{
+			IsFiltered:   true,
+			Reason:       filtering.FilteredAnswerPolicy,
+			Rules:        []*filtering.ResultRule{rule},
+			AnswerPolicy: p.name,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAnswerPolicies(t *testing.T) {
+	testCases := []struct {
+		confs      []*AnswerPolicyConfig
+		name       string
+		wantErrMsg string
+	}{{
+		confs: []*AnswerPolicyConfig{{
+			Name:     "internal",
+			Networks: []string{"10.0.0.0/8", "192.0.2.1"},
+		}},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		confs:      []*AnswerPolicyConfig{{Name: ""}},
+		name:       "no_name",
+		wantErrMsg: "answer policy at index 0: no name",
+	}, {
+		confs:      []*AnswerPolicyConfig{{Name: RebindingPolicyName}},
+		name:       "reserved_name",
+		wantErrMsg: `answer policy at index 0: duplicate name "rebinding_protection"`,
+	}, {
+		confs: []*AnswerPolicyConfig{{
+			Name:     "bad",
+			Networks: []string{"10.0.0.0/33"},
+		}},
+		name: "bad_network",
+		wantErrMsg: `answer policy at index 0: bad network "10.0.0.0/33": ` +
+			`invalid CIDR address: 10.0.0.0/33`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newAnswerPolicies(true, nil, tc.confs)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestAnswerPolicy_match(t *testing.T) {
+	ps, err := newAnswerPolicies(true, []string{"allowed.example"}, []*AnswerPolicyConfig{{
+		Name:       "trackers",
+		CNAMEs:     []string{"tracker-cdn.example"},
+		Networks:   []string{"203.0.113.0/24"},
+		Exemptions: []string{"exempt.example"},
+	}})
+	require.NoError(t, err)
+	require.Len(t, ps, 2)
+
+	rebinding, trackers := ps[0], ps[1]
+
+	newA := func(ip net.IP) (rr dns.RR) {
+		return &dns.A{Hdr: dns.RR_Header{Rrtype: dns.TypeA}, A: ip}
+	}
+
+	testCases := []struct {
+		policy   *answerPolicy
+		name     string
+		host     string
+		ans      []dns.RR
+		wantRule string
+	}{{
+		policy:   rebinding,
+		name:     "rebinding_private",
+		host:     "public.example.",
+		ans:      []dns.RR{newA(net.IP{192, 168, 0, 1})},
+		wantRule: "192.168.0.0/16",
+	}, {
+		policy:   rebinding,
+		name:     "rebinding_public",
+		host:     "public.example.",
+		ans:      []dns.RR{newA(net.IP{93, 184, 216, 34})},
+		wantRule: "",
+	}, {
+		policy:   rebinding,
+		name:     "rebinding_allowed",
+		host:     "sub.allowed.example.",
+		ans:      []dns.RR{newA(net.IP{192, 168, 0, 1})},
+		wantRule: "",
+	}, {
+		policy:   rebinding,
+		name:     "rebinding_local_domain",
+		host:     "printer.lan.",
+		ans:      []dns.RR{newA(net.IP{192, 168, 0, 1})},
+		wantRule: "",
+	}, {
+		policy:   rebinding,
+		name:     "rebinding_unspecified",
+		host:     "public.example.",
+		ans:      []dns.RR{newA(net.IPv4zero)},
+		wantRule: "",
+	}, {
+		policy: trackers,
+		name:   "cname",
+		host:   "www.example.",
+		ans: []dns.RR{&dns.CNAME{
+			Hdr:    dns.RR_Header{Rrtype: dns.TypeCNAME},
+			Target: "a1.tracker-cdn.example.",
+		}},
+		wantRule: "tracker-cdn.example",
+	}, {
+		policy:   trackers,
+		name:     "network",
+		host:     "www.example.",
+		ans:      []dns.RR{newA(net.IP{203, 0, 113, 5})},
+		wantRule: "203.0.113.0/24",
+	}, {
+		policy:   trackers,
+		name:     "exempt",
+		host:     "exempt.example.",
+		ans:      []dns.RR{newA(net.IP{203, 0, 113, 5})},
+		wantRule: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := tc.policy.match(tc.host, defaultLocalDomainSuffix, tc.ans)
+			if tc.wantRule == "" {
+				assert.Nil(t, rule)
+
+				return
+			}
+
+			require.NotNil(t, rule)
+
+			assert.Equal(t, tc.wantRule, rule.Text)
+			assert.Equal(t, int64(filtering.AnswerPolicyListID), rule.FilterListID)
+		})
+	}
+}
+
+func TestServer_checkAnswerPolicies(t *testing.T) {
+	ps, err := newAnswerPolicies(true, nil, nil)
+	require.NoError(t, err)
+
+	s := &Server{
+		answerPolicies:    ps,
+		localDomainSuffix: defaultLocalDomainSuffix,
+	}
+
+	req := createTestMessage("rebind.example.")
+	resp := (&dns.Msg{}).SetReply(req)
+	resp.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: "rebind.example.", Rrtype: dns.TypeA},
+		A:   net.IP{10, 0, 0, 1},
+	}}
+
+	res := s.checkAnswerPolicies(&dnsContext{
+		proxyCtx: &proxy.DNSContext{
+			Req: req,
+			Res: resp,
+		},
+	})
+	require.NotNil(t, res)
+
+	assert.True(t, res.IsFiltered)
+	assert.Equal(t, filtering.FilteredAnswerPolicy, res.Reason)
+	assert.Equal(t, RebindingPolicyName, res.AnswerPolicy)
+}
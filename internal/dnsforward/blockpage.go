@@ -0,0 +1,59 @@
+package dnsforward
+
+import (
+	"net"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// BlockPage is the server showing the block page which the blocked requests
+// are answered with.
+type BlockPage interface {
+	// IPs returns the addresses of the block page server.  ipv6 is nil if
+	// the server doesn't listen on IPv6.
+	IPs() (ipv4, ipv6 net.IP)
+
+	// Record remembers that the request for host from the client with ip has
+	// been blocked with res, so that the block page could show the details.
+	Record(host string, ip net.IP, res *filtering.Result)
+}
+
+// genBlockPageResponse returns the response pointing the client to the block
+// page server, if it's enabled.  ruleIPs are the addresses from the blocking
+// hosts rules, which take precedence in the default blocking mode.  resp is nil
+// if the request must be answered according to the blocking mode.
+func (s *Server) genBlockPageResponse(
+	d *proxy.DNSContext,
+	res *filtering.Result,
+	ruleIPs []net.IP,
+) (resp *dns.Msg) {
+	bp := s.conf.BlockPage
+	if bp == nil || (s.conf.BlockingMode == BlockingModeDefault && len(ruleIPs) > 0) {
+		return nil
+	}
+
+	req := d.Req
+	ipv4, ipv6 := bp.IPs()
+	switch req.Question[0].Qtype {
+	case dns.TypeA:
+		resp = s.genARecord(req, ipv4)
+	case dns.TypeAAAA:
+		if ipv6 == nil {
+			// Answer with no addresses so that the client falls back to
+			// IPv4.
+			resp = s.makeResponse(req)
+		} else {
+			resp = s.genAAAARecord(req, ipv6)
+		}
+	default:
+		return nil
+	}
+
+	ip, _ := netutil.IPAndPortFromAddr(d.Addr)
+	bp.Record(req.Question[0].Name, ip, res)
+
+	return resp
+}
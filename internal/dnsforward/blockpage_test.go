@@ -0,0 +1,88 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBlockPage is a BlockPage for tests.
+type testBlockPage struct {
+	ipv4   net.IP
+	ipv6   net.IP
+	hosts  []string
+	client net.IP
+}
+
+// IPs implements the BlockPage interface for *testBlockPage.
+func (bp *testBlockPage) IPs() (ipv4, ipv6 net.IP) {
+	return bp.ipv4, bp.ipv6
+}
+
+// Record implements the BlockPage interface for *testBlockPage.
+func (bp *testBlockPage) Record(host string, ip net.IP, _ *filtering.Result) {
+	bp.hosts = append(bp.hosts, host)
+	bp.client = ip
+}
+
+func TestServer_genFilteredResponse_blockPage(t *testing.T) {
+	bp := &testBlockPage{ipv4: net.IP{192, 168, 0, 1}}
+	s := createTestServer(t, &filtering.Config{}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		FilteringConfig: FilteringConfig{
+			ProtectionEnabled: true,
+			BlockingMode:      BlockingModeNXDOMAIN,
+		},
+		BlockPage: bp,
+	}, nil)
+
+	res := &filtering.Result{
+		IsFiltered: true,
+		Reason:     filtering.FilteredBlockList,
+		Rules:      []*filtering.ResultRule{{Text: "||example.org^"}},
+	}
+	clientAddr := &net.UDPAddr{IP: net.IP{192, 168, 0, 2}, Port: 53}
+
+	t.Run("a", func(t *testing.T) {
+		resp := s.genFilteredResponse(&proxy.DNSContext{
+			Req:  createTestMessage("example.org."),
+			Addr: clientAddr,
+		}, res)
+		require.NotNil(t, resp)
+		require.Len(t, resp.Answer, 1)
+
+		a, ok := resp.Answer[0].(*dns.A)
+		require.True(t, ok)
+
+		assert.Equal(t, bp.ipv4, a.A)
+		assert.Equal(t, []string{"example.org."}, bp.hosts)
+		assert.Equal(t, clientAddr.IP, bp.client)
+	})
+
+	t.Run("aaaa_no_ipv6", func(t *testing.T) {
+		resp := s.genFilteredResponse(&proxy.DNSContext{
+			Req:  createTestMessageWithType("example.org.", dns.TypeAAAA),
+			Addr: clientAddr,
+		}, res)
+		require.NotNil(t, resp)
+
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Empty(t, resp.Answer)
+	})
+
+	t.Run("other_type", func(t *testing.T) {
+		resp := s.genFilteredResponse(&proxy.DNSContext{
+			Req:  createTestMessageWithType("example.org.", dns.TypeTXT),
+			Addr: clientAddr,
+		}, res)
+		require.NotNil(t, resp)
+
+		assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+	})
+}
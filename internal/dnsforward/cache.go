@@ -0,0 +1,736 @@
+package dnsforward
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultCacheStaleTTL is the default TTL of the stale responses in
+	// seconds, as recommended by RFC 8767.
+	defaultCacheStaleTTL = 30
+
+	// defaultCacheMaxStale is the default maximum time the expired responses
+	// are served for.  RFC 8767 recommends a value between one and three
+	// days.
+	defaultCacheMaxStale = 24 * time.Hour
+
+	// cacheOptimisticTTL is the TTL of the expired responses served by the
+	// optimistic cache in seconds.
+	cacheOptimisticTTL = 10
+
+	// cachePrefetchRatio defines when the popular responses are prefetched:
+	// it happens when less than 1/cachePrefetchRatio of their TTL remains.
+	cachePrefetchRatio = 10
+)
+
+// cacheState is the state of the cached response.
+type cacheState uint8
+
+// Cache states.
+const (
+	// cacheStateMiss means that there is no suitable response in the cache.
+	cacheStateMiss cacheState = iota
+
+	// cacheStateFresh means that the cached response hasn't expired yet.
+	cacheStateFresh
+
+	// cacheStateOptimistic means that the cached response has expired, but
+	// may be served while it's being refreshed.
+	cacheStateOptimistic
+
+	// cacheStateStale means that the cached response has expired and may
+	// only be served if the upstream servers fail to respond.
+	cacheStateStale
+)
+
+// cacheItem is a cached response with its metadata.
+type cacheItem struct {
+	// resp is the cached response with the TTLs at the moment it was
+	// stored.  It must not be modified.
+	resp *dns.Msg
+
+	// stored is the moment the response was stored.
+	stored time.Time
+
+	// key is the key of the item in the cache.
+	key string
+
+	// name is the canonical name from the question of the response.
+	name string
+
+	// upstream is the address of the upstream server which the response
+	// was received from.
+	upstream string
+
+	// dnssecStatus is the result of the local DNSSEC validation of the
+	// response, if any.
+	dnssecStatus dnssecStatus
+
+	// size is the approximate size of the item in bytes.
+	size int
+
+	// ttl is the lowest TTL of the response in seconds.
+	ttl uint32
+
+	// hits is the number of times the response was requested from the
+	// cache.
+	hits uint32
+
+	// qtype is the type from the question of the response.
+	qtype uint16
+
+	// responseAD shows if the response had the AD bit set.
+	responseAD bool
+
+	// refreshing is true while the response is being refreshed.
+	refreshing bool
+}
+
+// expire returns the moment the item expires.
+func (ci *cacheItem) expire() (t time.Time) {
+	return ci.stored.Add(time.Duration(ci.ttl) * time.Second)
+}
+
+// reply returns a copy of the cached response to req.  The TTLs are set to ttl
+// if it's not zero, and are decreased by the time passed since the response was
+// stored otherwise.
+func (ci *cacheItem) reply(req *dns.Msg, now time.Time, ttl uint32) (resp *dns.Msg) {
+	resp = ci.resp.Copy()
+	resp.Id = req.Id
+	resp.Question = append([]dns.Question(nil), req.Question...)
+
+	passed := uint32(now.Sub(ci.stored) / time.Second)
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range rrs {
+			hdr := rr.Header()
+			switch {
+			case hdr.Rrtype == dns.TypeOPT:
+				// Don't touch the EDNS data.
+			case ttl != 0:
+				hdr.Ttl = ttl
+			case hdr.Ttl > passed:
+				hdr.Ttl -= passed
+			default:
+				hdr.Ttl = 0
+			}
+		}
+	}
+
+	return resp
+}
+
+// dnsCache is a cache of the responses from the upstream servers limited by
+// their total size.  The least recently used responses are evicted first.  It
+// is safe for concurrent use.
+type dnsCache struct {
+	// items are the elements of lru mapped by the keys of their items.
+	items map[string]*list.Element
+
+	// lru is the list of *cacheItem ordered from the most recently used to
+	// the least recently used one.
+	lru *list.List
+
+	// lock protects items, lru, size, and the items themselves.
+	lock sync.Mutex
+
+	// maxStale is the maximum time the expired responses are served for if
+	// optimistic or serveStale is true.
+	maxStale time.Duration
+
+	// maxSize is the maximum total size of the items in bytes.
+	maxSize int
+
+	// size is the current total size of the items in bytes.
+	size int
+
+	// staleTTL is the TTL of the stale responses in seconds.
+	staleTTL uint32
+
+	// minTTL and maxTTL are the bounds of the TTLs of the cached responses
+	// in seconds.  Zero means no bound.
+	minTTL uint32
+	maxTTL uint32
+
+	// prefetchHits is the number of hits after which the response is
+	// refreshed before it expires.  If it's zero, the prefetching is
+	// disabled.
+	prefetchHits uint32
+
+	// optimistic defines if the expired responses are served while they're
+	// being refreshed.
+	optimistic bool
+
+	// serveStale defines if the expired responses are served when the
+	// upstream servers fail to respond.
+	serveStale bool
+}
+
+// newDNSCache creates a new cache using the cache settings from conf.
+func newDNSCache(conf *FilteringConfig) (c *dnsCache) {
+	c = &dnsCache{
+		items:        map[string]*list.Element{},
+		lru:          list.New(),
+		maxStale:     conf.CacheMaxStale.Duration,
+		maxSize:      int(conf.CacheSize),
+		staleTTL:     conf.CacheStaleTTL,
+		minTTL:       conf.CacheMinTTL,
+		maxTTL:       conf.CacheMaxTTL,
+		prefetchHits: conf.CachePrefetchHits,
+		optimistic:   conf.CacheOptimistic,
+		serveStale:   conf.CacheServeStale,
+	}
+
+	if c.maxStale == 0 {
+		c.maxStale = defaultCacheMaxStale
+	}
+
+	if c.staleTTL == 0 {
+		c.staleTTL = defaultCacheStaleTTL
+	}
+
+	return c
+}
+
+// get returns a copy of the item with key and its state at the moment now.
+// refresh is true if the caller should refresh the item.
+func (c *dnsCache) get(key string, now time.Time) (ci *cacheItem, st cacheState, refresh bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, cacheStateMiss, false
+	}
+
+	item := e.Value.(*cacheItem)
+	exp := item.expire()
+	// Don't serve the responses which failed to refresh for too long.
+	servable := now.Before(exp.Add(c.maxStale))
+	switch {
+	case now.Before(exp):
+		st = cacheStateFresh
+		left := exp.Sub(now)
+		refresh = c.prefetchHits > 0 &&
+			item.hits+1 >= c.prefetchHits &&
+			left*cachePrefetchRatio <= time.Duration(item.ttl)*time.Second
+	case servable && c.optimistic:
+		st, refresh = cacheStateOptimistic, true
+	case servable && c.serveStale:
+		st = cacheStateStale
+	default:
+		c.removeLocked(e)
+
+		return nil, cacheStateMiss, false
+	}
+
+	c.lru.MoveToFront(e)
+	item.hits++
+
+	if refresh {
+		refresh = !item.refreshing
+		item.refreshing = true
+	}
+
+	cp := *item
+
+	return &cp, st, refresh
+}
+
+// set stores ci in the cache.  The number of hits of the replaced item, if
+// any, is preserved.
+func (c *dnsCache) set(ci *cacheItem) {
+	if ci.size > c.maxSize {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.items[ci.key]; ok {
+		ci.hits = e.Value.(*cacheItem).hits
+		c.removeLocked(e)
+	}
+
+	c.items[ci.key] = c.lru.PushFront(ci)
+	c.size += ci.size
+
+	for c.size > c.maxSize {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+// finishRefresh marks the item with key as not being refreshed, so that it
+// could be refreshed again.
+func (c *dnsCache) finishRefresh(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		e.Value.(*cacheItem).refreshing = false
+	}
+}
+
+// removeLocked removes e from the cache.  c.lock is expected to be locked.
+func (c *dnsCache) removeLocked(e *list.Element) {
+	ci := c.lru.Remove(e).(*cacheItem)
+	delete(c.items, ci.key)
+	c.size -= ci.size
+}
+
+// matching returns the copies of the items for name and qtype.  All the types
+// match if qtype is dns.TypeNone, and all the items match if name is empty as
+// well.
+func (c *dnsCache) matching(name string, qtype uint16) (items []*cacheItem) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		ci := e.Value.(*cacheItem)
+		if ci.matches(name, qtype) {
+			cp := *ci
+			items = append(items, &cp)
+		}
+	}
+
+	return items
+}
+
+// flush removes the items for name and qtype from the cache and returns the
+// number of the removed items.  All the types match if qtype is dns.TypeNone,
+// and all the items match if name is empty as well.
+func (c *dnsCache) flush(name string, qtype uint16) (n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*cacheItem).matches(name, qtype) {
+			c.removeLocked(e)
+			n++
+		}
+
+		e = next
+	}
+
+	return n
+}
+
+// matches returns true if ci is the item for name and qtype.  All the types
+// match if qtype is dns.TypeNone, and all the items match if name is empty as
+// well.
+func (ci *cacheItem) matches(name string, qtype uint16) (ok bool) {
+	return (name == "" || ci.name == name) && (qtype == dns.TypeNone || ci.qtype == qtype)
+}
+
+// isCacheable returns true if resp may be cached, that is if it's a successful
+// response or a negative one with an SOA record, see RFC 2308.
+func isCacheable(resp *dns.Msg) (ok bool) {
+	if resp == nil || resp.Truncated || len(resp.Question) != 1 {
+		return false
+	}
+
+	switch resp.Rcode {
+	case dns.RcodeSuccess:
+		return len(resp.Answer) > 0 || hasSOA(resp.Ns)
+	case dns.RcodeNameError:
+		return hasSOA(resp.Ns)
+	default:
+		return false
+	}
+}
+
+// hasSOA returns true if rrs contain an SOA record.
+func hasSOA(rrs []dns.RR) (ok bool) {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clampTTL returns ttl limited by minTTL and maxTTL.  Zero bounds are ignored.
+func clampTTL(ttl, minTTL, maxTTL uint32) (clamped uint32) {
+	switch {
+	case minTTL != 0 && ttl < minTTL:
+		return minTTL
+	case maxTTL != 0 && ttl > maxTTL:
+		return maxTTL
+	default:
+		return ttl
+	}
+}
+
+// cacheTTL returns the lowest TTL of the records in resp limited by minTTL
+// and maxTTL.  The minimum TTL field of SOA records is respected as well, see
+// RFC 2308.  ttl is zero if resp contains no records.
+func cacheTTL(resp *dns.Msg, minTTL, maxTTL uint32) (ttl uint32) {
+	ttl = ^uint32(0)
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range rrs {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				continue
+			}
+
+			if hdr.Ttl < ttl {
+				ttl = hdr.Ttl
+			}
+
+			if soa, ok := rr.(*dns.SOA); ok && soa.Minttl < ttl {
+				ttl = soa.Minttl
+			}
+		}
+	}
+
+	if ttl == ^uint32(0) {
+		return 0
+	}
+
+	return clampTTL(ttl, minTTL, maxTTL)
+}
+
+// newItem returns a new cache item with key for the response in dctx.  The
+// TTLs of the records of the cached copy are limited by the TTL bounds of c.
+// ci is nil if the response may not be cached.
+func (c *dnsCache) newItem(dctx *dnsContext, key string) (ci *cacheItem) {
+	pctx := dctx.proxyCtx
+	resp := pctx.Res
+	if !isCacheable(resp) {
+		return nil
+	}
+
+	ttl := cacheTTL(resp, c.minTTL, c.maxTTL)
+	if ttl == 0 {
+		return nil
+	}
+
+	cached := resp.Copy()
+	for _, rrs := range [][]dns.RR{cached.Answer, cached.Ns, cached.Extra} {
+		for _, rr := range rrs {
+			if hdr := rr.Header(); hdr.Rrtype != dns.TypeOPT {
+				hdr.Ttl = clampTTL(hdr.Ttl, c.minTTL, c.maxTTL)
+			}
+		}
+	}
+
+	ci = &cacheItem{
+		resp:         cached,
+		stored:       time.Now(),
+		key:          key,
+		name:         canonicalName(resp.Question[0].Name),
+		dnssecStatus: dctx.dnssecStatus,
+		size:         resp.Len() + len(key),
+		ttl:          ttl,
+		qtype:        resp.Question[0].Qtype,
+		responseAD:   dctx.responseAD,
+	}
+
+	if pctx.Upstream != nil {
+		ci.upstream = pctx.Upstream.Address()
+	}
+
+	return ci
+}
+
+// cacheKey returns the cache key for the request in pctx.  The flags of the
+// request affecting the response are a part of the key as well as the client's
+// subnet if ecs is true.
+func cacheKey(pctx *proxy.DNSContext, ecs bool) (key string) {
+	req := pctx.Req
+	q := req.Question[0]
+
+	var do bool
+	if opt := req.IsEdns0(); opt != nil {
+		do = opt.Do()
+	}
+
+	key = fmt.Sprintf(
+		"%s %d %d %t %t %t",
+		canonicalName(q.Name),
+		q.Qtype,
+		q.Qclass,
+		req.AuthenticatedData,
+		req.CheckingDisabled,
+		do,
+	)
+
+	if ecs {
+		key += " " + ecsSubnet(pctx)
+	}
+
+	return key
+}
+
+// ecsSubnet returns the subnet from the EDNS Client Subnet option of the
+// request in pctx or the subnet of the client's IP address if there is no
+// such option.
+func ecsSubnet(pctx *proxy.DNSContext) (subnet string) {
+	if opt := pctx.Req.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+				bits := 8 * net.IPv6len
+				if ecs.Family == 1 {
+					bits = 8 * net.IPv4len
+				}
+
+				mask := net.CIDRMask(int(ecs.SourceNetmask), bits)
+
+				return (&net.IPNet{IP: ecs.Address.Mask(mask), Mask: mask}).String()
+			}
+		}
+	}
+
+	ip, _ := netutil.IPAndPortFromAddr(pctx.Addr)
+	if ip == nil {
+		return ""
+	}
+
+	// Use the same prefix lengths as the proxy.
+	mask := net.CIDRMask(56, 8*net.IPv6len)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, mask = ip4, net.CIDRMask(24, 8*net.IPv4len)
+	}
+
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+// prepareCache sets up the cache of the responses, if it's enabled.
+func (s *Server) prepareCache() {
+	s.cache = nil
+	if s.conf.CacheSize != 0 {
+		s.cache = newDNSCache(&s.conf.FilteringConfig)
+	}
+}
+
+// isCacheBypassed returns true if the responses to the client must not be
+// cached or served from the cache.
+func (s *Server) isCacheBypassed(dctx *dnsContext) (ok bool) {
+	pctx := dctx.proxyCtx
+	if pctx.Addr == nil || s.conf.IsCacheBypassedByClient == nil {
+		return false
+	}
+
+	// Use the clientID first, since it has a higher priority.
+	id := stringutil.Coalesce(dctx.clientID, ipStringFromAddr(pctx.Addr))
+
+	return s.conf.IsCacheBypassedByClient(id)
+}
+
+// replyFromCache sets the response in dctx from c, if there is a suitable one,
+// and starts refreshing it, if needed.  stale is the expired item which may be
+// served if the upstream servers fail to respond.
+func (s *Server) replyFromCache(
+	dctx *dnsContext,
+	c *dnsCache,
+	key string,
+) (ok bool, stale *cacheItem) {
+	now := time.Now()
+	ci, st, refresh := c.get(key, now)
+	if refresh {
+		s.refreshCache(dctx, c, key)
+	}
+
+	req := dctx.proxyCtx.Req
+	switch st {
+	case cacheStateFresh:
+		setCachedResponse(dctx, ci, ci.reply(req, now, 0))
+	case cacheStateOptimistic:
+		setCachedResponse(dctx, ci, ci.reply(req, now, cacheOptimisticTTL))
+	case cacheStateStale:
+		return false, ci
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// replyStale sets the stale response from ci in dctx.  err is the error
+// returned by the upstream servers.
+func (s *Server) replyStale(dctx *dnsContext, c *dnsCache, ci *cacheItem, err error) {
+	log.Debug("dns: serving stale response for %s: %s", ci.name, err)
+
+	req := dctx.proxyCtx.Req
+	resp := ci.reply(req, time.Now(), c.staleTTL)
+	s.setFailureEDE(req, resp, dns.ExtendedErrorCodeStaleAnswer, err.Error())
+
+	setCachedResponse(dctx, ci, resp)
+}
+
+// setCachedResponse sets resp built from ci as the response in dctx.
+func setCachedResponse(dctx *dnsContext, ci *cacheItem, resp *dns.Msg) {
+	dctx.proxyCtx.Res = resp
+	dctx.proxyCtx.CachedUpstreamAddr = ci.upstream
+	dctx.responseFromUpstream = true
+	dctx.responseAD = ci.responseAD
+	dctx.dnssecStatus = ci.dnssecStatus
+}
+
+// refreshCache resolves the request from dctx again in the background and
+// stores the new response in c.
+func (s *Server) refreshCache(dctx *dnsContext, c *dnsCache, key string) {
+	pctx := dctx.proxyCtx
+	rctx := &dnsContext{
+		proxyCtx: &proxy.DNSContext{
+			Proto:     pctx.Proto,
+			Req:       pctx.Req.Copy(),
+			Addr:      pctx.Addr,
+			StartTime: time.Now(),
+		},
+		result:    &filtering.Result{},
+		clientID:  dctx.clientID,
+		startTime: time.Now(),
+	}
+
+	go func() {
+		defer log.OnPanic("dns: refreshing cache")
+
+		err := s.exchangeUpstream(rctx)
+		if err != nil {
+			log.Debug("dns: refreshing cached response for %s: %s", key, err)
+			c.finishRefresh(key)
+
+			return
+		}
+
+		if ci := c.newItem(rctx, key); ci != nil {
+			c.set(ci)
+		} else {
+			c.finishRefresh(key)
+		}
+	}()
+}
+
+// cacheEntryJSON is the JSON representation of a cached response.
+type cacheEntryJSON struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Upstream string   `json:"upstream"`
+	Rcode    string   `json:"rcode"`
+	Answer   []string `json:"answer"`
+	TTL      int64    `json:"ttl"`
+	Hits     uint32   `json:"hits"`
+	Expired  bool     `json:"expired"`
+}
+
+// cacheFlushJSON is the JSON structure of the request to flush the cache.
+type cacheFlushJSON struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// parseCacheQuery returns the canonical name and the type from the strings.
+// qtype is dns.TypeNone if typ is empty.
+func parseCacheQuery(name, typ string) (canon string, qtype uint16, err error) {
+	if typ != "" {
+		var ok bool
+		qtype, ok = dns.StringToType[typ]
+		if !ok {
+			return "", 0, fmt.Errorf("bad type %q", typ)
+		}
+	}
+
+	if name == "" {
+		return "", qtype, nil
+	}
+
+	canon = canonicalName(name)
+	if _, ok := dns.IsDomainName(canon); !ok {
+		return "", 0, fmt.Errorf("bad name %q", name)
+	}
+
+	return canon, qtype, nil
+}
+
+// respCache returns the current cache of the responses.  c is nil if the
+// cache is disabled.
+func (s *Server) respCache() (c *dnsCache) {
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	return s.cache
+}
+
+func (s *Server) handleCacheList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("name") == "" {
+		aghhttp.Error(r, w, http.StatusBadRequest, "no name specified")
+
+		return
+	}
+
+	name, qtype, err := parseCacheQuery(q.Get("name"), q.Get("type"))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	entries := []*cacheEntryJSON{}
+	if c := s.respCache(); c != nil {
+		now := time.Now()
+		for _, ci := range c.matching(name, qtype) {
+			e := &cacheEntryJSON{
+				Name:     ci.name,
+				Type:     dns.Type(ci.qtype).String(),
+				Upstream: ci.upstream,
+				Rcode:    dns.RcodeToString[ci.resp.Rcode],
+				TTL:      int64(ci.expire().Sub(now) / time.Second),
+				Hits:     ci.hits,
+				Expired:  !now.Before(ci.expire()),
+			}
+
+			for _, rr := range ci.resp.Answer {
+				e.Answer = append(e.Answer, rr.String())
+			}
+
+			entries = append(entries, e)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(entries)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "encoding response: %s", err)
+
+		return
+	}
+}
+
+func (s *Server) handleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	req := &cacheFlushJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	name, qtype, err := parseCacheQuery(req.Name, req.Type)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	if c := s.respCache(); c != nil {
+		n := c.flush(name, qtype)
+		log.Debug("dns: flushed %d cached responses for %q %q", n, name, req.Type)
+	}
+}
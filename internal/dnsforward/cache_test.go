@@ -0,0 +1,437 @@
+package dnsforward
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCacheUpstream is an upstream responding with an A record with a fixed
+// TTL and counting the requests.
+type testCacheUpstream struct {
+	// err, if not nil, is returned instead of the response.
+	err error
+
+	mu sync.Mutex
+
+	// reqNum is the number of the received requests.
+	reqNum int
+}
+
+// Exchange implements the upstream.Upstream interface for *testCacheUpstream.
+func (u *testCacheUpstream) Exchange(m *dns.Msg) (resp *dns.Msg, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.reqNum++
+	if u.err != nil {
+		return nil, u.err
+	}
+
+	resp = (&dns.Msg{}).SetReply(m)
+	resp.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{
+			Name:   m.Question[0].Name,
+			Rrtype: dns.TypeA,
+			Class:  dns.ClassINET,
+			Ttl:    60,
+		},
+		A: net.IP{192, 0, 2, 1},
+	}}
+
+	return resp, nil
+}
+
+// Address implements the upstream.Upstream interface for *testCacheUpstream.
+func (u *testCacheUpstream) Address() (addr string) {
+	return "test.upstream"
+}
+
+// set sets the error returned by u and returns the number of requests
+// received so far.
+func (u *testCacheUpstream) set(err error) (reqNum int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.err = err
+
+	return u.reqNum
+}
+
+// newTestCacheItem returns a cache item for the A response to host with ttl
+// stored at the moment stored.
+func newTestCacheItem(host string, ttl uint32, stored time.Time) (ci *cacheItem) {
+	req := createTestMessage(host)
+	resp := (&dns.Msg{}).SetReply(req)
+	resp.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{
+			Name:   host,
+			Rrtype: dns.TypeA,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		A: net.IP{192, 0, 2, 1},
+	}}
+
+	key := cacheKey(&proxy.DNSContext{Req: req}, false)
+
+	return &cacheItem{
+		resp:   resp,
+		stored: stored,
+		key:    key,
+		name:   canonicalName(host),
+		size:   resp.Len() + len(key),
+		ttl:    ttl,
+		qtype:  dns.TypeA,
+	}
+}
+
+func TestDNSCache_get(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-2 * time.Minute)
+
+	testCases := []struct {
+		name        string
+		conf        FilteringConfig
+		stored      time.Time
+		wantState   cacheState
+		wantRefresh bool
+	}{{
+		name:        "fresh",
+		conf:        FilteringConfig{},
+		stored:      now,
+		wantState:   cacheStateFresh,
+		wantRefresh: false,
+	}, {
+		name:        "expired",
+		conf:        FilteringConfig{},
+		stored:      expired,
+		wantState:   cacheStateMiss,
+		wantRefresh: false,
+	}, {
+		name:        "optimistic",
+		conf:        FilteringConfig{CacheOptimistic: true},
+		stored:      expired,
+		wantState:   cacheStateOptimistic,
+		wantRefresh: true,
+	}, {
+		name:        "optimistic_too_stale",
+		conf:        FilteringConfig{CacheOptimistic: true},
+		stored:      now.Add(-2 * defaultCacheMaxStale),
+		wantState:   cacheStateMiss,
+		wantRefresh: false,
+	}, {
+		name:        "stale",
+		conf:        FilteringConfig{CacheServeStale: true},
+		stored:      expired,
+		wantState:   cacheStateStale,
+		wantRefresh: false,
+	}, {
+		name:        "too_stale",
+		conf:        FilteringConfig{CacheServeStale: true},
+		stored:      now.Add(-2 * defaultCacheMaxStale),
+		wantState:   cacheStateMiss,
+		wantRefresh: false,
+	}, {
+		name:        "prefetch",
+		conf:        FilteringConfig{CachePrefetchHits: 1},
+		stored:      now.Add(-55 * time.Second),
+		wantState:   cacheStateFresh,
+		wantRefresh: true,
+	}, {
+		name:        "no_prefetch_early",
+		conf:        FilteringConfig{CachePrefetchHits: 1},
+		stored:      now.Add(-10 * time.Second),
+		wantState:   cacheStateFresh,
+		wantRefresh: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.conf.CacheSize = 4096
+			c := newDNSCache(&tc.conf)
+
+			ci := newTestCacheItem("example.org.", 60, tc.stored)
+			c.set(ci)
+
+			got, st, refresh := c.get(ci.key, now)
+			assert.Equal(t, tc.wantState, st)
+			assert.Equal(t, tc.wantRefresh, refresh)
+
+			if st == cacheStateMiss {
+				assert.Nil(t, got)
+				assert.Empty(t, c.items)
+				assert.Zero(t, c.size)
+
+				return
+			}
+
+			require.NotNil(t, got)
+			assert.Equal(t, uint32(1), got.hits)
+
+			// The item is refreshed only once at a time.
+			_, _, refresh = c.get(ci.key, now)
+			assert.False(t, refresh)
+		})
+	}
+}
+
+func TestDNSCache_set(t *testing.T) {
+	now := time.Now()
+	first := newTestCacheItem("first.example.", 60, now)
+	second := newTestCacheItem("second.example.", 60, now)
+
+	c := newDNSCache(&FilteringConfig{CacheSize: uint32(first.size + second.size - 1)})
+
+	c.set(first)
+	_, st, _ := c.get(first.key, now)
+	require.Equal(t, cacheStateFresh, st)
+
+	// The least recently used item is evicted.
+	c.set(second)
+
+	_, st, _ = c.get(first.key, now)
+	assert.Equal(t, cacheStateMiss, st)
+
+	_, st, _ = c.get(second.key, now)
+	assert.Equal(t, cacheStateFresh, st)
+
+	assert.Equal(t, second.size, c.size)
+
+	t.Run("hits_preserved", func(t *testing.T) {
+		c.set(newTestCacheItem("second.example.", 60, now))
+
+		ci, _, _ := c.get(second.key, now)
+		require.NotNil(t, ci)
+
+		assert.Equal(t, uint32(2), ci.hits)
+	})
+
+	t.Run("too_big", func(t *testing.T) {
+		small := newDNSCache(&FilteringConfig{CacheSize: 1})
+		small.set(newTestCacheItem("first.example.", 60, now))
+
+		assert.Empty(t, small.items)
+	})
+}
+
+func TestDNSCache_newItem(t *testing.T) {
+	c := newDNSCache(&FilteringConfig{
+		CacheSize:   1024,
+		CacheMinTTL: 30,
+		CacheMaxTTL: 120,
+	})
+
+	testCases := []struct {
+		name    string
+		ttl     uint32
+		wantTTL uint32
+	}{{
+		name:    "below_min",
+		ttl:     10,
+		wantTTL: 30,
+	}, {
+		name:    "zero",
+		ttl:     0,
+		wantTTL: 30,
+	}, {
+		name:    "within",
+		ttl:     60,
+		wantTTL: 60,
+	}, {
+		name:    "above_max",
+		ttl:     3600,
+		wantTTL: 120,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := newTestCacheItem("example.org.", tc.ttl, time.Now()).resp
+			ci := c.newItem(&dnsContext{
+				proxyCtx: &proxy.DNSContext{Res: resp},
+			}, "key")
+			require.NotNil(t, ci)
+
+			assert.Equal(t, tc.wantTTL, ci.ttl)
+			assert.Equal(t, tc.wantTTL, ci.resp.Answer[0].Header().Ttl)
+
+			// The original response must not be changed.
+			assert.Equal(t, tc.ttl, resp.Answer[0].Header().Ttl)
+		})
+	}
+}
+
+func TestCacheItem_reply(t *testing.T) {
+	stored := time.Now()
+	ci := newTestCacheItem("example.org.", 60, stored)
+
+	req := createTestMessage("Example.ORG.")
+	resp := ci.reply(req, stored.Add(15*time.Second), 0)
+
+	assert.Equal(t, req.Id, resp.Id)
+	assert.Equal(t, req.Question, resp.Question)
+
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, uint32(45), resp.Answer[0].Header().Ttl)
+
+	resp = ci.reply(req, stored.Add(time.Hour), defaultCacheStaleTTL)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, uint32(defaultCacheStaleTTL), resp.Answer[0].Header().Ttl)
+
+	// The cached response itself isn't modified.
+	assert.Equal(t, uint32(60), ci.resp.Answer[0].Header().Ttl)
+}
+
+func TestServer_processUpstream_cache(t *testing.T) {
+	bypassed := net.IP{192, 168, 0, 2}
+	s := createTestServer(t, &filtering.Config{}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		FilteringConfig: FilteringConfig{
+			CacheSize:       4096,
+			CacheServeStale: true,
+			ExtendedErrors:  true,
+			IsCacheBypassedByClient: func(id string) (ok bool) {
+				return id == bypassed.String()
+			},
+		},
+	}, nil)
+
+	ups := &testCacheUpstream{}
+	s.conf.UpstreamConfig.Upstreams = []upstream.Upstream{ups}
+
+	process := func(t *testing.T, ip net.IP) (dctx *dnsContext) {
+		t.Helper()
+
+		req := createTestMessage("example.org.")
+		req.SetEdns0(dns.DefaultMsgSize, false)
+
+		dctx = &dnsContext{
+			proxyCtx: &proxy.DNSContext{
+				Proto: proxy.ProtoUDP,
+				Req:   req,
+				Addr:  &net.UDPAddr{IP: ip},
+			},
+		}
+
+		rc := s.processUpstream(dctx)
+		require.Equal(t, resultCodeSuccess, rc)
+		require.NotNil(t, dctx.proxyCtx.Res)
+		require.Len(t, dctx.proxyCtx.Res.Answer, 1)
+
+		return dctx
+	}
+
+	client := net.IP{192, 168, 0, 1}
+
+	dctx := process(t, client)
+	assert.Empty(t, dctx.proxyCtx.CachedUpstreamAddr)
+	assert.Equal(t, 1, ups.set(nil))
+
+	t.Run("cached", func(t *testing.T) {
+		dctx = process(t, client)
+		assert.Equal(t, "test.upstream", dctx.proxyCtx.CachedUpstreamAddr)
+		assert.True(t, dctx.responseFromUpstream)
+		assert.Equal(t, 1, ups.set(nil))
+	})
+
+	t.Run("bypassed", func(t *testing.T) {
+		dctx = process(t, bypassed)
+		assert.Empty(t, dctx.proxyCtx.CachedUpstreamAddr)
+		assert.Equal(t, 2, ups.set(nil))
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		// Make the cached response expire.
+		for _, ci := range s.cache.matching("", dns.TypeNone) {
+			ci.stored = ci.stored.Add(-time.Hour)
+			s.cache.set(ci)
+		}
+
+		ups.set(errors.Error("upstream failed"))
+
+		dctx = process(t, client)
+		resp := dctx.proxyCtx.Res
+
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Equal(t, uint32(defaultCacheStaleTTL), resp.Answer[0].Header().Ttl)
+
+		ede := extendedError(resp)
+		require.NotNil(t, ede)
+
+		assert.Equal(t, uint16(dns.ExtendedErrorCodeStaleAnswer), ede.InfoCode)
+	})
+}
+
+func TestServer_handleCache(t *testing.T) {
+	s := createTestServer(t, &filtering.Config{}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		FilteringConfig: FilteringConfig{
+			CacheSize: 4096,
+		},
+	}, nil)
+
+	now := time.Now()
+	s.cache.set(newTestCacheItem("example.org.", 60, now))
+	s.cache.set(newTestCacheItem("other.example.", 60, now))
+
+	list := func(t *testing.T, query string) (entries []*cacheEntryJSON) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/control/cache/list?"+query, nil)
+		s.handleCacheList(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		err := json.NewDecoder(w.Body).Decode(&entries)
+		require.NoError(t, err)
+
+		return entries
+	}
+
+	entries := list(t, "name=Example.ORG&type=A")
+	require.Len(t, entries, 1)
+
+	assert.Equal(t, "example.org.", entries[0].Name)
+	assert.Equal(t, "A", entries[0].Type)
+	assert.Equal(t, "NOERROR", entries[0].Rcode)
+	assert.False(t, entries[0].Expired)
+	require.Len(t, entries[0].Answer, 1)
+
+	assert.Empty(t, list(t, "name=example.org&type=AAAA"))
+
+	t.Run("bad_request", func(t *testing.T) {
+		for _, query := range []string{"", "name=example.org&type=BAD"} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/control/cache/list?"+query, nil)
+			s.handleCacheList(w, r)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		body := bytes.NewBufferString(`{"name":"example.org","type":""}`)
+		r := httptest.NewRequest(http.MethodPost, "/control/cache/flush", body)
+		s.handleCacheFlush(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		assert.Empty(t, list(t, "name=example.org"))
+		assert.Len(t, list(t, "name=other.example"), 1)
+	})
+}
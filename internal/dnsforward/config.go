@@ -46,6 +46,21 @@ const (
 	BlockingModeREFUSED BlockingMode = "refused"
 )
 
+// isValidBlockingMode returns true if m is one of the known blocking modes.
+func isValidBlockingMode(m BlockingMode) (ok bool) {
+	switch m {
+	case
+		BlockingModeCustomIP,
+		BlockingModeDefault,
+		BlockingModeNullIP,
+		BlockingModeNXDOMAIN,
+		BlockingModeREFUSED:
+		return true
+	default:
+		return false
+	}
+}
+
 // FilteringConfig represents the DNS filtering configuration of AdGuard Home
 // The zero FilteringConfig is empty and ready for use.
 type FilteringConfig struct {
@@ -60,6 +75,11 @@ type FilteringConfig struct {
 	// nil if there are no custom upstreams for the client.
 	GetCustomUpstreamByClient func(id string) (conf *proxy.UpstreamConfig, err error) `yaml:"-"`
 
+	// IsCacheBypassedByClient is a callback that returns true if the
+	// responses to the client with the IP address or ClientID mustn't be
+	// cached or served from the cache.
+	IsCacheBypassedByClient func(id string) (ok bool) `yaml:"-"`
+
 	// Protection configuration
 	// --
 
@@ -69,6 +89,15 @@ type FilteringConfig struct {
 	BlockingIPv6       net.IP       `yaml:"blocking_ipv6"`        // IP address to be returned for a blocked AAAA request
 	BlockedResponseTTL uint32       `yaml:"blocked_response_ttl"` // if 0, then default is used (3600)
 
+	// ExtendedErrors defines if the responses to the blocked and failed
+	// requests contain the Extended DNS Errors describing the reason, see
+	// RFC 8914.
+	ExtendedErrors bool `yaml:"extended_errors"`
+	// ExtendedErrorsModes are the blocking modes in which the responses to
+	// the blocked requests contain the Extended DNS Errors.  All the modes
+	// are used if it's empty.
+	ExtendedErrorsModes []BlockingMode `yaml:"extended_errors_modes"`
+
 	// IP (or domain name) which is used to respond to DNS requests blocked by parental control or safe-browsing
 	ParentalBlockHost     string `yaml:"parental_block_host"`
 	SafeBrowsingBlockHost string `yaml:"safebrowsing_block_host"`
@@ -92,6 +121,22 @@ type FilteringConfig struct {
 	// when FastestAddr is true.
 	FastestTimeout timeutil.Duration `yaml:"fastest_timeout"`
 
+	// RecursiveResolution defines if the requests are resolved iteratively
+	// starting from the root servers instead of being forwarded to the
+	// upstream servers.  The responses are validated using DNSSEC in this
+	// mode.
+	RecursiveResolution bool `yaml:"recursive_resolution"`
+	// RootHints are the IP addresses of the root servers used for the
+	// recursive resolution.  The IANA root servers are used if it's empty.
+	RootHints []string `yaml:"root_hints"`
+	// DNSSECValidation defines if the responses from the upstream servers
+	// are validated locally using DNSSEC instead of trusting their AD bit.
+	// The responses are always validated when RecursiveResolution is true.
+	DNSSECValidation bool `yaml:"dnssec_validation"`
+	// DNSSECTrustAnchors are the DS records of the DNSSEC trust anchors in
+	// the presentation format.  The root zone keys are used if it's empty.
+	DNSSECTrustAnchors []string `yaml:"dnssec_trust_anchors"`
+
 	// Access settings
 	// --
 
@@ -104,6 +149,20 @@ type FilteringConfig struct {
 	// Proxy not trust any address.
 	TrustedProxies []string `yaml:"trusted_proxies"`
 
+	// Answer policies
+	// --
+
+	// RebindingProtection defines if the responses to the requests for the
+	// public domain names are blocked when they contain addresses from the
+	// private networks.
+	RebindingProtection bool `yaml:"rebinding_protection"`
+	// RebindingAllowedDomains are the domain names exempt from the DNS
+	// rebinding protection along with their subdomains.
+	RebindingAllowedDomains []string `yaml:"rebinding_allowed_domains"`
+	// AnswerPolicies are the policies blocking the responses by the
+	// addresses and the canonical names in their answers.
+	AnswerPolicies []*AnswerPolicyConfig `yaml:"answer_policies"`
+
 	// DNS cache settings
 	// --
 
@@ -112,6 +171,19 @@ type FilteringConfig struct {
 	CacheMaxTTL uint32 `yaml:"cache_ttl_max"` // override TTL value (maximum) received from upstream server
 	// CacheOptimistic defines if optimistic cache mechanism should be used.
 	CacheOptimistic bool `yaml:"cache_optimistic"`
+	// CacheServeStale defines if the expired responses are served when the
+	// upstream servers fail to respond, see RFC 8767.
+	CacheServeStale bool `yaml:"cache_serve_stale"`
+	// CacheStaleTTL is the TTL of the stale responses in seconds.  If 0,
+	// the default value of 30 seconds is used.
+	CacheStaleTTL uint32 `yaml:"cache_stale_ttl"`
+	// CacheMaxStale is the maximum time the expired responses are served
+	// for.  If 0, the default value of one day is used.
+	CacheMaxStale timeutil.Duration `yaml:"cache_max_stale"`
+	// CachePrefetchHits is the number of requests after which a cached
+	// response is refreshed shortly before it expires.  If 0, the
+	// prefetching is disabled.
+	CachePrefetchHits uint32 `yaml:"cache_prefetch_hits"`
 
 	// Other settings
 	// --
@@ -202,6 +274,11 @@ type ServerConfig struct {
 	// LocalPTRResolvers is a slice of addresses to be used as upstreams for
 	// resolving PTR queries for local addresses.
 	LocalPTRResolvers []string
+
+	// BlockPage is the block page server the blocked requests are answered
+	// with instead of the blocking mode.  It's nil if the block page is
+	// disabled.
+	BlockPage BlockPage
 }
 
 // if any of ServerConfig values are zero, then default values from below are used
@@ -222,7 +299,6 @@ func (s *Server) createProxyConfig() (proxy.Config, error) {
 		TrustedProxies:         s.conf.TrustedProxies,
 		CacheMinTTL:            s.conf.CacheMinTTL,
 		CacheMaxTTL:            s.conf.CacheMaxTTL,
-		CacheOptimistic:        s.conf.CacheOptimistic,
 		UpstreamConfig:         s.conf.UpstreamConfig,
 		BeforeRequestHandler:   s.beforeRequestHandler,
 		RequestHandler:         s.handleDNSRequest,
@@ -230,11 +306,6 @@ func (s *Server) createProxyConfig() (proxy.Config, error) {
 		MaxGoroutines:          int(s.conf.MaxGoroutines),
 	}
 
-	if s.conf.CacheSize != 0 {
-		proxyConfig.CacheEnabled = true
-		proxyConfig.CacheSizeBytes = int(s.conf.CacheSize)
-	}
-
 	proxyConfig.UpstreamMode = proxy.UModeLoadBalance
 	if s.conf.AllServers {
 		proxyConfig.UpstreamMode = proxy.UModeParallel
@@ -368,6 +439,19 @@ func (s *Server) prepareUpstreamSettings() error {
 		upstreamConfig.Upstreams = uc.Upstreams
 	}
 
+	s.dnssec = nil
+	if s.conf.RecursiveResolution {
+		err = s.prepareRecursor(upstreamConfig)
+		if err != nil {
+			return fmt.Errorf("dns: preparing recursive resolution: %w", err)
+		}
+	} else if s.conf.DNSSECValidation {
+		err = s.prepareDNSSEC(upstreamConfig)
+		if err != nil {
+			return fmt.Errorf("dns: preparing dnssec validation: %w", err)
+		}
+	}
+
 	s.conf.UpstreamConfig = upstreamConfig
 
 	return nil
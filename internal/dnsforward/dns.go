@@ -56,6 +56,10 @@ type dnsContext struct {
 	// responseAD shows if the response had the AD bit set.
 	responseAD bool
 
+	// dnssecStatus is the result of the local DNSSEC validation of the
+	// response, if any.
+	dnssecStatus dnssecStatus
+
 	// isLocalClient shows if client's IP address is from locally-served
 	// network.
 	isLocalClient bool
@@ -543,6 +547,47 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 		}
 	}
 
+	c := s.respCache()
+	useCache := c != nil && pctx.CustomUpstreamConfig == nil && !s.isCacheBypassed(dctx)
+
+	var key string
+	var stale *cacheItem
+	if useCache {
+		key = cacheKey(pctx, s.conf.EnableEDNSClientSubnet)
+
+		var ok bool
+		ok, stale = s.replyFromCache(dctx, c, key)
+		if ok {
+			return resultCodeSuccess
+		}
+	}
+
+	err := s.exchangeUpstream(dctx)
+	if err != nil {
+		if stale != nil {
+			s.replyStale(dctx, c, stale, err)
+
+			return resultCodeSuccess
+		}
+
+		dctx.err = err
+
+		return resultCodeError
+	}
+
+	if useCache {
+		if ci := c.newItem(dctx, key); ci != nil {
+			c.set(ci)
+		}
+	}
+
+	return resultCodeSuccess
+}
+
+// exchangeUpstream passes the request in dctx to the upstream servers and
+// validates the response, if needed.
+func (s *Server) exchangeUpstream(dctx *dnsContext) (err error) {
+	pctx := dctx.proxyCtx
 	req := pctx.Req
 	origReqAD := false
 	if s.conf.EnableDNSSEC {
@@ -556,13 +601,28 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 	// Process the request further since it wasn't filtered.
 	prx := s.proxy()
 	if prx == nil {
-		dctx.err = srvClosedErr
+		pctx.Res = s.genServerFailure(req)
+		s.setFailureEDE(req, pctx.Res, dns.ExtendedErrorCodeNotReady, srvClosedErr.Error())
 
-		return resultCodeError
+		return srvClosedErr
 	}
 
-	if dctx.err = prx.Resolve(pctx); dctx.err != nil {
-		return resultCodeError
+	// Request the DNSSEC records to validate the response locally.
+	var reqSt dnssecReqState
+	if s.dnssec != nil {
+		reqSt = requestDNSSEC(req)
+	}
+
+	if err = prx.Resolve(pctx); err != nil {
+		if pctx.Res != nil {
+			s.setFailureEDE(req, pctx.Res, dns.ExtendedErrorCodeNetworkError, err.Error())
+		}
+
+		return err
+	}
+
+	if s.dnssec != nil {
+		s.validateResponse(dctx, reqSt)
 	}
 
 	dctx.responseFromUpstream = true
@@ -573,7 +633,7 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 		pctx.Res.AuthenticatedData = false
 	}
 
-	return resultCodeSuccess
+	return nil
 }
 
 // Apply filtering logic after we have received response from upstream servers
@@ -602,7 +662,7 @@ func (s *Server) processFilteringAfterResponse(ctx *dnsContext) (rc resultCode)
 		// Check the response only if the it's from an upstream.  Don't check
 		// the response if the protection is disabled since dnsrewrite rules
 		// aren't applied to it anyway.
-		if !ctx.protectionEnabled || !ctx.responseFromUpstream || s.dnsFilter == nil {
+		if !ctx.protectionEnabled || !ctx.responseFromUpstream {
 			break
 		}
 
@@ -614,6 +674,13 @@ func (s *Server) processFilteringAfterResponse(ctx *dnsContext) (rc resultCode)
 			return resultCodeError
 		}
 
+		if result == nil {
+			result = s.checkAnswerPolicies(ctx)
+			if result != nil {
+				d.Res = s.genDNSFilterMessage(d, result)
+			}
+		}
+
 		if result != nil {
 			ctx.result = result
 			ctx.origResp = origResp
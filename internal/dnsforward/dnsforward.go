@@ -69,6 +69,10 @@ type Server struct {
 	stats      stats.Stats
 	access     *accessCtx
 
+	// answerPolicies are the policies blocking the responses by their
+	// answers, in the order they're checked.
+	answerPolicies []*answerPolicy
+
 	// localDomainSuffix is the suffix used to detect internal hosts.  It
 	// must be a valid domain name plus dots on each side.
 	localDomainSuffix string
@@ -82,6 +86,14 @@ type Server struct {
 	// anonymizer masks the client's IP addresses if needed.
 	anonymizer *aghnet.IPMut
 
+	// dnssec validates the responses locally.  It's nil if the local
+	// validation is disabled.
+	dnssec *dnssecValidator
+
+	// cache is the cache of the responses from the upstream servers.  It's
+	// nil if the cache is disabled.
+	cache *dnsCache
+
 	tableHostToIP     hostToIPTable
 	tableHostToIPLock sync.Mutex
 
@@ -238,6 +250,11 @@ func (s *Server) WriteDiskConfig(c *FilteringConfig) {
 	c.BlockedHosts = stringutil.CloneSlice(sc.BlockedHosts)
 	c.TrustedProxies = stringutil.CloneSlice(sc.TrustedProxies)
 	c.UpstreamDNS = stringutil.CloneSlice(sc.UpstreamDNS)
+	c.RootHints = stringutil.CloneSlice(sc.RootHints)
+	c.DNSSECTrustAnchors = stringutil.CloneSlice(sc.DNSSECTrustAnchors)
+	c.ExtendedErrorsModes = append([]BlockingMode(nil), sc.ExtendedErrorsModes...)
+	c.RebindingAllowedDomains = stringutil.CloneSlice(sc.RebindingAllowedDomains)
+	c.AnswerPolicies = append([]*AnswerPolicyConfig(nil), sc.AnswerPolicies...)
 }
 
 // RDNSSettings returns the copy of actual RDNS configuration.
@@ -470,6 +487,12 @@ func (s *Server) Prepare(config *ServerConfig) error {
 				return fmt.Errorf("dns: invalid custom blocking IP address specified")
 			}
 		}
+
+		for _, m := range s.conf.ExtendedErrorsModes {
+			if !isValidBlockingMode(m) {
+				return fmt.Errorf("dns: invalid extended errors blocking mode %q", m)
+			}
+		}
 	}
 
 	// Set default values in the case if nothing is configured
@@ -492,6 +515,10 @@ func (s *Server) Prepare(config *ServerConfig) error {
 		return err
 	}
 
+	// Prepare the cache of the responses
+	// --
+	s.prepareCache()
+
 	// Create DNS proxy configuration
 	// --
 	var proxyConfig proxy.Config
@@ -509,6 +536,15 @@ func (s *Server) Prepare(config *ServerConfig) error {
 		return err
 	}
 
+	s.answerPolicies, err = newAnswerPolicies(
+		s.conf.RebindingProtection,
+		s.conf.RebindingAllowedDomains,
+		s.conf.AnswerPolicies,
+	)
+	if err != nil {
+		return fmt.Errorf("dns: %w", err)
+	}
+
 	// Register web handlers if necessary
 	// --
 	if !webRegistered && s.conf.HTTPRegister != nil {
@@ -493,6 +493,64 @@ func TestSafeSearch(t *testing.T) {
 	}
 }
 
+func TestServer_genSafeSearchResponse(t *testing.T) {
+	s := &Server{
+		conf: ServerConfig{
+			FilteringConfig: FilteringConfig{
+				BlockedResponseTTL: 3600,
+			},
+		},
+	}
+
+	ip4 := net.IP{1, 2, 3, 4}
+	ip6 := net.ParseIP("2001:db8::1")
+	ips := []net.IP{ip4, ip6}
+
+	newReq := func(qtype uint16) (req *dns.Msg) {
+		return (&dns.Msg{}).SetQuestion("www.google.com.", qtype)
+	}
+
+	t.Run("a", func(t *testing.T) {
+		resp := s.genSafeSearchResponse(newReq(dns.TypeA), ips)
+		require.Len(t, resp.Answer, 1)
+
+		a, ok := resp.Answer[0].(*dns.A)
+		require.True(t, ok)
+
+		assert.Equal(t, ip4, a.A)
+	})
+
+	t.Run("aaaa", func(t *testing.T) {
+		resp := s.genSafeSearchResponse(newReq(dns.TypeAAAA), ips)
+		require.Len(t, resp.Answer, 1)
+
+		aaaa, ok := resp.Answer[0].(*dns.AAAA)
+		require.True(t, ok)
+
+		assert.Equal(t, ip6, aaaa.AAAA)
+	})
+
+	t.Run("aaaa_no_ipv6", func(t *testing.T) {
+		resp := s.genSafeSearchResponse(newReq(dns.TypeAAAA), []net.IP{ip4})
+
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Empty(t, resp.Answer)
+	})
+
+	t.Run("https", func(t *testing.T) {
+		resp := s.genSafeSearchResponse(newReq(dns.TypeHTTPS), ips)
+		require.Len(t, resp.Answer, 1)
+
+		https, ok := resp.Answer[0].(*dns.HTTPS)
+		require.True(t, ok)
+
+		assert.Equal(t, []dns.SVCBKeyValue{
+			&dns.SVCBIPv4Hint{Hint: []net.IP{ip4}},
+			&dns.SVCBIPv6Hint{Hint: []net.IP{ip6}},
+		}, https.Value)
+	})
+}
+
 func TestInvalidRequest(t *testing.T) {
 	s := createTestServer(t, &filtering.Config{}, ServerConfig{
 		UDPListenAddrs: []*net.UDPAddr{{}},
@@ -542,6 +600,156 @@ func TestBlockedRequest(t *testing.T) {
 	assert.True(t, reply.Answer[0].(*dns.A).A.IsUnspecified())
 }
 
+// extendedError returns the first extended DNS error option of resp, if any.
+func extendedError(resp *dns.Msg) (ede *dns.EDNS0_EDE) {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+
+	for _, o := range opt.Option {
+		if e, ok := o.(*dns.EDNS0_EDE); ok {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func TestServer_genDNSFilterMessage_extendedErrors(t *testing.T) {
+	s := createTestServer(t, &filtering.Config{}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		FilteringConfig: FilteringConfig{
+			ProtectionEnabled:   true,
+			ExtendedErrors:      true,
+			ExtendedErrorsModes: []BlockingMode{BlockingModeNXDOMAIN, BlockingModeREFUSED},
+		},
+	}, nil)
+
+	blockList := &filtering.Result{
+		IsFiltered: true,
+		Reason:     filtering.FilteredBlockList,
+		Rules: []*filtering.ResultRule{{
+			Text:         "||example.org^",
+			FilterListID: 42,
+		}},
+	}
+	service := &filtering.Result{
+		IsFiltered:  true,
+		Reason:      filtering.FilteredBlockedService,
+		ServiceName: "youtube",
+	}
+
+	testCases := []struct {
+		res       *filtering.Result
+		name      string
+		mode      BlockingMode
+		wantText  string
+		wantRcode int
+		wantCode  uint16
+		noEDNS    bool
+	}{{
+		res:       blockList,
+		name:      "nxdomain",
+		mode:      BlockingModeNXDOMAIN,
+		wantText:  `blocked: rule "||example.org^" from filter list 42`,
+		wantRcode: dns.RcodeNameError,
+		wantCode:  dns.ExtendedErrorCodeBlocked,
+		noEDNS:    false,
+	}, {
+		res:       service,
+		name:      "refused_service",
+		mode:      BlockingModeREFUSED,
+		wantText:  "blocked service youtube",
+		wantRcode: dns.RcodeRefused,
+		wantCode:  dns.ExtendedErrorCodeBlocked,
+		noEDNS:    false,
+	}, {
+		res:       blockList,
+		name:      "mode_disabled",
+		mode:      BlockingModeNullIP,
+		wantText:  "",
+		wantRcode: dns.RcodeSuccess,
+		wantCode:  0,
+		noEDNS:    false,
+	}, {
+		res:       blockList,
+		name:      "no_edns",
+		mode:      BlockingModeNXDOMAIN,
+		wantText:  "",
+		wantRcode: dns.RcodeNameError,
+		wantCode:  0,
+		noEDNS:    true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s.conf.BlockingMode = tc.mode
+
+			req := createTestMessage("example.org.")
+			if !tc.noEDNS {
+				req.SetEdns0(dns.DefaultMsgSize, false)
+			}
+
+			resp := s.genDNSFilterMessage(&proxy.DNSContext{Req: req}, tc.res)
+			require.NotNil(t, resp)
+
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+
+			ede := extendedError(resp)
+			if tc.wantCode == 0 {
+				assert.Nil(t, ede)
+
+				return
+			}
+
+			require.NotNil(t, ede)
+
+			assert.Equal(t, tc.wantCode, ede.InfoCode)
+			assert.Equal(t, tc.wantText, ede.ExtraText)
+		})
+	}
+}
+
+func TestServer_processUpstream_extendedErrors(t *testing.T) {
+	s := createTestServer(t, &filtering.Config{}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		FilteringConfig: FilteringConfig{
+			ExtendedErrors: true,
+		},
+	}, nil)
+	s.conf.UpstreamConfig.Upstreams = []upstream.Upstream{&aghtest.TestErrUpstream{
+		Err: errors.Error("timeout"),
+	}}
+
+	req := createTestMessage("example.org.")
+	req.SetEdns0(dns.DefaultMsgSize, false)
+
+	dctx := &dnsContext{
+		proxyCtx: &proxy.DNSContext{
+			Proto: proxy.ProtoUDP,
+			Req:   req,
+			Addr:  &net.UDPAddr{IP: net.IP{192, 168, 0, 1}},
+		},
+	}
+
+	rc := s.processUpstream(dctx)
+	require.Equal(t, resultCodeError, rc)
+
+	resp := dctx.proxyCtx.Res
+	require.NotNil(t, resp)
+
+	assert.Equal(t, dns.RcodeServerFailure, resp.Rcode)
+
+	ede := extendedError(resp)
+	require.NotNil(t, ede)
+
+	assert.Equal(t, uint16(dns.ExtendedErrorCodeNetworkError), ede.InfoCode)
+	assert.Contains(t, ede.ExtraText, "timeout")
+}
+
 func TestServerCustomClientUpstream(t *testing.T) {
 	forwardConf := ServerConfig{
 		UDPListenAddrs: []*net.UDPAddr{{}},
@@ -0,0 +1,1288 @@
+package dnsforward
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/proxyutil"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// dnssecStatus is the result of the local DNSSEC validation of a response.
+// See RFC 4033, section 5.
+type dnssecStatus string
+
+// Allowed DNSSEC validation results.
+const (
+	// dnssecStatusNone means that the response hasn't been validated.
+	dnssecStatusNone dnssecStatus = ""
+
+	// dnssecStatusSecure means that there is a chain of trust from a trust
+	// anchor to every RRset in the response.
+	dnssecStatusSecure dnssecStatus = "secure"
+
+	// dnssecStatusInsecure means that the response is proven to come from
+	// an unsigned zone or is signed with unsupported algorithms.
+	dnssecStatusInsecure dnssecStatus = "insecure"
+
+	// dnssecStatusBogus means that the response should have been signed
+	// but the signatures are missing, expired, or don't match.
+	dnssecStatusBogus dnssecStatus = "bogus"
+)
+
+// worse returns the least secure status of st and other.
+func (st dnssecStatus) worse(other dnssecStatus) (res dnssecStatus) {
+	switch {
+	case st == dnssecStatusBogus, other == dnssecStatusBogus:
+		return dnssecStatusBogus
+	case st == dnssecStatusInsecure, other == dnssecStatusInsecure:
+		return dnssecStatusInsecure
+	default:
+		return st
+	}
+}
+
+// defaultTrustAnchors are the DS records of the root zone key signing keys,
+// KSK-2017 and KSK-2024.
+var defaultTrustAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+const (
+	// dnssecUDPBufSize is the UDP buffer size advertised in the requests for
+	// DNSSEC records.
+	dnssecUDPBufSize = 1232
+
+	// maxDNSSECDepth is the maximum number of zones the chain of trust is
+	// followed through.
+	maxDNSSECDepth = 16
+
+	// maxDNSSECZonesCount is the maximum number of zones the security state
+	// of which is cached.
+	maxDNSSECZonesCount = 4096
+
+	// dnssecZoneMaxTTL is the maximum time the security state of a zone is
+	// cached for.
+	dnssecZoneMaxTTL = 1 * time.Hour
+
+	// dnssecBogusZoneTTL is the time the bogus state of a zone is cached
+	// for.
+	dnssecBogusZoneTTL = 1 * time.Minute
+)
+
+// Validation errors.
+const (
+	// errNoRRSIG is returned when an RRset from a secure zone isn't signed.
+	errNoRRSIG errors.Error = "no signature"
+
+	// errExpiredRRSIG is returned when a signature is expired or not valid
+	// yet.
+	errExpiredRRSIG errors.Error = "signature is expired"
+
+	// errNoDNSKEY is returned when the keys of a secure zone are missing.
+	errNoDNSKEY errors.Error = "no dnskey"
+
+	// errNoDenial is returned when a negative response from a secure zone
+	// doesn't prove the nonexistence.
+	errNoDenial errors.Error = "no denial of existence"
+)
+
+// dnssecLookupFunc is the function requesting the DNSSEC records for name and
+// qtype.
+type dnssecLookupFunc func(name string, qtype uint16) (resp *dns.Msg, err error)
+
+// dnssecZone is the cached security state of a zone.
+type dnssecZone struct {
+	// expire is the time after which the state should be checked again.
+	expire time.Time
+
+	// err is the reason why the zone is bogus.
+	err error
+
+	// status is the security state of the zone.
+	status dnssecStatus
+
+	// keys are the validated zone keys.  It's only set for secure zones.
+	keys []*dns.DNSKEY
+}
+
+// dnssecValidator validates the responses by following the chains of trust
+// from the trust anchors.
+type dnssecValidator struct {
+	// lookup is used to request the DS and DNSKEY records and to find zones
+	// of the unsigned data.
+	lookup dnssecLookupFunc
+
+	// anchors are the trust anchors mapped by the canonical zone names.
+	anchors map[string][]*dns.DS
+
+	// zones is the cache of zones security states mapped by the canonical
+	// zone names.
+	zones map[string]*dnssecZone
+
+	// zonesLock protects zones.
+	zonesLock sync.Mutex
+}
+
+// newDNSSECValidator creates a new validator using lookup to request the
+// DNSSEC records.
+func newDNSSECValidator(lookup dnssecLookupFunc, anchors map[string][]*dns.DS) (v *dnssecValidator) {
+	return &dnssecValidator{
+		lookup:  lookup,
+		anchors: anchors,
+		zones:   map[string]*dnssecZone{},
+	}
+}
+
+// parseTrustAnchors parses the DS records in the presentation format.
+// defaultTrustAnchors are used if anchors are empty.
+func parseTrustAnchors(anchors []string) (ds map[string][]*dns.DS, err error) {
+	if len(anchors) == 0 {
+		anchors = defaultTrustAnchors
+	}
+
+	ds = map[string][]*dns.DS{}
+	for i, a := range anchors {
+		var rr dns.RR
+		rr, err = dns.NewRR(a)
+		if err != nil {
+			return nil, fmt.Errorf("trust anchor at index %d: %w", i, err)
+		}
+
+		d, ok := rr.(*dns.DS)
+		if !ok {
+			return nil, fmt.Errorf("trust anchor at index %d: not a ds record", i)
+		}
+
+		zone := canonicalName(d.Hdr.Name)
+		ds[zone] = append(ds[zone], d)
+	}
+
+	return ds, nil
+}
+
+// canonicalName returns the lowercased FQDN form of name.
+func canonicalName(name string) (canon string) {
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+// parentName returns the name of the parent domain of name.  The parent of
+// the root domain is the root domain.
+func parentName(name string) (parent string) {
+	i, end := dns.NextLabel(name, 0)
+	if end || i >= len(name) {
+		return "."
+	}
+
+	return name[i:]
+}
+
+// validate returns the security state of resp, which is the response to the
+// single question.  err describes the reason for the bogus state.  The
+// responses with other codes than NOERROR and NXDOMAIN aren't validated.
+func (v *dnssecValidator) validate(resp *dns.Msg) (st dnssecStatus, err error) {
+	if len(resp.Question) != 1 {
+		return dnssecStatusNone, nil
+	}
+
+	switch resp.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+		return v.validateMsg(resp, 0)
+	default:
+		return dnssecStatusNone, nil
+	}
+}
+
+// validateMsg returns the security state of the answer and, for negative
+// responses, of the authority sections of m.
+func (v *dnssecValidator) validateMsg(m *dns.Msg, depth int) (st dnssecStatus, err error) {
+	if depth > maxDNSSECDepth {
+		return dnssecStatusBogus, errors.Error("chain of trust is too long")
+	}
+
+	st = dnssecStatusSecure
+	var exps []*wildcardExpansion
+	for _, set := range splitRRsets(m.Answer) {
+		setSt, exp, setErr := v.validateRRset(set, depth)
+		if setSt == dnssecStatusBogus {
+			return dnssecStatusBogus, setErr
+		} else if exp != nil {
+			exps = append(exps, exp)
+		}
+
+		st = st.worse(setSt)
+	}
+
+	q := m.Question[0]
+	name, ok := followCNAMEs(m.Answer, q.Name, q.Qtype)
+	positive := ok && m.Rcode == dns.RcodeSuccess
+	if positive && len(exps) == 0 {
+		return st, nil
+	}
+
+	// The response is negative or contains the wildcard expansions, so check
+	// the denial of existence.
+	var hasSOA bool
+	var nsecs []dns.RR
+	for _, set := range splitRRsets(m.Ns) {
+		switch set.rrtype {
+		case dns.TypeSOA:
+			hasSOA = true
+		case dns.TypeNSEC, dns.TypeNSEC3:
+			nsecs = append(nsecs, set.rrs...)
+		default:
+			continue
+		}
+
+		setSt, exp, setErr := v.validateRRset(set, depth)
+		if setSt == dnssecStatusBogus {
+			return dnssecStatusBogus, setErr
+		} else if exp != nil {
+			return dnssecStatusBogus, fmt.Errorf("%s is expanded from a wildcard", exp.name)
+		}
+
+		st = st.worse(setSt)
+	}
+
+	if !positive && !hasSOA && len(nsecs) == 0 {
+		z := v.nameZone(name, depth)
+		switch z.status {
+		case dnssecStatusSecure:
+			return dnssecStatusBogus, fmt.Errorf("%w for %s", errNoDenial, name)
+		case dnssecStatusBogus:
+			return dnssecStatusBogus, z.err
+		default:
+			return dnssecStatusInsecure, nil
+		}
+	}
+
+	if st != dnssecStatusSecure {
+		return st, nil
+	}
+
+	for _, exp := range exps {
+		err = checkNoCloserMatch(nsecs, exp)
+		if err != nil {
+			return dnssecStatusBogus, err
+		}
+	}
+
+	if positive {
+		return dnssecStatusSecure, nil
+	}
+
+	return checkDenial(nsecs, name, q.Qtype, m.Rcode == dns.RcodeNameError)
+}
+
+// wildcardExpansion describes an RRset synthesized from a wildcard.  See RFC
+// 4035, section 5.3.4.
+type wildcardExpansion struct {
+	// name is the owner name of the expanded RRset.
+	name string
+
+	// closestEncloser is the parent of the wildcard the RRset is expanded
+	// from.
+	closestEncloser string
+}
+
+// validateRRset returns the security state of the RRset.  exp is not nil if
+// the RRset is secure and expanded from a wildcard, in which case the response
+// must also prove that there is no closer match.
+func (v *dnssecValidator) validateRRset(
+	set *rrset,
+	depth int,
+) (st dnssecStatus, exp *wildcardExpansion, err error) {
+	if len(set.rrs) == 0 {
+		// Signatures without the data, nothing to validate.
+		return dnssecStatusSecure, nil, nil
+	}
+
+	hdr := set.rrs[0].Header()
+	if len(set.sigs) == 0 {
+		st, err = v.unsignedState(hdr, depth)
+
+		return st, nil, err
+	}
+
+	supported := false
+	for _, sig := range set.sigs {
+		if !isSupportedDNSSECAlgorithm(sig.Algorithm) {
+			continue
+		}
+
+		supported = true
+		signer := canonicalName(sig.SignerName)
+		if !dns.IsSubDomain(signer, hdr.Name) {
+			err = fmt.Errorf("signer %s is not a parent of %s", signer, hdr.Name)
+
+			continue
+		}
+
+		z := v.zoneKeys(signer, depth+1)
+		switch z.status {
+		case dnssecStatusInsecure:
+			return dnssecStatusInsecure, nil, nil
+		case dnssecStatusBogus:
+			err = z.err
+
+			continue
+		default:
+			// Go on.
+		}
+
+		err = verifyRRSIG(sig, z.keys, set.rrs)
+		if err == nil {
+			return dnssecStatusSecure, expansionOf(sig, hdr.Name), nil
+		}
+	}
+
+	if !supported {
+		// The signatures with unsupported algorithms are only acceptable
+		// from the zones without any supported ones, see RFC 4035, section
+		// 5.2.  Otherwise the real signatures could be replaced with them to
+		// make the response look insecure.
+		st, err = v.unsignedState(hdr, depth)
+
+		return st, nil, err
+	}
+
+	return dnssecStatusBogus, nil, err
+}
+
+// unsignedState returns the security state of the RRset with hdr which has no
+// signatures that could be verified.  It's bogus if the zone containing the
+// RRset is secure.
+func (v *dnssecValidator) unsignedState(hdr *dns.RR_Header, depth int) (st dnssecStatus, err error) {
+	z := v.nameZone(hdr.Name, depth)
+	switch z.status {
+	case dnssecStatusSecure:
+		return dnssecStatusBogus, fmt.Errorf("%w for %s %s", errNoRRSIG, hdr.Name, dns.Type(hdr.Rrtype))
+	case dnssecStatusBogus:
+		return dnssecStatusBogus, z.err
+	default:
+		return dnssecStatusInsecure, nil
+	}
+}
+
+// expansionOf returns the wildcard expansion of the RRset with owner name
+// signed by sig.  exp is nil if the RRset isn't expanded from a wildcard.
+func expansionOf(sig *dns.RRSIG, name string) (exp *wildcardExpansion) {
+	if int(sig.Labels) >= labelCount(name) {
+		return nil
+	}
+
+	return &wildcardExpansion{
+		name:            canonicalName(name),
+		closestEncloser: ancestorName(name, int(sig.Labels)),
+	}
+}
+
+// labelCount returns the number of labels in name not counting the root and
+// the leading wildcard labels.  See RFC 4034, section 3.1.3.
+func labelCount(name string) (n int) {
+	labels := dns.SplitDomainName(name)
+	if len(labels) > 0 && labels[0] == "*" {
+		return len(labels) - 1
+	}
+
+	return len(labels)
+}
+
+// ancestorName returns the canonical name made of the last n labels of name.
+func ancestorName(name string, n int) (ancestor string) {
+	labels := dns.SplitDomainName(canonicalName(name))
+	if n <= 0 {
+		return "."
+	} else if n < len(labels) {
+		labels = labels[len(labels)-n:]
+	}
+
+	return strings.Join(labels, ".") + "."
+}
+
+// wildcardName returns the name of the wildcard with the closest encloser ce.
+func wildcardName(ce string) (name string) {
+	if ce == "." {
+		return "*."
+	}
+
+	return "*." + ce
+}
+
+// nameZone returns the security state of the zone containing name.
+func (v *dnssecValidator) nameZone(name string, depth int) (z *dnssecZone) {
+	apex, err := v.zoneOf(name)
+	if err != nil {
+		return &dnssecZone{
+			err:    fmt.Errorf("looking up zone of %s: %w", name, err),
+			status: dnssecStatusBogus,
+		}
+	}
+
+	return v.zoneKeys(apex, depth+1)
+}
+
+// zoneOf returns the canonical name of the zone containing name.
+func (v *dnssecValidator) zoneOf(name string) (apex string, err error) {
+	name = canonicalName(name)
+	for {
+		var resp *dns.Msg
+		resp, err = v.lookup(name, dns.TypeSOA)
+		if err != nil {
+			return "", err
+		}
+
+		for _, rr := range append(resp.Answer, resp.Ns...) {
+			soa, ok := rr.(*dns.SOA)
+			if ok && dns.IsSubDomain(soa.Hdr.Name, name) {
+				return canonicalName(soa.Hdr.Name), nil
+			}
+		}
+
+		if name == "." {
+			return ".", nil
+		}
+
+		name = parentName(name)
+	}
+}
+
+// zoneKeys returns the security state of the zone, using the cached one if
+// it's still valid.
+func (v *dnssecValidator) zoneKeys(zone string, depth int) (z *dnssecZone) {
+	zone = canonicalName(zone)
+	now := time.Now()
+
+	v.zonesLock.Lock()
+	z, ok := v.zones[zone]
+	v.zonesLock.Unlock()
+
+	if ok && now.Before(z.expire) {
+		return z
+	}
+
+	z = v.fetchZoneKeys(zone, depth)
+	if z.status == dnssecStatusBogus {
+		log.Debug("dnssec: zone %s is bogus: %s", zone, z.err)
+		z.expire = now.Add(dnssecBogusZoneTTL)
+	}
+
+	v.zonesLock.Lock()
+	defer v.zonesLock.Unlock()
+
+	if len(v.zones) >= maxDNSSECZonesCount {
+		v.zones = map[string]*dnssecZone{}
+	}
+	v.zones[zone] = z
+
+	return z
+}
+
+// fetchZoneKeys requests the DS records of the zone from its parent unless
+// it's a trust anchor and the DNSKEY records of the zone.
+func (v *dnssecValidator) fetchZoneKeys(zone string, depth int) (z *dnssecZone) {
+	if depth > maxDNSSECDepth {
+		return &dnssecZone{
+			err:    errors.Error("chain of trust is too long"),
+			status: dnssecStatusBogus,
+		}
+	}
+
+	ds, ok := v.anchors[zone]
+	if !ok {
+		if zone == "." {
+			// No trust anchor for the root zone, so nothing is secure.
+			return &dnssecZone{
+				expire: time.Now().Add(dnssecZoneMaxTTL),
+				status: dnssecStatusInsecure,
+			}
+		}
+
+		ds, z = v.fetchDS(zone, depth)
+		if z != nil {
+			return z
+		}
+	}
+
+	return v.fetchDNSKEY(zone, ds)
+}
+
+// fetchDS requests the DS records of the zone.  z is not nil if the zone is
+// found to be insecure or bogus.
+func (v *dnssecValidator) fetchDS(zone string, depth int) (ds []*dns.DS, z *dnssecZone) {
+	resp, err := v.lookup(zone, dns.TypeDS)
+	if err != nil {
+		return nil, &dnssecZone{
+			err:    fmt.Errorf("looking up ds of %s: %w", zone, err),
+			status: dnssecStatusBogus,
+		}
+	}
+
+	switch resp.Rcode {
+	case dns.RcodeSuccess:
+		// Go on.
+	case dns.RcodeNameError:
+		return nil, &dnssecZone{
+			err:    fmt.Errorf("zone %s does not exist", zone),
+			status: dnssecStatusBogus,
+		}
+	default:
+		return nil, &dnssecZone{
+			err:    fmt.Errorf("looking up ds of %s: got %s", zone, dns.RcodeToString[resp.Rcode]),
+			status: dnssecStatusBogus,
+		}
+	}
+
+	st, err := v.validateMsg(resp, depth+1)
+	switch st {
+	case dnssecStatusBogus:
+		return nil, &dnssecZone{err: err, status: st}
+	case dnssecStatusInsecure:
+		return nil, &dnssecZone{
+			expire: time.Now().Add(rrsTTL(resp.Ns)),
+			status: st,
+		}
+	default:
+		// Go on.
+	}
+
+	for _, rr := range resp.Answer {
+		if d, isDS := rr.(*dns.DS); isDS && canonicalName(d.Hdr.Name) == zone {
+			ds = append(ds, d)
+		}
+	}
+
+	if len(ds) > 0 {
+		return ds, nil
+	}
+
+	// The absence of DS is proven, make sure that it's a delegation.
+	if !isDelegationDenial(resp.Ns, zone) {
+		return nil, &dnssecZone{
+			err:    fmt.Errorf("%s is not a zone cut", zone),
+			status: dnssecStatusBogus,
+		}
+	}
+
+	return nil, &dnssecZone{
+		expire: time.Now().Add(rrsTTL(resp.Ns)),
+		status: dnssecStatusInsecure,
+	}
+}
+
+// fetchDNSKEY requests the DNSKEY records of the zone and authenticates them
+// using ds.
+func (v *dnssecValidator) fetchDNSKEY(zone string, ds []*dns.DS) (z *dnssecZone) {
+	var supported []*dns.DS
+	for _, d := range ds {
+		if isSupportedDNSSECAlgorithm(d.Algorithm) && isSupportedDigest(d.DigestType) {
+			supported = append(supported, d)
+		}
+	}
+
+	if len(supported) == 0 {
+		// See RFC 4035, section 5.2.
+		return &dnssecZone{
+			expire: time.Now().Add(dnssecZoneMaxTTL),
+			status: dnssecStatusInsecure,
+		}
+	}
+
+	resp, err := v.lookup(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return &dnssecZone{
+			err:    fmt.Errorf("looking up dnskey of %s: %w", zone, err),
+			status: dnssecStatusBogus,
+		}
+	}
+
+	var keys []*dns.DNSKEY
+	var rrs []dns.RR
+	var sigs []*dns.RRSIG
+	for _, rr := range resp.Answer {
+		if canonicalName(rr.Header().Name) != zone {
+			continue
+		}
+
+		switch rr := rr.(type) {
+		case *dns.DNSKEY:
+			keys = append(keys, rr)
+			rrs = append(rrs, rr)
+		case *dns.RRSIG:
+			if rr.TypeCovered == dns.TypeDNSKEY {
+				sigs = append(sigs, rr)
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return &dnssecZone{
+			err:    fmt.Errorf("%w for %s", errNoDNSKEY, zone),
+			status: dnssecStatusBogus,
+		}
+	}
+
+	for _, d := range supported {
+		ksk := matchDS(d, keys)
+		if ksk == nil {
+			continue
+		}
+
+		for _, sig := range sigs {
+			if verifyRRSIG(sig, []*dns.DNSKEY{ksk}, rrs) == nil {
+				return &dnssecZone{
+					expire: time.Now().Add(rrsTTL(rrs)),
+					status: dnssecStatusSecure,
+					keys:   keys,
+				}
+			}
+		}
+	}
+
+	return &dnssecZone{
+		err:    fmt.Errorf("no valid signature over dnskey of %s", zone),
+		status: dnssecStatusBogus,
+	}
+}
+
+// matchDS returns the zone key from keys which d refers to, if any.
+func matchDS(d *dns.DS, keys []*dns.DNSKEY) (k *dns.DNSKEY) {
+	for _, k = range keys {
+		if k.Flags&dns.ZONE == 0 || k.Algorithm != d.Algorithm || k.KeyTag() != d.KeyTag {
+			continue
+		}
+
+		if kds := k.ToDS(d.DigestType); kds != nil && strings.EqualFold(kds.Digest, d.Digest) {
+			return k
+		}
+	}
+
+	return nil
+}
+
+// verifyRRSIG returns an error if sig is not a currently valid signature of
+// rrs made with one of keys.
+func verifyRRSIG(sig *dns.RRSIG, keys []*dns.DNSKEY, rrs []dns.RR) (err error) {
+	hdr := rrs[0].Header()
+	if !sig.ValidityPeriod(time.Now()) {
+		return fmt.Errorf("%s %s: %w", hdr.Name, dns.Type(hdr.Rrtype), errExpiredRRSIG)
+	} else if int(sig.Labels) > labelCount(hdr.Name) {
+		// See RFC 4035, section 5.3.1.
+		return fmt.Errorf("%s %s: too many labels in signature", hdr.Name, dns.Type(hdr.Rrtype))
+	}
+
+	for _, k := range keys {
+		if k.Flags&dns.ZONE == 0 || k.Algorithm != sig.Algorithm || k.KeyTag() != sig.KeyTag {
+			continue
+		}
+
+		if err = sig.Verify(k, rrs); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no valid signature of %s %s", hdr.Name, dns.Type(hdr.Rrtype))
+}
+
+// isSupportedDNSSECAlgorithm returns true if the signatures made with alg can
+// be verified.
+func isSupportedDNSSECAlgorithm(alg uint8) (ok bool) {
+	switch alg {
+	case
+		dns.RSASHA1,
+		dns.RSASHA1NSEC3SHA1,
+		dns.RSASHA256,
+		dns.RSASHA512,
+		dns.ECDSAP256SHA256,
+		dns.ECDSAP384SHA384,
+		dns.ED25519:
+		return true
+	default:
+		return false
+	}
+}
+
+// isSupportedDigest returns true if the DS digests of type t can be computed.
+func isSupportedDigest(t uint8) (ok bool) {
+	return t == dns.SHA1 || t == dns.SHA256 || t == dns.SHA384
+}
+
+// rrsTTL returns the minimum TTL of rrs limited by dnssecZoneMaxTTL.
+func rrsTTL(rrs []dns.RR) (ttl time.Duration) {
+	ttl = dnssecZoneMaxTTL
+	for _, rr := range rrs {
+		if rrTTL := time.Duration(rr.Header().Ttl) * time.Second; rrTTL < ttl {
+			ttl = rrTTL
+		}
+	}
+
+	return ttl
+}
+
+// rrset is a set of resource records with the same owner name and type along
+// with their signatures.
+type rrset struct {
+	rrs    []dns.RR
+	sigs   []*dns.RRSIG
+	rrtype uint16
+}
+
+// splitRRsets groups rrs into RRsets.  The OPT records are skipped.
+func splitRRsets(rrs []dns.RR) (sets []*rrset) {
+	type rrsetKey struct {
+		name   string
+		rrtype uint16
+	}
+
+	idx := map[rrsetKey]*rrset{}
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		k := rrsetKey{name: canonicalName(hdr.Name), rrtype: hdr.Rrtype}
+		sig, isSig := rr.(*dns.RRSIG)
+		if isSig {
+			k.rrtype = sig.TypeCovered
+		} else if hdr.Rrtype == dns.TypeOPT {
+			continue
+		}
+
+		set, ok := idx[k]
+		if !ok {
+			set = &rrset{rrtype: k.rrtype}
+			idx[k] = set
+			sets = append(sets, set)
+		}
+
+		if isSig {
+			set.sigs = append(set.sigs, sig)
+		} else {
+			set.rrs = append(set.rrs, rr)
+		}
+	}
+
+	return sets
+}
+
+// followCNAMEs follows the chain of CNAME records in ans starting from name.
+// final is the last name in the chain, ok is true if ans contains the records
+// of qtype for it.
+func followCNAMEs(ans []dns.RR, name string, qtype uint16) (final string, ok bool) {
+	final = canonicalName(name)
+	for i := 0; i <= len(ans); i++ {
+		var next string
+		for _, rr := range ans {
+			hdr := rr.Header()
+			if canonicalName(hdr.Name) != final {
+				continue
+			}
+
+			if hdr.Rrtype == qtype {
+				return final, true
+			} else if cname, isCNAME := rr.(*dns.CNAME); isCNAME {
+				next = canonicalName(cname.Target)
+			}
+		}
+
+		if next == "" {
+			break
+		}
+
+		final = next
+	}
+
+	return final, false
+}
+
+// checkDenial returns the security state of the denial of existence of name,
+// if nxdomain is true, or of its qtype records otherwise, proven by nsecs.
+// The denial is insecure if it relies on an opt-out NSEC3 record.  See RFC
+// 4035, section 5.4, and RFC 5155, section 8.
+func checkDenial(
+	nsecs []dns.RR,
+	name string,
+	qtype uint16,
+	nxdomain bool,
+) (st dnssecStatus, err error) {
+	name = canonicalName(name)
+	nsec, nsec3 := splitNSECs(nsecs)
+	if len(nsec) > 0 {
+		err = checkNSECDenial(nsec, name, qtype, nxdomain)
+		if err != nil {
+			return dnssecStatusBogus, err
+		}
+
+		return dnssecStatusSecure, nil
+	}
+
+	return checkNSEC3Denial(nsec3, name, qtype, nxdomain)
+}
+
+// splitNSECs returns the NSEC and the NSEC3 records from rrs.  The NSEC3
+// records with unsupported hash algorithms are skipped.
+func splitNSECs(rrs []dns.RR) (nsecs []*dns.NSEC, nsec3s []*dns.NSEC3) {
+	for _, rr := range rrs {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			nsecs = append(nsecs, rr)
+		case *dns.NSEC3:
+			if rr.Hash == dns.SHA1 {
+				nsec3s = append(nsec3s, rr)
+			}
+		}
+	}
+
+	return nsecs, nsec3s
+}
+
+// hasData returns true if the NSEC type bitmap shows that there are records
+// answering the question of qtype.
+func hasData(bitmap []uint16, qtype uint16) (ok bool) {
+	return hasType(bitmap, qtype) || hasType(bitmap, dns.TypeCNAME)
+}
+
+// checkNSECDenial returns an error if nsecs don't prove the nonexistence of
+// name, if nxdomain is true, or of its qtype records otherwise.  See RFC 4035,
+// section 5.4.
+func checkNSECDenial(nsecs []*dns.NSEC, name string, qtype uint16, nxdomain bool) (err error) {
+	if !nxdomain {
+		for _, n := range nsecs {
+			if canonicalName(n.Hdr.Name) == name && !hasData(n.TypeBitMap, qtype) {
+				return nil
+			}
+		}
+	}
+
+	cover := findNSECCover(nsecs, name)
+	if cover == nil {
+		return fmt.Errorf("%w for %s %s", errNoDenial, name, dns.Type(qtype))
+	}
+
+	next := canonicalName(cover.NextDomain)
+	if !nxdomain && next != name && dns.IsSubDomain(name, next) {
+		// name is an empty non-terminal.
+		return nil
+	}
+
+	wildcard := wildcardName(nsecClosestEncloser(cover, name))
+	if nxdomain {
+		if findNSECCover(nsecs, wildcard) == nil {
+			return fmt.Errorf("%w for wildcard %s", errNoDenial, wildcard)
+		}
+
+		return nil
+	}
+
+	// The answer may only be a NODATA from the wildcard.
+	for _, n := range nsecs {
+		if canonicalName(n.Hdr.Name) == wildcard && !hasData(n.TypeBitMap, qtype) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w for %s %s", errNoDenial, name, dns.Type(qtype))
+}
+
+// findNSECCover returns the record from nsecs covering name, if any.
+func findNSECCover(nsecs []*dns.NSEC, name string) (n *dns.NSEC) {
+	for _, n = range nsecs {
+		if nsecCovers(n, name) {
+			return n
+		}
+	}
+
+	return nil
+}
+
+// nsecClosestEncloser returns the closest encloser of name proven by the NSEC
+// record n covering it, which is the longest common ancestor of name and
+// either the owner or the next domain name of n.
+func nsecClosestEncloser(n *dns.NSEC, name string) (ce string) {
+	common := dns.CompareDomainName(name, n.Hdr.Name)
+	if c := dns.CompareDomainName(name, n.NextDomain); c > common {
+		common = c
+	}
+
+	return ancestorName(name, common)
+}
+
+// checkNSEC3Denial returns the security state of the denial of existence of
+// name, if nxdomain is true, or of its qtype records otherwise, proven by
+// nsec3s.  See RFC 5155, section 8.
+func checkNSEC3Denial(
+	nsec3s []*dns.NSEC3,
+	name string,
+	qtype uint16,
+	nxdomain bool,
+) (st dnssecStatus, err error) {
+	if !nxdomain {
+		for _, n := range nsec3s {
+			if !n.Match(name) {
+				continue
+			} else if hasData(n.TypeBitMap, qtype) {
+				break
+			}
+
+			return dnssecStatusSecure, nil
+		}
+	}
+
+	ce, optOut, ok := nsec3ClosestEncloser(nsec3s, name)
+	if !ok {
+		return dnssecStatusBogus, fmt.Errorf("%w for %s %s", errNoDenial, name, dns.Type(qtype))
+	} else if optOut {
+		// There may be an unsigned delegation for name, see RFC 5155,
+		// sections 8.4 and 8.6.
+		return dnssecStatusInsecure, nil
+	}
+
+	wildcard := wildcardName(ce)
+	if nxdomain {
+		for _, n := range nsec3s {
+			if n.Cover(wildcard) {
+				return dnssecStatusSecure, nil
+			}
+		}
+
+		return dnssecStatusBogus, fmt.Errorf("%w for wildcard %s", errNoDenial, wildcard)
+	}
+
+	// The answer may only be a NODATA from the wildcard, see RFC 5155,
+	// section 8.7.
+	for _, n := range nsec3s {
+		if n.Match(wildcard) && !hasData(n.TypeBitMap, qtype) {
+			return dnssecStatusSecure, nil
+		}
+	}
+
+	return dnssecStatusBogus, fmt.Errorf("%w for %s %s", errNoDenial, name, dns.Type(qtype))
+}
+
+// nsec3ClosestEncloser returns the closest encloser of name proven by nsec3s.
+// optOut is true if the record covering the next closer name has the opt-out
+// flag.  ok is false if there is no such proof.  See RFC 5155, section 8.3.
+func nsec3ClosestEncloser(nsec3s []*dns.NSEC3, name string) (ce string, optOut, ok bool) {
+	nextCloser := ""
+	for ce = name; ; ce = parentName(ce) {
+		match := findNSEC3Match(nsec3s, ce)
+		if match != nil {
+			if hasType(match.TypeBitMap, dns.TypeDNAME) ||
+				(hasType(match.TypeBitMap, dns.TypeNS) && !hasType(match.TypeBitMap, dns.TypeSOA)) {
+				// The records below the zone cuts and the DNAMEs can't
+				// prove anything about name.
+				return "", false, false
+			}
+
+			break
+		} else if ce == "." {
+			return "", false, false
+		}
+
+		nextCloser = ce
+	}
+
+	if nextCloser == "" {
+		// name itself exists.
+		return "", false, false
+	}
+
+	for _, n := range nsec3s {
+		if n.Cover(nextCloser) {
+			return ce, n.Flags&1 == 1, true
+		}
+	}
+
+	return "", false, false
+}
+
+// findNSEC3Match returns the record from nsec3s matching name, if any.
+func findNSEC3Match(nsec3s []*dns.NSEC3, name string) (n *dns.NSEC3) {
+	for _, n = range nsec3s {
+		if n.Match(name) {
+			return n
+		}
+	}
+
+	return nil
+}
+
+// checkNoCloserMatch returns an error if nsecs don't prove that there is no
+// closer match for the name of the wildcard expansion exp.  See RFC 4035,
+// section 5.3.4, and RFC 5155, section 8.8.
+func checkNoCloserMatch(nsecs []dns.RR, exp *wildcardExpansion) (err error) {
+	nsec, nsec3 := splitNSECs(nsecs)
+	if findNSECCover(nsec, exp.name) != nil {
+		return nil
+	}
+
+	nextCloser := ancestorName(exp.name, labelCount(exp.closestEncloser)+1)
+	for _, n := range nsec3 {
+		if n.Cover(nextCloser) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w of closer match for wildcard expansion %s", errNoDenial, exp.name)
+}
+
+// isDelegationDenial returns true if the NSEC or NSEC3 records in ns show that
+// zone is a delegation point.
+func isDelegationDenial(ns []dns.RR, zone string) (ok bool) {
+	for _, rr := range ns {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			if canonicalName(rr.Hdr.Name) == zone {
+				return hasType(rr.TypeBitMap, dns.TypeNS) && !hasType(rr.TypeBitMap, dns.TypeSOA)
+			}
+		case *dns.NSEC3:
+			if rr.Match(zone) {
+				return hasType(rr.TypeBitMap, dns.TypeNS) && !hasType(rr.TypeBitMap, dns.TypeSOA)
+			} else if rr.Flags&1 == 1 && rr.Cover(zone) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// nsecCovers returns true if name is between the owner name and the next
+// domain name of n in the canonical order.
+func nsecCovers(n *dns.NSEC, name string) (ok bool) {
+	owner, next := canonicalName(n.Hdr.Name), canonicalName(n.NextDomain)
+	if compareCanonical(owner, name) >= 0 {
+		return false
+	}
+
+	// The next domain name of the last NSEC in the zone is the apex.
+	return compareCanonical(owner, next) >= 0 || compareCanonical(name, next) < 0
+}
+
+// compareCanonical compares the domain names a and b in the canonical order.
+// See RFC 4034, section 6.1.
+func compareCanonical(a, b string) (res int) {
+	al, bl := canonicalLabels(a), canonicalLabels(b)
+	for i, j := len(al)-1, len(bl)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if res = bytes.Compare(al[i], bl[j]); res != 0 {
+			return res
+		}
+	}
+
+	return len(al) - len(bl)
+}
+
+// canonicalLabels returns the lowercased labels of name in the wire format.
+func canonicalLabels(name string) (labels [][]byte) {
+	buf := make([]byte, 256)
+	l, err := dns.PackDomainName(dns.Fqdn(name), buf, 0, nil, false)
+	if err != nil {
+		// Shouldn't happen with the names from the parsed messages, so just
+		// compare the presentation format.
+		for _, label := range dns.SplitDomainName(strings.ToLower(name)) {
+			labels = append(labels, []byte(label))
+		}
+
+		return labels
+	}
+
+	for i := 0; i < l && buf[i] != 0; i += int(buf[i]) + 1 {
+		label := buf[i+1 : i+1+int(buf[i])]
+		for j, c := range label {
+			// Only the ASCII letters are case-insensitive in domain names.
+			if 'A' <= c && c <= 'Z' {
+				label[j] = c + 'a' - 'A'
+			}
+		}
+
+		labels = append(labels, label)
+	}
+
+	return labels
+}
+
+// hasType returns true if the NSEC type bitmap contains t.
+func hasType(bitmap []uint16, t uint16) (ok bool) {
+	for _, bt := range bitmap {
+		if bt == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isDNSSECRR returns true if rr is a DNSSEC record.
+func isDNSSECRR(rr dns.RR) (ok bool) {
+	switch rr.Header().Rrtype {
+	case
+		dns.TypeNSEC,
+		dns.TypeNSEC3,
+		dns.TypeDS,
+		dns.TypeRRSIG,
+		dns.TypeSIG,
+		dns.TypeDNSKEY:
+		return true
+	default:
+		return false
+	}
+}
+
+// filterDNSSECRRs removes the DNSSEC records except the ones of type except
+// from rrs.
+func filterDNSSECRRs(rrs []dns.RR, except uint16) (filtered []dns.RR) {
+	for _, rr := range rrs {
+		if !isDNSSECRR(rr) || rr.Header().Rrtype == except {
+			filtered = append(filtered, rr)
+		}
+	}
+
+	return filtered
+}
+
+// dnssecReqState is the original state of the request flags changed by
+// requestDNSSEC.
+type dnssecReqState struct {
+	// hadEDNS is true if the request had an OPT record.
+	hadEDNS bool
+
+	// hadDO is true if the request had the DO bit set.
+	hadDO bool
+
+	// hadCD is true if the request had the CD bit set.
+	hadCD bool
+}
+
+// requestDNSSEC sets the DO and CD bits of req so that the DNSSEC records are
+// returned even by the validating upstreams and returns the original state of
+// the request.
+func requestDNSSEC(req *dns.Msg) (st dnssecReqState) {
+	st.hadCD = req.CheckingDisabled
+	req.CheckingDisabled = true
+
+	opt := req.IsEdns0()
+	if opt == nil {
+		req.SetEdns0(dnssecUDPBufSize, true)
+
+		return st
+	}
+
+	st.hadEDNS, st.hadDO = true, opt.Do()
+	opt.SetDo()
+
+	return st
+}
+
+// restoreDNSSEC reverts the changes made to req by requestDNSSEC and removes
+// the DNSSEC records the client hasn't requested from resp.
+func restoreDNSSEC(pctx *proxy.DNSContext, st dnssecReqState) {
+	req, resp := pctx.Req, pctx.Res
+	req.CheckingDisabled, resp.CheckingDisabled = st.hadCD, st.hadCD
+	if st.hadDO {
+		return
+	}
+
+	if st.hadEDNS {
+		req.IsEdns0().SetDo(false)
+		if opt := resp.IsEdns0(); opt != nil {
+			opt.SetDo(false)
+		}
+	} else {
+		req.Extra = removeOPT(req.Extra)
+		resp.Extra = removeOPT(resp.Extra)
+	}
+
+	qtype := req.Question[0].Qtype
+	resp.Answer = filterDNSSECRRs(resp.Answer, qtype)
+	resp.Ns = filterDNSSECRRs(resp.Ns, dns.TypeNone)
+	resp.Extra = filterDNSSECRRs(resp.Extra, dns.TypeNone)
+
+	resp.Truncate(proxyutil.DNSSize(pctx.Proto == proxy.ProtoUDP, req))
+}
+
+// removeOPT returns rrs without the OPT records.
+func removeOPT(rrs []dns.RR) (filtered []dns.RR) {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			filtered = append(filtered, rr)
+		}
+	}
+
+	return filtered
+}
+
+// validateResponse validates the response in dctx and replaces it with
+// SERVFAIL if it's bogus, unless the client has disabled the checking.  It
+// also reverts the changes made to the request by requestDNSSEC.
+func (s *Server) validateResponse(dctx *dnsContext, reqSt dnssecReqState) {
+	pctx := dctx.proxyCtx
+	req := pctx.Req
+
+	st, err := s.dnssec.validate(pctx.Res)
+	dctx.dnssecStatus = st
+	if st == dnssecStatusBogus {
+		log.Debug("dns: bogus response for %s: %s", req.Question[0].Name, err)
+	}
+
+	restoreDNSSEC(pctx, reqSt)
+
+	if st == dnssecStatusBogus && !req.CheckingDisabled {
+		dctx.origResp = pctx.Res
+		pctx.Res = s.genServerFailure(req)
+		s.setFailureEDE(req, pctx.Res, dnssecErrorCode(err), err.Error())
+
+		return
+	}
+
+	pctx.Res.AuthenticatedData = st == dnssecStatusSecure && (req.AuthenticatedData || reqSt.hadDO)
+}
+
+// dnssecErrorCode returns the extended DNS error code for the validation
+// error.  See RFC 8914.
+func dnssecErrorCode(err error) (code uint16) {
+	switch {
+	case errors.Is(err, errNoRRSIG):
+		return dns.ExtendedErrorCodeRRSIGsMissing
+	case errors.Is(err, errExpiredRRSIG):
+		return dns.ExtendedErrorCodeSignatureExpired
+	case errors.Is(err, errNoDNSKEY):
+		return dns.ExtendedErrorCodeDNSKEYMissing
+	case errors.Is(err, errNoDenial):
+		return dns.ExtendedErrorCodeNSECMissing
+	default:
+		return dns.ExtendedErrorCodeDNSBogus
+	}
+}
+
+// lookupUpstreamDNSSEC returns a dnssecLookupFunc requesting the DNSSEC
+// records from the default upstreams of uc.
+func lookupUpstreamDNSSEC(uc *proxy.UpstreamConfig) (lookup dnssecLookupFunc) {
+	return func(name string, qtype uint16) (resp *dns.Msg, err error) {
+		req := &dns.Msg{}
+		req.SetQuestion(name, qtype)
+		req.CheckingDisabled = true
+		req.SetEdns0(dnssecUDPBufSize, true)
+
+		resp, _, err = upstream.ExchangeParallel(uc.Upstreams, req)
+
+		return resp, err
+	}
+}
+
+// prepareDNSSEC sets up the local DNSSEC validation of the responses from the
+// upstream servers.
+func (s *Server) prepareDNSSEC(uc *proxy.UpstreamConfig) (err error) {
+	anchors, err := parseTrustAnchors(s.conf.DNSSECTrustAnchors)
+	if err != nil {
+		return err
+	}
+
+	s.dnssec = newDNSSECValidator(lookupUpstreamDNSSEC(uc), anchors)
+
+	return nil
+}
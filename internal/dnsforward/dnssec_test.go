@@ -0,0 +1,537 @@
+package dnsforward
+
+import (
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSSECValidator_validate(t *testing.T) {
+	h, anchor := newTestHierarchy(t)
+	r := newTestRecursor(t, h)
+
+	anchors, err := parseTrustAnchors([]string{anchor.String()})
+	require.NoError(t, err)
+
+	v := newDNSSECValidator(r.lookup, anchors)
+
+	wildResp, err := r.Exchange(createTestMessage("a.wild.example.test."))
+	require.NoError(t, err)
+
+	nxResp, err := r.Exchange(createTestMessage("nx.example.test."))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name   string
+		host   string
+		want   dnssecStatus
+		qtype  uint16
+		modify func(resp *dns.Msg)
+	}{{
+		name:   "secure",
+		host:   "www.example.test.",
+		want:   dnssecStatusSecure,
+		qtype:  dns.TypeA,
+		modify: nil,
+	}, {
+		name:   "secure_cname",
+		host:   "alias.example.test.",
+		want:   dnssecStatusSecure,
+		qtype:  dns.TypeA,
+		modify: nil,
+	}, {
+		name:   "secure_nxdomain",
+		host:   "nx.example.test.",
+		want:   dnssecStatusSecure,
+		qtype:  dns.TypeA,
+		modify: nil,
+	}, {
+		name:   "secure_nodata",
+		host:   "www.example.test.",
+		want:   dnssecStatusSecure,
+		qtype:  dns.TypeTXT,
+		modify: nil,
+	}, {
+		name:   "secure_wildcard",
+		host:   "a.wild.example.test.",
+		want:   dnssecStatusSecure,
+		qtype:  dns.TypeA,
+		modify: nil,
+	}, {
+		name:   "insecure",
+		host:   "www.insecure.test.",
+		want:   dnssecStatusInsecure,
+		qtype:  dns.TypeA,
+		modify: nil,
+	}, {
+		name:   "insecure_cname",
+		host:   "other.example.test.",
+		want:   dnssecStatusInsecure,
+		qtype:  dns.TypeA,
+		modify: nil,
+	}, {
+		name:   "insecure_nxdomain",
+		host:   "nx.insecure.test.",
+		want:   dnssecStatusInsecure,
+		qtype:  dns.TypeA,
+		modify: nil,
+	}, {
+		name:   "bogus_wrong_key",
+		host:   "www.bogus.test.",
+		want:   dnssecStatusBogus,
+		qtype:  dns.TypeA,
+		modify: nil,
+	}, {
+		name:  "bogus_modified",
+		host:  "www.example.test.",
+		want:  dnssecStatusBogus,
+		qtype: dns.TypeA,
+		modify: func(resp *dns.Msg) {
+			resp.Answer[0].(*dns.A).A = net.IP{192, 0, 2, 200}
+		},
+	}, {
+		name:  "bogus_stripped",
+		host:  "www.example.test.",
+		want:  dnssecStatusBogus,
+		qtype: dns.TypeA,
+		modify: func(resp *dns.Msg) {
+			resp.Answer = filterDNSSECRRs(resp.Answer, dns.TypeNone)
+		},
+	}, {
+		name:  "bogus_no_denial",
+		host:  "nx.example.test.",
+		want:  dnssecStatusBogus,
+		qtype: dns.TypeA,
+		modify: func(resp *dns.Msg) {
+			resp.Ns = nil
+		},
+	}, {
+		name:  "bogus_unsupported_algorithm",
+		host:  "www.example.test.",
+		want:  dnssecStatusBogus,
+		qtype: dns.TypeA,
+		modify: func(resp *dns.Msg) {
+			// Replace the real signature with one made with an unknown
+			// algorithm.
+			var sig *dns.RRSIG
+			for _, rr := range resp.Answer {
+				if s, ok := rr.(*dns.RRSIG); ok {
+					sig = dns.Copy(s).(*dns.RRSIG)
+				}
+			}
+			sig.Algorithm = 253
+
+			resp.Answer = append(filterDNSSECRRs(resp.Answer, dns.TypeNone), sig)
+			resp.Answer[0].(*dns.A).A = net.IP{192, 0, 2, 200}
+		},
+	}, {
+		name:  "bogus_wildcard_replay",
+		host:  "host.wild.example.test.",
+		want:  dnssecStatusBogus,
+		qtype: dns.TypeA,
+		modify: func(resp *dns.Msg) {
+			// Replay the wildcard expansion for a name which exists.
+			resp.Answer = nil
+			for _, rr := range wildResp.Answer {
+				rr = dns.Copy(rr)
+				rr.Header().Name = "host.wild.example.test."
+				resp.Answer = append(resp.Answer, rr)
+			}
+
+			resp.Ns = wildResp.Ns
+		},
+	}, {
+		name:  "bogus_wildcard_nxdomain",
+		host:  "b.wild.example.test.",
+		want:  dnssecStatusBogus,
+		qtype: dns.TypeA,
+		modify: func(resp *dns.Msg) {
+			// Deny the name which the wildcard is expanded to.
+			resp.Rcode = dns.RcodeNameError
+			resp.Answer = nil
+			resp.Ns = append(append([]dns.RR{}, nxResp.Ns...), resp.Ns...)
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, lookupErr := r.Exchange(createTestMessageWithType(tc.host, tc.qtype))
+			require.NoError(t, lookupErr)
+
+			if tc.modify != nil {
+				tc.modify(resp)
+			}
+
+			st, vErr := v.validate(resp)
+			assert.Equal(t, tc.want, st)
+			if tc.want == dnssecStatusBogus {
+				assert.Error(t, vErr)
+			} else {
+				assert.NoError(t, vErr)
+			}
+		})
+	}
+
+	t.Run("cache", func(t *testing.T) {
+		z, ok := v.zones["example.test."]
+		require.True(t, ok)
+
+		assert.Equal(t, dnssecStatusSecure, z.status)
+		assert.Len(t, z.keys, 1)
+
+		z, ok = v.zones["insecure.test."]
+		require.True(t, ok)
+
+		assert.Equal(t, dnssecStatusInsecure, z.status)
+		assert.Empty(t, z.keys)
+	})
+}
+
+func TestParseTrustAnchors(t *testing.T) {
+	ds, err := parseTrustAnchors(nil)
+	require.NoError(t, err)
+
+	require.Len(t, ds, 1)
+	assert.Len(t, ds["."], len(defaultTrustAnchors))
+
+	_, err = parseTrustAnchors([]string{". IN A 1.2.3.4"})
+	assert.Error(t, err)
+
+	_, err = parseTrustAnchors([]string{"bad"})
+	assert.Error(t, err)
+}
+
+func TestCompareCanonical(t *testing.T) {
+	// The example from RFC 4034, section 6.1.
+	names := []string{
+		"example.",
+		"a.example.",
+		"yljkjljk.a.example.",
+		"Z.a.example.",
+		"zABC.a.EXAMPLE.",
+		"z.example.",
+		"\\001.z.example.",
+		"*.z.example.",
+		"\\200.z.example.",
+	}
+
+	for i := 0; i < len(names)-1; i++ {
+		assert.Negative(t, compareCanonical(names[i], names[i+1]), "%s < %s", names[i], names[i+1])
+		assert.Positive(t, compareCanonical(names[i+1], names[i]), "%s > %s", names[i+1], names[i])
+	}
+
+	assert.Zero(t, compareCanonical("Example.", "example."))
+}
+
+func TestCheckDenial(t *testing.T) {
+	apex := &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "example.", Rrtype: dns.TypeNSEC},
+		NextDomain: "b.example.",
+		TypeBitMap: []uint16{dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC},
+	}
+	nsec := &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "b.example.", Rrtype: dns.TypeNSEC},
+		NextDomain: "d.example.",
+		TypeBitMap: []uint16{dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC},
+	}
+	last := &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "x.example.", Rrtype: dns.TypeNSEC},
+		NextDomain: "example.",
+		TypeBitMap: []uint16{dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC},
+	}
+	nsecs := []dns.RR{apex, nsec, last}
+
+	testCases := []struct {
+		name     string
+		host     string
+		qtype    uint16
+		nxdomain bool
+		wantErr  bool
+	}{{
+		name:     "nxdomain_covered",
+		host:     "c.example.",
+		qtype:    dns.TypeA,
+		nxdomain: true,
+		wantErr:  false,
+	}, {
+		name:     "nxdomain_covered_last",
+		host:     "y.example.",
+		qtype:    dns.TypeA,
+		nxdomain: true,
+		wantErr:  false,
+	}, {
+		name:     "nxdomain_not_covered",
+		host:     "e.example.",
+		qtype:    dns.TypeA,
+		nxdomain: true,
+		wantErr:  true,
+	}, {
+		name:     "nodata",
+		host:     "b.example.",
+		qtype:    dns.TypeTXT,
+		nxdomain: false,
+		wantErr:  false,
+	}, {
+		name:     "nodata_type_exists",
+		host:     "b.example.",
+		qtype:    dns.TypeA,
+		nxdomain: false,
+		wantErr:  true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			st, err := checkDenial(nsecs, tc.host, tc.qtype, tc.nxdomain)
+			if tc.wantErr {
+				assert.Error(t, err)
+				assert.Equal(t, dnssecStatusBogus, st)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, dnssecStatusSecure, st)
+			}
+		})
+	}
+
+	t.Run("nxdomain_no_wildcard_denial", func(t *testing.T) {
+		st, err := checkDenial([]dns.RR{nsec, last}, "c.example.", dns.TypeA, true)
+		assert.Error(t, err)
+		assert.Equal(t, dnssecStatusBogus, st)
+	})
+}
+
+// newTestNSEC3Chain returns the chain of NSEC3 records of zone containing the
+// names with the flags.
+func newTestNSEC3Chain(zone string, flags uint8, names ...string) (rrs []dns.RR) {
+	hashes := make([]string, 0, len(names))
+	for _, n := range names {
+		hashes = append(hashes, dns.HashName(n, dns.SHA1, 0, ""))
+	}
+	sort.Strings(hashes)
+
+	for i, h := range hashes {
+		rrs = append(rrs, &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: h + "." + zone, Rrtype: dns.TypeNSEC3},
+			Hash:       dns.SHA1,
+			Flags:      flags,
+			NextDomain: hashes[(i+1)%len(hashes)],
+			TypeBitMap: []uint16{dns.TypeA, dns.TypeRRSIG},
+		})
+	}
+
+	return rrs
+}
+
+func TestCheckDenial_nsec3(t *testing.T) {
+	nsec3s := newTestNSEC3Chain("example.", 0, "example.", "b.example.")
+	optOut := newTestNSEC3Chain("example.", 1, "example.", "b.example.")
+
+	testCases := []struct {
+		name     string
+		host     string
+		nsec3s   []dns.RR
+		want     dnssecStatus
+		qtype    uint16
+		nxdomain bool
+	}{{
+		name:     "nxdomain",
+		host:     "c.example.",
+		nsec3s:   nsec3s,
+		want:     dnssecStatusSecure,
+		qtype:    dns.TypeA,
+		nxdomain: true,
+	}, {
+		name:     "nxdomain_opt_out",
+		host:     "c.example.",
+		nsec3s:   optOut,
+		want:     dnssecStatusInsecure,
+		qtype:    dns.TypeA,
+		nxdomain: true,
+	}, {
+		name:     "nxdomain_exists",
+		host:     "b.example.",
+		nsec3s:   nsec3s,
+		want:     dnssecStatusBogus,
+		qtype:    dns.TypeA,
+		nxdomain: true,
+	}, {
+		name:     "nodata",
+		host:     "b.example.",
+		nsec3s:   nsec3s,
+		want:     dnssecStatusSecure,
+		qtype:    dns.TypeTXT,
+		nxdomain: false,
+	}, {
+		name:     "nodata_type_exists",
+		host:     "b.example.",
+		nsec3s:   nsec3s,
+		want:     dnssecStatusBogus,
+		qtype:    dns.TypeA,
+		nxdomain: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			st, err := checkDenial(tc.nsec3s, tc.host, tc.qtype, tc.nxdomain)
+			assert.Equal(t, tc.want, st)
+			if tc.want == dnssecStatusBogus {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// testADUpstream is an upstream setting the AD bit in all the responses and,
+// optionally, removing the signatures from them.
+type testADUpstream struct {
+	upstream.Upstream
+
+	stripSigs bool
+}
+
+// Exchange implements the upstream.Upstream interface for *testADUpstream.
+func (u *testADUpstream) Exchange(m *dns.Msg) (resp *dns.Msg, err error) {
+	resp, err = u.Upstream.Exchange(m)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.AuthenticatedData = true
+	if u.stripSigs && m.Question[0].Qtype == dns.TypeA {
+		resp.Answer = filterDNSSECRRs(resp.Answer, dns.TypeNone)
+	}
+
+	return resp, nil
+}
+
+func TestServer_dnssecValidation(t *testing.T) {
+	h, anchor := newTestHierarchy(t)
+
+	s := createTestServer(t, &filtering.Config{}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		FilteringConfig: FilteringConfig{
+			DNSSECValidation:   true,
+			DNSSECTrustAnchors: []string{anchor.String()},
+			ExtendedErrors:     true,
+		},
+	}, nil)
+
+	ups := &testADUpstream{Upstream: newTestRecursor(t, h)}
+	s.conf.UpstreamConfig.Upstreams = []upstream.Upstream{ups}
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantStatus dnssecStatus
+		wantRcode  int
+		wantEDE    uint16
+		stripSigs  bool
+		wantAD     bool
+	}{{
+		name:       "secure",
+		host:       "www.example.test.",
+		wantStatus: dnssecStatusSecure,
+		wantRcode:  dns.RcodeSuccess,
+		wantEDE:    0,
+		stripSigs:  false,
+		wantAD:     true,
+	}, {
+		name:       "insecure",
+		host:       "www.insecure.test.",
+		wantStatus: dnssecStatusInsecure,
+		wantRcode:  dns.RcodeSuccess,
+		wantEDE:    0,
+		stripSigs:  false,
+		wantAD:     false,
+	}, {
+		name:       "bogus",
+		host:       "www.bogus.test.",
+		wantStatus: dnssecStatusBogus,
+		wantRcode:  dns.RcodeServerFailure,
+		wantEDE:    dns.ExtendedErrorCodeDNSBogus,
+		stripSigs:  false,
+		wantAD:     false,
+	}, {
+		name:       "stripped",
+		host:       "www.example.test.",
+		wantStatus: dnssecStatusBogus,
+		wantRcode:  dns.RcodeServerFailure,
+		wantEDE:    dns.ExtendedErrorCodeRRSIGsMissing,
+		stripSigs:  true,
+		wantAD:     false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ups.stripSigs = tc.stripSigs
+
+			req := createTestMessage(tc.host)
+			req.AuthenticatedData = true
+			req.SetEdns0(dns.DefaultMsgSize, false)
+
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Proto: proxy.ProtoUDP,
+					Req:   req,
+					Addr:  &net.UDPAddr{IP: net.IP{192, 168, 0, 1}},
+				},
+			}
+
+			rc := s.processUpstream(dctx)
+			require.Equal(t, resultCodeSuccess, rc)
+
+			resp := dctx.proxyCtx.Res
+			require.NotNil(t, resp)
+
+			assert.Equal(t, tc.wantStatus, dctx.dnssecStatus)
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+			assert.Equal(t, tc.wantAD, resp.AuthenticatedData)
+			assert.False(t, req.CheckingDisabled)
+
+			require.NotNil(t, resp.IsEdns0())
+
+			ede := extendedError(resp)
+			if tc.wantEDE == 0 {
+				assert.Nil(t, ede)
+			} else {
+				require.NotNil(t, ede)
+				assert.Equal(t, tc.wantEDE, ede.InfoCode)
+				assert.NotEmpty(t, ede.ExtraText)
+			}
+		})
+	}
+
+	t.Run("no_extended_errors", func(t *testing.T) {
+		s.conf.ExtendedErrors = false
+		ups.stripSigs = false
+
+		req := createTestMessage("www.bogus.test.")
+		req.SetEdns0(dns.DefaultMsgSize, false)
+
+		dctx := &dnsContext{
+			proxyCtx: &proxy.DNSContext{
+				Proto: proxy.ProtoUDP,
+				Req:   req,
+				Addr:  &net.UDPAddr{IP: net.IP{192, 168, 0, 1}},
+			},
+		}
+
+		rc := s.processUpstream(dctx)
+		require.Equal(t, resultCodeSuccess, rc)
+
+		resp := dctx.proxyCtx.Res
+		require.NotNil(t, resp)
+
+		assert.Equal(t, dns.RcodeServerFailure, resp.Rcode)
+		assert.Nil(t, extendedError(resp))
+	})
+}
@@ -1,8 +1,10 @@
 package dnsforward
 
 import (
+	"fmt"
 	"net"
 	"time"
+	"unicode/utf8"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/dnsproxy/proxy"
@@ -37,10 +39,78 @@ func ipsFromRules(resRules []*filtering.ResultRule) (ips []net.IP) {
 	return ips
 }
 
-// genDNSFilterMessage generates a DNS message corresponding to the filtering result
-func (s *Server) genDNSFilterMessage(d *proxy.DNSContext, result *filtering.Result) *dns.Msg {
+// genDNSFilterMessage generates a DNS message corresponding to the filtering
+// result.  The extended DNS error describing the result is added to it if it's
+// enabled for the current blocking mode.
+func (s *Server) genDNSFilterMessage(d *proxy.DNSContext, result *filtering.Result) (resp *dns.Msg) {
+	resp = s.genFilteredResponse(d, result)
+	if s.isEDEBlockingMode() {
+		code, text := filteringEDE(result)
+		setEDE(d.Req, resp, code, text)
+	}
+
+	return resp
+}
+
+// isEDEBlockingMode returns true if the responses to the blocked requests
+// should contain the extended DNS errors in the current blocking mode.
+func (s *Server) isEDEBlockingMode() (ok bool) {
+	if !s.conf.ExtendedErrors {
+		return false
+	} else if len(s.conf.ExtendedErrorsModes) == 0 {
+		return true
+	}
+
+	for _, m := range s.conf.ExtendedErrorsModes {
+		if m == s.conf.BlockingMode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filteringEDE returns the code and the text of the extended DNS error
+// describing the filtering result.  The text names the matched rule and the ID
+// of its filter list, if there is one.
+func filteringEDE(res *filtering.Result) (code uint16, text string) {
+	switch res.Reason {
+	case filtering.FilteredSafeBrowsing:
+		code, text = dns.ExtendedErrorCodeBlocked, "safe browsing"
+	case filtering.FilteredParental:
+		code, text = dns.ExtendedErrorCodeFiltered, "parental control"
+	case filtering.FilteredSafeSearch:
+		code, text = dns.ExtendedErrorCodeFiltered, "safe search"
+	case filtering.FilteredBlockedService:
+		code, text = dns.ExtendedErrorCodeBlocked, "blocked service "+res.ServiceName
+	case filtering.FilteredThreatFeed:
+		code, text = dns.ExtendedErrorCodeBlocked, "threat feed "+res.ThreatFeed
+	case filtering.FilteredAnswerPolicy:
+		code, text = dns.ExtendedErrorCodeBlocked, "answer policy "+res.AnswerPolicy
+	default:
+		code, text = dns.ExtendedErrorCodeBlocked, "blocked"
+	}
+
+	if len(res.Rules) > 0 {
+		r := res.Rules[0]
+		text = fmt.Sprintf("%s: rule %q from filter list %d", text, r.Text, r.FilterListID)
+	}
+
+	return code, text
+}
+
+// genFilteredResponse generates a DNS message corresponding to the filtering
+// result according to the blocking mode.
+func (s *Server) genFilteredResponse(d *proxy.DNSContext, result *filtering.Result) *dns.Msg {
 	m := d.Req
 
+	// If the query was filtered by "Safe search", filtering also returns the
+	// addresses of the safe host which must be used in the response
+	// regardless of the blocking mode.
+	if result.Reason == filtering.FilteredSafeSearch {
+		return s.genSafeSearchResponse(m, ipsFromRules(result.Rules))
+	}
+
 	if m.Question[0].Qtype != dns.TypeA && m.Question[0].Qtype != dns.TypeAAAA {
 		if s.conf.BlockingMode == BlockingModeNullIP {
 			return s.makeResponse(m)
@@ -48,18 +118,24 @@ func (s *Server) genDNSFilterMessage(d *proxy.DNSContext, result *filtering.Resu
 		return s.genNXDomain(m)
 	}
 
+	// Threat feeds without their own block hosts are handled according to the
+	// blocking mode.
+	var feedBlockHost string
+	if result.Reason == filtering.FilteredThreatFeed && s.dnsFilter != nil {
+		feedBlockHost = s.dnsFilter.ThreatFeedBlockHost(result.ThreatFeed)
+	}
+
 	ips := ipsFromRules(result.Rules)
-	switch result.Reason {
-	case filtering.FilteredSafeBrowsing:
+	switch {
+	case result.Reason == filtering.FilteredSafeBrowsing:
 		return s.genBlockedHost(m, s.conf.SafeBrowsingBlockHost, d)
-	case filtering.FilteredParental:
+	case result.Reason == filtering.FilteredParental:
 		return s.genBlockedHost(m, s.conf.ParentalBlockHost, d)
+	case feedBlockHost != "":
+		return s.genBlockedHost(m, feedBlockHost, d)
 	default:
-		// If the query was filtered by "Safe search", filtering also must return
-		// the IP address that must be used in response.
-		// In this case regardless of the filtering method, we should return it
-		if result.Reason == filtering.FilteredSafeSearch && len(ips) > 0 {
-			return s.genResponseWithIPs(m, ips)
+		if resp := s.genBlockPageResponse(d, result, ips); resp != nil {
+			return resp
 		}
 
 		switch s.conf.BlockingMode {
@@ -107,6 +183,47 @@ func (s *Server) genServerFailure(request *dns.Msg) *dns.Msg {
 	return &resp
 }
 
+// setFailureEDE adds the extended DNS error option with code and text to the
+// failure response resp if the extended DNS errors are enabled.
+func (s *Server) setFailureEDE(req, resp *dns.Msg, code uint16, text string) {
+	if s.conf.ExtendedErrors {
+		setEDE(req, resp, code, text)
+	}
+}
+
+// maxEDETextLen is the maximum length of the extra text of the extended DNS
+// error in bytes.  It keeps the responses to the UDP requests small.
+const maxEDETextLen = 256
+
+// setEDE adds the extended DNS error option with code and text to resp if req
+// supports EDNS.  text is truncated to maxEDETextLen bytes.  See RFC 8914.
+func setEDE(req, resp *dns.Msg, code uint16, text string) {
+	reqOpt := req.IsEdns0()
+	if reqOpt == nil {
+		return
+	}
+
+	if len(text) > maxEDETextLen {
+		n := maxEDETextLen
+		for n > 0 && !utf8.RuneStart(text[n]) {
+			n--
+		}
+
+		text = text[:n]
+	}
+
+	opt := resp.IsEdns0()
+	if opt == nil {
+		resp.SetEdns0(reqOpt.UDPSize(), reqOpt.Do())
+		opt = resp.IsEdns0()
+	}
+
+	opt.Option = append(opt.Option, &dns.EDNS0_EDE{
+		InfoCode:  code,
+		ExtraText: text,
+	})
+}
+
 func (s *Server) genARecord(request *dns.Msg, ip net.IP) *dns.Msg {
 	resp := s.makeResponse(request)
 	resp.Answer = append(resp.Answer, s.genAnswerA(request, ip))
@@ -212,6 +329,65 @@ func (s *Server) genResponseWithIPs(req *dns.Msg, ips []net.IP) (resp *dns.Msg)
 	return resp
 }
 
+// genSafeSearchResponse generates a response with the addresses of the safe
+// search host.  A and AAAA questions are answered with the addresses of the
+// corresponding family and HTTPS questions with a record containing the address
+// hints.  The response has no answers for the other types or if there are no
+// suitable addresses.
+func (s *Server) genSafeSearchResponse(req *dns.Msg, ips []net.IP) (resp *dns.Msg) {
+	resp = s.makeResponse(req)
+
+	var ip4s, ip6s []net.IP
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip4s = append(ip4s, ip4)
+		} else {
+			ip6s = append(ip6s, ip)
+		}
+	}
+
+	switch req.Question[0].Qtype {
+	case dns.TypeA:
+		for _, ip := range ip4s {
+			resp.Answer = append(resp.Answer, s.genAnswerA(req, ip))
+		}
+	case dns.TypeAAAA:
+		for _, ip := range ip6s {
+			resp.Answer = append(resp.Answer, s.genAnswerAAAA(req, ip))
+		}
+	case dns.TypeHTTPS:
+		if len(ips) > 0 {
+			resp.Answer = append(resp.Answer, s.genAnswerHTTPSHints(req, ip4s, ip6s))
+		}
+	default:
+		// Go on and return an empty response.
+	}
+
+	return resp
+}
+
+// genAnswerHTTPSHints returns an HTTPS record in the service mode containing
+// the address hints.
+func (s *Server) genAnswerHTTPSHints(req *dns.Msg, ip4s, ip6s []net.IP) (ans *dns.HTTPS) {
+	ans = &dns.HTTPS{
+		SVCB: dns.SVCB{
+			Hdr:      s.hdr(req, dns.TypeHTTPS),
+			Priority: 1,
+			Target:   ".",
+		},
+	}
+
+	if len(ip4s) > 0 {
+		ans.Value = append(ans.Value, &dns.SVCBIPv4Hint{Hint: ip4s})
+	}
+
+	if len(ip6s) > 0 {
+		ans.Value = append(ans.Value, &dns.SVCBIPv6Hint{Hint: ip6s})
+	}
+
+	return ans
+}
+
 // makeResponseNullIP creates a response with 0.0.0.0 for A requests, :: for
 // AAAA requests, and an empty response for other types.
 func (s *Server) makeResponseNullIP(req *dns.Msg) (resp *dns.Msg) {
@@ -253,14 +429,20 @@ func (s *Server) genBlockedHost(request *dns.Msg, newAddr string, d *proxy.DNSCo
 	if prx == nil {
 		log.Debug("dns: %s", srvClosedErr)
 
-		return s.genServerFailure(request)
+		resp := s.genServerFailure(request)
+		s.setFailureEDE(request, resp, dns.ExtendedErrorCodeNotReady, srvClosedErr.Error())
+
+		return resp
 	}
 
 	err := prx.Resolve(newContext)
 	if err != nil {
 		log.Printf("couldn't look up replacement host %q: %s", newAddr, err)
 
-		return s.genServerFailure(request)
+		resp := s.genServerFailure(request)
+		s.setFailureEDE(request, resp, dns.ExtendedErrorCodeNetworkError, err.Error())
+
+		return resp
 	}
 
 	resp := s.makeResponse(request)
@@ -275,8 +457,13 @@ func (s *Server) genBlockedHost(request *dns.Msg, newAddr string, d *proxy.DNSCo
 }
 
 // preBlockedResponse returns a protocol-appropriate response for a request that
-// was blocked by access settings.
-func (s *Server) preBlockedResponse(pctx *proxy.DNSContext) (reply bool, err error) {
+// was blocked by access settings.  code and text describe the reason as an
+// extended DNS error.
+func (s *Server) preBlockedResponse(
+	pctx *proxy.DNSContext,
+	code uint16,
+	text string,
+) (reply bool, err error) {
 	if pctx.Proto == proxy.ProtoUDP || pctx.Proto == proxy.ProtoDNSCrypt {
 		// Return nil so that dnsproxy drops the connection and thus
 		// prevent DNS amplification attacks.
@@ -284,6 +471,7 @@ func (s *Server) preBlockedResponse(pctx *proxy.DNSContext) (reply bool, err err
 	}
 
 	pctx.Res = s.makeResponseREFUSED(pctx.Req)
+	s.setFailureEDE(pctx.Req, pctx.Res, code, text)
 
 	return true, nil
 }
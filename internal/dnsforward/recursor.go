@@ -0,0 +1,407 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
+)
+
+// defaultRootHints are the IPv4 addresses of the root servers.
+var defaultRootHints = []string{
+	"198.41.0.4",
+	"170.247.170.2",
+	"192.33.4.12",
+	"199.7.91.13",
+	"192.203.230.10",
+	"192.5.5.241",
+	"192.112.36.4",
+	"198.97.190.53",
+	"192.36.148.17",
+	"192.58.128.30",
+	"193.0.14.129",
+	"199.7.83.42",
+	"202.12.27.33",
+}
+
+const (
+	// recursorAddress is the address of the recursor shown in the query log.
+	recursorAddress = "recursive"
+
+	// maxRecursorReferrals is the maximum number of referrals followed while
+	// resolving a single name.
+	maxRecursorReferrals = 32
+
+	// maxRecursorDepth is the maximum depth of the nested resolutions of the
+	// nameservers' addresses.
+	maxRecursorDepth = 8
+
+	// maxRecursorCNAMEs is the maximum number of CNAME records followed
+	// while resolving a single name.
+	maxRecursorCNAMEs = 8
+
+	// maxRecursorDelegationsCount is the maximum number of cached
+	// delegations.
+	maxRecursorDelegationsCount = 4096
+
+	// recursorDelegationMaxTTL is the maximum time a delegation is cached
+	// for.
+	recursorDelegationMaxTTL = 6 * time.Hour
+)
+
+// recursorDelegation is the cached set of a zone nameservers' addresses.
+type recursorDelegation struct {
+	expire time.Time
+	addrs  []string
+}
+
+// recursor is an upstream.Upstream resolving the requests iteratively
+// starting from the root servers.  The responses contain the DNSSEC records,
+// but aren't validated by the recursor.
+type recursor struct {
+	// exchange sends req to the nameserver at addr.
+	exchange func(req *dns.Msg, addr string) (resp *dns.Msg, err error)
+
+	// delegations are the cached nameservers' addresses mapped by the
+	// canonical zone names.
+	delegations map[string]*recursorDelegation
+
+	// delegationsLock protects delegations.
+	delegationsLock sync.Mutex
+
+	// rootHints are the addresses of the root servers.
+	rootHints []string
+}
+
+// type check
+var _ upstream.Upstream = (*recursor)(nil)
+
+// newRecursor creates a new recursor.  hints are the IP addresses, with
+// optional ports, of the root servers, defaultRootHints are used if it's
+// empty.
+func newRecursor(hints []string, timeout time.Duration) (r *recursor, err error) {
+	if len(hints) == 0 {
+		hints = defaultRootHints
+	}
+
+	r = &recursor{
+		delegations: map[string]*recursorDelegation{},
+	}
+
+	for i, h := range hints {
+		var addr string
+		addr, err = parseRootHint(h)
+		if err != nil {
+			return nil, fmt.Errorf("root hint at index %d: %w", i, err)
+		}
+
+		r.rootHints = append(r.rootHints, addr)
+	}
+
+	udp := &dns.Client{Net: "udp", Timeout: timeout, UDPSize: dnssecUDPBufSize}
+	tcp := &dns.Client{Net: "tcp", Timeout: timeout}
+	r.exchange = func(req *dns.Msg, addr string) (resp *dns.Msg, err error) {
+		resp, _, err = udp.Exchange(req, addr)
+		if err == nil && resp.Truncated {
+			resp, _, err = tcp.Exchange(req, addr)
+		}
+
+		return resp, err
+	}
+
+	return r, nil
+}
+
+// parseRootHint returns the address of the root server from its IP address
+// with an optional port.
+func parseRootHint(h string) (addr string, err error) {
+	if ip := net.ParseIP(h); ip != nil {
+		return netutil.JoinHostPort(ip.String(), 53), nil
+	}
+
+	host, port, err := netutil.SplitHostPort(h)
+	if err != nil {
+		return "", err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", fmt.Errorf("bad ip address %q", host)
+	}
+
+	return netutil.JoinHostPort(ip.String(), port), nil
+}
+
+// Exchange implements the upstream.Upstream interface for *recursor.
+func (r *recursor) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	if len(req.Question) != 1 {
+		return nil, errors.Error("recursor: expected exactly one question")
+	}
+
+	q := req.Question[0]
+	res, err := r.resolve(q.Name, q.Qtype, 0)
+	if err != nil {
+		return nil, fmt.Errorf("recursor: resolving %s %s: %w", q.Name, dns.Type(q.Qtype), err)
+	}
+
+	resp = (&dns.Msg{}).SetRcode(req, res.Rcode)
+	resp.RecursionAvailable = true
+	resp.Answer = res.Answer
+	resp.Ns = res.Ns
+
+	return resp, nil
+}
+
+// Address implements the upstream.Upstream interface for *recursor.
+func (r *recursor) Address() (addr string) {
+	return recursorAddress
+}
+
+// lookup implements the dnssecLookupFunc for *recursor.
+func (r *recursor) lookup(name string, qtype uint16) (resp *dns.Msg, err error) {
+	return r.resolve(name, qtype, 0)
+}
+
+// resolve resolves name following the CNAME records.
+func (r *recursor) resolve(name string, qtype uint16, depth int) (resp *dns.Msg, err error) {
+	resp, err = r.iterate(name, qtype, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; ; i++ {
+		target, ok := followCNAMEs(resp.Answer, name, qtype)
+		if ok || qtype == dns.TypeCNAME || target == canonicalName(name) {
+			return resp, nil
+		} else if i >= maxRecursorCNAMEs {
+			return nil, fmt.Errorf("too many cnames for %s", name)
+		}
+
+		var next *dns.Msg
+		next, err = r.iterate(target, qtype, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Answer = append(resp.Answer, next.Answer...)
+		resp.Ns, resp.Rcode = next.Ns, next.Rcode
+		name = target
+	}
+}
+
+// iterate resolves name starting from the closest known nameservers and
+// following the referrals.
+func (r *recursor) iterate(name string, qtype uint16, depth int) (resp *dns.Msg, err error) {
+	if depth > maxRecursorDepth {
+		return nil, errors.Error("nameservers are nested too deep")
+	}
+
+	name = canonicalName(name)
+	zone, servers := r.closest(name, qtype == dns.TypeDS)
+	for i := 0; i < maxRecursorReferrals; i++ {
+		resp, err = r.query(servers, name, qtype)
+		if err != nil {
+			return nil, fmt.Errorf("querying nameservers of %s: %w", zone, err)
+		}
+
+		child, ns := findReferral(resp, zone, name, qtype)
+		if child == "" {
+			return resp, nil
+		}
+
+		addrs, ttl := r.referralAddrs(resp, ns, depth)
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("no addresses of nameservers of %s", child)
+		}
+
+		r.setDelegation(child, addrs, ttl)
+		zone, servers = child, addrs
+	}
+
+	return nil, fmt.Errorf("too many referrals for %s", name)
+}
+
+// closest returns the closest enclosing zone of name with known nameservers.
+// The DS records are served by the parent zone, so name itself is skipped if
+// ds is true.
+func (r *recursor) closest(name string, ds bool) (zone string, addrs []string) {
+	zone = name
+	if ds {
+		zone = parentName(zone)
+	}
+
+	now := time.Now()
+
+	r.delegationsLock.Lock()
+	defer r.delegationsLock.Unlock()
+
+	for ; zone != "."; zone = parentName(zone) {
+		if d, ok := r.delegations[zone]; ok && now.Before(d.expire) {
+			return zone, d.addrs
+		}
+	}
+
+	return ".", r.rootHints
+}
+
+// setDelegation caches the addresses of the zone nameservers.
+func (r *recursor) setDelegation(zone string, addrs []string, ttl time.Duration) {
+	r.delegationsLock.Lock()
+	defer r.delegationsLock.Unlock()
+
+	if len(r.delegations) >= maxRecursorDelegationsCount {
+		r.delegations = map[string]*recursorDelegation{}
+	}
+
+	r.delegations[zone] = &recursorDelegation{
+		expire: time.Now().Add(ttl),
+		addrs:  addrs,
+	}
+}
+
+// query sends the non-recursive request for the DNSSEC records to servers
+// until one of them responds.
+func (r *recursor) query(servers []string, name string, qtype uint16) (resp *dns.Msg, err error) {
+	req := &dns.Msg{}
+	req.SetQuestion(name, qtype)
+	req.RecursionDesired = false
+	req.SetEdns0(dnssecUDPBufSize, true)
+
+	err = errors.Error("no nameservers")
+	for _, addr := range servers {
+		resp, err = r.exchange(req, addr)
+		if err != nil {
+			log.Debug("recursor: querying %s: %s", addr, err)
+
+			continue
+		}
+
+		switch resp.Rcode {
+		case dns.RcodeSuccess, dns.RcodeNameError:
+			return resp, nil
+		default:
+			err = fmt.Errorf("%s responded with %s", addr, dns.RcodeToString[resp.Rcode])
+		}
+	}
+
+	return nil, err
+}
+
+// findReferral returns the name of the delegated zone and the names of its
+// nameservers if resp is a referral from zone to a zone closer to name.
+func findReferral(resp *dns.Msg, zone, name string, qtype uint16) (child string, ns []string) {
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) > 0 {
+		return "", nil
+	}
+
+	for _, rr := range resp.Ns {
+		nsRR, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+
+		owner := canonicalName(nsRR.Hdr.Name)
+		if child == "" {
+			if owner == zone || !dns.IsSubDomain(zone, owner) || !dns.IsSubDomain(owner, name) {
+				continue
+			} else if qtype == dns.TypeDS && owner == name {
+				// The parent zone is authoritative for the DS records.
+				continue
+			}
+
+			child = owner
+		}
+
+		if owner == child {
+			ns = append(ns, canonicalName(nsRR.Ns))
+		}
+	}
+
+	return child, ns
+}
+
+// referralAddrs returns the addresses of the nameservers ns from the glue
+// records in resp and resolves them if there is no glue.
+func (r *recursor) referralAddrs(
+	resp *dns.Msg,
+	ns []string,
+	depth int,
+) (addrs []string, ttl time.Duration) {
+	ttl = recursorDelegationMaxTTL
+	names := stringutil.NewSet(ns...)
+
+	var addrs6 []string
+	for _, rr := range append(resp.Ns, resp.Extra...) {
+		hdr := rr.Header()
+		if rrTTL := time.Duration(hdr.Ttl) * time.Second; rrTTL < ttl && hdr.Rrtype != dns.TypeOPT {
+			ttl = rrTTL
+		}
+
+		if !names.Has(canonicalName(hdr.Name)) {
+			continue
+		}
+
+		switch rr := rr.(type) {
+		case *dns.A:
+			addrs = append(addrs, netutil.JoinHostPort(rr.A.String(), 53))
+		case *dns.AAAA:
+			addrs6 = append(addrs6, netutil.JoinHostPort(rr.AAAA.String(), 53))
+		}
+	}
+
+	if len(addrs) == 0 {
+		addrs = addrs6
+	}
+
+	if len(addrs) > 0 {
+		return addrs, ttl
+	}
+
+	for _, n := range ns {
+		nsResp, err := r.resolve(n, dns.TypeA, depth+1)
+		if err != nil {
+			log.Debug("recursor: resolving nameserver %s: %s", n, err)
+
+			continue
+		}
+
+		for _, rr := range nsResp.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				addrs = append(addrs, netutil.JoinHostPort(a.A.String(), 53))
+			}
+		}
+
+		if len(addrs) > 0 {
+			break
+		}
+	}
+
+	return addrs, ttl
+}
+
+// prepareRecursor sets up the recursive resolution and the DNSSEC validation
+// of its results.  The default upstreams of uc are replaced with the recursor.
+func (s *Server) prepareRecursor(uc *proxy.UpstreamConfig) (err error) {
+	r, err := newRecursor(s.conf.RootHints, s.conf.UpstreamTimeout)
+	if err != nil {
+		return err
+	}
+
+	anchors, err := parseTrustAnchors(s.conf.DNSSECTrustAnchors)
+	if err != nil {
+		return err
+	}
+
+	s.dnssec = newDNSSECValidator(r.lookup, anchors)
+	uc.Upstreams = []upstream.Upstream{r}
+
+	return nil
+}
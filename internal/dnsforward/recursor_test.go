@@ -0,0 +1,578 @@
+package dnsforward
+
+import (
+	"crypto"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testZone is an authoritative nameserver of a single zone for testing the
+// recursive resolution and the DNSSEC validation.
+type testZone struct {
+	// key is the zone key, it's nil for unsigned zones.
+	key *dns.DNSKEY
+
+	// priv is the private part of key.
+	priv crypto.Signer
+
+	// dataPriv is used to sign the data instead of priv if set, making the
+	// zone bogus.
+	dataPriv crypto.Signer
+
+	// name is the name of the zone.
+	name string
+
+	// nsName is the name of the zone nameserver.
+	nsName string
+
+	// rrs are the zone data.
+	rrs []dns.RR
+
+	// children are the delegated zones.
+	children []*testZone
+}
+
+// newTestZone returns a new zone served by the nameserver with ip.  The zone
+// is signed if signed is true.
+func newTestZone(t *testing.T, name string, ip net.IP, signed bool) (z *testZone) {
+	t.Helper()
+
+	z = &testZone{
+		name:   name,
+		nsName: "ns." + name,
+	}
+	if name == "." {
+		z.nsName = "ns.root-servers.net."
+	}
+
+	z.add(t, fmt.Sprintf("%s 3600 IN SOA %s %s 1 3600 600 86400 60", name, z.nsName, z.nsName))
+	z.add(t, fmt.Sprintf("%s 3600 IN NS %s", name, z.nsName))
+	z.add(t, fmt.Sprintf("%s 3600 IN A %s", z.nsName, ip))
+
+	if signed {
+		z.key, z.priv = newTestKey(t, name)
+	}
+
+	return z
+}
+
+// newTestKey generates a new zone key.
+func newTestKey(t *testing.T, name string) (key *dns.DNSKEY, priv crypto.Signer) {
+	t.Helper()
+
+	key = &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+
+	pk, err := key.Generate(256)
+	require.NoError(t, err)
+
+	priv, ok := pk.(crypto.Signer)
+	require.True(t, ok)
+
+	return key, priv
+}
+
+// add adds the record in the presentation format to the zone.
+func (z *testZone) add(t *testing.T, s string) {
+	t.Helper()
+
+	rr, err := dns.NewRR(s)
+	require.NoError(t, err)
+
+	z.rrs = append(z.rrs, rr)
+}
+
+// delegate delegates the child zone.
+func (z *testZone) delegate(t *testing.T, child *testZone) {
+	t.Helper()
+
+	z.children = append(z.children, child)
+	for _, rr := range child.rrs {
+		switch rr.Header().Rrtype {
+		case dns.TypeNS, dns.TypeA:
+			if rr.Header().Name == child.name || rr.Header().Name == child.nsName {
+				z.rrs = append(z.rrs, dns.Copy(rr))
+			}
+		}
+	}
+
+	if child.key != nil && z.key != nil {
+		z.rrs = append(z.rrs, child.key.ToDS(dns.SHA256))
+	}
+}
+
+// find returns the records of the zone with name and qtype.
+func (z *testZone) find(name string, qtype uint16) (rrs []dns.RR) {
+	for _, rr := range z.rrs {
+		if hdr := rr.Header(); hdr.Name == name && hdr.Rrtype == qtype {
+			rrs = append(rrs, rr)
+		}
+	}
+
+	return rrs
+}
+
+// signed returns rrs along with their signature if the zone is signed.
+func (z *testZone) signed(t *testing.T, rrs ...dns.RR) (res []dns.RR) {
+	t.Helper()
+
+	if z.key == nil || len(rrs) == 0 {
+		return rrs
+	}
+
+	priv := z.priv
+	if z.dataPriv != nil && rrs[0].Header().Rrtype != dns.TypeDNSKEY {
+		priv = z.dataPriv
+	}
+
+	now := time.Now()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrs[0].Header().Ttl},
+		Algorithm:  z.key.Algorithm,
+		Expiration: uint32(now.Add(time.Hour).Unix()),
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+	}
+	require.NoError(t, sig.Sign(priv, rrs))
+
+	return append(rrs, sig)
+}
+
+// names returns the sorted owner names of the zone data excluding the ones
+// below the zone cuts.
+func (z *testZone) names() (names []string) {
+	seen := map[string]bool{}
+	for _, rr := range z.rrs {
+		name := rr.Header().Name
+		if seen[name] {
+			continue
+		}
+
+		seen[name] = true
+		below := false
+		for _, c := range z.children {
+			below = below || (name != c.name && dns.IsSubDomain(c.name, name))
+		}
+
+		if !below {
+			names = append(names, name)
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool { return compareCanonical(names[i], names[j]) < 0 })
+
+	return names
+}
+
+// nsec returns the NSEC record covering or matching name.
+func (z *testZone) nsec(name string) (rr *dns.NSEC) {
+	names := z.names()
+	i := sort.Search(len(names), func(i int) bool { return compareCanonical(names[i], name) > 0 }) - 1
+	if i < 0 {
+		i = len(names) - 1
+	}
+
+	owner := names[i]
+	types := []uint16{dns.TypeRRSIG, dns.TypeNSEC}
+	if owner == z.name {
+		types = append(types, dns.TypeDNSKEY)
+	}
+
+	for _, rr := range z.rrs {
+		if hdr := rr.Header(); hdr.Name == owner && !hasType(types, hdr.Rrtype) {
+			types = append(types, hdr.Rrtype)
+		}
+	}
+
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	return &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   owner,
+			Rrtype: dns.TypeNSEC,
+			Class:  dns.ClassINET,
+			Ttl:    60,
+		},
+		NextDomain: names[(i+1)%len(names)],
+		TypeBitMap: types,
+	}
+}
+
+// respond returns the response to req.
+func (z *testZone) respond(t *testing.T, req *dns.Msg) (resp *dns.Msg) {
+	t.Helper()
+
+	q := req.Question[0]
+	name := canonicalName(q.Name)
+	resp = (&dns.Msg{}).SetReply(req)
+
+	for _, c := range z.children {
+		if !dns.IsSubDomain(c.name, name) || (q.Qtype == dns.TypeDS && name == c.name) {
+			continue
+		}
+
+		resp.Ns = z.find(c.name, dns.TypeNS)
+		if ds := z.find(c.name, dns.TypeDS); len(ds) > 0 {
+			resp.Ns = append(resp.Ns, z.signed(t, ds...)...)
+		} else if z.key != nil {
+			resp.Ns = append(resp.Ns, z.signed(t, z.nsec(c.name))...)
+		}
+		resp.Extra = z.find(c.nsName, dns.TypeA)
+
+		return resp
+	}
+
+	resp.Authoritative = true
+	if q.Qtype == dns.TypeDNSKEY && name == z.name && z.key != nil {
+		resp.Answer = z.signed(t, z.key)
+
+		return resp
+	}
+
+	if ans := z.find(name, q.Qtype); len(ans) > 0 {
+		resp.Answer = z.signed(t, ans...)
+
+		return resp
+	} else if cname := z.find(name, dns.TypeCNAME); len(cname) > 0 {
+		resp.Answer = z.signed(t, cname...)
+		target := cname[0].(*dns.CNAME).Target
+		if dns.IsSubDomain(z.name, target) {
+			resp.Answer = append(resp.Answer, z.signed(t, z.find(target, q.Qtype)...)...)
+		}
+
+		return resp
+	}
+
+	exists, ce := z.closestEncloser(name)
+	wildcard := wildcardName(ce)
+	if ans := z.find(wildcard, q.Qtype); !exists && len(ans) > 0 {
+		// Expand the wildcard and prove that there is no closer match.
+		for _, rr := range z.signed(t, ans...) {
+			rr = dns.Copy(rr)
+			rr.Header().Name = name
+			resp.Answer = append(resp.Answer, rr)
+		}
+
+		if z.key != nil {
+			resp.Ns = z.signed(t, z.nsec(name))
+		}
+
+		return resp
+	}
+
+	resp.Ns = z.signed(t, z.find(z.name, dns.TypeSOA)...)
+	if z.key != nil {
+		nsec := z.nsec(name)
+		resp.Ns = append(resp.Ns, z.signed(t, nsec)...)
+		if wcNSEC := z.nsec(wildcard); !exists && wcNSEC.Hdr.Name != nsec.Hdr.Name {
+			resp.Ns = append(resp.Ns, z.signed(t, wcNSEC)...)
+		}
+	}
+
+	if !exists {
+		resp.Rcode = dns.RcodeNameError
+	}
+
+	return resp
+}
+
+// closestEncloser returns true if name exists in the zone.  Otherwise, it
+// returns its closest existing ancestor.
+func (z *testZone) closestEncloser(name string) (exists bool, ce string) {
+	names := z.names()
+	for _, n := range names {
+		if n == name {
+			return true, name
+		}
+	}
+
+	for ce = parentName(name); ce != z.name; ce = parentName(ce) {
+		for _, n := range names {
+			// The empty non-terminals exist as well.
+			if dns.IsSubDomain(ce, n) {
+				return false, ce
+			}
+		}
+	}
+
+	return false, ce
+}
+
+// testHierarchy is a set of test zones mapped by the addresses of their
+// nameservers.
+type testHierarchy map[string]*testZone
+
+// exchange imitates sending req to the nameserver at addr.
+func (h testHierarchy) exchange(t *testing.T) (f func(req *dns.Msg, addr string) (*dns.Msg, error)) {
+	return func(req *dns.Msg, addr string) (resp *dns.Msg, err error) {
+		z, ok := h[addr]
+		if !ok {
+			return nil, fmt.Errorf("no server at %s", addr)
+		}
+
+		return z.respond(t, req), nil
+	}
+}
+
+// newTestHierarchy returns the hierarchy of the test zones and the trust
+// anchor for its root zone.  The zones are:
+//
+//   .                 signed, served by 192.0.2.1
+//   test.             signed, served by 192.0.2.2
+//   example.test.     signed, served by 192.0.2.3
+//   insecure.test.    unsigned, served by 192.0.2.4
+//   bogus.test.       signed with a wrong key, served by 192.0.2.5
+//
+func newTestHierarchy(t *testing.T) (h testHierarchy, anchor *dns.DS) {
+	t.Helper()
+
+	root := newTestZone(t, ".", net.IP{192, 0, 2, 1}, true)
+	tld := newTestZone(t, "test.", net.IP{192, 0, 2, 2}, true)
+
+	example := newTestZone(t, "example.test.", net.IP{192, 0, 2, 3}, true)
+	example.add(t, "www.example.test. 300 IN A 192.0.2.100")
+	example.add(t, "alias.example.test. 300 IN CNAME www.example.test.")
+	example.add(t, "other.example.test. 300 IN CNAME www.insecure.test.")
+	example.add(t, "*.wild.example.test. 300 IN A 192.0.2.110")
+	example.add(t, "host.wild.example.test. 300 IN A 192.0.2.111")
+
+	insecure := newTestZone(t, "insecure.test.", net.IP{192, 0, 2, 4}, false)
+	insecure.add(t, "www.insecure.test. 300 IN A 192.0.2.101")
+
+	bogus := newTestZone(t, "bogus.test.", net.IP{192, 0, 2, 5}, true)
+	bogus.add(t, "www.bogus.test. 300 IN A 192.0.2.102")
+	_, bogus.dataPriv = newTestKey(t, "bogus.test.")
+
+	tld.delegate(t, example)
+	tld.delegate(t, insecure)
+	tld.delegate(t, bogus)
+	root.delegate(t, tld)
+
+	h = testHierarchy{
+		"192.0.2.1:53": root,
+		"192.0.2.2:53": tld,
+		"192.0.2.3:53": example,
+		"192.0.2.4:53": insecure,
+		"192.0.2.5:53": bogus,
+	}
+
+	return h, root.key.ToDS(dns.SHA256)
+}
+
+// newTestRecursor returns a recursor resolving the names in h.
+func newTestRecursor(t *testing.T, h testHierarchy) (r *recursor) {
+	t.Helper()
+
+	r, err := newRecursor([]string{"192.0.2.1"}, time.Second)
+	require.NoError(t, err)
+
+	r.exchange = h.exchange(t)
+
+	return r
+}
+
+func TestRecursor_Exchange(t *testing.T) {
+	h, _ := newTestHierarchy(t)
+	r := newTestRecursor(t, h)
+
+	testCases := []struct {
+		name      string
+		host      string
+		wantIP    net.IP
+		wantRcode int
+	}{{
+		name:      "secure",
+		host:      "www.example.test.",
+		wantIP:    net.IP{192, 0, 2, 100},
+		wantRcode: dns.RcodeSuccess,
+	}, {
+		name:      "insecure",
+		host:      "www.insecure.test.",
+		wantIP:    net.IP{192, 0, 2, 101},
+		wantRcode: dns.RcodeSuccess,
+	}, {
+		name:      "cname",
+		host:      "alias.example.test.",
+		wantIP:    net.IP{192, 0, 2, 100},
+		wantRcode: dns.RcodeSuccess,
+	}, {
+		name:      "cname_other_zone",
+		host:      "other.example.test.",
+		wantIP:    net.IP{192, 0, 2, 101},
+		wantRcode: dns.RcodeSuccess,
+	}, {
+		name:      "nxdomain",
+		host:      "nx.example.test.",
+		wantIP:    nil,
+		wantRcode: dns.RcodeNameError,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := r.Exchange(createTestMessage(tc.host))
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+			assert.True(t, resp.RecursionAvailable)
+
+			var ip net.IP
+			for _, rr := range resp.Answer {
+				if a, ok := rr.(*dns.A); ok {
+					ip = a.A
+				}
+			}
+
+			assert.Equal(t, tc.wantIP.To16(), ip.To16())
+		})
+	}
+
+	t.Run("delegations", func(t *testing.T) {
+		zone, addrs := r.closest("www.example.test.", false)
+		assert.Equal(t, "example.test.", zone)
+		assert.Equal(t, []string{"192.0.2.3:53"}, addrs)
+
+		zone, addrs = r.closest("example.test.", true)
+		assert.Equal(t, "test.", zone)
+		assert.Equal(t, []string{"192.0.2.2:53"}, addrs)
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		delete(h, "192.0.2.1:53")
+		_, err := r.Exchange(createTestMessage("www.unknown."))
+		assert.Error(t, err)
+	})
+}
+
+func TestServer_recursiveResolution(t *testing.T) {
+	h, anchor := newTestHierarchy(t)
+
+	s := createTestServer(t, &filtering.Config{}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		FilteringConfig: FilteringConfig{
+			RecursiveResolution: true,
+			RootHints:           []string{"192.0.2.1"},
+			DNSSECTrustAnchors:  []string{anchor.String()},
+		},
+	}, nil)
+
+	require.Len(t, s.conf.UpstreamConfig.Upstreams, 1)
+	r, ok := s.conf.UpstreamConfig.Upstreams[0].(*recursor)
+	require.True(t, ok)
+
+	r.exchange = h.exchange(t)
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantStatus dnssecStatus
+		wantRcode  int
+		do         bool
+		cd         bool
+		wantAD     bool
+		wantSig    bool
+	}{{
+		name:       "secure",
+		host:       "www.example.test.",
+		wantStatus: dnssecStatusSecure,
+		wantRcode:  dns.RcodeSuccess,
+		do:         false,
+		cd:         false,
+		wantAD:     false,
+		wantSig:    false,
+	}, {
+		name:       "secure_do",
+		host:       "www.example.test.",
+		wantStatus: dnssecStatusSecure,
+		wantRcode:  dns.RcodeSuccess,
+		do:         true,
+		cd:         false,
+		wantAD:     true,
+		wantSig:    true,
+	}, {
+		name:       "insecure",
+		host:       "www.insecure.test.",
+		wantStatus: dnssecStatusInsecure,
+		wantRcode:  dns.RcodeSuccess,
+		do:         true,
+		cd:         false,
+		wantAD:     false,
+		wantSig:    false,
+	}, {
+		name:       "bogus",
+		host:       "www.bogus.test.",
+		wantStatus: dnssecStatusBogus,
+		wantRcode:  dns.RcodeServerFailure,
+		do:         true,
+		cd:         false,
+		wantAD:     false,
+		wantSig:    false,
+	}, {
+		name:       "bogus_cd",
+		host:       "www.bogus.test.",
+		wantStatus: dnssecStatusBogus,
+		wantRcode:  dns.RcodeSuccess,
+		do:         true,
+		cd:         true,
+		wantAD:     false,
+		wantSig:    true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := createTestMessage(tc.host)
+			req.CheckingDisabled = tc.cd
+			if tc.do {
+				req.SetEdns0(dns.DefaultMsgSize, true)
+			}
+
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Proto: proxy.ProtoUDP,
+					Req:   req,
+					Addr:  &net.UDPAddr{IP: net.IP{192, 168, 0, 1}},
+				},
+			}
+
+			rc := s.processUpstream(dctx)
+			require.Equal(t, resultCodeSuccess, rc)
+
+			resp := dctx.proxyCtx.Res
+			require.NotNil(t, resp)
+
+			assert.Equal(t, tc.wantStatus, dctx.dnssecStatus)
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+			assert.Equal(t, tc.wantAD, resp.AuthenticatedData)
+
+			hasSig := false
+			for _, rr := range resp.Answer {
+				hasSig = hasSig || rr.Header().Rrtype == dns.TypeRRSIG
+			}
+			assert.Equal(t, tc.wantSig, hasSig)
+
+			if !tc.do {
+				assert.Nil(t, req.IsEdns0())
+				assert.Nil(t, resp.IsEdns0())
+			}
+		})
+	}
+}
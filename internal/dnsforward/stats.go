@@ -50,6 +50,7 @@ func (s *Server) processQueryLogsAndStats(dctx *dnsContext) (rc resultCode) {
 			ClientID:          dctx.clientID,
 			ClientIP:          ip,
 			AuthenticatedData: dctx.responseAD,
+			DNSSECStatus:      string(dctx.dnssecStatus),
 		}
 
 		switch pctx.Proto {
@@ -77,6 +78,10 @@ func (s *Server) processQueryLogsAndStats(dctx *dnsContext) (rc resultCode) {
 
 	s.updateStats(dctx, elapsed, *dctx.result, ip)
 
+	if s.dnsFilter != nil {
+		s.dnsFilter.RecordHits(dctx.result)
+	}
+
 	return resultCodeSuccess
 }
 
@@ -111,9 +116,13 @@ func (s *Server) updateStats(
 		e.Result = stats.RParental
 	case filtering.FilteredSafeSearch:
 		e.Result = stats.RSafeSearch
+	case filtering.FilteredThreatFeed:
+		e.Result = stats.RThreatFeed
+		e.ThreatFeed = res.ThreatFeed
 	case filtering.FilteredBlockList,
 		filtering.FilteredInvalid,
-		filtering.FilteredBlockedService:
+		filtering.FilteredBlockedService,
+		filtering.FilteredAnswerPolicy:
 		e.Result = stats.RFiltered
 	}
 
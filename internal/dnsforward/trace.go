@@ -0,0 +1,546 @@
+package dnsforward
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
+)
+
+// traceAction is the action a step of the request processing has taken.
+type traceAction string
+
+// Valid traceAction values.
+const (
+	traceActionSkipped   traceAction = "skipped"
+	traceActionPassed    traceAction = "passed"
+	traceActionAllowed   traceAction = "allowed"
+	traceActionBlocked   traceAction = "blocked"
+	traceActionRewritten traceAction = "rewritten"
+	traceActionAnswered  traceAction = "answered"
+	traceActionFailed    traceAction = "failed"
+)
+
+// traceRuleJSON is the JSON representation of a rule that has decided a step.
+type traceRuleJSON struct {
+	Text         string `json:"text"`
+	FilterListID int64  `json:"filter_list_id"`
+}
+
+// traceStepJSON is the JSON representation of a step of the request
+// processing.
+type traceStepJSON struct {
+	// Name is the name of the step.
+	Name string `json:"name"`
+
+	// Action is what the step has done with the request.
+	Action traceAction `json:"action"`
+
+	// Details explains the action, for example the setting that has
+	// disabled the step.
+	Details string `json:"details"`
+
+	// FilteringReason is the filtering reason of the result of the step, if
+	// any.
+	FilteringReason string `json:"filtering_reason,omitempty"`
+
+	// Rules are the rules that have decided the step, if any.
+	Rules []*traceRuleJSON `json:"rules,omitempty"`
+}
+
+// traceJSON is the JSON representation of the trace of a request.
+type traceJSON struct {
+	// Steps are the steps of the request processing, in order.
+	Steps []*traceStepJSON `json:"steps"`
+
+	// Answer are the answer records of the resulting response, if any.
+	Answer []string `json:"answer"`
+
+	// Rcode is the response code of the resulting response.  It's empty if
+	// there is no response.
+	Rcode string `json:"rcode"`
+}
+
+// add appends a new step to t and returns it.
+func (t *traceJSON) add(
+	name string,
+	action traceAction,
+	format string,
+	args ...interface{},
+) (step *traceStepJSON) {
+	step = &traceStepJSON{
+		Name:    name,
+		Action:  action,
+		Details: fmt.Sprintf(format, args...),
+	}
+	t.Steps = append(t.Steps, step)
+
+	return step
+}
+
+// setResult sets the filtering reason and the rules of step from res.
+func (step *traceStepJSON) setResult(res *filtering.Result) {
+	step.FilteringReason = res.Reason.String()
+	for _, r := range res.Rules {
+		step.Rules = append(step.Rules, &traceRuleJSON{
+			Text:         r.Text,
+			FilterListID: r.FilterListID,
+		})
+	}
+}
+
+// traceFunc is a step of tracing.  It returns resultCodeFinish if the
+// response has been decided.
+type traceFunc func(t *traceJSON, dctx *dnsContext) (rc resultCode)
+
+// trace processes the request for host with qtype from the client with ip
+// and clientID the same way handleDNSRequest does and records each of the
+// processing steps.  The responses cache isn't used, and nothing is written to
+// the query log or the statistics.
+func (s *Server) trace(host string, qtype uint16, ip net.IP, clientID string) (t *traceJSON) {
+	req := &dns.Msg{}
+	req.SetQuestion(dns.Fqdn(host), qtype)
+
+	dctx := &dnsContext{
+		proxyCtx: &proxy.DNSContext{
+			Proto: proxy.ProtoUDP,
+			Req:   req,
+			Addr:  &net.UDPAddr{IP: ip},
+		},
+		result:    &filtering.Result{},
+		clientID:  clientID,
+		startTime: time.Now(),
+	}
+
+	t = &traceJSON{
+		Steps:  []*traceStepJSON{},
+		Answer: []string{},
+	}
+
+	steps := []traceFunc{
+		s.traceAccess,
+		s.traceInitial,
+		s.traceInternalHosts,
+		s.traceFiltering,
+		s.traceUpstream,
+		s.traceResponseFiltering,
+	}
+	for _, step := range steps {
+		if step(t, dctx) != resultCodeSuccess {
+			break
+		}
+	}
+
+	if resp := dctx.proxyCtx.Res; resp != nil {
+		t.Rcode = dns.RcodeToString[resp.Rcode]
+		for _, rr := range resp.Answer {
+			t.Answer = append(t.Answer, rr.String())
+		}
+	}
+
+	return t
+}
+
+// traceAccess records the access settings step.
+func (s *Server) traceAccess(t *traceJSON, dctx *dnsContext) (rc resultCode) {
+	const name = "access list"
+
+	ip, _ := netutil.IPAndPortFromAddr(dctx.proxyCtx.Addr)
+	blocked, rule := s.IsBlockedClient(ip, dctx.clientID)
+	if blocked {
+		if s.access.allowlistMode() {
+			t.add(name, traceActionBlocked, "client is not in the allowed clients")
+		} else {
+			t.add(name, traceActionBlocked, "client matches disallowed clients entry %q", rule)
+		}
+
+		return resultCodeFinish
+	}
+
+	host := strings.TrimSuffix(dctx.proxyCtx.Req.Question[0].Name, ".")
+	if s.access.isBlockedHost(host) {
+		t.add(name, traceActionBlocked, "host is in disallowed domains")
+
+		return resultCodeFinish
+	}
+
+	t.add(name, traceActionPassed, "client and host are allowed")
+
+	return resultCodeSuccess
+}
+
+// traceInitial records the steps of processInitial.
+func (s *Server) traceInitial(t *traceJSON, dctx *dnsContext) (rc resultCode) {
+	d := dctx.proxyCtx
+	q := d.Req.Question[0]
+	if s.conf.AAAADisabled && q.Qtype == dns.TypeAAAA {
+		_ = proxy.CheckDisabledAAAARequest(d, true)
+		t.add("aaaa disabled", traceActionAnswered, "aaaa_disabled is set")
+
+		return resultCodeFinish
+	}
+
+	if (q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA) && q.Name == "use-application-dns.net." {
+		d.Res = s.genNXDomain(d.Req)
+		t.add("doh canary", traceActionAnswered, "host is the canary domain of Mozilla DoH")
+
+		return resultCodeFinish
+	}
+
+	dctx.protectionEnabled = s.conf.ProtectionEnabled
+	dctx.setts = s.getClientRequestFilteringSettings(dctx)
+
+	t.add("client settings", traceActionPassed, "%s", describeSettings(dctx.setts))
+
+	return resultCodeSuccess
+}
+
+// describeSettings returns a human-readable description of setts.
+func describeSettings(setts *filtering.Settings) (desc string) {
+	onOff := func(ok bool) (s string) {
+		if ok {
+			return "on"
+		}
+
+		return "off"
+	}
+
+	b := &strings.Builder{}
+	if setts.ClientName != "" {
+		stringutil.WriteToBuilder(b, "client ", setts.ClientName, ": ")
+	} else {
+		stringutil.WriteToBuilder(b, "global settings: ")
+	}
+
+	stringutil.WriteToBuilder(
+		b,
+		"protection ", onOff(setts.ProtectionEnabled),
+		", filtering ", onOff(setts.FilteringEnabled),
+		", safe browsing ", onOff(setts.SafeBrowsingEnabled),
+		", parental ", onOff(setts.ParentalEnabled),
+		", safe search ", onOff(setts.SafeSearchEnabled),
+	)
+
+	if len(setts.ClientTags) > 0 {
+		stringutil.WriteToBuilder(b, ", tags ", strings.Join(setts.ClientTags, " "))
+	}
+
+	if setts.FilterListIDs != nil {
+		_, _ = fmt.Fprintf(b, ", filter lists %v", setts.FilterListIDs)
+	} else {
+		stringutil.WriteToBuilder(b, ", global filter lists")
+	}
+
+	svcs := make([]string, 0, len(setts.ServicesRules))
+	for _, s := range setts.ServicesRules {
+		svcs = append(svcs, s.Name)
+	}
+
+	if len(svcs) > 0 {
+		stringutil.WriteToBuilder(b, ", blocked services ", strings.Join(svcs, " "))
+	} else {
+		stringutil.WriteToBuilder(b, ", no blocked services")
+	}
+
+	return b.String()
+}
+
+// traceInternalHosts records the step of processInternalHosts.
+func (s *Server) traceInternalHosts(t *traceJSON, dctx *dnsContext) (rc resultCode) {
+	const name = "dhcp hosts"
+
+	_ = s.processDetermineLocal(dctx)
+	_ = s.processInternalHosts(dctx)
+
+	resp := dctx.proxyCtx.Res
+	switch {
+	case resp == nil:
+		return resultCodeSuccess
+	case !dctx.isLocalClient:
+		t.add(name, traceActionAnswered, "internal hosts are only resolved for local clients")
+	case resp.Rcode == dns.RcodeNameError:
+		t.add(name, traceActionAnswered, "host has no dhcp lease")
+	default:
+		t.add(name, traceActionAnswered, "host has a dhcp lease")
+	}
+
+	return resultCodeFinish
+}
+
+// traceFiltering records the steps of processFilteringBeforeRequest.
+func (s *Server) traceFiltering(t *traceJSON, dctx *dnsContext) (rc resultCode) {
+	q := dctx.proxyCtx.Req.Question[0]
+	host := strings.TrimSuffix(q.Name, ".")
+
+	var steps []*filtering.TraceStep
+	var err error
+	func() {
+		s.serverLock.RLock()
+		defer s.serverLock.RUnlock()
+
+		if s.dnsFilter != nil {
+			steps, _, err = s.dnsFilter.TraceHost(host, q.Qtype, dctx.setts)
+		}
+	}()
+	if err != nil {
+		t.add("filtering", traceActionFailed, "%s", err)
+
+		return resultCodeError
+	}
+
+	for _, fs := range steps {
+		addFilteringStep(t, fs, dctx.setts)
+	}
+
+	// Apply the result to the request the same way the actual processing
+	// does.
+	rc = s.processFilteringBeforeRequest(dctx)
+	if rc != resultCodeSuccess {
+		t.add("filtering", traceActionFailed, "%s", dctx.err)
+
+		return rc
+	}
+
+	if dctx.proxyCtx.Res != nil {
+		return resultCodeFinish
+	}
+
+	return resultCodeSuccess
+}
+
+// addFilteringStep adds a step from the trace of the filtering checks.
+func addFilteringStep(t *traceJSON, fs *filtering.TraceStep, setts *filtering.Settings) {
+	if !fs.Enabled {
+		details := "disabled in settings"
+		if !setts.ProtectionEnabled {
+			details = "protection is disabled"
+		}
+
+		t.add(fs.Name, traceActionSkipped, "%s", details)
+
+		return
+	}
+
+	if !fs.Matched() {
+		t.add(fs.Name, traceActionPassed, "no match")
+
+		return
+	}
+
+	res := &fs.Result
+
+	var step *traceStepJSON
+	switch {
+	case res.IsFiltered && res.ServiceName != "":
+		step = t.add(fs.Name, traceActionBlocked, "service %s is blocked", res.ServiceName)
+	case res.IsFiltered && res.ThreatFeed != "":
+		step = t.add(fs.Name, traceActionBlocked, "host is in threat feed %s", res.ThreatFeed)
+	case res.IsFiltered:
+		step = t.add(fs.Name, traceActionBlocked, "host is blocked")
+	case res.Reason == filtering.NotFilteredAllowList:
+		step = t.add(fs.Name, traceActionAllowed, "host is allowed")
+	case res.CanonName != "":
+		step = t.add(fs.Name, traceActionRewritten, "host is rewritten to %s", res.CanonName)
+	default:
+		step = t.add(fs.Name, traceActionRewritten, "host is rewritten")
+	}
+
+	step.setResult(res)
+}
+
+// upstreamsForDomain returns the upstreams of uc used for host as well as the
+// domain they're specified for.  domain is empty if the default upstreams are
+// used.  It mirrors the logic of the proxy.
+func upstreamsForDomain(uc *proxy.UpstreamConfig, host string) (ups []upstream.Upstream, domain string) {
+	if len(uc.DomainReservedUpstreams) == 0 {
+		return uc.Upstreams, ""
+	}
+
+	dotsCount := strings.Count(host, ".")
+	if dotsCount < 2 {
+		host = proxy.UnqualifiedNames
+	} else {
+		host = strings.ToLower(host)
+	}
+
+	for i := 1; i <= dotsCount; i++ {
+		h := strings.SplitAfterN(host, ".", i)
+		name := h[i-1]
+
+		var ok bool
+		ups, ok = uc.DomainReservedUpstreams[name]
+		if !ok {
+			continue
+		} else if len(ups) == 0 {
+			// The domain has been excluded from reserved upstreams
+			// querying.
+			return uc.Upstreams, ""
+		}
+
+		return ups, name
+	}
+
+	return uc.Upstreams, ""
+}
+
+// traceUpstream records the step of processUpstream.
+func (s *Server) traceUpstream(t *traceJSON, dctx *dnsContext) (rc resultCode) {
+	const name = "upstream"
+
+	pctx := dctx.proxyCtx
+	desc := "default upstreams"
+	uc := s.conf.UpstreamConfig
+	if s.conf.GetCustomUpstreamByClient != nil {
+		id := stringutil.Coalesce(dctx.clientID, ipStringFromAddr(pctx.Addr))
+		upsConf, err := s.conf.GetCustomUpstreamByClient(id)
+		if err != nil {
+			t.add(name, traceActionFailed, "getting custom upstreams for client %s: %s", id, err)
+
+			return resultCodeError
+		} else if upsConf != nil {
+			pctx.CustomUpstreamConfig = upsConf
+			uc = upsConf
+			desc = "custom upstreams of client " + id
+		}
+	}
+
+	var addrs []string
+	if uc != nil {
+		ups, domain := upstreamsForDomain(uc, pctx.Req.Question[0].Name)
+		if domain != "" {
+			desc = fmt.Sprintf("%s for domain %s", desc, domain)
+		}
+
+		for _, u := range ups {
+			addrs = append(addrs, u.Address())
+		}
+	}
+
+	err := s.exchangeUpstream(dctx)
+	if err != nil {
+		t.add(name, traceActionFailed, "%s %s: %s", desc, strings.Join(addrs, " "), err)
+
+		return resultCodeError
+	}
+
+	var from string
+	if pctx.Upstream != nil {
+		from = pctx.Upstream.Address()
+	}
+
+	t.add(name, traceActionAnswered, "%s %s: response from %s", desc, strings.Join(addrs, " "), from)
+
+	return resultCodeSuccess
+}
+
+// traceResponseFiltering records the step of processFilteringAfterResponse.
+func (s *Server) traceResponseFiltering(t *traceJSON, dctx *dnsContext) (rc resultCode) {
+	const name = "response filtering"
+
+	switch dctx.result.Reason {
+	case filtering.NotFilteredAllowList:
+		t.add(name, traceActionSkipped, "host is allowed")
+	case filtering.Rewritten, filtering.RewrittenRule:
+		t.add(name, traceActionSkipped, "host is rewritten")
+	default:
+		if !dctx.protectionEnabled {
+			t.add(name, traceActionSkipped, "protection is disabled")
+
+			break
+		}
+
+		rc = s.processFilteringAfterResponse(dctx)
+		if rc != resultCodeSuccess {
+			t.add(name, traceActionFailed, "%s", dctx.err)
+
+			return rc
+		}
+
+		var step *traceStepJSON
+		switch policy := dctx.result.AnswerPolicy; {
+		case dctx.origResp == nil:
+			t.add(name, traceActionPassed, "no match")
+
+			return resultCodeSuccess
+		case policy != "":
+			step = t.add(name, traceActionBlocked, "response is blocked by answer policy %s", policy)
+		default:
+			step = t.add(name, traceActionBlocked, "response contains a blocked cname or ip address")
+		}
+
+		step.setResult(dctx.result)
+
+		return resultCodeSuccess
+	}
+
+	return s.processFilteringAfterResponse(dctx)
+}
+
+// handleTrace is the handler for the GET /control/dns_trace HTTP API.
+func (s *Server) handleTrace(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("name") == "" {
+		aghhttp.Error(r, w, http.StatusBadRequest, "no name specified")
+
+		return
+	}
+
+	typ := q.Get("qtype")
+	if typ == "" {
+		typ = "A"
+	}
+
+	name, qtype, err := parseCacheQuery(q.Get("name"), strings.ToUpper(typ))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	// Use the address of the requester if the client is identified by its
+	// ClientID or isn't specified at all, since the requests always have
+	// one.
+	var clientID string
+	client := q.Get("client")
+	ip := net.ParseIP(client)
+	if ip == nil {
+		if client != "" {
+			err = ValidateClientID(client)
+			if err != nil {
+				aghhttp.Error(r, w, http.StatusBadRequest, "bad client: %s", err)
+
+				return
+			}
+
+			clientID = client
+		}
+
+		var host string
+		host, err = netutil.SplitHost(r.RemoteAddr)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "bad remote address: %s", err)
+
+			return
+		}
+
+		ip = net.ParseIP(host)
+	}
+
+	t := s.trace(strings.TrimSuffix(name, "."), qtype, ip, clientID)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(t)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "encoding response: %s", err)
+	}
+}
@@ -0,0 +1,116 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_trace(t *testing.T) {
+	forwardConf := ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		FilteringConfig: FilteringConfig{
+			ProtectionEnabled: true,
+			BlockingMode:      BlockingModeDefault,
+		},
+	}
+	s := createTestServer(t, &filtering.Config{}, forwardConf, nil)
+	s.conf.UpstreamConfig.Upstreams = []upstream.Upstream{&aghtest.TestUpstream{
+		CName: testCNAMEs,
+		IPv4:  testIPv4,
+	}}
+
+	var err error
+	s.access, err = newAccessCtx(nil, []string{"3.3.3.3"}, nil)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		host      string
+		ip        net.IP
+		wantSteps []string
+		wantLast  traceAction
+		wantRcode string
+	}{{
+		name: "blocklist",
+		host: "nxdomain.example.org",
+		ip:   net.IP{1, 2, 3, 4},
+		wantSteps: []string{
+			"access list",
+			"client settings",
+			filtering.TraceStepRewrites,
+			"hosts container",
+			filtering.TraceStepAllowlist,
+			filtering.TraceStepDNSRewrite,
+			filtering.TraceStepBlocklist,
+		},
+		wantLast:  traceActionBlocked,
+		wantRcode: dns.RcodeToString[dns.RcodeSuccess],
+	}, {
+		name: "allowlist",
+		host: "whitelist.example.org",
+		ip:   net.IP{1, 2, 3, 4},
+		wantSteps: []string{
+			"access list",
+			"client settings",
+			filtering.TraceStepRewrites,
+			"hosts container",
+			filtering.TraceStepAllowlist,
+			"upstream",
+			"response filtering",
+		},
+		wantLast:  traceActionSkipped,
+		wantRcode: dns.RcodeToString[dns.RcodeSuccess],
+	}, {
+		name:      "access",
+		host:      "example.org",
+		ip:        net.IP{3, 3, 3, 3},
+		wantSteps: []string{"access list"},
+		wantLast:  traceActionBlocked,
+		wantRcode: "",
+	}, {
+		name: "response",
+		host: "badhost",
+		ip:   net.IP{1, 2, 3, 4},
+		wantSteps: []string{
+			"access list",
+			"client settings",
+			filtering.TraceStepRewrites,
+			"hosts container",
+			filtering.TraceStepAllowlist,
+			filtering.TraceStepDNSRewrite,
+			filtering.TraceStepBlocklist,
+			"blocked services",
+			"safe browsing",
+			"parental",
+			"safe search",
+			"upstream",
+			"response filtering",
+		},
+		wantLast:  traceActionBlocked,
+		wantRcode: dns.RcodeToString[dns.RcodeSuccess],
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := s.trace(tc.host, dns.TypeA, tc.ip, "")
+			require.NotEmpty(t, tr.Steps)
+
+			names := make([]string, 0, len(tr.Steps))
+			for _, step := range tr.Steps {
+				names = append(names, step.Name)
+			}
+
+			assert.Equal(t, tc.wantSteps, names)
+			assert.Equal(t, tc.wantLast, tr.Steps[len(tr.Steps)-1].Action)
+			assert.Equal(t, tc.wantRcode, tr.Rcode)
+		})
+	}
+}
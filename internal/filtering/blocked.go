@@ -6,13 +6,11 @@ import (
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/golibs/log"
-	"github.com/AdguardTeam/urlfilter/rules"
 )
 
-var serviceRules map[string][]*rules.NetworkRule // service name -> filtering rules
-
 type svc struct {
 	name  string
+	title string
 	rules []string
 }
 
@@ -20,8 +18,8 @@ type svc struct {
 // client/src/helpers/constants.js
 // client/src/components/ui/Icons.js
 var serviceRulesArray = []svc{
-	{"whatsapp", []string{"||whatsapp.net^", "||whatsapp.com^"}},
-	{"facebook", []string{
+	{"whatsapp", "WhatsApp", []string{"||whatsapp.net^", "||whatsapp.com^"}},
+	{"facebook", "Facebook", []string{
 		"||facebook.com^",
 		"||facebook.net^",
 		"||fbcdn.net^",
@@ -34,8 +32,8 @@ var serviceRulesArray = []svc{
 		"||fbcdn.com^",
 		"||fb.watch^",
 	}},
-	{"twitter", []string{"||twitter.com^", "||twttr.com^", "||t.co^", "||twimg.com^"}},
-	{"youtube", []string{
+	{"twitter", "Twitter", []string{"||twitter.com^", "||twttr.com^", "||t.co^", "||twimg.com^"}},
+	{"youtube", "YouTube", []string{
 		"||youtube.com^",
 		"||ytimg.com^",
 		"||youtu.be^",
@@ -44,10 +42,10 @@ var serviceRulesArray = []svc{
 		"||youtube-nocookie.com^",
 		"||youtube",
 	}},
-	{"twitch", []string{"||twitch.tv^", "||ttvnw.net^", "||jtvnw.net^", "||twitchcdn.net^"}},
-	{"netflix", []string{"||nflxext.com^", "||netflix.com^", "||nflximg.net^", "||nflxvideo.net^", "||nflxso.net^"}},
-	{"instagram", []string{"||instagram.com^", "||cdninstagram.com^"}},
-	{"snapchat", []string{
+	{"twitch", "Twitch", []string{"||twitch.tv^", "||ttvnw.net^", "||jtvnw.net^", "||twitchcdn.net^"}},
+	{"netflix", "Netflix", []string{"||nflxext.com^", "||netflix.com^", "||nflximg.net^", "||nflxvideo.net^", "||nflxso.net^"}},
+	{"instagram", "Instagram", []string{"||instagram.com^", "||cdninstagram.com^"}},
+	{"snapchat", "Snapchat", []string{
 		"||snapchat.com^",
 		"||sc-cdn.net^",
 		"||snap-dev.net^",
@@ -55,12 +53,12 @@ var serviceRulesArray = []svc{
 		"||snapads.com^",
 		"||impala-media-production.s3.amazonaws.com^",
 	}},
-	{"discord", []string{"||discord.gg^", "||discordapp.net^", "||discordapp.com^", "||discord.com^", "||discord.media^"}},
-	{"ok", []string{"||ok.ru^"}},
-	{"skype", []string{"||skype.com^", "||skypeassets.com^"}},
-	{"vk", []string{"||vk.com^", "||userapi.com^", "||vk-cdn.net^", "||vkuservideo.net^"}},
-	{"origin", []string{"||origin.com^", "||signin.ea.com^", "||accounts.ea.com^"}},
-	{"steam", []string{
+	{"discord", "Discord", []string{"||discord.gg^", "||discordapp.net^", "||discordapp.com^", "||discord.com^", "||discord.media^"}},
+	{"ok", "OK.ru", []string{"||ok.ru^"}},
+	{"skype", "Skype", []string{"||skype.com^", "||skypeassets.com^"}},
+	{"vk", "VK.com", []string{"||vk.com^", "||userapi.com^", "||vk-cdn.net^", "||vkuservideo.net^"}},
+	{"origin", "Origin", []string{"||origin.com^", "||signin.ea.com^", "||accounts.ea.com^"}},
+	{"steam", "Steam", []string{
 		"||steam.com^",
 		"||steampowered.com^",
 		"||steamcommunity.com^",
@@ -68,10 +66,10 @@ var serviceRulesArray = []svc{
 		"||steamstore-a.akamaihd.net^",
 		"||steamcdn-a.akamaihd.net^",
 	}},
-	{"epic_games", []string{"||epicgames.com^", "||easyanticheat.net^", "||easy.ac^", "||eac-cdn.com^"}},
-	{"reddit", []string{"||reddit.com^", "||redditstatic.com^", "||redditmedia.com^", "||redd.it^"}},
-	{"mail_ru", []string{"||mail.ru^"}},
-	{"cloudflare", []string{
+	{"epic_games", "Epic Games", []string{"||epicgames.com^", "||easyanticheat.net^", "||easy.ac^", "||eac-cdn.com^"}},
+	{"reddit", "Reddit", []string{"||reddit.com^", "||redditstatic.com^", "||redditmedia.com^", "||redd.it^"}},
+	{"mail_ru", "Mail.ru", []string{"||mail.ru^"}},
+	{"cloudflare", "CloudFlare", []string{
 		"||cloudflare.com^",
 		"||cloudflare-dns.com^",
 		"||cloudflare.net^",
@@ -87,7 +85,7 @@ var serviceRulesArray = []svc{
 		"||1.1.1.1^",
 		"||dns4torpnlfs2ifuz2s2yf3fc7rdmsbhm6rw75euj35pac6ap25zgqad.onion^",
 	}},
-	{"amazon", []string{
+	{"amazon", "Amazon", []string{
 		"||amazon.com^",
 		"||media-amazon.com^",
 		"||primevideo.com^",
@@ -115,7 +113,7 @@ var serviceRulesArray = []svc{
 		"||createspace.com^",
 		"||aws",
 	}},
-	{"ebay", []string{
+	{"ebay", "EBay", []string{
 		"||ebay.com^",
 		"||ebayimg.com^",
 		"||ebaystatic.com^",
@@ -142,7 +140,7 @@ var serviceRulesArray = []svc{
 		"||ebay.com.sg^",
 		"||ebay.co.uk^",
 	}},
-	{"tiktok", []string{
+	{"tiktok", "TikTok", []string{
 		"||tiktok.com^",
 		"||tiktokcdn.com^",
 		"||musical.ly^",
@@ -163,58 +161,58 @@ var serviceRulesArray = []svc{
 		"||douyin.com^",
 		"||tiktokv.com^",
 	}},
-	{"vimeo", []string{
+	{"vimeo", "Vimeo", []string{
 		"||vimeo.com^",
 		"||vimeocdn.com^",
 		"*vod-adaptive.akamaized.net^",
 	}},
-	{"pinterest", []string{
+	{"pinterest", "Pinterest", []string{
 		"||pinterest.*^",
 		"||pinimg.com^",
 	}},
-	{"imgur", []string{
+	{"imgur", "Imgur", []string{
 		"||imgur.com^",
 	}},
-	{"dailymotion", []string{
+	{"dailymotion", "Dailymotion", []string{
 		"||dailymotion.com^",
 		"||dm-event.net^",
 		"||dmcdn.net^",
 	}},
-	{"qq", []string{
+	{"qq", "QQ", []string{
 		// block qq.com and subdomains excluding WeChat domains
 		"^(?!weixin|wx)([^.]+\\.)?qq\\.com$",
 		"||qqzaixian.com^",
 	}},
-	{"wechat", []string{
+	{"wechat", "WeChat", []string{
 		"||wechat.com^",
 		"||weixin.qq.com^",
 		"||wx.qq.com^",
 	}},
-	{"viber", []string{
+	{"viber", "Viber", []string{
 		"||viber.com^",
 	}},
-	{"weibo", []string{
+	{"weibo", "Weibo", []string{
 		"||weibo.com^",
 	}},
-	{"9gag", []string{
+	{"9gag", "9GAG", []string{
 		"||9cache.com^",
 		"||9gag.com^",
 	}},
-	{"telegram", []string{
+	{"telegram", "Telegram", []string{
 		"||t.me^",
 		"||telegram.me^",
 		"||telegram.org^",
 	}},
-	{"disneyplus", []string{
+	{"disneyplus", "Disney+", []string{
 		"||disney-plus.net^",
 		"||disneyplus.com^",
 		"||disney.playback.edge.bamgrid.com^",
 		"||media.dssott.com^",
 	}},
-	{"hulu", []string{
+	{"hulu", "Hulu", []string{
 		"||hulu.com^",
 	}},
-	{"spotify", []string{
+	{"spotify", "Spotify", []string{
 		"/_spotify-connect._tcp.local/",
 		"||spotify.com^",
 		"||scdn.co^",
@@ -227,33 +225,29 @@ var serviceRulesArray = []svc{
 		"||heads-ak-spotify-com.akamaized.net^",
 		"||heads4-ak-spotify-com.akamaized.net^",
 	}},
-	{"tinder", []string{
+	{"tinder", "Tinder", []string{
 		"||gotinder.com^",
 		"||tinder.com^",
 		"||tindersparks.com^",
 	}},
 }
 
-// convert array to map
+// initBlockedServices initializes the blocked services with the built-in
+// catalogue.
 func initBlockedServices() {
-	serviceRules = make(map[string][]*rules.NetworkRule)
-	for _, s := range serviceRulesArray {
-		netRules := []*rules.NetworkRule{}
-		for _, text := range s.rules {
-			rule, err := rules.NewNetworkRule(text, BlockedSvcsListID)
-			if err != nil {
-				log.Error("rules.NewNetworkRule: %s  rule: %s", err, text)
-				continue
-			}
-			netRules = append(netRules, rule)
-		}
-		serviceRules[s.name] = netRules
+	err := SetServicesCatalogue(nil, nil)
+	if err != nil {
+		log.Error("filtering: initializing built-in blocked services: %s", err)
 	}
 }
 
 // BlockedSvcKnown - return TRUE if a blocked service name is known
 func BlockedSvcKnown(s string) bool {
+	servicesLock.RLock()
+	defer servicesLock.RUnlock()
+
 	_, ok := serviceRules[s]
+
 	return ok
 }
 
@@ -265,6 +259,10 @@ func (d *DNSFilter) ApplyBlockedServices(setts *Settings, list []string, global
 		defer d.confLock.RUnlock()
 		list = d.Config.BlockedServices
 	}
+
+	servicesLock.RLock()
+	defer servicesLock.RUnlock()
+
 	for _, name := range list {
 		rules, ok := serviceRules[name]
 
@@ -314,6 +312,7 @@ func (d *DNSFilter) handleBlockedServicesSet(w http.ResponseWriter, r *http.Requ
 
 // registerBlockedServicesHandlers - register HTTP handlers
 func (d *DNSFilter) registerBlockedServicesHandlers() {
+	d.Config.HTTPRegister(http.MethodGet, "/control/blocked_services/all", handleBlockedServicesAll)
 	d.Config.HTTPRegister(http.MethodGet, "/control/blocked_services/list", d.handleBlockedServicesList)
 	d.Config.HTTPRegister(http.MethodPost, "/control/blocked_services/set", d.handleBlockedServicesSet)
 }
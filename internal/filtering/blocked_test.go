@@ -17,8 +17,7 @@ import (
 // Usage:
 // 1. go run ./internal/filtering/blocked_test.go
 // 2. Use the output to replace `SERVICES` array in "client/src/helpers/constants.js".
-// 3. You'll need to enter services names manually.
-// 4. Don't forget to add missing icons to "client/src/components/ui/Icons.js".
+// 3. Don't forget to add missing icons to "client/src/components/ui/Icons.js".
 //
 // TODO(ameshkov): Rework generator: have a JSON file with all the metadata we need
 // then use this JSON file to generate JS and Go code
@@ -32,7 +31,7 @@ func TestGenServicesArray(t *testing.T) {
 
 	fmt.Println("export const SERVICES = [")
 	for _, s := range services {
-		fmt.Printf("    {\n        id: '%s',\n        name: '%s',\n    },\n", s.name, s.name)
+		fmt.Printf("    {\n        id: '%s',\n        name: '%s',\n    },\n", s.name, s.title)
 	}
 	fmt.Println("];")
 }
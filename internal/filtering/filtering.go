@@ -20,6 +20,7 @@ import (
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/AdguardTeam/urlfilter"
 	"github.com/AdguardTeam/urlfilter/filterlist"
 	"github.com/AdguardTeam/urlfilter/rules"
@@ -36,6 +37,8 @@ const (
 	ParentalListID
 	SafeBrowsingListID
 	SafeSearchListID
+	ThreatFeedListID
+	AnswerPolicyListID
 )
 
 // ServiceEntry - blocked service array element
@@ -52,6 +55,17 @@ type Settings struct {
 
 	ServicesRules []ServiceEntry
 
+	// SafeSearchEngines are the restriction levels of the search engines by
+	// their names.  See Config.SafeSearchEngines.
+	SafeSearchEngines map[string]string
+
+	// FilterListIDs are the IDs of the filter lists applied to the client
+	// instead of the global ones and the ones assigned to its tags.  The
+	// custom filtering rules are applied regardless.  If it's nil, the
+	// client uses the global filter lists and the lists assigned to its
+	// tags.
+	FilterListIDs []int64
+
 	ProtectionEnabled   bool
 	FilteringEnabled    bool
 	SafeSearchEnabled   bool
@@ -80,12 +94,46 @@ type Config struct {
 	ParentalCacheSize     uint `yaml:"parental_cache_size"`     // (in bytes)
 	CacheTime             uint `yaml:"cache_time"`              // Element's TTL (in minutes)
 
+	// SafeSearchEngines are the restriction levels of the search engines by
+	// their names, for example "youtube": "strict".  The engines not listed
+	// here use their default levels, and the level "disabled" turns the safe
+	// search off for the engine.
+	SafeSearchEngines map[string]string `yaml:"safesearch_engines"`
+
+	// SafeSearchDataFile is the path to the file with the search engines and
+	// the safe variants of their hosts.  The file is reloaded once it's
+	// modified.  The built-in data is used if it's empty.
+	SafeSearchDataFile string `yaml:"safesearch_data_file"`
+
+	// ThreatFeeds are the additional sources of malicious domains, which are
+	// checked along with the safe browsing and the parental control.
+	ThreatFeeds []*ThreatFeedConfig `yaml:"threat_feeds"`
+
+	// HitsFile is the path to the file the hit counters of the filter lists'
+	// rules are stored in.  The counters are only kept in memory if it's
+	// empty.
+	HitsFile string `yaml:"-"`
+
 	Rewrites []RewriteEntry `yaml:"rewrites"`
 
 	// Names of services to block (globally).
 	// Per-client settings can override this configuration.
 	BlockedServices []string `yaml:"blocked_services"`
 
+	// BlockedServicesCatalogue is either the absolute path to the file or
+	// the URL of the catalogue of the services which may be blocked.  The
+	// built-in catalogue is used if it's empty.
+	BlockedServicesCatalogue string `yaml:"blocked_services_catalogue"`
+
+	// BlockedServicesCatalogueIvl is the interval between the refreshes of
+	// the catalogue.  The default interval of one day is used if it's zero.
+	BlockedServicesCatalogueIvl timeutil.Duration `yaml:"blocked_services_catalogue_interval"`
+
+	// CustomBlockedServices are the user-defined services which are added
+	// to the catalogue.  They replace the services from the catalogue with
+	// the same IDs.
+	CustomBlockedServices []*BlockedService `yaml:"custom_blocked_services"`
+
 	// EtcHosts is a container of IP-hostname pairs taken from the operating
 	// system configuration files (e.g. /etc/hosts).
 	EtcHosts *aghnet.HostsContainer `yaml:"-"`
@@ -123,7 +171,27 @@ type filtersInitializerParams struct {
 
 type hostChecker struct {
 	check func(host string, qtype uint16, setts *Settings) (res Result, err error)
-	name  string
+
+	// enabled returns false if the checker is disabled by setts, so that
+	// check isn't called.
+	enabled func(setts *Settings) (ok bool)
+
+	name string
+
+	// engines is true if the checker matches the host against the filtering
+	// engines, so that its trace consists of several steps.
+	engines bool
+}
+
+// filteringEnabled returns true if the rule-based filtering is enabled in
+// setts.
+func filteringEnabled(setts *Settings) (ok bool) {
+	return setts.FilteringEnabled
+}
+
+// protectionEnabled returns true if the protection is enabled in setts.
+func protectionEnabled(setts *Settings) (ok bool) {
+	return setts.ProtectionEnabled
 }
 
 // DNSFilter matches hostnames and DNS requests against filtering rules.
@@ -134,6 +202,11 @@ type DNSFilter struct {
 	filteringEngineAllow *urlfilter.DNSEngine
 	engineLock           sync.RWMutex
 
+	// listEngines are the engines of the filter lists which may be applied
+	// to particular clients only, sorted by the IDs of the lists.  They're
+	// built and reset along with the global engines.
+	listEngines []*listEngine
+
 	parentalServer       string // access via methods
 	safeBrowsingServer   string // access via methods
 	parentalUpstream     upstream.Upstream
@@ -156,6 +229,16 @@ type DNSFilter struct {
 	// TODO(e.burkov): Use upstream that configured in dnsforward instead.
 	resolver Resolver
 
+	// safeSearch provides the mapping of the search engines' hosts to their
+	// safe variants.
+	safeSearch *safeSearchSource
+
+	// threatFeeds are the enabled threat feeds.
+	threatFeeds []*threatFeed
+
+	// hits are the hit counters of the filter lists' rules.  It's never nil.
+	hits *hitCounter
+
 	hostCheckers []hostChecker
 }
 
@@ -164,6 +247,14 @@ type Filter struct {
 	ID       int64  // auto-assigned when filter is added (see nextFilterID)
 	Data     []byte `yaml:"-"` // List of rules divided by '\n'
 	FilePath string `yaml:"-"` // Path to a filtering rules file
+
+	// ClientTags are the tags of the clients the filter list is applied to.
+	// The list is applied to all clients if it's empty.
+	ClientTags []string `yaml:"client_tags,omitempty"`
+
+	// Selected is true if some clients use the list as one of their own
+	// filter lists, so that it's also loaded separately from the other lists.
+	Selected bool `yaml:"-"`
 }
 
 // Reason holds an enum detailing why it was filtered or not filtered
@@ -210,6 +301,14 @@ const (
 	//
 	// See https://github.com/AdguardTeam/AdGuardHome/issues/2499.
 	RewrittenRule
+
+	// FilteredThreatFeed is returned when the host was matched by one of the
+	// threat feeds.
+	FilteredThreatFeed
+
+	// FilteredAnswerPolicy is returned when the response was blocked by one
+	// of the answer policies, for example the DNS rebinding protection.
+	FilteredAnswerPolicy
 )
 
 // TODO(a.garipov): Resync with actual code names or replace completely
@@ -229,6 +328,10 @@ var reasonNames = []string{
 	Rewritten:          "Rewrite",
 	RewrittenAutoHosts: "RewriteEtcHosts",
 	RewrittenRule:      "RewriteRule",
+
+	FilteredThreatFeed: "FilteredThreatFeed",
+
+	FilteredAnswerPolicy: "FilteredAnswerPolicy",
 }
 
 func (r Reason) String() string {
@@ -267,6 +370,7 @@ func (d *DNSFilter) GetConfig() (s Settings) {
 	return Settings{
 		FilteringEnabled:    atomic.LoadUint32(&d.Config.enabled) != 0,
 		SafeSearchEnabled:   d.Config.SafeSearchEnabled,
+		SafeSearchEngines:   d.Config.SafeSearchEngines,
 		SafeBrowsingEnabled: d.Config.SafeBrowsingEnabled,
 		ParentalEnabled:     d.Config.ParentalEnabled,
 	}
@@ -335,6 +439,9 @@ func (d *DNSFilter) filtersInitializer() {
 
 // Close - close the object
 func (d *DNSFilter) Close() {
+	d.SaveHits()
+	d.safeSearch.stop()
+
 	d.engineLock.Lock()
 	defer d.engineLock.Unlock()
 	d.reset()
@@ -356,6 +463,9 @@ func (d *DNSFilter) reset() {
 			log.Error("filtering: rulesStorageAllow.Close: %s", err)
 		}
 	}
+
+	closeListEngines(d.listEngines)
+	d.listEngines = nil
 }
 
 // ResultRule contains information about applied rules.
@@ -398,6 +508,14 @@ type Result struct {
 	// Reason is set to FilteredBlockedService.
 	ServiceName string `json:",omitempty"`
 
+	// ThreatFeed is the name of the threat feed.  It is empty unless Reason
+	// is set to FilteredThreatFeed.
+	ThreatFeed string `json:",omitempty"`
+
+	// AnswerPolicy is the name of the answer policy.  It is empty unless
+	// Reason is set to FilteredAnswerPolicy.
+	AnswerPolicy string `json:",omitempty"`
+
 	// DNSRewriteResult is the $dnsrewrite filter rule result.
 	DNSRewriteResult *DNSRewriteResult `json:",omitempty"`
 }
@@ -426,33 +544,66 @@ func (d *DNSFilter) CheckHost(
 	qtype uint16,
 	setts *Settings,
 ) (res Result, err error) {
+	_, res, err = d.checkHost(host, qtype, setts, false)
+
+	return res, err
+}
+
+// checkHost is the common implementation of CheckHost and TraceHost.  steps are
+// only collected if trace is true.
+func (d *DNSFilter) checkHost(
+	host string,
+	qtype uint16,
+	setts *Settings,
+	trace bool,
+) (steps []*TraceStep, res Result, err error) {
 	// Sometimes clients try to resolve ".", which is a request to get root
 	// servers.
 	if host == "" {
-		return Result{}, nil
+		return nil, Result{}, nil
 	}
 
 	host = strings.ToLower(host)
 
-	if setts.FilteringEnabled {
+	enabled := filteringEnabled(setts)
+	if trace {
+		steps = append(steps, &TraceStep{Name: TraceStepRewrites, Enabled: enabled})
+	}
+
+	if enabled {
 		res = d.processRewrites(host, qtype)
 		if res.Reason == Rewritten {
-			return res, nil
+			return setStepResult(steps, res), res, nil
 		}
 	}
 
 	for _, hc := range d.hostCheckers {
+		enabled = hc.enabled(setts)
+		if trace {
+			steps = append(steps, hc.traceSteps(enabled, setts)...)
+		}
+
+		if !enabled {
+			continue
+		}
+
 		res, err = hc.check(host, qtype, setts)
 		if err != nil {
-			return Result{}, fmt.Errorf("%s: %w", hc.name, err)
+			return steps, Result{}, fmt.Errorf("%s: %w", hc.name, err)
 		}
 
-		if res.Reason.Matched() {
-			return res, nil
+		if !res.Reason.Matched() {
+			continue
+		}
+
+		if trace && hc.engines {
+			steps = trimFilteringSteps(steps, res.Reason)
 		}
+
+		return setStepResult(steps, res), res, nil
 	}
 
-	return Result{}, nil
+	return steps, Result{}, nil
 }
 
 // matchSysHosts tries to match the host against the operating system's hosts
@@ -659,12 +810,17 @@ func newRuleStorage(filters []Filter) (rs *filterlist.RuleStorage, err error) {
 
 // Initialize urlfilter objects.
 func (d *DNSFilter) initFiltering(allowFilters, blockFilters []Filter) error {
-	rulesStorage, err := newRuleStorage(blockFilters)
+	rulesStorage, err := newRuleStorage(globalFilters(blockFilters))
+	if err != nil {
+		return err
+	}
+
+	rulesStorageAllow, err := newRuleStorage(globalFilters(allowFilters))
 	if err != nil {
 		return err
 	}
 
-	rulesStorageAllow, err := newRuleStorage(allowFilters)
+	listEngines, err := newListEngines(blockFilters, allowFilters)
 	if err != nil {
 		return err
 	}
@@ -681,6 +837,7 @@ func (d *DNSFilter) initFiltering(allowFilters, blockFilters []Filter) error {
 		d.filteringEngine = filteringEngine
 		d.rulesStorageAllow = rulesStorageAllow
 		d.filteringEngineAllow = filteringEngineAllow
+		d.listEngines = listEngines
 	}()
 
 	// Make sure that the OS reclaims memory as soon as possible.
@@ -690,6 +847,17 @@ func (d *DNSFilter) initFiltering(allowFilters, blockFilters []Filter) error {
 	return nil
 }
 
+// globalFilters returns the filter lists applied to all clients.
+func globalFilters(filters []Filter) (global []Filter) {
+	for _, f := range filters {
+		if len(f.ClientTags) == 0 {
+			global = append(global, f)
+		}
+	}
+
+	return global
+}
+
 // hostRules is a helper that converts a slice of host rules into a slice of the
 // rules.Rule interface values.
 func hostRulesToRules(netRules []*rules.HostRule) (res []rules.Rule) {
@@ -804,20 +972,17 @@ func (d *DNSFilter) matchHost(
 	// TODO(e.burkov):  Inspect if the above is true.
 	defer d.engineLock.RUnlock()
 
-	if setts.ProtectionEnabled && d.filteringEngineAllow != nil {
-		dnsres, ok := d.filteringEngineAllow.MatchRequest(ureq)
+	enginesAllow, engines := d.clientEngines(setts)
+	if setts.ProtectionEnabled {
+		dnsres, _, ok := matchEngines(enginesAllow, ureq)
 		if ok {
 			return d.matchHostProcessAllowList(host, dnsres)
 		}
 	}
 
-	if d.filteringEngine == nil {
-		return Result{}, nil
-	}
-
-	dnsres, ok := d.filteringEngine.MatchRequest(ureq)
+	dnsres, dnsr, ok := matchEngines(engines, ureq)
 	// Check DNS rewrites first, because the API there is a bit awkward.
-	if dnsr := dnsres.DNSRewrites(); len(dnsr) > 0 {
+	if len(dnsr) > 0 {
 		res = d.processDNSRewrites(dnsr)
 		if res.Reason == RewrittenRule && res.CanonName == host {
 			// A rewrite of a host to itself.  Go on and try matching other
@@ -874,6 +1039,14 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter) {
 	d = &DNSFilter{
 		resolver: net.DefaultResolver,
 	}
+
+	var hitsFile string
+	if c != nil {
+		hitsFile = c.HitsFile
+	}
+
+	d.hits = newHitCounter(hitsFile)
+
 	if c != nil {
 
 		d.safebrowsingCache = cache.New(cache.Config{
@@ -892,27 +1065,38 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter) {
 		if c.CustomResolver != nil {
 			d.resolver = c.CustomResolver
 		}
+
+		d.initThreatFeeds(c.ThreatFeeds)
 	}
 
 	d.hostCheckers = []hostChecker{{
-		check: d.matchSysHosts,
-		name:  "hosts container",
+		check:   d.matchSysHosts,
+		enabled: func(setts *Settings) (ok bool) { return setts.FilteringEnabled && d.EtcHosts != nil },
+		name:    "hosts container",
 	}, {
-		check: d.matchHost,
-		name:  "filtering",
+		check:   d.matchHost,
+		enabled: filteringEnabled,
+		name:    "filtering",
+		engines: true,
 	}, {
-		check: matchBlockedServicesRules,
-		name:  "blocked services",
+		check:   matchBlockedServicesRules,
+		enabled: protectionEnabled,
+		name:    "blocked services",
 	}, {
-		check: d.checkSafeBrowsing,
-		name:  "safe browsing",
+		check:   d.checkSafeBrowsing,
+		enabled: func(setts *Settings) (ok bool) { return setts.ProtectionEnabled && setts.SafeBrowsingEnabled },
+		name:    "safe browsing",
 	}, {
-		check: d.checkParental,
-		name:  "parental",
-	}, {
-		check: d.checkSafeSearch,
-		name:  "safe search",
+		check:   d.checkParental,
+		enabled: func(setts *Settings) (ok bool) { return setts.ProtectionEnabled && setts.ParentalEnabled },
+		name:    "parental",
 	}}
+	d.hostCheckers = append(d.hostCheckers, d.threatFeedCheckers()...)
+	d.hostCheckers = append(d.hostCheckers, hostChecker{
+		check:   d.checkSafeSearch,
+		enabled: func(setts *Settings) (ok bool) { return setts.ProtectionEnabled && setts.SafeSearchEnabled },
+		name:    "safe search",
+	})
 
 	err := d.initSecurityServices()
 	if err != nil {
@@ -920,6 +1104,17 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter) {
 		return nil
 	}
 
+	var safeSearchDataFile string
+	if c != nil {
+		safeSearchDataFile = c.SafeSearchDataFile
+	}
+
+	d.safeSearch, err = newSafeSearchSource(safeSearchDataFile)
+	if err != nil {
+		log.Error("filtering: %s", err)
+		return nil
+	}
+
 	if c != nil {
 		d.Config = *c
 		d.prepareRewrites()
@@ -954,6 +1149,8 @@ func (d *DNSFilter) Start() {
 	d.filtersInitializerChan = make(chan filtersInitializerParams, 1)
 	go d.filtersInitializer()
 
+	d.safeSearch.start()
+
 	if d.Config.HTTPRegister != nil { // for tests
 		d.registerSecurityHandlers()
 		d.registerRewritesHandlers()
@@ -317,7 +317,7 @@ func TestSafeSearchCacheYandex(t *testing.T) {
 	assert.Equal(t, res.Rules[0].IP, yandexIP)
 
 	// Check cache.
-	cachedValue, isFound := getCachedResult(d.safeSearchCache, domain)
+	cachedValue, isFound := getCachedResult(d.safeSearchCache, safeSearchCacheKey(yandexIP.String(), dns.TypeA))
 	require.True(t, isFound)
 	require.Len(t, cachedValue.Rules, 1)
 
@@ -366,7 +366,7 @@ func TestSafeSearchCacheGoogle(t *testing.T) {
 	assert.True(t, res.Rules[0].IP.Equal(ip))
 
 	// Check cache.
-	cachedValue, isFound := getCachedResult(d.safeSearchCache, domain)
+	cachedValue, isFound := getCachedResult(d.safeSearchCache, safeSearchCacheKey(safeDomain, dns.TypeA))
 	require.True(t, isFound)
 	require.Len(t, cachedValue.Rules, 1)
 
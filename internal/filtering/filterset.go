@@ -0,0 +1,242 @@
+package filtering
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+)
+
+// listEngine is the rule storage and the engine of a single filter list
+// applied to particular clients only.
+type listEngine struct {
+	rulesStorage *filterlist.RuleStorage
+	engine       *urlfilter.DNSEngine
+
+	// tags are the client tags of the list.
+	tags []string
+
+	// id is the ID of the list.
+	id int64
+
+	// allow is true if the list is an allowlist.
+	allow bool
+}
+
+// newListEngine returns a new engine of the filter list f.
+func newListEngine(f Filter, allow bool) (le *listEngine, err error) {
+	rulesStorage, err := newRuleStorage([]Filter{f})
+	if err != nil {
+		return nil, err
+	}
+
+	return &listEngine{
+		rulesStorage: rulesStorage,
+		engine:       urlfilter.NewDNSEngine(rulesStorage),
+		tags:         f.ClientTags,
+		id:           f.ID,
+		allow:        allow,
+	}, nil
+}
+
+// needsListEngine returns true if the filter list f may be applied to some
+// clients separately from the other lists applied to all clients.
+func needsListEngine(f Filter) (ok bool) {
+	return f.ID == CustomListID || f.Selected || len(f.ClientTags) != 0
+}
+
+// newListEngines returns the engines of the filter lists which may be applied
+// to particular clients only, sorted by the IDs of the lists.
+func newListEngines(blockFilters, allowFilters []Filter) (les []*listEngine, err error) {
+	for _, filters := range []struct {
+		filters []Filter
+		allow   bool
+	}{{
+		filters: blockFilters,
+		allow:   false,
+	}, {
+		filters: allowFilters,
+		allow:   true,
+	}} {
+		for _, f := range filters.filters {
+			if !needsListEngine(f) {
+				continue
+			}
+
+			var le *listEngine
+			le, err = newListEngine(f, filters.allow)
+			if err != nil {
+				closeListEngines(les)
+
+				return nil, fmt.Errorf("filter list %d: %w", f.ID, err)
+			}
+
+			les = append(les, le)
+		}
+	}
+
+	sort.SliceStable(les, func(i, j int) bool { return les[i].id < les[j].id })
+
+	return les, nil
+}
+
+// closeListEngines closes the rule storages of les.
+func closeListEngines(les []*listEngine) {
+	for _, le := range les {
+		closeRuleStorage(le.rulesStorage)
+	}
+}
+
+// closeRuleStorage closes rs and logs the error, if any.
+func closeRuleStorage(rs *filterlist.RuleStorage) {
+	err := rs.Close()
+	if err != nil {
+		log.Error("filtering: closing rule storage: %s", err)
+	}
+}
+
+// hasAnyTag returns true if any of the sorted client tags is in tags.
+func hasAnyTag(sortedClientTags, tags []string) (ok bool) {
+	for _, t := range tags {
+		i := sort.SearchStrings(sortedClientTags, t)
+		if i < len(sortedClientTags) && sortedClientTags[i] == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsID returns true if ids contains id.
+func containsID(ids []int64, id int64) (ok bool) {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientEngines returns the allowlist and the blocklist engines applied to the
+// client with setts.  The engines of the
+// global lists are shared by all clients, so the clients with tags only add the
+// engines of the tagged lists.  The clients with their own filter lists only
+// use the custom filtering rules and the selected lists.  d.engineLock is
+// expected to be locked for reading at least.
+func (d *DNSFilter) clientEngines(setts *Settings) (allow, block []*urlfilter.DNSEngine) {
+	own := setts.FilterListIDs != nil
+	if !own {
+		if d.filteringEngineAllow != nil {
+			allow = append(allow, d.filteringEngineAllow)
+		}
+
+		if d.filteringEngine != nil {
+			block = append(block, d.filteringEngine)
+		}
+	}
+
+	for _, le := range d.listEngines {
+		var applies bool
+		if own {
+			applies = le.id == CustomListID || containsID(setts.FilterListIDs, le.id)
+		} else {
+			applies = len(le.tags) != 0 && hasAnyTag(setts.ClientTags, le.tags)
+		}
+
+		if !applies {
+			continue
+		} else if le.allow {
+			allow = append(allow, le.engine)
+		} else {
+			block = append(block, le.engine)
+		}
+	}
+
+	return allow, block
+}
+
+// matchEngines matches ureq against the rules of engines and resolves the
+// results the same way a single engine with all their rules would do.  That
+// is, the network rules take precedence over the host rules, and the network
+// rule with the highest priority wins, so that the exceptions and the important
+// rules of one list apply to the rules of the other lists as well.  dnsr are
+// the $dnsrewrite rules of all engines with the exceptions applied.
+func matchEngines(
+	engines []*urlfilter.DNSEngine,
+	ureq urlfilter.DNSRequest,
+) (res *urlfilter.DNSResult, dnsr []*rules.NetworkRule, ok bool) {
+	if len(engines) == 1 {
+		res, ok = engines[0].MatchRequest(ureq)
+
+		return res, res.DNSRewrites(), ok
+	}
+
+	res = &urlfilter.DNSResult{}
+	var netRules []*rules.NetworkRule
+	for _, engine := range engines {
+		dnsres, _ := engine.MatchRequest(ureq)
+		dnsr = append(dnsr, dnsres.DNSRewritesAll()...)
+		if dnsres.NetworkRule != nil {
+			netRules = append(netRules, dnsres.NetworkRule)
+		}
+
+		res.HostRulesV4 = append(res.HostRulesV4, dnsres.HostRulesV4...)
+		res.HostRulesV6 = append(res.HostRulesV6, dnsres.HostRulesV6...)
+	}
+
+	dnsr = applyDNSRewriteExceptions(dnsr)
+
+	if len(netRules) > 0 {
+		res.NetworkRule = rules.NewMatchingResult(netRules, nil).GetBasicResult()
+		res.HostRulesV4, res.HostRulesV6 = nil, nil
+	}
+
+	ok = res.NetworkRule != nil || res.HostRulesV4 != nil || res.HostRulesV6 != nil
+
+	return res, dnsr, ok
+}
+
+// applyDNSRewriteExceptions removes the $dnsrewrite exceptions from nrules
+// along with the rules they disable.  It mirrors the logic of
+// urlfilter.DNSResult.DNSRewrites, which only applies to a single engine.
+func applyDNSRewriteExceptions(nrules []*rules.NetworkRule) (flt []*rules.NetworkRule) {
+	var excs []*rules.DNSRewrite
+	for _, nr := range nrules {
+		if nr.Whitelist {
+			excs = append(excs, nr.DNSRewrite)
+		}
+	}
+
+	for _, nr := range nrules {
+		if !nr.Whitelist && !isDNSRewriteExcepted(nr.DNSRewrite, excs) {
+			flt = append(flt, nr)
+		}
+	}
+
+	return flt
+}
+
+// isDNSRewriteExcepted returns true if any of excs disables dnsr.
+func isDNSRewriteExcepted(dnsr *rules.DNSRewrite, excs []*rules.DNSRewrite) (ok bool) {
+	for _, exc := range excs {
+		switch {
+		case *exc == (rules.DNSRewrite{}):
+			// An exception like "$dnsrewrite=" disables all rewrites.
+			return true
+		case dnsr.NewCNAME == exc.NewCNAME:
+			return true
+		case dnsr.RCode != exc.RCode:
+			// Go on.
+		case exc.RCode != dns.RcodeSuccess,
+			dnsr.RRType == exc.RRType && dnsr.Value == exc.Value:
+			return true
+		}
+	}
+
+	return false
+}
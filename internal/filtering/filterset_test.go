@@ -0,0 +1,238 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_clientFilters(t *testing.T) {
+	const (
+		customID   = CustomListID
+		globalID   = 1
+		kidsID     = 2
+		allowID    = 3
+		selectedID = 4
+	)
+
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	err := d.SetFilters([]Filter{{
+		ID:   customID,
+		Data: []byte("||custom.example^\n"),
+	}, {
+		ID:   globalID,
+		Data: []byte("||global.example^\n"),
+	}, {
+		ID:         kidsID,
+		Data:       []byte("||games.example^\n||allowed-games.example^\n"),
+		ClientTags: []string{"user_child"},
+	}, {
+		ID:       selectedID,
+		Data:     []byte("||selected.example^\n"),
+		Selected: true,
+	}}, []Filter{{
+		ID:         allowID,
+		Data:       []byte("@@||allowed-games.example^\n"),
+		ClientTags: []string{"user_child"},
+	}}, false)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		setts  *Settings
+		name   string
+		host   string
+		wantID int64
+	}{{
+		setts:  &Settings{},
+		name:   "global",
+		host:   "global.example",
+		wantID: globalID,
+	}, {
+		setts:  &Settings{},
+		name:   "global_not_tagged",
+		host:   "games.example",
+		wantID: -1,
+	}, {
+		setts: &Settings{
+			ClientTags: []string{"device_pc", "user_child"},
+		},
+		name:   "tagged",
+		host:   "games.example",
+		wantID: kidsID,
+	}, {
+		setts: &Settings{
+			ClientTags: []string{"user_child"},
+		},
+		name:   "tagged_global",
+		host:   "global.example",
+		wantID: globalID,
+	}, {
+		setts: &Settings{
+			ClientTags: []string{"user_child"},
+		},
+		name:   "tagged_allowlist",
+		host:   "allowed-games.example",
+		wantID: -1,
+	}, {
+		setts: &Settings{
+			FilterListIDs: []int64{kidsID},
+		},
+		name:   "own_lists",
+		host:   "games.example",
+		wantID: kidsID,
+	}, {
+		setts: &Settings{
+			FilterListIDs: []int64{},
+		},
+		name:   "own_lists_no_global",
+		host:   "global.example",
+		wantID: -1,
+	}, {
+		setts: &Settings{
+			FilterListIDs: []int64{},
+		},
+		name:   "own_lists_custom",
+		host:   "custom.example",
+		wantID: customID,
+	}, {
+		setts:  &Settings{},
+		name:   "selected_global",
+		host:   "selected.example",
+		wantID: selectedID,
+	}, {
+		setts: &Settings{
+			FilterListIDs: []int64{selectedID},
+		},
+		name:   "own_lists_selected",
+		host:   "selected.example",
+		wantID: selectedID,
+	}, {
+		setts: &Settings{
+			FilterListIDs: []int64{selectedID},
+		},
+		name:   "own_lists_selected_no_global",
+		host:   "global.example",
+		wantID: -1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.setts.ProtectionEnabled = true
+			tc.setts.FilteringEnabled = true
+
+			res, err := d.CheckHost(tc.host, dns.TypeA, tc.setts)
+			require.NoError(t, err)
+
+			if tc.wantID < 0 {
+				assert.False(t, res.IsFiltered)
+
+				return
+			}
+
+			assert.True(t, res.IsFiltered)
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantID, res.Rules[0].FilterListID)
+		})
+	}
+
+	d.engineLock.RLock()
+	defer d.engineLock.RUnlock()
+
+	// Only the custom filtering rules, the tagged lists, and the selected
+	// ones are loaded separately.
+	ids := make([]int64, len(d.listEngines))
+	for i, le := range d.listEngines {
+		ids[i] = le.id
+	}
+
+	assert.Equal(t, []int64{customID, kidsID, allowID, selectedID}, ids)
+}
+
+func TestDNSFilter_clientFilters_priority(t *testing.T) {
+	const (
+		globalID = 1
+		taggedID = 2
+	)
+
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	err := d.SetFilters([]Filter{{
+		ID: globalID,
+		Data: []byte("||blocked.example^\n" +
+			"@@||excepted.example^\n" +
+			"||important.example^$important\n" +
+			"@@||forced.example^\n" +
+			"||rewritten.example^$dnsrewrite=192.0.2.1\n"),
+	}, {
+		ID: taggedID,
+		Data: []byte("@@||blocked.example^\n" +
+			"||excepted.example^\n" +
+			"@@||important.example^\n" +
+			"||forced.example^$important\n" +
+			"@@||rewritten.example^$dnsrewrite=192.0.2.1\n"),
+		ClientTags: []string{"user_child"},
+	}}, nil, false)
+	require.NoError(t, err)
+
+	setts := &Settings{
+		ClientTags:        []string{"user_child"},
+		ProtectionEnabled: true,
+		FilteringEnabled:  true,
+	}
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantReason Reason
+		wantID     int64
+	}{{
+		name:       "tagged_exception",
+		host:       "blocked.example",
+		wantReason: NotFilteredAllowList,
+		wantID:     taggedID,
+	}, {
+		name:       "global_exception",
+		host:       "excepted.example",
+		wantReason: NotFilteredAllowList,
+		wantID:     globalID,
+	}, {
+		name:       "global_important",
+		host:       "important.example",
+		wantReason: FilteredBlockList,
+		wantID:     globalID,
+	}, {
+		name:       "tagged_important",
+		host:       "forced.example",
+		wantReason: FilteredBlockList,
+		wantID:     taggedID,
+	}, {
+		name:       "tagged_dnsrewrite_exception",
+		host:       "rewritten.example",
+		wantReason: NotFilteredNotFound,
+		wantID:     -1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, dns.TypeA, setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			if tc.wantID < 0 {
+				assert.Empty(t, res.Rules)
+
+				return
+			}
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantID, res.Rules[0].FilterListID)
+		})
+	}
+}
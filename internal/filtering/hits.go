@@ -0,0 +1,231 @@
+package filtering
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/google/renameio/maybe"
+)
+
+// maxHitRules is the maximum number of rules the hits of which are counted for
+// a single filter list.  The total number of hits of the list is counted
+// regardless.
+const maxHitRules = 10_000
+
+// ListHits are the hit counters of a filter list.
+type ListHits struct {
+	// LastHit is the time of the latest hit.
+	LastHit time.Time `json:"last_hit"`
+
+	// Rules are the numbers of hits by the rules' texts.
+	Rules map[string]uint64 `json:"rules"`
+
+	// Total is the total number of hits.
+	Total uint64 `json:"total"`
+}
+
+// clone returns a deep copy of h.
+func (h *ListHits) clone() (c *ListHits) {
+	c = &ListHits{
+		LastHit: h.LastHit,
+		Rules:   make(map[string]uint64, len(h.Rules)),
+		Total:   h.Total,
+	}
+	for text, n := range h.Rules {
+		c.Rules[text] = n
+	}
+
+	return c
+}
+
+// RuleHits is the number of hits of a single rule.
+type RuleHits struct {
+	Text string `json:"text"`
+	Hits uint64 `json:"hits"`
+}
+
+// TopRules returns the rules of h sorted by the number of hits in descending
+// order.
+func (h *ListHits) TopRules() (rules []*RuleHits) {
+	rules = make([]*RuleHits, 0, len(h.Rules))
+	for text, n := range h.Rules {
+		rules = append(rules, &RuleHits{
+			Text: text,
+			Hits: n,
+		})
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Hits != rules[j].Hits {
+			return rules[i].Hits > rules[j].Hits
+		}
+
+		return rules[i].Text < rules[j].Text
+	})
+
+	return rules
+}
+
+// hitCounter counts the hits of the rules of the filter lists.
+type hitCounter struct {
+	// lists are the counters by the filter lists' IDs.
+	lists map[int64]*ListHits
+
+	// path is the path to the file the counters are stored in.  The
+	// counters aren't stored if it's empty.
+	path string
+
+	// mu protects lists.
+	mu sync.Mutex
+}
+
+// newHitCounter returns a new hit counter loaded from the file at path, if
+// there is one.
+func newHitCounter(path string) (hc *hitCounter) {
+	hc = &hitCounter{
+		lists: map[int64]*ListHits{},
+		path:  path,
+	}
+	if path == "" {
+		return hc
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Error("filtering: reading hits: %s", err)
+		}
+
+		return hc
+	}
+
+	err = json.Unmarshal(b, &hc.lists)
+	if err != nil {
+		log.Error("filtering: decoding hits from %q: %s", path, err)
+
+		hc.lists = map[int64]*ListHits{}
+	}
+
+	for _, h := range hc.lists {
+		if h.Rules == nil {
+			h.Rules = map[string]uint64{}
+		}
+	}
+
+	return hc
+}
+
+// record counts the hits of the rules from res.  The total number of hits of
+// each list is only increased once per result, even if several rules of the
+// list have matched.
+func (hc *hitCounter) record(res *Result, now time.Time) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	counted := make(map[int64]struct{}, len(res.Rules))
+	for _, r := range res.Rules {
+		h, ok := hc.lists[r.FilterListID]
+		if !ok {
+			h = &ListHits{
+				Rules: map[string]uint64{},
+			}
+			hc.lists[r.FilterListID] = h
+		}
+
+		if _, ok = counted[r.FilterListID]; !ok {
+			h.Total++
+			counted[r.FilterListID] = struct{}{}
+		}
+
+		h.LastHit = now
+		if r.Text == "" {
+			continue
+		}
+
+		if _, ok = h.Rules[r.Text]; ok || len(h.Rules) < maxHitRules {
+			h.Rules[r.Text]++
+		}
+	}
+}
+
+// save writes the counters to the file.
+func (hc *hitCounter) save() (err error) {
+	if hc.path == "" {
+		return nil
+	}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	b, err := json.Marshal(hc.lists)
+	if err != nil {
+		return fmt.Errorf("encoding hits: %w", err)
+	}
+
+	err = maybe.WriteFile(hc.path, b, 0o644)
+	if err != nil {
+		return fmt.Errorf("writing hits: %w", err)
+	}
+
+	return nil
+}
+
+// RecordHits counts the hits of the rules matched in res.  It's safe for
+// concurrent use.
+func (d *DNSFilter) RecordHits(res *Result) {
+	if res == nil || len(res.Rules) == 0 {
+		return
+	}
+
+	d.hits.record(res, time.Now())
+}
+
+// ListHits returns a copy of the hit counters of the filter list with the
+// given ID.  h is nil if the list has never been hit.
+func (d *DNSFilter) ListHits(id int64) (h *ListHits) {
+	d.hits.mu.Lock()
+	defer d.hits.mu.Unlock()
+
+	h, ok := d.hits.lists[id]
+	if !ok {
+		return nil
+	}
+
+	return h.clone()
+}
+
+// HitsSummary returns the total number of hits and the time of the latest hit
+// of the filter list with the given ID.
+func (d *DNSFilter) HitsSummary(id int64) (total uint64, lastHit time.Time) {
+	d.hits.mu.Lock()
+	defer d.hits.mu.Unlock()
+
+	h, ok := d.hits.lists[id]
+	if !ok {
+		return 0, time.Time{}
+	}
+
+	return h.Total, h.LastHit
+}
+
+// ResetHits removes the hit counters of the filter list with the given ID.
+func (d *DNSFilter) ResetHits(id int64) {
+	d.hits.mu.Lock()
+	defer d.hits.mu.Unlock()
+
+	delete(d.hits.lists, id)
+}
+
+// SaveHits writes the hit counters to the file set in the configuration.
+func (d *DNSFilter) SaveHits() {
+	err := d.hits.save()
+	if err != nil {
+		log.Error("filtering: %s", err)
+	}
+}
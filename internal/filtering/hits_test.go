@@ -0,0 +1,58 @@
+package filtering
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHitCounter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hits.json")
+	hc := newHitCounter(path)
+
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := &Result{
+		Rules: []*ResultRule{{
+			Text:         "||example.org^",
+			FilterListID: 1,
+		}},
+	}
+
+	hc.record(res, now)
+	hc.record(res, now)
+	hc.record(&Result{
+		Rules: []*ResultRule{{
+			Text:         "||example.com^",
+			FilterListID: 1,
+		}, {
+			Text:         "@@||example.com^",
+			FilterListID: 2,
+		}, {
+			Text:         "||example.com^$important",
+			FilterListID: 2,
+		}},
+	}, now.Add(time.Second))
+
+	require.NoError(t, hc.save())
+
+	loaded := newHitCounter(path)
+	require.Len(t, loaded.lists, 2)
+
+	h := loaded.lists[1]
+	require.NotNil(t, h)
+
+	assert.EqualValues(t, 3, h.Total)
+	assert.True(t, now.Add(time.Second).Equal(h.LastHit))
+	assert.Equal(t, []*RuleHits{{
+		Text: "||example.org^",
+		Hits: 2,
+	}, {
+		Text: "||example.com^",
+		Hits: 1,
+	}}, h.TopRules())
+
+	assert.EqualValues(t, 1, loaded.lists[2].Total)
+}
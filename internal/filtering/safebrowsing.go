@@ -131,8 +131,11 @@ func (c *sbCtx) getCached() int {
 }
 
 type sbCtx struct {
-	host       string
-	svc        string
+	host string
+	svc  string
+	// txtSuffix is the domain of the TXT queries.  If it's empty, the suffix
+	// of the AdGuard service is chosen by svc.
+	txtSuffix  string
 	hashToHost map[[32]byte]string
 	cache      cache.Cache
 	cacheTime  uint
@@ -190,7 +193,11 @@ func (c *sbCtx) getQuestion() string {
 		stringutil.WriteToBuilder(b, hex.EncodeToString(hash[0:2]), ".")
 	}
 
-	if c.svc == "SafeBrowsing" {
+	if c.txtSuffix != "" {
+		stringutil.WriteToBuilder(b, c.txtSuffix)
+
+		return b.String()
+	} else if c.svc == "SafeBrowsing" {
 		stringutil.WriteToBuilder(b, sbTXTSuffix)
 
 		return b.String()
@@ -3,17 +3,25 @@ package filtering
 import (
 	"bytes"
 	"context"
+	_ "embed"
 	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/golibs/cache"
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
 )
 
 /*
@@ -64,15 +72,305 @@ func getCachedResult(cache cache.Cache, host string) (Result, bool) {
 	return r, true
 }
 
+// safeSearchLevelDisabled is the restriction level turning the safe search off
+// for a search engine.
+const safeSearchLevelDisabled = "disabled"
+
+// safeSearchReloadIvl is the interval between the checks of the safe search
+// data file for changes.
+const safeSearchReloadIvl = 1 * time.Minute
+
+// safeSearchBuiltinData is the safe search data used unless a data file is
+// configured.
+//
+//go:embed safesearch.json
+var safeSearchBuiltinData []byte
+
+// safeSearchEngine is a search engine supporting the safe search.
+type safeSearchEngine struct {
+	// Name is the unique name of the engine, for example "youtube".
+	Name string `json:"name"`
+
+	// DefaultLevel is the restriction level used unless another one is
+	// configured.
+	DefaultLevel string `json:"default_level"`
+
+	// Levels are the safe hosts or IP addresses of the engine by the
+	// restriction levels, for example "strict" or "moderate".
+	Levels map[string]string `json:"levels"`
+
+	// Domains are the hosts of the engine replaced with the safe ones.
+	Domains []string `json:"domains"`
+}
+
+// levelNames returns the sorted names of the restriction levels of e.
+func (e *safeSearchEngine) levelNames() (names []string) {
+	for l := range e.Levels {
+		names = append(names, l)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// safeSearchData is the mapping of the search engines' hosts to their safe
+// variants.
+type safeSearchData struct {
+	// hosts are the engines by their lowercased domains.
+	hosts map[string]*safeSearchEngine
+
+	// Engines are the search engines supporting the safe search.
+	Engines []*safeSearchEngine `json:"engines"`
+}
+
+// parseSafeSearchData decodes and validates the safe search data from b.
+func parseSafeSearchData(b []byte) (data *safeSearchData, err error) {
+	data = &safeSearchData{}
+	err = json.Unmarshal(b, data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	data.hosts = map[string]*safeSearchEngine{}
+	names := stringutil.NewSet()
+	for i, e := range data.Engines {
+		switch {
+		case e == nil || e.Name == "":
+			return nil, fmt.Errorf("engine at index %d: no name", i)
+		case names.Has(e.Name):
+			return nil, fmt.Errorf("engine %q: duplicate name", e.Name)
+		case e.Levels[e.DefaultLevel] == "":
+			return nil, fmt.Errorf("engine %q: no host for default level %q", e.Name, e.DefaultLevel)
+		}
+
+		names.Add(e.Name)
+		for _, d := range e.Domains {
+			d = strings.ToLower(d)
+			if other, ok := data.hosts[d]; ok {
+				return nil, fmt.Errorf("engine %q: domain %q is used by %q", e.Name, d, other.Name)
+			}
+
+			data.hosts[d] = e
+		}
+	}
+
+	return data, nil
+}
+
+// safeSearchSource provides the safe search data.  It reloads the data file
+// in the background once it's modified.
+type safeSearchSource struct {
+	// data is the current *safeSearchData.  It's never nil.
+	data atomic.Value
+
+	// modTime is the modification time of the loaded data file.  It's only
+	// used by the goroutine reloading the file.
+	modTime time.Time
+
+	// done is closed to stop reloading the data file.  It's nil if the
+	// reloading isn't running.
+	done chan struct{}
+
+	// path is the path to the data file.  The built-in data is used if it's
+	// empty.
+	path string
+
+	// mu protects done.
+	mu sync.Mutex
+}
+
+// newSafeSearchSource returns a new source of the safe search data from the
+// file at path.  The built-in data is used if path is empty or until the file
+// is loaded successfully.
+func newSafeSearchSource(path string) (src *safeSearchSource, err error) {
+	data, err := parseSafeSearchData(safeSearchBuiltinData)
+	if err != nil {
+		// Should not happen, since the built-in data is tested.
+		return nil, fmt.Errorf("parsing built-in safe search data: %w", err)
+	}
+
+	src = &safeSearchSource{
+		path: path,
+	}
+	src.data.Store(data)
+	if path == "" {
+		return src, nil
+	}
+
+	err = src.reload()
+	if err != nil {
+		log.Error("safesearch: loading %q: %s; using built-in data", path, err)
+	}
+
+	return src, nil
+}
+
+// get returns the current safe search data.  It's safe for concurrent use.
+func (src *safeSearchSource) get() (data *safeSearchData) {
+	return src.data.Load().(*safeSearchData)
+}
+
+// start starts reloading the data file every safeSearchReloadIvl, if there is
+// one.
+func (src *safeSearchSource) start() {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	if src.path == "" || src.done != nil {
+		return
+	}
+
+	src.done = make(chan struct{})
+	go src.reloadPeriodically(src.done)
+}
+
+// stop stops reloading the data file.
+func (src *safeSearchSource) stop() {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	if src.done != nil {
+		close(src.done)
+		src.done = nil
+	}
+}
+
+// reloadPeriodically reloads the data file every safeSearchReloadIvl until done
+// is closed.
+func (src *safeSearchSource) reloadPeriodically(done <-chan struct{}) {
+	defer log.OnPanic("safesearch")
+
+	t := time.NewTicker(safeSearchReloadIvl)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			err := src.reload()
+			if err != nil {
+				log.Error("safesearch: reloading %q: %s", src.path, err)
+			}
+		}
+	}
+}
+
+// reload loads the data file if it has been modified since the last loading.
+// It must not be called concurrently.
+func (src *safeSearchSource) reload() (err error) {
+	fi, err := os.Stat(src.path)
+	if err != nil {
+		return err
+	} else if fi.ModTime().Equal(src.modTime) {
+		return nil
+	}
+
+	b, err := os.ReadFile(src.path)
+	if err != nil {
+		return err
+	}
+
+	data, err := parseSafeSearchData(b)
+	if err != nil {
+		return err
+	}
+
+	src.data.Store(data)
+	src.modTime = fi.ModTime()
+	log.Info("safesearch: loaded %d engines from %q", len(data.Engines), src.path)
+
+	return nil
+}
+
+// safeSearchLevel returns the restriction level for the search engine e
+// according to setts.
+func safeSearchLevel(e *safeSearchEngine, setts *Settings) (level string) {
+	level, ok := setts.SafeSearchEngines[e.Name]
+	if !ok {
+		return e.DefaultLevel
+	}
+
+	return level
+}
+
+// safeSearchHost returns the safe host or IP address to replace host with
+// according to setts.  ok is false if host doesn't belong to any search engine
+// or the safe search is disabled for its engine.
+func (d *DNSFilter) safeSearchHost(host string, setts *Settings) (safeHost string, ok bool) {
+	e, ok := d.safeSearch.get().hosts[strings.ToLower(host)]
+	if !ok {
+		return "", false
+	}
+
+	level := safeSearchLevel(e, setts)
+	if level == safeSearchLevelDisabled {
+		return "", false
+	}
+
+	safeHost, ok = e.Levels[level]
+	if !ok {
+		log.Debug("safesearch: unknown level %q for %s, using %q", level, e.Name, e.DefaultLevel)
+
+		safeHost = e.Levels[e.DefaultLevel]
+	}
+
+	return safeHost, true
+}
+
+// ValidateSafeSearchEngines returns an error if engines contain an unknown
+// search engine or an unknown restriction level of one.
+func (d *DNSFilter) ValidateSafeSearchEngines(engines map[string]string) (err error) {
+	data := d.safeSearch.get()
+	for name, level := range engines {
+		var e *safeSearchEngine
+		for _, de := range data.Engines {
+			if de.Name == name {
+				e = de
+
+				break
+			}
+		}
+
+		if e == nil {
+			return fmt.Errorf("unknown safe search engine %q", name)
+		} else if _, ok := e.Levels[level]; !ok && level != safeSearchLevelDisabled {
+			return fmt.Errorf("safe search engine %q: unknown level %q", name, level)
+		}
+	}
+
+	return nil
+}
+
 // SafeSearchDomain returns replacement address for search engine
 func (d *DNSFilter) SafeSearchDomain(host string) (string, bool) {
-	val, ok := safeSearchDomains[host]
-	return val, ok
+	setts := d.GetConfig()
+
+	return d.safeSearchHost(host, &setts)
+}
+
+// safeSearchCacheKey returns the key of the cached result of the safe search
+// for safeHost and qtype.
+func safeSearchCacheKey(safeHost string, qtype uint16) (key string) {
+	return fmt.Sprintf("%s %d", safeHost, qtype)
+}
+
+// safeSearchIPMatches returns true if ip is suitable for the answer to the
+// question of qtype.
+func safeSearchIPMatches(ip net.IP, qtype uint16) (ok bool) {
+	switch qtype {
+	case dns.TypeA:
+		return ip.To4() != nil
+	case dns.TypeAAAA:
+		return ip.To4() == nil
+	default:
+		return true
+	}
 }
 
 func (d *DNSFilter) checkSafeSearch(
 	host string,
-	_ uint16,
+	qtype uint16,
 	setts *Settings,
 ) (res Result, err error) {
 	if !setts.ProtectionEnabled || !setts.SafeSearchEnabled {
@@ -84,55 +382,57 @@ func (d *DNSFilter) checkSafeSearch(
 		defer timer.LogElapsed("SafeSearch: lookup for %s", host)
 	}
 
+	safeHost, ok := d.safeSearchHost(host, setts)
+	if !ok {
+		return Result{}, nil
+	}
+
 	// Check cache. Return cached result if it was found
-	cachedValue, isFound := getCachedResult(d.safeSearchCache, host)
+	key := safeSearchCacheKey(safeHost, qtype)
+	cachedValue, isFound := getCachedResult(d.safeSearchCache, key)
 	if isFound {
 		// atomic.AddUint64(&gctx.stats.Safesearch.CacheHits, 1)
 		log.Tracef("SafeSearch: found in cache: %s", host)
 		return cachedValue, nil
 	}
 
-	safeHost, ok := d.SafeSearchDomain(host)
-	if !ok {
-		return Result{}, nil
+	var ips []net.IP
+	if ip := net.ParseIP(safeHost); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ips, err = d.resolver.LookupIP(context.Background(), "ip", safeHost)
+		if err != nil {
+			log.Tracef("SafeSearchDomain for %s was found but failed to lookup for %s cause %s", host, safeHost, err)
+			return Result{}, err
+		}
 	}
 
 	res = Result{
 		IsFiltered: true,
 		Reason:     FilteredSafeSearch,
-		Rules: []*ResultRule{{
-			FilterListID: SafeSearchListID,
-		}},
-	}
-
-	if ip := net.ParseIP(safeHost); ip != nil {
-		res.Rules[0].IP = ip
-		valLen := d.setCacheResult(d.safeSearchCache, host, res)
-		log.Debug("SafeSearch: stored in cache: %s (%d bytes)", host, valLen)
-
-		return res, nil
-	}
-
-	ips, err := d.resolver.LookupIP(context.Background(), "ip", safeHost)
-	if err != nil {
-		log.Tracef("SafeSearchDomain for %s was found but failed to lookup for %s cause %s", host, safeHost, err)
-		return Result{}, err
 	}
 
 	for _, ip := range ips {
-		if ip = ip.To4(); ip == nil {
-			continue
+		if safeSearchIPMatches(ip, qtype) {
+			res.Rules = append(res.Rules, &ResultRule{
+				FilterListID: SafeSearchListID,
+				IP:           ip,
+			})
 		}
+	}
 
-		res.Rules[0].IP = ip
-
-		l := d.setCacheResult(d.safeSearchCache, host, res)
-		log.Debug("SafeSearch: stored in cache: %s (%d bytes)", host, l)
-
-		return res, nil
+	// Without the addresses of the suitable family the response contains no
+	// answers, so that the client doesn't reach the original host.
+	if len(res.Rules) == 0 {
+		res.Rules = []*ResultRule{{
+			FilterListID: SafeSearchListID,
+		}}
 	}
 
-	return Result{}, fmt.Errorf("no ipv4 addresses in safe search response for %s", safeHost)
+	l := d.setCacheResult(d.safeSearchCache, key, res)
+	log.Debug("SafeSearch: stored in cache: %s (%d bytes)", host, l)
+
+	return res, nil
 }
 
 func (d *DNSFilter) handleSafeSearchEnable(w http.ResponseWriter, r *http.Request) {
@@ -147,11 +447,20 @@ func (d *DNSFilter) handleSafeSearchDisable(w http.ResponseWriter, r *http.Reque
 
 func (d *DNSFilter) handleSafeSearchStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(&struct {
-		Enabled bool `json:"enabled"`
-	}{
-		Enabled: d.Config.SafeSearchEnabled,
-	})
+	setts := d.GetConfig()
+	resp := &safeSearchStatusJSON{
+		Enabled: setts.SafeSearchEnabled,
+	}
+
+	for _, e := range d.safeSearch.get().Engines {
+		resp.Engines = append(resp.Engines, &safeSearchEngineJSON{
+			Name:   e.Name,
+			Level:  safeSearchLevel(e, &setts),
+			Levels: e.levelNames(),
+		})
+	}
+
+	err := json.NewEncoder(w).Encode(resp)
 	if err != nil {
 		aghhttp.Error(
 			r,
@@ -163,219 +472,21 @@ func (d *DNSFilter) handleSafeSearchStatus(w http.ResponseWriter, r *http.Reques
 	}
 }
 
-var safeSearchDomains = map[string]string{
-	"yandex.com":     "213.180.193.56",
-	"yandex.ru":      "213.180.193.56",
-	"yandex.ua":      "213.180.193.56",
-	"yandex.by":      "213.180.193.56",
-	"yandex.kz":      "213.180.193.56",
-	"www.yandex.com": "213.180.193.56",
-	"www.yandex.ru":  "213.180.193.56",
-	"www.yandex.ua":  "213.180.193.56",
-	"www.yandex.by":  "213.180.193.56",
-	"www.yandex.kz":  "213.180.193.56",
-
-	"www.bing.com": "strict.bing.com",
-
-	"duckduckgo.com":       "safe.duckduckgo.com",
-	"www.duckduckgo.com":   "safe.duckduckgo.com",
-	"start.duckduckgo.com": "safe.duckduckgo.com",
-
-	"www.google.com":    "forcesafesearch.google.com",
-	"www.google.ad":     "forcesafesearch.google.com",
-	"www.google.ae":     "forcesafesearch.google.com",
-	"www.google.com.af": "forcesafesearch.google.com",
-	"www.google.com.ag": "forcesafesearch.google.com",
-	"www.google.com.ai": "forcesafesearch.google.com",
-	"www.google.al":     "forcesafesearch.google.com",
-	"www.google.am":     "forcesafesearch.google.com",
-	"www.google.co.ao":  "forcesafesearch.google.com",
-	"www.google.com.ar": "forcesafesearch.google.com",
-	"www.google.as":     "forcesafesearch.google.com",
-	"www.google.at":     "forcesafesearch.google.com",
-	"www.google.com.au": "forcesafesearch.google.com",
-	"www.google.az":     "forcesafesearch.google.com",
-	"www.google.ba":     "forcesafesearch.google.com",
-	"www.google.com.bd": "forcesafesearch.google.com",
-	"www.google.be":     "forcesafesearch.google.com",
-	"www.google.bf":     "forcesafesearch.google.com",
-	"www.google.bg":     "forcesafesearch.google.com",
-	"www.google.com.bh": "forcesafesearch.google.com",
-	"www.google.bi":     "forcesafesearch.google.com",
-	"www.google.bj":     "forcesafesearch.google.com",
-	"www.google.com.bn": "forcesafesearch.google.com",
-	"www.google.com.bo": "forcesafesearch.google.com",
-	"www.google.com.br": "forcesafesearch.google.com",
-	"www.google.bs":     "forcesafesearch.google.com",
-	"www.google.bt":     "forcesafesearch.google.com",
-	"www.google.co.bw":  "forcesafesearch.google.com",
-	"www.google.by":     "forcesafesearch.google.com",
-	"www.google.com.bz": "forcesafesearch.google.com",
-	"www.google.ca":     "forcesafesearch.google.com",
-	"www.google.cd":     "forcesafesearch.google.com",
-	"www.google.cf":     "forcesafesearch.google.com",
-	"www.google.cg":     "forcesafesearch.google.com",
-	"www.google.ch":     "forcesafesearch.google.com",
-	"www.google.ci":     "forcesafesearch.google.com",
-	"www.google.co.ck":  "forcesafesearch.google.com",
-	"www.google.cl":     "forcesafesearch.google.com",
-	"www.google.cm":     "forcesafesearch.google.com",
-	"www.google.cn":     "forcesafesearch.google.com",
-	"www.google.com.co": "forcesafesearch.google.com",
-	"www.google.co.cr":  "forcesafesearch.google.com",
-	"www.google.com.cu": "forcesafesearch.google.com",
-	"www.google.cv":     "forcesafesearch.google.com",
-	"www.google.com.cy": "forcesafesearch.google.com",
-	"www.google.cz":     "forcesafesearch.google.com",
-	"www.google.de":     "forcesafesearch.google.com",
-	"www.google.dj":     "forcesafesearch.google.com",
-	"www.google.dk":     "forcesafesearch.google.com",
-	"www.google.dm":     "forcesafesearch.google.com",
-	"www.google.com.do": "forcesafesearch.google.com",
-	"www.google.dz":     "forcesafesearch.google.com",
-	"www.google.com.ec": "forcesafesearch.google.com",
-	"www.google.ee":     "forcesafesearch.google.com",
-	"www.google.com.eg": "forcesafesearch.google.com",
-	"www.google.es":     "forcesafesearch.google.com",
-	"www.google.com.et": "forcesafesearch.google.com",
-	"www.google.fi":     "forcesafesearch.google.com",
-	"www.google.com.fj": "forcesafesearch.google.com",
-	"www.google.fm":     "forcesafesearch.google.com",
-	"www.google.fr":     "forcesafesearch.google.com",
-	"www.google.ga":     "forcesafesearch.google.com",
-	"www.google.ge":     "forcesafesearch.google.com",
-	"www.google.gg":     "forcesafesearch.google.com",
-	"www.google.com.gh": "forcesafesearch.google.com",
-	"www.google.com.gi": "forcesafesearch.google.com",
-	"www.google.gl":     "forcesafesearch.google.com",
-	"www.google.gm":     "forcesafesearch.google.com",
-	"www.google.gp":     "forcesafesearch.google.com",
-	"www.google.gr":     "forcesafesearch.google.com",
-	"www.google.com.gt": "forcesafesearch.google.com",
-	"www.google.gy":     "forcesafesearch.google.com",
-	"www.google.com.hk": "forcesafesearch.google.com",
-	"www.google.hn":     "forcesafesearch.google.com",
-	"www.google.hr":     "forcesafesearch.google.com",
-	"www.google.ht":     "forcesafesearch.google.com",
-	"www.google.hu":     "forcesafesearch.google.com",
-	"www.google.co.id":  "forcesafesearch.google.com",
-	"www.google.ie":     "forcesafesearch.google.com",
-	"www.google.co.il":  "forcesafesearch.google.com",
-	"www.google.im":     "forcesafesearch.google.com",
-	"www.google.co.in":  "forcesafesearch.google.com",
-	"www.google.iq":     "forcesafesearch.google.com",
-	"www.google.is":     "forcesafesearch.google.com",
-	"www.google.it":     "forcesafesearch.google.com",
-	"www.google.je":     "forcesafesearch.google.com",
-	"www.google.com.jm": "forcesafesearch.google.com",
-	"www.google.jo":     "forcesafesearch.google.com",
-	"www.google.co.jp":  "forcesafesearch.google.com",
-	"www.google.co.ke":  "forcesafesearch.google.com",
-	"www.google.com.kh": "forcesafesearch.google.com",
-	"www.google.ki":     "forcesafesearch.google.com",
-	"www.google.kg":     "forcesafesearch.google.com",
-	"www.google.co.kr":  "forcesafesearch.google.com",
-	"www.google.com.kw": "forcesafesearch.google.com",
-	"www.google.kz":     "forcesafesearch.google.com",
-	"www.google.la":     "forcesafesearch.google.com",
-	"www.google.com.lb": "forcesafesearch.google.com",
-	"www.google.li":     "forcesafesearch.google.com",
-	"www.google.lk":     "forcesafesearch.google.com",
-	"www.google.co.ls":  "forcesafesearch.google.com",
-	"www.google.lt":     "forcesafesearch.google.com",
-	"www.google.lu":     "forcesafesearch.google.com",
-	"www.google.lv":     "forcesafesearch.google.com",
-	"www.google.com.ly": "forcesafesearch.google.com",
-	"www.google.co.ma":  "forcesafesearch.google.com",
-	"www.google.md":     "forcesafesearch.google.com",
-	"www.google.me":     "forcesafesearch.google.com",
-	"www.google.mg":     "forcesafesearch.google.com",
-	"www.google.mk":     "forcesafesearch.google.com",
-	"www.google.ml":     "forcesafesearch.google.com",
-	"www.google.com.mm": "forcesafesearch.google.com",
-	"www.google.mn":     "forcesafesearch.google.com",
-	"www.google.ms":     "forcesafesearch.google.com",
-	"www.google.com.mt": "forcesafesearch.google.com",
-	"www.google.mu":     "forcesafesearch.google.com",
-	"www.google.mv":     "forcesafesearch.google.com",
-	"www.google.mw":     "forcesafesearch.google.com",
-	"www.google.com.mx": "forcesafesearch.google.com",
-	"www.google.com.my": "forcesafesearch.google.com",
-	"www.google.co.mz":  "forcesafesearch.google.com",
-	"www.google.com.na": "forcesafesearch.google.com",
-	"www.google.com.nf": "forcesafesearch.google.com",
-	"www.google.com.ng": "forcesafesearch.google.com",
-	"www.google.com.ni": "forcesafesearch.google.com",
-	"www.google.ne":     "forcesafesearch.google.com",
-	"www.google.nl":     "forcesafesearch.google.com",
-	"www.google.no":     "forcesafesearch.google.com",
-	"www.google.com.np": "forcesafesearch.google.com",
-	"www.google.nr":     "forcesafesearch.google.com",
-	"www.google.nu":     "forcesafesearch.google.com",
-	"www.google.co.nz":  "forcesafesearch.google.com",
-	"www.google.com.om": "forcesafesearch.google.com",
-	"www.google.com.pa": "forcesafesearch.google.com",
-	"www.google.com.pe": "forcesafesearch.google.com",
-	"www.google.com.pg": "forcesafesearch.google.com",
-	"www.google.com.ph": "forcesafesearch.google.com",
-	"www.google.com.pk": "forcesafesearch.google.com",
-	"www.google.pl":     "forcesafesearch.google.com",
-	"www.google.pn":     "forcesafesearch.google.com",
-	"www.google.com.pr": "forcesafesearch.google.com",
-	"www.google.ps":     "forcesafesearch.google.com",
-	"www.google.pt":     "forcesafesearch.google.com",
-	"www.google.com.py": "forcesafesearch.google.com",
-	"www.google.com.qa": "forcesafesearch.google.com",
-	"www.google.ro":     "forcesafesearch.google.com",
-	"www.google.ru":     "forcesafesearch.google.com",
-	"www.google.rw":     "forcesafesearch.google.com",
-	"www.google.com.sa": "forcesafesearch.google.com",
-	"www.google.com.sb": "forcesafesearch.google.com",
-	"www.google.sc":     "forcesafesearch.google.com",
-	"www.google.se":     "forcesafesearch.google.com",
-	"www.google.com.sg": "forcesafesearch.google.com",
-	"www.google.sh":     "forcesafesearch.google.com",
-	"www.google.si":     "forcesafesearch.google.com",
-	"www.google.sk":     "forcesafesearch.google.com",
-	"www.google.com.sl": "forcesafesearch.google.com",
-	"www.google.sn":     "forcesafesearch.google.com",
-	"www.google.so":     "forcesafesearch.google.com",
-	"www.google.sm":     "forcesafesearch.google.com",
-	"www.google.sr":     "forcesafesearch.google.com",
-	"www.google.st":     "forcesafesearch.google.com",
-	"www.google.com.sv": "forcesafesearch.google.com",
-	"www.google.td":     "forcesafesearch.google.com",
-	"www.google.tg":     "forcesafesearch.google.com",
-	"www.google.co.th":  "forcesafesearch.google.com",
-	"www.google.com.tj": "forcesafesearch.google.com",
-	"www.google.tk":     "forcesafesearch.google.com",
-	"www.google.tl":     "forcesafesearch.google.com",
-	"www.google.tm":     "forcesafesearch.google.com",
-	"www.google.tn":     "forcesafesearch.google.com",
-	"www.google.to":     "forcesafesearch.google.com",
-	"www.google.com.tr": "forcesafesearch.google.com",
-	"www.google.tt":     "forcesafesearch.google.com",
-	"www.google.com.tw": "forcesafesearch.google.com",
-	"www.google.co.tz":  "forcesafesearch.google.com",
-	"www.google.com.ua": "forcesafesearch.google.com",
-	"www.google.co.ug":  "forcesafesearch.google.com",
-	"www.google.co.uk":  "forcesafesearch.google.com",
-	"www.google.com.uy": "forcesafesearch.google.com",
-	"www.google.co.uz":  "forcesafesearch.google.com",
-	"www.google.com.vc": "forcesafesearch.google.com",
-	"www.google.co.ve":  "forcesafesearch.google.com",
-	"www.google.vg":     "forcesafesearch.google.com",
-	"www.google.co.vi":  "forcesafesearch.google.com",
-	"www.google.com.vn": "forcesafesearch.google.com",
-	"www.google.vu":     "forcesafesearch.google.com",
-	"www.google.ws":     "forcesafesearch.google.com",
-	"www.google.rs":     "forcesafesearch.google.com",
-
-	"www.youtube.com":          "restrictmoderate.youtube.com",
-	"m.youtube.com":            "restrictmoderate.youtube.com",
-	"youtubei.googleapis.com":  "restrictmoderate.youtube.com",
-	"youtube.googleapis.com":   "restrictmoderate.youtube.com",
-	"www.youtube-nocookie.com": "restrictmoderate.youtube.com",
-
-	"pixabay.com": "safesearch.pixabay.com",
+// safeSearchEngineJSON is the status of a search engine supporting the safe
+// search.
+type safeSearchEngineJSON struct {
+	// Name is the name of the engine.
+	Name string `json:"name"`
+
+	// Level is the current restriction level of the engine.
+	Level string `json:"level"`
+
+	// Levels are the restriction levels supported by the engine.
+	Levels []string `json:"levels"`
+}
+
+// safeSearchStatusJSON is the response to the safe search status request.
+type safeSearchStatusJSON struct {
+	Engines []*safeSearchEngineJSON `json:"engines"`
+	Enabled bool                    `json:"enabled"`
 }
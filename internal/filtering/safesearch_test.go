@@ -0,0 +1,214 @@
+package filtering
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSafeSearchData(t *testing.T) {
+	t.Run("builtin", func(t *testing.T) {
+		data, err := parseSafeSearchData(safeSearchBuiltinData)
+		require.NoError(t, err)
+
+		e, ok := data.hosts["www.youtube.com"]
+		require.True(t, ok)
+
+		assert.Equal(t, "youtube", e.Name)
+		assert.Equal(t, []string{"moderate", "strict"}, e.levelNames())
+	})
+
+	testCases := []struct {
+		name       string
+		data       string
+		wantErrMsg string
+	}{{
+		name:       "no_name",
+		data:       `{"engines":[{"default_level":"strict"}]}`,
+		wantErrMsg: "engine at index 0: no name",
+	}, {
+		name: "duplicate",
+		data: `{"engines":[` +
+			`{"name":"a","default_level":"strict","levels":{"strict":"a.example"}},` +
+			`{"name":"a","default_level":"strict","levels":{"strict":"a.example"}}` +
+			`]}`,
+		wantErrMsg: `engine "a": duplicate name`,
+	}, {
+		name:       "no_default_level",
+		data:       `{"engines":[{"name":"a","default_level":"strict","levels":{}}]}`,
+		wantErrMsg: `engine "a": no host for default level "strict"`,
+	}, {
+		name: "same_domain",
+		data: `{"engines":[` +
+			`{"name":"a","default_level":"strict","levels":{"strict":"a.example"},"domains":["x.example"]},` +
+			`{"name":"b","default_level":"strict","levels":{"strict":"b.example"},"domains":["X.example"]}` +
+			`]}`,
+		wantErrMsg: `engine "b": domain "x.example" is used by "a"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseSafeSearchData([]byte(tc.data))
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestCheckHostSafeSearchEngines(t *testing.T) {
+	resolver := &aghtest.TestResolver{}
+	d := newForTest(t, &Config{
+		SafeSearchEnabled: true,
+		CustomResolver:    resolver,
+	}, nil)
+	t.Cleanup(d.Close)
+
+	moderate4, _ := resolver.HostToIPs("restrictmoderate.youtube.com")
+	strict4, _ := resolver.HostToIPs("restrict.youtube.com")
+	_, google6 := resolver.HostToIPs("forcesafesearch.google.com")
+
+	clientSetts := setts
+	clientSetts.SafeSearchEngines = map[string]string{
+		"google":  safeSearchLevelDisabled,
+		"youtube": "strict",
+	}
+
+	testCases := []struct {
+		setts  *Settings
+		wantIP net.IP
+		name   string
+		host   string
+		qtype  uint16
+		want   bool
+	}{{
+		setts:  &setts,
+		wantIP: moderate4,
+		name:   "default_level",
+		host:   "www.youtube.com",
+		qtype:  dns.TypeA,
+		want:   true,
+	}, {
+		setts:  &clientSetts,
+		wantIP: strict4,
+		name:   "client_level",
+		host:   "www.youtube.com",
+		qtype:  dns.TypeA,
+		want:   true,
+	}, {
+		setts:  &clientSetts,
+		wantIP: nil,
+		name:   "client_disabled",
+		host:   "www.google.com",
+		qtype:  dns.TypeA,
+		want:   false,
+	}, {
+		setts:  &setts,
+		wantIP: google6,
+		name:   "aaaa",
+		host:   "www.google.com",
+		qtype:  dns.TypeAAAA,
+		want:   true,
+	}, {
+		setts:  &setts,
+		wantIP: nil,
+		name:   "aaaa_no_ipv6",
+		host:   "yandex.ru",
+		qtype:  dns.TypeAAAA,
+		want:   true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, tc.qtype, tc.setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, res.IsFiltered)
+			if !tc.want {
+				return
+			}
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantIP, res.Rules[0].IP)
+			assert.EqualValues(t, SafeSearchListID, res.Rules[0].FilterListID)
+		})
+	}
+}
+
+func TestDNSFilter_ValidateSafeSearchEngines(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		engines    map[string]string
+		name       string
+		wantErrMsg string
+	}{{
+		engines:    nil,
+		name:       "empty",
+		wantErrMsg: "",
+	}, {
+		engines: map[string]string{
+			"google":  safeSearchLevelDisabled,
+			"youtube": "strict",
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		engines:    map[string]string{"altavista": "strict"},
+		name:       "unknown_engine",
+		wantErrMsg: `unknown safe search engine "altavista"`,
+	}, {
+		engines:    map[string]string{"google": "moderate"},
+		name:       "unknown_level",
+		wantErrMsg: `safe search engine "google": unknown level "moderate"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := d.ValidateSafeSearchEngines(tc.engines)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestSafeSearchSource_reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "safesearch.json")
+
+	const data = `{"engines":[{` +
+		`"name":"example",` +
+		`"default_level":"strict",` +
+		`"levels":{"strict":"safe.example"},` +
+		`"domains":["search.example"]` +
+		`}]}`
+	err := os.WriteFile(path, []byte(data), 0o644)
+	require.NoError(t, err)
+
+	src, err := newSafeSearchSource(path)
+	require.NoError(t, err)
+
+	e, ok := src.get().hosts["search.example"]
+	require.True(t, ok)
+
+	assert.Equal(t, "safe.example", e.Levels["strict"])
+
+	// Broken files don't replace the loaded data.
+	err = os.WriteFile(path, []byte(`{`), 0o644)
+	require.NoError(t, err)
+
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+
+	err = src.reload()
+	require.Error(t, err)
+
+	_, ok = src.get().hosts["search.example"]
+	assert.True(t, ok)
+}
@@ -0,0 +1,202 @@
+package filtering
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/AdguardTeam/urlfilter/rules"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// BlockedService is a service of the blocked services catalogue.
+type BlockedService struct {
+	// ID is the unique identifier of the service, for example "youtube".
+	// It's used in the blocked services settings.
+	ID string `json:"id" yaml:"id"`
+
+	// Name is the human-readable name of the service.
+	Name string `json:"name" yaml:"name"`
+
+	// IconSVG is the SVG image of the icon of the service, if any.
+	IconSVG string `json:"icon_svg,omitempty" yaml:"icon_svg,omitempty"`
+
+	// Rules are the filtering rules blocking the service.
+	Rules []string `json:"rules" yaml:"rules"`
+
+	// Groups are the IDs of the groups of the service.
+	Groups []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+}
+
+// BlockedServiceGroup is a group of the blocked services, for example the
+// social networks.
+type BlockedServiceGroup struct {
+	ID   string `json:"id" yaml:"id"`
+	Name string `json:"name" yaml:"name"`
+}
+
+// ServicesCatalogue is the catalogue of the services which may be blocked.
+type ServicesCatalogue struct {
+	Services []*BlockedService      `json:"blocked_services" yaml:"blocked_services"`
+	Groups   []*BlockedServiceGroup `json:"groups" yaml:"groups"`
+}
+
+var (
+	// servicesLock protects servicesCatalogue and serviceRules.
+	servicesLock sync.RWMutex
+
+	// servicesCatalogue is the current catalogue, including the custom
+	// services.
+	servicesCatalogue *ServicesCatalogue
+
+	// serviceRules are the compiled rules of the services from
+	// servicesCatalogue by their IDs.
+	serviceRules map[string][]*rules.NetworkRule
+)
+
+// builtinServicesCatalogue returns the catalogue of the built-in services.
+func builtinServicesCatalogue() (cat *ServicesCatalogue) {
+	cat = &ServicesCatalogue{
+		Services: make([]*BlockedService, 0, len(serviceRulesArray)),
+		Groups:   []*BlockedServiceGroup{},
+	}
+
+	for _, s := range serviceRulesArray {
+		cat.Services = append(cat.Services, &BlockedService{
+			ID:    s.name,
+			Name:  s.title,
+			Rules: s.rules,
+		})
+	}
+
+	return cat
+}
+
+// ParseServicesCatalogue parses the blocked services catalogue in either the
+// JSON or the YAML format.
+func ParseServicesCatalogue(data []byte) (cat *ServicesCatalogue, err error) {
+	cat = &ServicesCatalogue{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		err = json.Unmarshal(trimmed, cat)
+	} else {
+		err = yaml.Unmarshal(data, cat)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("decoding catalogue: %w", err)
+	}
+
+	if len(cat.Services) == 0 {
+		return nil, errors.Error("no services")
+	}
+
+	return cat, nil
+}
+
+// compileServices validates svcs and returns their compiled rules by their
+// IDs.
+func compileServices(svcs []*BlockedService) (compiled map[string][]*rules.NetworkRule, err error) {
+	compiled = make(map[string][]*rules.NetworkRule, len(svcs))
+	for i, s := range svcs {
+		switch {
+		case s == nil:
+			return nil, fmt.Errorf("service at index %d: no service", i)
+		case s.ID == "":
+			return nil, fmt.Errorf("service at index %d: no id", i)
+		case len(s.Rules) == 0:
+			return nil, fmt.Errorf("service %q: no rules", s.ID)
+		default:
+			// Go on.
+		}
+
+		if _, ok := compiled[s.ID]; ok {
+			return nil, fmt.Errorf("service %q: duplicate id", s.ID)
+		}
+
+		netRules := make([]*rules.NetworkRule, 0, len(s.Rules))
+		for _, text := range s.Rules {
+			var rule *rules.NetworkRule
+			rule, err = rules.NewNetworkRule(text, BlockedSvcsListID)
+			if err != nil {
+				return nil, fmt.Errorf("service %q: rule %q: %w", s.ID, text, err)
+			}
+
+			netRules = append(netRules, rule)
+		}
+
+		compiled[s.ID] = netRules
+	}
+
+	return compiled, nil
+}
+
+// SetServicesCatalogue sets the catalogue of the blocked services.  The
+// built-in catalogue is used if cat is nil.  custom are the user-defined
+// services which replace the services from the catalogue with the same IDs.
+// The current catalogue is kept if the new one is invalid.
+func SetServicesCatalogue(cat *ServicesCatalogue, custom []*BlockedService) (err error) {
+	if cat == nil {
+		cat = builtinServicesCatalogue()
+	}
+
+	customIDs := stringutil.NewSet()
+	for _, s := range custom {
+		if s != nil {
+			customIDs.Add(s.ID)
+		}
+	}
+
+	merged := &ServicesCatalogue{
+		Services: make([]*BlockedService, 0, len(cat.Services)+len(custom)),
+		Groups:   cat.Groups,
+	}
+
+	if merged.Groups == nil {
+		merged.Groups = []*BlockedServiceGroup{}
+	}
+
+	for _, s := range cat.Services {
+		if s != nil && customIDs.Has(s.ID) {
+			log.Debug("filtering: custom blocked service %q replaces the catalogue one", s.ID)
+
+			continue
+		}
+
+		merged.Services = append(merged.Services, s)
+	}
+
+	merged.Services = append(merged.Services, custom...)
+
+	compiled, err := compileServices(merged.Services)
+	if err != nil {
+		return err
+	}
+
+	servicesLock.Lock()
+	defer servicesLock.Unlock()
+
+	servicesCatalogue, serviceRules = merged, compiled
+
+	log.Debug("filtering: set %d blocked services", len(compiled))
+
+	return nil
+}
+
+// handleBlockedServicesAll is the handler for the GET
+// /control/blocked_services/all HTTP API.
+func handleBlockedServicesAll(w http.ResponseWriter, r *http.Request) {
+	servicesLock.RLock()
+	defer servicesLock.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(servicesCatalogue)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "encoding response: %s", err)
+	}
+}
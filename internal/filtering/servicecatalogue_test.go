@@ -0,0 +1,129 @@
+package filtering
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseServicesCatalogue(t *testing.T) {
+	testCases := []struct {
+		name       string
+		data       string
+		wantErrMsg string
+		wantLen    int
+	}{{
+		name: "json",
+		data: `{"blocked_services":[{"id":"example","name":"Example",` +
+			`"rules":["||example.org^"]}],"groups":[]}`,
+		wantErrMsg: "",
+		wantLen:    1,
+	}, {
+		name: "yaml",
+		data: "blocked_services:\n" +
+			"- id: example\n" +
+			"  name: Example\n" +
+			"  rules:\n" +
+			"  - '||example.org^'\n" +
+			"  groups:\n" +
+			"  - social\n" +
+			"groups:\n" +
+			"- id: social\n" +
+			"  name: Social\n",
+		wantErrMsg: "",
+		wantLen:    1,
+	}, {
+		name:       "empty",
+		data:       `{"blocked_services":[]}`,
+		wantErrMsg: "no services",
+		wantLen:    0,
+	}, {
+		name:       "bad",
+		data:       "{",
+		wantErrMsg: "decoding catalogue: unexpected end of JSON input",
+		wantLen:    0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cat, err := ParseServicesCatalogue([]byte(tc.data))
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if tc.wantErrMsg != "" {
+				return
+			}
+
+			assert.Len(t, cat.Services, tc.wantLen)
+		})
+	}
+}
+
+func TestSetServicesCatalogue(t *testing.T) {
+	t.Cleanup(initBlockedServices)
+
+	cat := &ServicesCatalogue{
+		Services: []*BlockedService{{
+			ID:    "example",
+			Name:  "Example",
+			Rules: []string{"||example.org^"},
+		}, {
+			ID:    "other",
+			Name:  "Other",
+			Rules: []string{"||other.example^"},
+		}},
+	}
+
+	custom := []*BlockedService{{
+		ID:    "other",
+		Name:  "Other Custom",
+		Rules: []string{"||other.example^", "||other-cdn.example^"},
+	}, {
+		ID:    "mine",
+		Name:  "Mine",
+		Rules: []string{"||mine.example^"},
+	}}
+
+	err := SetServicesCatalogue(cat, custom)
+	require.NoError(t, err)
+
+	assert.True(t, BlockedSvcKnown("example"))
+	assert.True(t, BlockedSvcKnown("mine"))
+	assert.False(t, BlockedSvcKnown("youtube"))
+
+	require.Contains(t, serviceRules, "other")
+	assert.Len(t, serviceRules["other"], 2)
+
+	t.Run("invalid", func(t *testing.T) {
+		err = SetServicesCatalogue(cat, []*BlockedService{{ID: "bad"}})
+		testutil.AssertErrorMsg(t, `service "bad": no rules`, err)
+
+		// The previous catalogue must be kept.
+		assert.True(t, BlockedSvcKnown("mine"))
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		err = SetServicesCatalogue(&ServicesCatalogue{
+			Services: []*BlockedService{cat.Services[0], cat.Services[0]},
+		}, nil)
+		testutil.AssertErrorMsg(t, `service "example": duplicate id`, err)
+	})
+
+	t.Run("http", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handleBlockedServicesAll(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		got := &ServicesCatalogue{}
+		err = json.NewDecoder(w.Body).Decode(got)
+		require.NoError(t, err)
+
+		require.Len(t, got.Services, 3)
+
+		assert.Equal(t, "Other Custom", got.Services[1].Name)
+		assert.NotNil(t, got.Groups)
+	})
+}
@@ -0,0 +1,416 @@
+package filtering
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/cache"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
+)
+
+// Threat feed types.
+const (
+	// ThreatFeedTypeDomains is the type of the feeds which are local files
+	// with the lists of malicious domains.
+	ThreatFeedTypeDomains = "domains"
+
+	// ThreatFeedTypeHashPrefix is the type of the feeds which are DNS servers
+	// answering the hash-prefix TXT queries the same way the AdGuard safe
+	// browsing service does.
+	ThreatFeedTypeHashPrefix = "hash_prefix"
+)
+
+// Threat feed categories.  The category defines which of the settings enables
+// the feed.
+const (
+	// ThreatFeedCategorySafeBrowsing is the category of the feeds enabled
+	// along with the safe browsing.
+	ThreatFeedCategorySafeBrowsing = "safe_browsing"
+
+	// ThreatFeedCategoryParental is the category of the feeds enabled along
+	// with the parental control.
+	ThreatFeedCategoryParental = "parental"
+)
+
+// threatFeedReloadIvl is the minimum interval between the checks of a domain
+// list file for modifications.
+const threatFeedReloadIvl = 1 * time.Minute
+
+// ThreatFeedConfig is the configuration of an additional source of malicious
+// domains.
+type ThreatFeedConfig struct {
+	// Name is the unique name of the feed.  It's used in the query log and
+	// the statistics.
+	Name string `yaml:"name"`
+
+	// Type is either ThreatFeedTypeDomains or ThreatFeedTypeHashPrefix.
+	Type string `yaml:"type"`
+
+	// Category is either ThreatFeedCategorySafeBrowsing or
+	// ThreatFeedCategoryParental.
+	Category string `yaml:"category"`
+
+	// Path is the path to the domain list file of a domains feed.  Each
+	// line of the file is either a domain name, a URL, or a hosts file
+	// entry.  The file is reloaded once it's modified.
+	Path string `yaml:"path"`
+
+	// Server is the address of the DNS server of a hash-prefix feed.
+	Server string `yaml:"server"`
+
+	// TXTSuffix is the domain to which the hash prefixes are prepended in
+	// the TXT queries to Server, for example "sb.dns.example.com".
+	TXTSuffix string `yaml:"txt_suffix"`
+
+	// BlockHost is the host or the IP address the blocked queries are
+	// answered with.  The blocking mode is used if it's empty.
+	BlockHost string `yaml:"block_host"`
+
+	// CacheSize is the size of the cache of a hash-prefix feed in bytes.
+	CacheSize uint `yaml:"cache_size"`
+
+	// Enabled defines if the feed is used.
+	Enabled bool `yaml:"enabled"`
+}
+
+// threatFeed is an additional source of malicious domains.
+type threatFeed struct {
+	// conf is the configuration of the feed.
+	conf *ThreatFeedConfig
+
+	// domains are the domains of a domains feed.  It's nil for other types.
+	domains *threatFeedDomains
+
+	// upstream is the server of a hash-prefix feed.  It's nil for other
+	// types.
+	upstream upstream.Upstream
+
+	// cache is the cache of the hash prefixes of a hash-prefix feed.  It's
+	// nil for other types.
+	cache cache.Cache
+}
+
+// newThreatFeed validates conf and returns a new properly initialized threat
+// feed.
+func newThreatFeed(conf *ThreatFeedConfig) (f *threatFeed, err error) {
+	f = &threatFeed{
+		conf: conf,
+	}
+
+	switch conf.Category {
+	case ThreatFeedCategorySafeBrowsing, ThreatFeedCategoryParental:
+		// Go on.
+	default:
+		return nil, fmt.Errorf("bad category %q", conf.Category)
+	}
+
+	switch conf.Type {
+	case ThreatFeedTypeDomains:
+		if conf.Path == "" {
+			return nil, errors.Error("no path")
+		}
+
+		f.domains = &threatFeedDomains{
+			set:  stringutil.NewSet(),
+			path: conf.Path,
+		}
+
+		err = f.domains.reload(time.Now())
+		if err != nil {
+			// Don't return the error, since the file may appear later.
+			log.Error("threat feed %q: loading %q: %s", conf.Name, conf.Path, err)
+		}
+	case ThreatFeedTypeHashPrefix:
+		if conf.Server == "" {
+			return nil, errors.Error("no server")
+		} else if conf.TXTSuffix == "" {
+			return nil, errors.Error("no txt suffix")
+		}
+
+		f.upstream, err = upstream.AddressToUpstream(conf.Server, &upstream.Options{
+			Timeout: dnsTimeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("converting server: %w", err)
+		}
+
+		f.cache = cache.New(cache.Config{
+			EnableLRU: true,
+			MaxSize:   conf.CacheSize,
+		})
+	default:
+		return nil, fmt.Errorf("bad type %q", conf.Type)
+	}
+
+	return f, nil
+}
+
+// initThreatFeeds initializes the enabled feeds from confs.  The feeds with
+// invalid configuration are skipped.
+func (d *DNSFilter) initThreatFeeds(confs []*ThreatFeedConfig) {
+	names := stringutil.NewSet()
+	for i, conf := range confs {
+		if conf == nil || !conf.Enabled {
+			continue
+		}
+
+		if conf.Name == "" {
+			log.Error("filtering: threat feed at index %d: no name", i)
+
+			continue
+		} else if names.Has(conf.Name) {
+			log.Error("filtering: threat feed %q: duplicate name", conf.Name)
+
+			continue
+		}
+
+		f, err := newThreatFeed(conf)
+		if err != nil {
+			log.Error("filtering: threat feed %q: %s", conf.Name, err)
+
+			continue
+		}
+
+		names.Add(conf.Name)
+		d.threatFeeds = append(d.threatFeeds, f)
+	}
+}
+
+// threatFeedCheckers returns the host checkers of the threat feeds.
+func (d *DNSFilter) threatFeedCheckers() (hcs []hostChecker) {
+	for _, f := range d.threatFeeds {
+		f := f
+		hcs = append(hcs, hostChecker{
+			check:   d.newThreatFeedCheck(f),
+			enabled: func(setts *Settings) (ok bool) { return setts.ProtectionEnabled && f.enabled(setts) },
+			name:    "threat feed " + f.conf.Name,
+		})
+	}
+
+	return hcs
+}
+
+// ThreatFeedBlockHost returns the block host of the threat feed named name.
+// It returns an empty string if there is no such feed or the feed has no block
+// host.
+func (d *DNSFilter) ThreatFeedBlockHost(name string) (host string) {
+	for _, f := range d.threatFeeds {
+		if f.conf.Name == name {
+			return f.conf.BlockHost
+		}
+	}
+
+	return ""
+}
+
+// newThreatFeedCheck returns the check function of the host checker for f.
+func (d *DNSFilter) newThreatFeedCheck(
+	f *threatFeed,
+) (c func(host string, qtype uint16, setts *Settings) (res Result, err error)) {
+	return func(host string, _ uint16, setts *Settings) (res Result, err error) {
+		if !setts.ProtectionEnabled || !f.enabled(setts) {
+			return Result{}, nil
+		}
+
+		if log.GetLevel() >= log.DEBUG {
+			timer := log.StartTimer()
+			defer timer.LogElapsed("threat feed %q lookup for %s", f.conf.Name, host)
+		}
+
+		res = Result{
+			IsFiltered: true,
+			Reason:     FilteredThreatFeed,
+			Rules: []*ResultRule{{
+				Text:         f.conf.Name,
+				FilterListID: ThreatFeedListID,
+			}},
+			ThreatFeed: f.conf.Name,
+		}
+
+		if f.domains != nil {
+			if f.domains.has(host) {
+				return res, nil
+			}
+
+			return Result{}, nil
+		}
+
+		sctx := &sbCtx{
+			host:      host,
+			svc:       "threat feed " + f.conf.Name,
+			txtSuffix: dns.Fqdn(f.conf.TXTSuffix),
+			cache:     f.cache,
+			cacheTime: d.Config.CacheTime,
+		}
+
+		return check(sctx, res, f.upstream)
+	}
+}
+
+// enabled returns true if the category of f is enabled in setts.
+func (f *threatFeed) enabled(setts *Settings) (ok bool) {
+	if f.conf.Category == ThreatFeedCategoryParental {
+		return setts.ParentalEnabled
+	}
+
+	return setts.SafeBrowsingEnabled
+}
+
+// threatFeedDomains is the domain list of a domains feed.  It reloads the list
+// file once it's modified.
+type threatFeedDomains struct {
+	// set is the current set of the domains.  It's never nil.
+	set *stringutil.Set
+
+	// modTime is the modification time of the loaded list file.
+	modTime time.Time
+
+	// checked is the time of the latest check of the list file.
+	checked time.Time
+
+	// path is the path to the list file.
+	path string
+
+	// mu protects set, modTime, and checked.
+	mu sync.Mutex
+}
+
+// has returns true if host or any of its parent domains is in the list.  It
+// checks the list file for changes at most once per threatFeedReloadIvl.
+func (fd *threatFeedDomains) has(host string) (ok bool) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(fd.checked) >= threatFeedReloadIvl {
+		err := fd.reload(now)
+		if err != nil {
+			log.Error("threat feed: reloading %q: %s", fd.path, err)
+		}
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for host != "" {
+		if fd.set.Has(host) {
+			return true
+		}
+
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			break
+		}
+
+		host = host[i+1:]
+	}
+
+	return false
+}
+
+// reload loads the list file if it has been modified since the last loading.
+// fd.mu is expected to be locked, if necessary.
+func (fd *threatFeedDomains) reload(now time.Time) (err error) {
+	fd.checked = now
+
+	fi, err := os.Stat(fd.path)
+	if err != nil {
+		return err
+	} else if fi.ModTime().Equal(fd.modTime) {
+		return nil
+	}
+
+	b, err := os.ReadFile(fd.path)
+	if err != nil {
+		return err
+	}
+
+	set, err := parseThreatFeedDomains(b)
+	if err != nil {
+		return err
+	}
+
+	fd.set, fd.modTime = set, fi.ModTime()
+	log.Info("threat feed: loaded %d domains from %q", set.Len(), fd.path)
+
+	return nil
+}
+
+// parseThreatFeedDomains parses the domain list data.  The empty lines and the
+// lines starting with "#" or "!" are skipped.  The other lines are either
+// domain names, URLs, "||domain^" rules, or hosts file entries.
+func parseThreatFeedDomains(data []byte) (set *stringutil.Set, err error) {
+	set = stringutil.NewSet()
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+			// A hosts file entry.
+			fields = fields[1:]
+		} else {
+			fields = fields[:1]
+		}
+
+		for _, f := range fields {
+			if f[0] == '#' {
+				break
+			}
+
+			if host := threatFeedHost(f); host != "" {
+				set.Add(host)
+			}
+		}
+	}
+
+	err = s.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
+
+// threatFeedHost returns the normalized host from a domain list entry.  It
+// returns an empty string if there is no valid host in the entry.
+func threatFeedHost(entry string) (host string) {
+	if strings.Contains(entry, "://") {
+		u, err := url.Parse(entry)
+		if err != nil {
+			return ""
+		}
+
+		entry = u.Hostname()
+	} else {
+		entry = strings.TrimPrefix(entry, "||")
+		entry = strings.TrimSuffix(entry, "^")
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(entry, "."))
+	if !strings.Contains(host, ".") || net.ParseIP(host) != nil {
+		// Skip the top-level domains and the entries like "localhost",
+		// which are common in hosts files.
+		return ""
+	}
+
+	_, ok := dns.IsDomainName(host)
+	if !ok {
+		return ""
+	}
+
+	return host
+}
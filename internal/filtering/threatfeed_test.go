@@ -0,0 +1,148 @@
+package filtering
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseThreatFeedDomains(t *testing.T) {
+	const data = "# comment\n" +
+		"! another comment\n" +
+		"\n" +
+		"Malware.Example.\n" +
+		"http://phishing.example:8080/login.php\n" +
+		"||adblock.example^\n" +
+		"0.0.0.0 hosts-1.example hosts-2.example # comment\n" +
+		"127.0.0.1 localhost\n" +
+		"1.2.3.4\n" +
+		"http://[::1\n"
+
+	set, err := parseThreatFeedDomains([]byte(data))
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{
+		"malware.example",
+		"phishing.example",
+		"adblock.example",
+		"hosts-1.example",
+		"hosts-2.example",
+	}, set.Values())
+}
+
+func TestDNSFilter_threatFeeds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urlhaus.txt")
+	err := os.WriteFile(path, []byte("malware.example\n"), 0o644)
+	require.NoError(t, err)
+
+	d := newForTest(t, &Config{
+		ThreatFeeds: []*ThreatFeedConfig{{
+			Name:      "urlhaus",
+			Type:      ThreatFeedTypeDomains,
+			Category:  ThreatFeedCategorySafeBrowsing,
+			Path:      path,
+			BlockHost: "192.0.2.1",
+			Enabled:   true,
+		}, {
+			Name:      "hashes",
+			Type:      ThreatFeedTypeHashPrefix,
+			Category:  ThreatFeedCategoryParental,
+			Server:    "127.0.0.1",
+			TXTSuffix: "pc.dns.example",
+			CacheSize: 10000,
+			Enabled:   true,
+		}, {
+			Name:     "bad_category",
+			Type:     ThreatFeedTypeDomains,
+			Category: "unknown",
+			Path:     path,
+			Enabled:  true,
+		}, {
+			Name:     "disabled",
+			Type:     ThreatFeedTypeDomains,
+			Category: ThreatFeedCategorySafeBrowsing,
+			Path:     path,
+		}},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	require.Len(t, d.threatFeeds, 2)
+
+	const blockedHost = "blocked.example"
+	d.threatFeeds[1].upstream = &aghtest.TestBlockUpstream{
+		Hostname: blockedHost,
+		Block:    true,
+	}
+
+	// Don't block anything with the built-in services.
+	ups := &aghtest.TestBlockUpstream{
+		Hostname: blockedHost,
+		Block:    false,
+	}
+	d.SetSafeBrowsingUpstream(ups)
+	d.SetParentalUpstream(ups)
+
+	assert.Equal(t, "192.0.2.1", d.ThreatFeedBlockHost("urlhaus"))
+	assert.Empty(t, d.ThreatFeedBlockHost("hashes"))
+
+	feedSetts := &Settings{
+		ProtectionEnabled:   true,
+		SafeBrowsingEnabled: true,
+		ParentalEnabled:     true,
+	}
+
+	testCases := []struct {
+		setts    *Settings
+		name     string
+		host     string
+		wantFeed string
+	}{{
+		setts:    feedSetts,
+		name:     "domains",
+		host:     "malware.example",
+		wantFeed: "urlhaus",
+	}, {
+		setts:    feedSetts,
+		name:     "domains_subdomain",
+		host:     "sub.malware.example",
+		wantFeed: "urlhaus",
+	}, {
+		setts:    feedSetts,
+		name:     "hash_prefix",
+		host:     blockedHost,
+		wantFeed: "hashes",
+	}, {
+		setts: &Settings{
+			ProtectionEnabled: true,
+		},
+		name:     "category_disabled",
+		host:     "malware.example",
+		wantFeed: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, dns.TypeA, tc.setts)
+			require.NoError(t, err)
+
+			if tc.wantFeed == "" {
+				assert.False(t, res.IsFiltered)
+
+				return
+			}
+
+			assert.True(t, res.IsFiltered)
+			assert.Equal(t, FilteredThreatFeed, res.Reason)
+			assert.Equal(t, tc.wantFeed, res.ThreatFeed)
+
+			require.Len(t, res.Rules, 1)
+
+			assert.EqualValues(t, ThreatFeedListID, res.Rules[0].FilterListID)
+		})
+	}
+}
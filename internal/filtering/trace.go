@@ -0,0 +1,79 @@
+package filtering
+
+// Names of the trace steps that aren't named after the host checkers.
+const (
+	TraceStepRewrites   = "rewrites"
+	TraceStepAllowlist  = "allowlist"
+	TraceStepBlocklist  = "blocklist"
+	TraceStepDNSRewrite = "$dnsrewrite"
+)
+
+// TraceStep is a step of checking a host by CheckHost.
+type TraceStep struct {
+	// Name is the name of the step, for example TraceStepBlocklist or "safe
+	// browsing".
+	Name string
+
+	// Result is the result of the step.  It's only set if the step matched
+	// the host.
+	Result Result
+
+	// Enabled is false if the step has been skipped since it's disabled by
+	// the filtering settings.
+	Enabled bool
+}
+
+// Matched returns true if the step has decided the result of the check.
+func (s *TraceStep) Matched() (ok bool) {
+	return s.Result.Reason.Matched()
+}
+
+// TraceHost checks the host like CheckHost does and returns the steps it has
+// performed, in order.  The last step is the one that has decided res, if any.
+func (d *DNSFilter) TraceHost(
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (steps []*TraceStep, res Result, err error) {
+	return d.checkHost(host, qtype, setts, true)
+}
+
+// traceSteps returns the trace steps of hc.  enabled is the result of
+// hc.enabled for setts.
+func (hc *hostChecker) traceSteps(enabled bool, setts *Settings) (steps []*TraceStep) {
+	if !hc.engines {
+		return []*TraceStep{{Name: hc.name, Enabled: enabled}}
+	}
+
+	// The filtering engines check the allowlist first, then the $dnsrewrite
+	// rules, and then the blocklist.
+	return []*TraceStep{
+		{Name: TraceStepAllowlist, Enabled: enabled && setts.ProtectionEnabled},
+		{Name: TraceStepDNSRewrite, Enabled: enabled},
+		{Name: TraceStepBlocklist, Enabled: enabled && setts.ProtectionEnabled},
+	}
+}
+
+// setStepResult sets the result of the last of steps, if any, to res and
+// returns steps.
+func setStepResult(steps []*TraceStep, res Result) (withRes []*TraceStep) {
+	if len(steps) > 0 {
+		steps[len(steps)-1].Result = res
+	}
+
+	return steps
+}
+
+// trimFilteringSteps removes the steps of the filtering engines that come
+// after the one which has returned a result with reason.  steps must end with
+// the steps of the filtering engines.
+func trimFilteringSteps(steps []*TraceStep, reason Reason) (trimmed []*TraceStep) {
+	switch reason {
+	case NotFilteredAllowList:
+		return steps[:len(steps)-2]
+	case RewrittenRule:
+		return steps[:len(steps)-1]
+	default:
+		return steps
+	}
+}
@@ -0,0 +1,102 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_TraceHost(t *testing.T) {
+	const text = `||blocked.example^
+@@||allowed.example^
+||rewritten.example^$dnsrewrite=1.2.3.4
+`
+
+	d := newForTest(t, nil, []Filter{{ID: 0, Data: []byte(text)}})
+	t.Cleanup(d.Close)
+
+	setts := &Settings{
+		ProtectionEnabled: true,
+		FilteringEnabled:  true,
+	}
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantLast   string
+		wantReason Reason
+	}{{
+		name:       "blocklist",
+		host:       "blocked.example",
+		wantLast:   TraceStepBlocklist,
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "allowlist",
+		host:       "allowed.example",
+		wantLast:   TraceStepAllowlist,
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "dnsrewrite",
+		host:       "rewritten.example",
+		wantLast:   TraceStepDNSRewrite,
+		wantReason: RewrittenRule,
+	}, {
+		name:       "none",
+		host:       "example.org",
+		wantLast:   "safe search",
+		wantReason: NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			steps, res, err := d.TraceHost(tc.host, dns.TypeA, setts)
+			require.NoError(t, err)
+			require.NotEmpty(t, steps)
+
+			last := steps[len(steps)-1]
+			assert.Equal(t, tc.wantLast, last.Name)
+			assert.Equal(t, tc.wantReason, res.Reason)
+			assert.Equal(t, tc.wantReason, last.Result.Reason)
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		steps, res, err := d.TraceHost("blocked.example", dns.TypeA, &Settings{})
+		require.NoError(t, err)
+
+		assert.False(t, res.Reason.Matched())
+		for _, s := range steps {
+			assert.Falsef(t, s.Enabled, "step %s", s.Name)
+		}
+	})
+
+	t.Run("disabled_checker", func(t *testing.T) {
+		hcs := d.hostCheckers
+		t.Cleanup(func() { d.hostCheckers = hcs })
+
+		d.hostCheckers = []hostChecker{{
+			check: func(_ string, _ uint16, _ *Settings) (res Result, err error) {
+				return Result{Reason: FilteredBlockList}, nil
+			},
+			enabled: func(_ *Settings) (ok bool) { return false },
+			name:    "disabled",
+		}}
+
+		res, err := d.CheckHost("example.org", dns.TypeA, setts)
+		require.NoError(t, err)
+
+		assert.False(t, res.Reason.Matched())
+
+		steps, res, err := d.TraceHost("example.org", dns.TypeA, setts)
+		require.NoError(t, err)
+		require.NotEmpty(t, steps)
+
+		assert.False(t, res.Reason.Matched())
+
+		last := steps[len(steps)-1]
+		assert.Equal(t, "disabled", last.Name)
+		assert.False(t, last.Enabled)
+	})
+}
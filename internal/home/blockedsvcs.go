@@ -0,0 +1,225 @@
+package home
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghio"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/google/renameio/maybe"
+)
+
+const (
+	// servicesCatalogueFile is the name of the file within the data
+	// directory where the blocked services catalogue downloaded from a URL is
+	// cached.
+	servicesCatalogueFile = "blocked_services.json"
+
+	// defaultServicesCatalogueIvl is the default interval of refreshing the
+	// blocked services catalogue.
+	defaultServicesCatalogueIvl = 24 * time.Hour
+
+	// maxServicesCatalogueSize is the maximum size of the blocked services
+	// catalogue.
+	maxServicesCatalogueSize = 16 * 1024 * 1024
+)
+
+// initServicesCatalogue sets the catalogue of the blocked services from the
+// configuration.  It must be called before the persistent clients are
+// initialized, since those drop the unknown services.  The catalogue from a URL
+// is only read from the cache, since the DNS server, which the HTTP client
+// resolves the hostnames with, isn't running yet.  Any errors with the
+// catalogue itself are only logged and the built-in one is used instead.
+func initServicesCatalogue() (err error) {
+	conf := config.DNS.DnsfilterConf
+	cat, err := loadServicesCatalogue(conf.BlockedServicesCatalogue, true)
+	if err != nil {
+		log.Error("blocked services: loading catalogue: %s; using built-in", err)
+
+		cat = nil
+	}
+
+	err = filtering.SetServicesCatalogue(cat, conf.CustomBlockedServices)
+	if err == nil {
+		return nil
+	} else if cat == nil {
+		return fmt.Errorf("custom blocked services: %w", err)
+	}
+
+	log.Error("blocked services: setting catalogue: %s; using built-in", err)
+
+	err = filtering.SetServicesCatalogue(nil, conf.CustomBlockedServices)
+	if err != nil {
+		return fmt.Errorf("custom blocked services: %w", err)
+	}
+
+	return nil
+}
+
+// loadServicesCatalogue loads the blocked services catalogue from src, which
+// is either an absolute path to a file or a URL.  If cacheOnly is true, the
+// catalogue from a URL is only read from the cache file.  cat is nil if src is
+// empty or if cacheOnly is true and there is no cache file yet.
+func loadServicesCatalogue(src string, cacheOnly bool) (cat *filtering.ServicesCatalogue, err error) {
+	if src == "" {
+		return nil, nil
+	}
+
+	var data []byte
+	switch {
+	case filepath.IsAbs(src):
+		data, err = readServicesCatalogue(src)
+	case cacheOnly:
+		data, err = readCachedServicesCatalogue()
+	default:
+		data, err = fetchServicesCatalogue(src)
+	}
+	if err != nil {
+		return nil, err
+	} else if data == nil {
+		return nil, nil
+	}
+
+	cat, err = filtering.ParseServicesCatalogue(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", src, err)
+	}
+
+	return cat, nil
+}
+
+// readServicesCatalogue reads the catalogue data from the file at path.
+func readServicesCatalogue(path string) (data []byte, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading catalogue: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	return readLimitedCatalogue(f)
+}
+
+// readCachedServicesCatalogue reads the catalogue data downloaded previously.
+// data is nil if there is no cache file.
+func readCachedServicesCatalogue() (data []byte, err error) {
+	data, err = os.ReadFile(filepath.Join(Context.getDataDir(), servicesCatalogueFile))
+	if errors.Is(err, os.ErrNotExist) {
+		log.Debug("blocked services: no cached catalogue yet")
+
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading cache: %w", err)
+	}
+
+	return data, nil
+}
+
+// fetchServicesCatalogue downloads the catalogue data from catURL and caches
+// it in the data directory.
+func fetchServicesCatalogue(catURL string) (data []byte, err error) {
+	resp, err := Context.client.Get(catURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching catalogue: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching catalogue: got status code %d", resp.StatusCode)
+	}
+
+	data, err = readLimitedCatalogue(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure the data is valid before caching it.
+	_, err = filtering.ParseServicesCatalogue(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", catURL, err)
+	}
+
+	err = os.MkdirAll(Context.getDataDir(), 0o755)
+	if err == nil {
+		err = maybe.WriteFile(filepath.Join(Context.getDataDir(), servicesCatalogueFile), data, 0o644)
+	}
+	if err != nil {
+		log.Error("blocked services: caching catalogue: %s", err)
+	}
+
+	return data, nil
+}
+
+// readLimitedCatalogue reads no more than maxServicesCatalogueSize bytes from
+// r.
+func readLimitedCatalogue(r io.Reader) (data []byte, err error) {
+	lr, err := aghio.LimitReader(r, maxServicesCatalogueSize)
+	if err != nil {
+		// Should not happen, since the limit is positive.
+		return nil, err
+	}
+
+	data, err = io.ReadAll(lr)
+	if err != nil {
+		return nil, fmt.Errorf("reading catalogue: %w", err)
+	}
+
+	return data, nil
+}
+
+// refreshServicesCatalogue reloads the blocked services catalogue from the
+// configured source, bypassing the cache.
+func refreshServicesCatalogue() (err error) {
+	config.RLock()
+	src := config.DNS.DnsfilterConf.BlockedServicesCatalogue
+	custom := config.DNS.DnsfilterConf.CustomBlockedServices
+	config.RUnlock()
+
+	if src == "" {
+		return nil
+	}
+
+	cat, err := loadServicesCatalogue(src, false)
+	if err != nil {
+		return fmt.Errorf("loading catalogue: %w", err)
+	}
+
+	err = filtering.SetServicesCatalogue(cat, custom)
+	if err != nil {
+		return fmt.Errorf("setting catalogue: %w", err)
+	}
+
+	log.Info("blocked services: refreshed catalogue from %q", src)
+
+	return nil
+}
+
+// periodicallyRefreshServicesCatalogue refreshes the blocked services
+// catalogue right away and then with the configured interval.  It must only be
+// started after the DNS server, since the HTTP client uses it to resolve the
+// hostnames.
+func periodicallyRefreshServicesCatalogue() {
+	defer log.OnPanic("blocked services")
+
+	for {
+		err := refreshServicesCatalogue()
+		if err != nil {
+			log.Error("blocked services: %s", err)
+		}
+
+		config.RLock()
+		ivl := config.DNS.DnsfilterConf.BlockedServicesCatalogueIvl.Duration
+		config.RUnlock()
+
+		if ivl <= 0 {
+			ivl = defaultServicesCatalogueIvl
+		}
+
+		time.Sleep(ivl)
+	}
+}
@@ -0,0 +1,76 @@
+package home
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadServicesCatalogue(t *testing.T) {
+	const catData = `{"blocked_services":[{"id":"example","name":"Example",` +
+		`"rules":["||example.org^"]}],"groups":[]}`
+
+	reqs := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		reqs++
+		_, _ = w.Write([]byte(catData))
+	}))
+	t.Cleanup(srv.Close)
+
+	Context = homeContext{
+		workDir: t.TempDir(),
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "services.json")
+		err := os.WriteFile(path, []byte(catData), 0o644)
+		require.NoError(t, err)
+
+		cat, err := loadServicesCatalogue(path, true)
+		require.NoError(t, err)
+		require.Len(t, cat.Services, 1)
+
+		assert.Equal(t, "example", cat.Services[0].ID)
+	})
+
+	t.Run("no_cache", func(t *testing.T) {
+		cat, err := loadServicesCatalogue(srv.URL, true)
+		require.NoError(t, err)
+
+		assert.Nil(t, cat)
+		assert.Equal(t, 0, reqs)
+	})
+
+	t.Run("url", func(t *testing.T) {
+		cat, err := loadServicesCatalogue(srv.URL, false)
+		require.NoError(t, err)
+		require.Len(t, cat.Services, 1)
+
+		assert.Equal(t, 1, reqs)
+		assert.FileExists(t, filepath.Join(Context.getDataDir(), servicesCatalogueFile))
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		cat, err := loadServicesCatalogue(srv.URL, true)
+		require.NoError(t, err)
+		require.Len(t, cat.Services, 1)
+
+		assert.Equal(t, 1, reqs)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		cat, err := loadServicesCatalogue("", true)
+		require.NoError(t, err)
+
+		assert.Nil(t, cat)
+	})
+}
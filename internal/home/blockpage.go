@@ -0,0 +1,116 @@
+package home
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/blockpage"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// initBlockPage initializes the block page module if it's enabled.
+func initBlockPage() (err error) {
+	if !config.BlockPage.Enabled {
+		return nil
+	}
+
+	conf := config.BlockPage
+	conf.ClientName = blockPageClientName
+	conf.FilterListName = filterListName
+	conf.OnApprove = approveUnblock
+	conf.HTTPRegister = httpRegister
+	conf.DataDir = Context.getDataDir()
+
+	Context.blockPage, err = blockpage.New(&conf)
+	if err != nil {
+		return fmt.Errorf("initializing block page: %w", err)
+	}
+
+	return nil
+}
+
+// blockPageClientName returns the name of the persistent or the runtime client
+// with ip, if any.
+func blockPageClientName(ip net.IP) (name string) {
+	if c, ok := Context.clients.Find(ip.String()); ok {
+		return c.Name
+	}
+
+	if rc, ok := Context.clients.FindRuntimeClient(ip); ok {
+		return rc.Host
+	}
+
+	return ""
+}
+
+// filterListName returns the human-readable name of the filter list with id,
+// if any.
+func filterListName(id int64) (name string) {
+	switch id {
+	case filtering.CustomListID:
+		return "Custom filtering rules"
+	case filtering.SysHostsListID:
+		return "System hosts file"
+	case filtering.BlockedSvcsListID:
+		return "Blocked services"
+	case filtering.ParentalListID:
+		return "Parental control"
+	case filtering.SafeBrowsingListID:
+		return "Safe browsing"
+	case filtering.SafeSearchListID:
+		return "Safe search"
+	case filtering.ThreatFeedListID:
+		return "Threat feeds"
+	case filtering.AnswerPolicyListID:
+		return "Answer policies"
+	default:
+		// Go on.
+	}
+
+	config.RLock()
+	defer config.RUnlock()
+
+	for _, filters := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for _, f := range filters {
+			if f.ID == id {
+				return f.Name
+			}
+		}
+	}
+
+	return ""
+}
+
+// addUserRule appends rule to the custom filtering rules unless it's already
+// there.  It returns true if the rule has been added.
+func addUserRule(rule string) (added bool) {
+	config.Lock()
+	defer config.Unlock()
+
+	for _, r := range config.UserRules {
+		if r == rule {
+			return false
+		}
+	}
+
+	config.UserRules = append(config.UserRules, rule)
+
+	return true
+}
+
+// approveUnblock unblocks host by adding an important allowlist rule for it to
+// the custom filtering rules.
+func approveUnblock(host string) (err error) {
+	rule := "@@||" + host + "^$important"
+	if !addUserRule(rule) {
+		return nil
+	}
+
+	log.Info("block page: approved unblocking of %q with rule %q", host, rule)
+
+	onConfigModified()
+	enableFilters(true)
+
+	return nil
+}
@@ -0,0 +1,330 @@
+package home
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+)
+
+// clientGroup is a group of persistent clients.  The members of the group
+// inherit its settings unless they have their own ones.
+type clientGroup struct {
+	// upstreamConfig is the cached upstream configuration of the group.  It's
+	// nil if it has not been initialized yet.
+	upstreamConfig *proxy.UpstreamConfig
+
+	// BlockedServicesSchedule is the schedule during which the blocked
+	// services of the group are blocked for the members inheriting them.
+	// They're blocked all the time if it's nil.  The own blocked services of
+	// the members and the global ones aren't affected.
+	BlockedServicesSchedule *weeklySchedule
+
+	// SafeSearchEngines are the restriction levels of the search engines for
+	// the members by their names.  See Client.SafeSearchEngines.
+	SafeSearchEngines map[string]string
+
+	Name string
+
+	BlockedServices []string
+	Upstreams       []string
+
+	// FilterLists are the IDs of the filter lists applied to the members if
+	// UseOwnFilterLists is true.
+	FilterLists []int64
+
+	UseOwnSettings        bool
+	FilteringEnabled      bool
+	SafeSearchEnabled     bool
+	SafeBrowsingEnabled   bool
+	ParentalEnabled       bool
+	UseOwnBlockedServices bool
+	UseOwnFilterLists     bool
+}
+
+// clientGroupObject is the YAML representation of a client group.
+type clientGroupObject struct {
+	BlockedServicesSchedule *weeklySchedule `yaml:"blocked_services_schedule"`
+
+	SafeSearchEngines map[string]string `yaml:"safesearch_engines"`
+
+	Name string `yaml:"name"`
+
+	BlockedServices []string `yaml:"blocked_services"`
+	Upstreams       []string `yaml:"upstreams"`
+
+	FilterLists []int64 `yaml:"filter_lists"`
+
+	UseGlobalSettings        bool `yaml:"use_global_settings"`
+	FilteringEnabled         bool `yaml:"filtering_enabled"`
+	ParentalEnabled          bool `yaml:"parental_enabled"`
+	SafeSearchEnabled        bool `yaml:"safesearch_enabled"`
+	SafeBrowsingEnabled      bool `yaml:"safebrowsing_enabled"`
+	UseGlobalBlockedServices bool `yaml:"use_global_blocked_services"`
+	UseOwnFilterLists        bool `yaml:"use_own_filter_lists"`
+}
+
+// addGroupsFromConfig initializes the client groups with objects from the
+// configuration file.
+func (clients *clientsContainer) addGroupsFromConfig(objects []*clientGroupObject) {
+	for _, o := range objects {
+		g := &clientGroup{
+			BlockedServicesSchedule: o.BlockedServicesSchedule,
+			SafeSearchEngines:       o.SafeSearchEngines,
+
+			Name: o.Name,
+
+			Upstreams:   o.Upstreams,
+			FilterLists: o.FilterLists,
+
+			UseOwnSettings:        !o.UseGlobalSettings,
+			FilteringEnabled:      o.FilteringEnabled,
+			SafeSearchEnabled:     o.SafeSearchEnabled,
+			SafeBrowsingEnabled:   o.SafeBrowsingEnabled,
+			ParentalEnabled:       o.ParentalEnabled,
+			UseOwnBlockedServices: !o.UseGlobalBlockedServices,
+			UseOwnFilterLists:     o.UseOwnFilterLists,
+		}
+
+		for _, s := range o.BlockedServices {
+			if filtering.BlockedSvcKnown(s) {
+				g.BlockedServices = append(g.BlockedServices, s)
+			} else {
+				log.Info("clients: group %s: skipping unknown blocked service %q", g.Name, s)
+			}
+		}
+
+		err := clients.AddGroup(g)
+		if err != nil {
+			log.Error("clients: adding group %s: %s", g.Name, err)
+		}
+	}
+}
+
+// groupsForConfig returns all client groups as objects for the configuration
+// file.
+func (clients *clientsContainer) groupsForConfig() (objs []*clientGroupObject) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	objs = make([]*clientGroupObject, 0, len(clients.groups))
+	for _, g := range clients.groups {
+		objs = append(objs, &clientGroupObject{
+			BlockedServicesSchedule: g.BlockedServicesSchedule,
+			SafeSearchEngines:       cloneSafeSearchEngines(g.SafeSearchEngines),
+
+			Name: g.Name,
+
+			BlockedServices: stringutil.CloneSlice(g.BlockedServices),
+			Upstreams:       stringutil.CloneSlice(g.Upstreams),
+			FilterLists:     append([]int64(nil), g.FilterLists...),
+
+			UseGlobalSettings:        !g.UseOwnSettings,
+			FilteringEnabled:         g.FilteringEnabled,
+			ParentalEnabled:          g.ParentalEnabled,
+			SafeSearchEnabled:        g.SafeSearchEnabled,
+			SafeBrowsingEnabled:      g.SafeBrowsingEnabled,
+			UseGlobalBlockedServices: !g.UseOwnBlockedServices,
+			UseOwnFilterLists:        g.UseOwnFilterLists,
+		})
+	}
+
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Name < objs[j].Name })
+
+	return objs
+}
+
+// checkGroup validates the client group.
+func checkGroup(g *clientGroup) (err error) {
+	switch {
+	case g == nil:
+		return errors.Error("group is nil")
+	case g.Name == "":
+		return errors.Error("invalid name")
+	default:
+		// Go on.
+	}
+
+	if g.BlockedServicesSchedule != nil {
+		err = g.BlockedServicesSchedule.validate()
+		if err != nil {
+			return fmt.Errorf("invalid blocked services schedule: %w", err)
+		}
+	}
+
+	err = dnsforward.ValidateUpstreams(g.Upstreams)
+	if err != nil {
+		return fmt.Errorf("invalid upstream servers: %w", err)
+	}
+
+	return nil
+}
+
+// AddGroup adds a new client group.
+func (clients *clientsContainer) AddGroup(g *clientGroup) (err error) {
+	err = checkGroup(g)
+	if err != nil {
+		return err
+	}
+
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	if _, ok := clients.groups[g.Name]; ok {
+		return errors.Error("group already exists")
+	}
+
+	clients.groups[g.Name] = g
+
+	return nil
+}
+
+// DelGroup removes a client group.  The group mustn't have any members.
+func (clients *clientsContainer) DelGroup(name string) (err error) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	if _, ok := clients.groups[name]; !ok {
+		return errors.Error("group not found")
+	}
+
+	for _, c := range clients.list {
+		if c.Group == name {
+			return fmt.Errorf("group is used by client %q", c.Name)
+		}
+	}
+
+	delete(clients.groups, name)
+
+	return nil
+}
+
+// UpdateGroup updates a client group by its name.  The members of the renamed
+// group are moved to the new name.
+func (clients *clientsContainer) UpdateGroup(name string, g *clientGroup) (err error) {
+	err = checkGroup(g)
+	if err != nil {
+		return err
+	}
+
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	prev, ok := clients.groups[name]
+	if !ok {
+		return errors.Error("group not found")
+	}
+
+	if prev.Name != g.Name {
+		if _, ok = clients.groups[g.Name]; ok {
+			return errors.Error("group already exists")
+		}
+
+		for _, c := range clients.list {
+			if c.Group == prev.Name {
+				c.Group = g.Name
+			}
+		}
+
+		delete(clients.groups, prev.Name)
+		clients.groups[g.Name] = prev
+	}
+
+	// Update the upstreams cache.
+	g.upstreamConfig = nil
+
+	*prev = *g
+
+	return nil
+}
+
+// groupOfLocked returns the group of c, if any.  clients.lock is expected to
+// be locked.
+func (clients *clientsContainer) groupOfLocked(c *Client) (g *clientGroup) {
+	if c.Group == "" {
+		return nil
+	}
+
+	return clients.groups[c.Group]
+}
+
+// filteringSettings sets the filtering settings of the persistent client
+// identified by clientID or ip, if any, to setts.  The settings the client
+// doesn't have are inherited from its group.  svcs are the names of the
+// services blocked for the client at now, and ownSvcs is false if the client
+// uses the global blocked services.
+func (clients *clientsContainer) filteringSettings(
+	setts *filtering.Settings,
+	ip net.IP,
+	clientID string,
+	now time.Time,
+) (svcs []string, ownSvcs bool) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	c, ok := clients.findLocked(clientID)
+	if !ok {
+		c, ok = clients.findLocked(ip.String())
+		if !ok {
+			return nil, false
+		}
+	}
+
+	log.Debug("using settings for client %s with ip %s and id %q", c.Name, ip, clientID)
+
+	g := clients.groupOfLocked(c)
+
+	switch {
+	case c.UseOwnBlockedServices:
+		svcs, ownSvcs = stringutil.CloneSlice(c.BlockedServices), true
+	case g != nil && g.UseOwnBlockedServices:
+		// The schedule only limits the services of the group itself.
+		if g.BlockedServicesSchedule == nil || g.BlockedServicesSchedule.contains(now) {
+			svcs = stringutil.CloneSlice(g.BlockedServices)
+		}
+
+		ownSvcs = true
+	default:
+		// Use the global blocked services.
+	}
+
+	setts.ClientName = c.Name
+	setts.ClientTags = stringutil.CloneSlice(c.Tags)
+
+	switch {
+	case c.UseOwnFilterLists:
+		// Make sure the IDs are non-nil, since nil means the global filter
+		// lists.
+		setts.FilterListIDs = append([]int64{}, c.FilterLists...)
+	case g != nil && g.UseOwnFilterLists:
+		setts.FilterListIDs = append([]int64{}, g.FilterLists...)
+	default:
+		// Use the global filter lists.
+	}
+
+	switch {
+	case c.UseOwnSettings:
+		setts.FilteringEnabled = c.FilteringEnabled
+		setts.SafeSearchEnabled = c.SafeSearchEnabled
+		setts.SafeSearchEngines = c.SafeSearchEngines
+		setts.SafeBrowsingEnabled = c.SafeBrowsingEnabled
+		setts.ParentalEnabled = c.ParentalEnabled
+	case g != nil && g.UseOwnSettings:
+		setts.FilteringEnabled = g.FilteringEnabled
+		setts.SafeSearchEnabled = g.SafeSearchEnabled
+		setts.SafeSearchEngines = g.SafeSearchEngines
+		setts.SafeBrowsingEnabled = g.SafeBrowsingEnabled
+		setts.ParentalEnabled = g.ParentalEnabled
+	default:
+		// Use the global settings.
+	}
+
+	return svcs, ownSvcs
+}
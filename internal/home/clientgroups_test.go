@@ -0,0 +1,154 @@
+package home
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientsContainer_groups(t *testing.T) {
+	clients := clientsContainer{
+		testing: true,
+	}
+	clients.Init(nil, nil, nil, nil)
+
+	err := clients.AddGroup(&clientGroup{
+		Name:                  "kids",
+		BlockedServices:       []string{"youtube"},
+		FilterLists:           []int64{2},
+		UseOwnSettings:        true,
+		FilteringEnabled:      true,
+		SafeSearchEnabled:     true,
+		ParentalEnabled:       true,
+		UseOwnBlockedServices: true,
+		UseOwnFilterLists:     true,
+		BlockedServicesSchedule: &weeklySchedule{
+			TimeZone: "UTC",
+			Ranges: []*scheduleRange{{
+				Days:  []string{"mon"},
+				Start: "08:00",
+				End:   "15:00",
+			}},
+		},
+	})
+	require.NoError(t, err)
+
+	ok, err := clients.Add(&Client{
+		Name:  "inheriting",
+		Group: "kids",
+		IDs:   []string{"1.1.1.1"},
+	})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = clients.Add(&Client{
+		Name:                  "overriding",
+		Group:                 "kids",
+		IDs:                   []string{"2.2.2.2"},
+		BlockedServices:       []string{"tiktok"},
+		UseOwnSettings:        true,
+		UseOwnBlockedServices: true,
+	})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// Monday.
+	inSchedule := time.Date(2021, 11, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("inherited", func(t *testing.T) {
+		setts := &filtering.Settings{}
+		svcs, own := clients.filteringSettings(setts, net.IP{1, 1, 1, 1}, "", inSchedule)
+		require.True(t, own)
+
+		assert.Equal(t, []string{"youtube"}, svcs)
+		assert.True(t, setts.FilteringEnabled)
+		assert.True(t, setts.SafeSearchEnabled)
+		assert.True(t, setts.ParentalEnabled)
+		assert.False(t, setts.SafeBrowsingEnabled)
+		assert.Equal(t, []int64{2}, setts.FilterListIDs)
+	})
+
+	t.Run("overridden", func(t *testing.T) {
+		setts := &filtering.Settings{}
+		svcs, own := clients.filteringSettings(setts, net.IP{2, 2, 2, 2}, "", inSchedule)
+		require.True(t, own)
+
+		assert.Equal(t, []string{"tiktok"}, svcs)
+		assert.False(t, setts.FilteringEnabled)
+		assert.False(t, setts.ParentalEnabled)
+	})
+
+	t.Run("out_of_schedule", func(t *testing.T) {
+		setts := &filtering.Settings{}
+		svcs, own := clients.filteringSettings(
+			setts,
+			net.IP{1, 1, 1, 1},
+			"",
+			inSchedule.Add(24*time.Hour),
+		)
+		require.True(t, own)
+
+		assert.Empty(t, svcs)
+	})
+
+	t.Run("own_out_of_schedule", func(t *testing.T) {
+		setts := &filtering.Settings{}
+		svcs, own := clients.filteringSettings(
+			setts,
+			net.IP{2, 2, 2, 2},
+			"",
+			inSchedule.Add(24*time.Hour),
+		)
+		require.True(t, own)
+
+		// The schedule of the group doesn't affect the client's own
+		// services.
+		assert.Equal(t, []string{"tiktok"}, svcs)
+	})
+
+	t.Run("unknown_client", func(t *testing.T) {
+		setts := &filtering.Settings{}
+		_, own := clients.filteringSettings(setts, net.IP{3, 3, 3, 3}, "", inSchedule)
+
+		assert.False(t, own)
+		assert.Nil(t, setts.FilterListIDs)
+	})
+
+	t.Run("add_unknown_group", func(t *testing.T) {
+		ok, err = clients.Add(&Client{
+			Name:  "client",
+			Group: "unknown",
+			IDs:   []string{"3.3.3.3"},
+		})
+		testutil.AssertErrorMsg(t, `group "unknown" not found`, err)
+
+		assert.False(t, ok)
+	})
+
+	t.Run("del_used", func(t *testing.T) {
+		err = clients.DelGroup("kids")
+		require.Error(t, err)
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		err = clients.UpdateGroup("kids", &clientGroup{
+			Name: "children",
+		})
+		require.NoError(t, err)
+
+		c, found := clients.Find("1.1.1.1")
+		require.True(t, found)
+
+		assert.Equal(t, "children", c.Group)
+
+		objs := clients.groupsForConfig()
+		require.Len(t, objs, 1)
+
+		assert.Equal(t, "children", objs[0].Name)
+	})
+}
@@ -0,0 +1,228 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+)
+
+// clientGroupJSON is the JSON representation of a client group.
+type clientGroupJSON struct {
+	// BlockedServicesSchedule is the schedule during which the blocked
+	// services of the members are blocked.
+	BlockedServicesSchedule *weeklySchedule `json:"blocked_services_schedule"`
+
+	// SafeSearchEngines are the restriction levels of the search engines by
+	// their names.
+	SafeSearchEngines map[string]string `json:"safesearch_engines,omitempty"`
+
+	Name string `json:"name"`
+
+	BlockedServices []string `json:"blocked_services"`
+	Upstreams       []string `json:"upstreams"`
+
+	// FilterLists are the IDs of the filter lists applied to the members if
+	// UseOwnFilterLists is true.
+	FilterLists []int64 `json:"filter_lists"`
+
+	// Members are the names of the clients in the group.  It's only used in
+	// responses.
+	Members []string `json:"members"`
+
+	FilteringEnabled         bool `json:"filtering_enabled"`
+	ParentalEnabled          bool `json:"parental_enabled"`
+	SafeBrowsingEnabled      bool `json:"safebrowsing_enabled"`
+	SafeSearchEnabled        bool `json:"safesearch_enabled"`
+	UseGlobalBlockedServices bool `json:"use_global_blocked_services"`
+	UseGlobalSettings        bool `json:"use_global_settings"`
+	UseOwnFilterLists        bool `json:"use_own_filter_lists"`
+}
+
+// clientGroupListJSON is the response for getting the client groups.
+type clientGroupListJSON struct {
+	Groups []*clientGroupJSON `json:"groups"`
+}
+
+// jsonToClientGroup converts the JSON representation of a client group.
+func jsonToClientGroup(gj *clientGroupJSON) (g *clientGroup) {
+	return &clientGroup{
+		BlockedServicesSchedule: gj.BlockedServicesSchedule,
+		SafeSearchEngines:       gj.SafeSearchEngines,
+
+		Name: gj.Name,
+
+		BlockedServices: gj.BlockedServices,
+		Upstreams:       gj.Upstreams,
+		FilterLists:     gj.FilterLists,
+
+		UseOwnSettings:        !gj.UseGlobalSettings,
+		FilteringEnabled:      gj.FilteringEnabled,
+		SafeSearchEnabled:     gj.SafeSearchEnabled,
+		SafeBrowsingEnabled:   gj.SafeBrowsingEnabled,
+		ParentalEnabled:       gj.ParentalEnabled,
+		UseOwnBlockedServices: !gj.UseGlobalBlockedServices,
+		UseOwnFilterLists:     gj.UseOwnFilterLists,
+	}
+}
+
+// clientGroupToJSON converts the client group into its JSON representation.
+// members are the names of the clients in the group.
+func clientGroupToJSON(g *clientGroup, members []string) (gj *clientGroupJSON) {
+	return &clientGroupJSON{
+		BlockedServicesSchedule: g.BlockedServicesSchedule,
+		SafeSearchEngines:       g.SafeSearchEngines,
+
+		Name: g.Name,
+
+		BlockedServices: g.BlockedServices,
+		Upstreams:       g.Upstreams,
+		FilterLists:     g.FilterLists,
+		Members:         members,
+
+		FilteringEnabled:         g.FilteringEnabled,
+		ParentalEnabled:          g.ParentalEnabled,
+		SafeBrowsingEnabled:      g.SafeBrowsingEnabled,
+		SafeSearchEnabled:        g.SafeSearchEnabled,
+		UseGlobalBlockedServices: !g.UseOwnBlockedServices,
+		UseGlobalSettings:        !g.UseOwnSettings,
+		UseOwnFilterLists:        g.UseOwnFilterLists,
+	}
+}
+
+// handleGetGroups is the handler for the GET /control/groups HTTP API.
+func (clients *clientsContainer) handleGetGroups(w http.ResponseWriter, r *http.Request) {
+	data := &clientGroupListJSON{
+		Groups: []*clientGroupJSON{},
+	}
+
+	func() {
+		clients.lock.Lock()
+		defer clients.lock.Unlock()
+
+		members := map[string][]string{}
+		for _, c := range clients.list {
+			if c.Group != "" {
+				members[c.Group] = append(members[c.Group], c.Name)
+			}
+		}
+
+		for _, g := range clients.groups {
+			names := members[g.Name]
+			if names == nil {
+				names = []string{}
+			}
+
+			sort.Strings(names)
+			data.Groups = append(data.Groups, clientGroupToJSON(g, names))
+		}
+	}()
+
+	sort.Slice(data.Groups, func(i, j int) bool { return data.Groups[i].Name < data.Groups[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "json encode: %s", err)
+	}
+}
+
+// handleAddGroup is the handler for the POST /control/groups/add HTTP API.
+func (clients *clientsContainer) handleAddGroup(w http.ResponseWriter, r *http.Request) {
+	gj := &clientGroupJSON{}
+	err := json.NewDecoder(r.Body).Decode(gj)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "failed to process request body: %s", err)
+
+		return
+	}
+
+	g := jsonToClientGroup(gj)
+	err = validateFilteringSettings(g.UseOwnFilterLists, g.FilterLists, g.SafeSearchEngines)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	err = clients.AddGroup(g)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	onConfigModified()
+
+	if g.UseOwnFilterLists {
+		// Load the newly selected filter lists.
+		enableFilters(true)
+	}
+}
+
+// handleDelGroup is the handler for the POST /control/groups/delete HTTP API.
+func (clients *clientsContainer) handleDelGroup(w http.ResponseWriter, r *http.Request) {
+	gj := &clientGroupJSON{}
+	err := json.NewDecoder(r.Body).Decode(gj)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "failed to process request body: %s", err)
+
+		return
+	}
+
+	err = clients.DelGroup(gj.Name)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
+
+// updateGroupJSON is the request for updating a client group.
+type updateGroupJSON struct {
+	Data *clientGroupJSON `json:"data"`
+	Name string           `json:"name"`
+}
+
+// handleUpdateGroup is the handler for the POST /control/groups/update HTTP
+// API.
+func (clients *clientsContainer) handleUpdateGroup(w http.ResponseWriter, r *http.Request) {
+	uj := &updateGroupJSON{}
+	err := json.NewDecoder(r.Body).Decode(uj)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "failed to process request body: %s", err)
+
+		return
+	}
+
+	if uj.Name == "" || uj.Data == nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "invalid request")
+
+		return
+	}
+
+	g := jsonToClientGroup(uj.Data)
+	err = validateFilteringSettings(g.UseOwnFilterLists, g.FilterLists, g.SafeSearchEngines)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	err = clients.UpdateGroup(uj.Name, g)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	onConfigModified()
+
+	if g.UseOwnFilterLists {
+		// Load the newly selected filter lists.
+		enableFilters(true)
+	}
+}
@@ -38,6 +38,10 @@ type Client struct {
 
 	Name string
 
+	// Group is the name of the client group the client belongs to, if any.
+	// The client inherits the settings of the group it doesn't have.
+	Group string
+
 	IDs             []string
 	Tags            []string
 	BlockedServices []string
@@ -49,6 +53,25 @@ type Client struct {
 	SafeBrowsingEnabled   bool
 	ParentalEnabled       bool
 	UseOwnBlockedServices bool
+
+	// SafeSearchEngines are the restriction levels of the search engines for
+	// the client by their names.  Like the other own settings, they replace
+	// the global ones, so the engines not listed here use their default
+	// levels.
+	SafeSearchEngines map[string]string
+
+	// FilterLists are the IDs of the filter lists applied to the client if
+	// UseOwnFilterLists is true.
+	FilterLists []int64
+
+	// BypassCache defines if the responses to the client mustn't be cached
+	// or served from the cache.
+	BypassCache bool
+
+	// UseOwnFilterLists defines if only FilterLists and the custom filtering
+	// rules are applied to the client instead of the global filter lists and
+	// the ones assigned to its tags.
+	UseOwnFilterLists bool
 }
 
 type clientSource uint
@@ -82,6 +105,9 @@ type clientsContainer struct {
 	list    map[string]*Client // name -> client
 	idIndex map[string]*Client // ID -> client
 
+	// groups are the client groups by their names.
+	groups map[string]*clientGroup
+
 	// ipToRC is the IP address to *RuntimeClient map.
 	ipToRC *netutil.IPMap
 
@@ -107,6 +133,7 @@ type clientsContainer struct {
 // Note: this function must be called only once
 func (clients *clientsContainer) Init(
 	objects []*clientObject,
+	groups []*clientGroupObject,
 	dhcpServer *dhcpd.Server,
 	etcHosts *aghnet.HostsContainer,
 ) {
@@ -115,12 +142,14 @@ func (clients *clientsContainer) Init(
 	}
 	clients.list = make(map[string]*Client)
 	clients.idIndex = make(map[string]*Client)
+	clients.groups = make(map[string]*clientGroup)
 	clients.ipToRC = netutil.NewIPMap(0)
 
 	clients.allTags = stringutil.NewSet(clientTags...)
 
 	clients.dhcpServer = dhcpServer
 	clients.etcHosts = etcHosts
+	clients.addGroupsFromConfig(groups)
 	clients.addFromConfig(objects)
 
 	if clients.testing {
@@ -160,19 +189,41 @@ func (clients *clientsContainer) Reload() {
 }
 
 type clientObject struct {
-	Name string `yaml:"name"`
+	Name  string `yaml:"name"`
+	Group string `yaml:"group,omitempty"`
 
 	Tags            []string `yaml:"tags"`
 	IDs             []string `yaml:"ids"`
 	BlockedServices []string `yaml:"blocked_services"`
 	Upstreams       []string `yaml:"upstreams"`
 
+	SafeSearchEngines map[string]string `yaml:"safesearch_engines"`
+
+	FilterLists []int64 `yaml:"filter_lists"`
+
 	UseGlobalSettings        bool `yaml:"use_global_settings"`
 	FilteringEnabled         bool `yaml:"filtering_enabled"`
 	ParentalEnabled          bool `yaml:"parental_enabled"`
 	SafeSearchEnabled        bool `yaml:"safesearch_enabled"`
 	SafeBrowsingEnabled      bool `yaml:"safebrowsing_enabled"`
 	UseGlobalBlockedServices bool `yaml:"use_global_blocked_services"`
+	BypassCache              bool `yaml:"bypass_cache"`
+	UseOwnFilterLists        bool `yaml:"use_own_filter_lists"`
+}
+
+// cloneSafeSearchEngines returns a copy of the restriction levels of the search
+// engines.
+func cloneSafeSearchEngines(engines map[string]string) (clone map[string]string) {
+	if engines == nil {
+		return nil
+	}
+
+	clone = make(map[string]string, len(engines))
+	for name, level := range engines {
+		clone[name] = level
+	}
+
+	return clone
 }
 
 // addFromConfig initializes the clients containter with objects from the
@@ -180,7 +231,8 @@ type clientObject struct {
 func (clients *clientsContainer) addFromConfig(objects []*clientObject) {
 	for _, o := range objects {
 		cli := &Client{
-			Name: o.Name,
+			Name:  o.Name,
+			Group: o.Group,
 
 			IDs:       o.IDs,
 			Upstreams: o.Upstreams,
@@ -191,6 +243,10 @@ func (clients *clientsContainer) addFromConfig(objects []*clientObject) {
 			SafeSearchEnabled:     o.SafeSearchEnabled,
 			SafeBrowsingEnabled:   o.SafeBrowsingEnabled,
 			UseOwnBlockedServices: !o.UseGlobalBlockedServices,
+			SafeSearchEngines:     o.SafeSearchEngines,
+			FilterLists:           o.FilterLists,
+			BypassCache:           o.BypassCache,
+			UseOwnFilterLists:     o.UseOwnFilterLists,
 		}
 
 		for _, s := range o.BlockedServices {
@@ -227,19 +283,26 @@ func (clients *clientsContainer) forConfig() (objs []*clientObject) {
 	objs = make([]*clientObject, 0, len(clients.list))
 	for _, cli := range clients.list {
 		o := &clientObject{
-			Name: cli.Name,
+			Name:  cli.Name,
+			Group: cli.Group,
 
 			Tags:            stringutil.CloneSlice(cli.Tags),
 			IDs:             stringutil.CloneSlice(cli.IDs),
 			BlockedServices: stringutil.CloneSlice(cli.BlockedServices),
 			Upstreams:       stringutil.CloneSlice(cli.Upstreams),
 
+			SafeSearchEngines: cloneSafeSearchEngines(cli.SafeSearchEngines),
+
+			FilterLists: append([]int64(nil), cli.FilterLists...),
+
 			UseGlobalSettings:        !cli.UseOwnSettings,
 			FilteringEnabled:         cli.FilteringEnabled,
 			ParentalEnabled:          cli.ParentalEnabled,
 			SafeSearchEnabled:        cli.SafeSearchEnabled,
 			SafeBrowsingEnabled:      cli.SafeBrowsingEnabled,
 			UseGlobalBlockedServices: !cli.UseOwnBlockedServices,
+			BypassCache:              cli.BypassCache,
+			UseOwnFilterLists:        cli.UseOwnFilterLists,
 		}
 
 		objs = append(objs, o)
@@ -254,6 +317,32 @@ func (clients *clientsContainer) forConfig() (objs []*clientObject) {
 	return objs
 }
 
+// selectedFilterLists returns the IDs of the filter lists which the clients or
+// the groups use as their own filter lists.
+func (clients *clientsContainer) selectedFilterLists() (ids map[int64]bool) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	ids = map[int64]bool{}
+	for _, c := range clients.list {
+		if c.UseOwnFilterLists {
+			for _, id := range c.FilterLists {
+				ids[id] = true
+			}
+		}
+	}
+
+	for _, g := range clients.groups {
+		if g.UseOwnFilterLists {
+			for _, id := range g.FilterLists {
+				ids[id] = true
+			}
+		}
+	}
+
+	return ids
+}
+
 func (clients *clientsContainer) periodicUpdate() {
 	for {
 		clients.Reload()
@@ -380,12 +469,14 @@ func (clients *clientsContainer) Find(id string) (c *Client, ok bool) {
 	c.Tags = stringutil.CloneSlice(c.Tags)
 	c.BlockedServices = stringutil.CloneSlice(c.BlockedServices)
 	c.Upstreams = stringutil.CloneSlice(c.Upstreams)
+	c.FilterLists = append([]int64(nil), c.FilterLists...)
 	return c, true
 }
 
 // findUpstreams returns upstreams configured for the client, identified either
-// by its IP address or its ClientID.  upsConf is nil if the client isn't found
-// or if the client has no custom upstreams.
+// by its IP address or its ClientID.  The client without custom upstreams
+// inherits the ones of its group.  upsConf is nil if the client isn't found or
+// if neither the client nor its group has custom upstreams.
 func (clients *clientsContainer) findUpstreams(
 	id string,
 ) (upsConf *proxy.UpstreamConfig, err error) {
@@ -397,13 +488,19 @@ func (clients *clientsContainer) findUpstreams(
 		return nil, nil
 	}
 
+	cached := &c.upstreamConfig
 	upstreams := stringutil.FilterOut(c.Upstreams, dnsforward.IsCommentOrEmpty)
+	if g := clients.groupOfLocked(c); len(upstreams) == 0 && g != nil {
+		cached = &g.upstreamConfig
+		upstreams = stringutil.FilterOut(g.Upstreams, dnsforward.IsCommentOrEmpty)
+	}
+
 	if len(upstreams) == 0 {
 		return nil, nil
 	}
 
-	if c.upstreamConfig != nil {
-		return c.upstreamConfig, nil
+	if *cached != nil {
+		return *cached, nil
 	}
 
 	var conf *proxy.UpstreamConfig
@@ -418,11 +515,22 @@ func (clients *clientsContainer) findUpstreams(
 		return nil, err
 	}
 
-	c.upstreamConfig = conf
+	*cached = conf
 
 	return conf, nil
 }
 
+// isCacheBypassed returns true if the responses to the client, identified
+// either by its IP address or its ClientID, mustn't be cached.
+func (clients *clientsContainer) isCacheBypassed(id string) (ok bool) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	c, ok := clients.findLocked(id)
+
+	return ok && c.BypassCache
+}
+
 // findLocked searches for a client by its ID.  For internal use only.
 func (clients *clientsContainer) findLocked(id string) (c *Client, ok bool) {
 	c, ok = clients.idIndex[id]
@@ -552,6 +660,18 @@ func (clients *clientsContainer) check(c *Client) (err error) {
 	return nil
 }
 
+// checkGroupExistsLocked returns an error if the group of c doesn't exist.
+// clients.lock is expected to be locked.
+func (clients *clientsContainer) checkGroupExistsLocked(c *Client) (err error) {
+	if c.Group == "" {
+		return nil
+	} else if _, ok := clients.groups[c.Group]; !ok {
+		return fmt.Errorf("group %q not found", c.Group)
+	}
+
+	return nil
+}
+
 // Add adds a new client object.  ok is false if such client already exists or
 // if an error occurred.
 func (clients *clientsContainer) Add(c *Client) (ok bool, err error) {
@@ -563,6 +683,11 @@ func (clients *clientsContainer) Add(c *Client) (ok bool, err error) {
 	clients.lock.Lock()
 	defer clients.lock.Unlock()
 
+	err = clients.checkGroupExistsLocked(c)
+	if err != nil {
+		return false, err
+	}
+
 	// check Name index
 	_, ok = clients.list[c.Name]
 	if ok {
@@ -643,6 +768,11 @@ func (clients *clientsContainer) Update(name string, c *Client) (err error) {
 		return errors.Error("client not found")
 	}
 
+	err = clients.checkGroupExistsLocked(c)
+	if err != nil {
+		return err
+	}
+
 	// First, check the name index.
 	if prev.Name != c.Name {
 		_, ok = clients.list[c.Name]
@@ -31,17 +31,30 @@ type clientJSON struct {
 
 	Name string `json:"name"`
 
+	// Group is the name of the client group, if any.
+	Group string `json:"group"`
+
 	BlockedServices []string `json:"blocked_services"`
 	IDs             []string `json:"ids"`
 	Tags            []string `json:"tags"`
 	Upstreams       []string `json:"upstreams"`
 
+	// SafeSearchEngines are the restriction levels of the search engines by
+	// their names.
+	SafeSearchEngines map[string]string `json:"safesearch_engines,omitempty"`
+
+	// FilterLists are the IDs of the filter lists applied to the client if
+	// UseOwnFilterLists is true.
+	FilterLists []int64 `json:"filter_lists"`
+
 	FilteringEnabled         bool `json:"filtering_enabled"`
 	ParentalEnabled          bool `json:"parental_enabled"`
 	SafeBrowsingEnabled      bool `json:"safebrowsing_enabled"`
 	SafeSearchEnabled        bool `json:"safesearch_enabled"`
 	UseGlobalBlockedServices bool `json:"use_global_blocked_services"`
 	UseGlobalSettings        bool `json:"use_global_settings"`
+	BypassCache              bool `json:"bypass_cache"`
+	UseOwnFilterLists        bool `json:"use_own_filter_lists"`
 }
 
 type runtimeClientJSON struct {
@@ -123,6 +136,7 @@ func (clients *clientsContainer) handleGetClients(w http.ResponseWriter, r *http
 func jsonToClient(cj clientJSON) (c *Client) {
 	return &Client{
 		Name:                cj.Name,
+		Group:               cj.Group,
 		IDs:                 cj.IDs,
 		Tags:                cj.Tags,
 		UseOwnSettings:      !cj.UseGlobalSettings,
@@ -131,10 +145,17 @@ func jsonToClient(cj clientJSON) (c *Client) {
 		SafeSearchEnabled:   cj.SafeSearchEnabled,
 		SafeBrowsingEnabled: cj.SafeBrowsingEnabled,
 
+		SafeSearchEngines: cj.SafeSearchEngines,
+
 		UseOwnBlockedServices: !cj.UseGlobalBlockedServices,
 		BlockedServices:       cj.BlockedServices,
 
 		Upstreams: cj.Upstreams,
+
+		UseOwnFilterLists: cj.UseOwnFilterLists,
+		FilterLists:       cj.FilterLists,
+
+		BypassCache: cj.BypassCache,
 	}
 }
 
@@ -142,6 +163,7 @@ func jsonToClient(cj clientJSON) (c *Client) {
 func clientToJSON(c *Client) (cj *clientJSON) {
 	return &clientJSON{
 		Name:                c.Name,
+		Group:               c.Group,
 		IDs:                 c.IDs,
 		Tags:                c.Tags,
 		UseGlobalSettings:   !c.UseOwnSettings,
@@ -150,11 +172,35 @@ func clientToJSON(c *Client) (cj *clientJSON) {
 		SafeSearchEnabled:   c.SafeSearchEnabled,
 		SafeBrowsingEnabled: c.SafeBrowsingEnabled,
 
+		SafeSearchEngines: c.SafeSearchEngines,
+
 		UseGlobalBlockedServices: !c.UseOwnBlockedServices,
 		BlockedServices:          c.BlockedServices,
 
 		Upstreams: c.Upstreams,
+
+		UseOwnFilterLists: c.UseOwnFilterLists,
+		FilterLists:       c.FilterLists,
+
+		BypassCache: c.BypassCache,
+	}
+}
+
+// validateFilteringSettings returns an error if the own filter lists or the
+// restriction levels of the search engines of a client or a group are invalid.
+func validateFilteringSettings(
+	useOwnFilterLists bool,
+	filterLists []int64,
+	safeSearchEngines map[string]string,
+) (err error) {
+	if useOwnFilterLists {
+		err = validateFilterListIDs(filterLists)
+		if err != nil {
+			return err
+		}
 	}
+
+	return Context.dnsFilter.ValidateSafeSearchEngines(safeSearchEngines)
 }
 
 // Add a new client
@@ -168,6 +214,13 @@ func (clients *clientsContainer) handleAddClient(w http.ResponseWriter, r *http.
 	}
 
 	c := jsonToClient(cj)
+	err = validateFilteringSettings(c.UseOwnFilterLists, c.FilterLists, c.SafeSearchEngines)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
 	ok, err := clients.Add(c)
 	if err != nil {
 		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
@@ -182,6 +235,11 @@ func (clients *clientsContainer) handleAddClient(w http.ResponseWriter, r *http.
 	}
 
 	onConfigModified()
+
+	if c.UseOwnFilterLists {
+		// Load the newly selected filter lists.
+		enableFilters(true)
+	}
 }
 
 // Remove client
@@ -230,6 +288,13 @@ func (clients *clientsContainer) handleUpdateClient(w http.ResponseWriter, r *ht
 	}
 
 	c := jsonToClient(dj.Data)
+	err = validateFilteringSettings(c.UseOwnFilterLists, c.FilterLists, c.SafeSearchEngines)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
 	err = clients.Update(dj.Name, c)
 	if err != nil {
 		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
@@ -238,6 +303,11 @@ func (clients *clientsContainer) handleUpdateClient(w http.ResponseWriter, r *ht
 	}
 
 	onConfigModified()
+
+	if c.UseOwnFilterLists {
+		// Load the newly selected filter lists.
+		enableFilters(true)
+	}
 }
 
 // Get the list of clients by IP address list
@@ -314,4 +384,9 @@ func (clients *clientsContainer) registerWebHandlers() {
 	httpRegister(http.MethodPost, "/control/clients/delete", clients.handleDelClient)
 	httpRegister(http.MethodPost, "/control/clients/update", clients.handleUpdateClient)
 	httpRegister(http.MethodGet, "/control/clients/find", clients.handleFindClient)
+
+	httpRegister(http.MethodGet, "/control/groups", clients.handleGetGroups)
+	httpRegister(http.MethodPost, "/control/groups/add", clients.handleAddGroup)
+	httpRegister(http.MethodPost, "/control/groups/delete", clients.handleDelGroup)
+	httpRegister(http.MethodPost, "/control/groups/update", clients.handleUpdateGroup)
 }
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"sync"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/blockpage"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
@@ -83,11 +84,18 @@ type configuration struct {
 
 	DHCP dhcpd.ServerConfig `yaml:"dhcp"`
 
+	// BlockPage is the configuration of the block page server.
+	BlockPage blockpage.Config `yaml:"block_page"`
+
 	// Clients contains the YAML representations of the persistent clients.
 	// This field is only used for reading and writing persistent client data.
 	// Keep this field sorted to ensure consistent ordering.
 	Clients []*clientObject `yaml:"clients"`
 
+	// ClientGroups contains the YAML representations of the client groups.
+	// Like Clients, it's only used for reading and writing the data.
+	ClientGroups []*clientGroupObject `yaml:"client_groups"`
+
 	logSettings `yaml:",inline"`
 
 	OSConfig *osConfig `yaml:"os"`
@@ -201,6 +209,10 @@ var config = &configuration{
 		ResolveClients:             true,
 		UsePrivateRDNS:             true,
 	},
+	BlockPage: blockpage.Config{
+		PortHTTP:  defaultPortHTTP,
+		PortHTTPS: defaultPortHTTPS,
+	},
 	TLS: tlsConfigSettings{
 		PortHTTPS:       defaultPortHTTPS,
 		PortDNSOverTLS:  defaultPortTLS, // needs to be passed through to dnsproxy
@@ -381,6 +393,7 @@ func (c *configuration) write() error {
 	}
 
 	config.Clients = Context.clients.forConfig()
+	config.ClientGroups = Context.clients.groupsForConfig()
 
 	configFile := config.getConfigFilename()
 	log.Debug("Writing YAML file: %s", configFile)
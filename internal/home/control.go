@@ -11,6 +11,7 @@ import (
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
 	"github.com/AdguardTeam/AdGuardHome/internal/version"
 	"github.com/AdguardTeam/golibs/log"
@@ -107,6 +108,11 @@ type statusResponse struct {
 	IsRunning       bool   `json:"running"`
 	Version         string `json:"version"`
 	Language        string `json:"language"`
+
+	// UnexpectedDHCPServers are the other DHCP servers found in the network
+	// which aren't allowed by the configuration.  Those are shown as an
+	// alert.
+	UnexpectedDHCPServers []*dhcpd.OtherServer `json:"unexpected_dhcp_servers,omitempty"`
 }
 
 func handleStatus(w http.ResponseWriter, r *http.Request) {
@@ -146,6 +152,10 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 		resp.IsDHCPAvailable = Context.dhcpServer != nil
 	}
 
+	if Context.dhcpServer != nil {
+		resp.UnexpectedDHCPServers = Context.dhcpServer.UnexpectedServers()
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(resp)
 	if err != nil {
@@ -9,10 +9,13 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/miekg/dns"
 )
@@ -40,10 +43,32 @@ func validateFilterURL(urlStr string) (err error) {
 	return nil
 }
 
+// validateFilterClientTags validates the client tags of a filter list and
+// returns them sorted.
+func validateFilterClientTags(tags []string) (sorted []string, err error) {
+	for _, t := range tags {
+		if !Context.clients.allTags.Has(t) {
+			return nil, fmt.Errorf("invalid tag: %q", t)
+		}
+	}
+
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	sorted = append([]string{}, tags...)
+	sort.Strings(sorted)
+
+	return sorted, nil
+}
+
 type filterAddJSON struct {
 	Name      string `json:"name"`
 	URL       string `json:"url"`
 	Whitelist bool   `json:"whitelist"`
+
+	// ClientTags are the tags of the clients the filter list is applied to.
+	ClientTags []string `json:"client_tags"`
 }
 
 func (f *Filtering) handleFilteringAddURL(w http.ResponseWriter, r *http.Request) {
@@ -63,6 +88,13 @@ func (f *Filtering) handleFilteringAddURL(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	clientTags, err := validateFilterClientTags(fj.ClientTags)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
 	// Check for duplicates
 	if filterExists(fj.URL) {
 		aghhttp.Error(r, w, http.StatusBadRequest, "Filter URL already added -- %s", fj.URL)
@@ -78,6 +110,7 @@ func (f *Filtering) handleFilteringAddURL(w http.ResponseWriter, r *http.Request
 		white:   fj.Whitelist,
 	}
 	filt.ID = assignUniqueFilterID()
+	filt.ClientTags = clientTags
 
 	// Download the filter contents
 	ok, err := f.update(&filt)
@@ -163,6 +196,17 @@ func (f *Filtering) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Requ
 	*filters = newFilters
 	config.Unlock()
 
+	if deleted.ID != 0 {
+		err = f.history.remove(deleted.ID)
+		if err != nil {
+			log.Error("deleting filter %d history: %s", deleted.ID, err)
+		}
+
+		if Context.dnsFilter != nil {
+			Context.dnsFilter.ResetHits(deleted.ID)
+		}
+	}
+
 	onConfigModified()
 	enableFilters(true)
 
@@ -182,6 +226,9 @@ type filterURLJSON struct {
 	Name    string `json:"name"`
 	URL     string `json:"url"`
 	Enabled bool   `json:"enabled"`
+
+	// ClientTags are the tags of the clients the filter list is applied to.
+	ClientTags []string `json:"client_tags"`
 }
 
 type filterURLReq struct {
@@ -207,11 +254,19 @@ func (f *Filtering) handleFilteringSetURL(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	clientTags, err := validateFilterClientTags(fj.Data.ClientTags)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
 	filt := filter{
 		Enabled: fj.Data.Enabled,
 		Name:    fj.Data.Name,
 		URL:     fj.Data.URL,
 	}
+	filt.ClientTags = clientTags
 	status := f.filterSetProperties(fj.URL, filt, fj.Whitelist)
 	if (status & statusFound) == 0 {
 		http.Error(w, "URL doesn't exist", http.StatusBadRequest)
@@ -224,7 +279,7 @@ func (f *Filtering) handleFilteringSetURL(w http.ResponseWriter, r *http.Request
 
 	onConfigModified()
 	restart := false
-	if (status & statusEnabledChanged) != 0 {
+	if (status & (statusEnabledChanged | statusTagsChanged)) != 0 {
 		// we must add or remove filter rules
 		restart = true
 	}
@@ -317,6 +372,28 @@ type filterJSON struct {
 	Name        string `json:"name"`
 	RulesCount  uint32 `json:"rules_count"`
 	LastUpdated string `json:"last_updated"`
+
+	// HitsCount is the number of the filtered requests matched by the
+	// filter's rules.
+	HitsCount uint64 `json:"hits_count"`
+
+	// LastHit is the time of the latest match, if there was one.
+	LastHit string `json:"last_hit,omitempty"`
+
+	// FetchStatus is the status of the latest fetch of the filter, if there
+	// was one.
+	FetchStatus string `json:"fetch_status,omitempty"`
+
+	// FetchError is the error of the latest fetch of the filter, if any.
+	FetchError string `json:"fetch_error,omitempty"`
+
+	// LastFetch is the time of the latest fetch of the filter, if there was
+	// one.
+	LastFetch string `json:"last_fetch,omitempty"`
+
+	// ClientTags are the tags of the clients the filter is applied to.  The
+	// filter is applied to all clients if it's empty.
+	ClientTags []string `json:"client_tags"`
 }
 
 type filteringConfig struct {
@@ -334,12 +411,30 @@ func filterToJSON(f filter) filterJSON {
 		URL:        f.URL,
 		Name:       f.Name,
 		RulesCount: uint32(f.RulesCount),
+		ClientTags: f.ClientTags,
+	}
+
+	if fj.ClientTags == nil {
+		fj.ClientTags = []string{}
 	}
 
 	if !f.LastUpdated.IsZero() {
 		fj.LastUpdated = f.LastUpdated.Format(time.RFC3339)
 	}
 
+	if Context.dnsFilter != nil {
+		var lastHit time.Time
+		fj.HitsCount, lastHit = Context.dnsFilter.HitsSummary(f.ID)
+		if !lastHit.IsZero() {
+			fj.LastHit = lastHit.Format(time.RFC3339)
+		}
+	}
+
+	fj.FetchStatus, fj.FetchError = f.fetch.status, f.fetch.err
+	if !f.fetch.lastFetch.IsZero() {
+		fj.LastFetch = f.fetch.lastFetch.Format(time.RFC3339)
+	}
+
 	return fj
 }
 
@@ -373,6 +468,43 @@ func (f *Filtering) handleFilteringStatus(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// filterHitsJSON is the response for getting the hit counters of a filter.
+type filterHitsJSON struct {
+	LastHit string                `json:"last_hit,omitempty"`
+	Rules   []*filtering.RuleHits `json:"rules"`
+	ID      int64                 `json:"id"`
+	Total   uint64                `json:"total"`
+}
+
+// handleFilteringHits is the handler for the GET /control/filtering/hits HTTP
+// API.  It returns the hit counters of the filter with the ID from the "id"
+// query parameter and of its rules.
+func (f *Filtering) handleFilteringHits(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "bad id: %s", err)
+
+		return
+	}
+
+	resp := &filterHitsJSON{
+		Rules: []*filtering.RuleHits{},
+		ID:    id,
+	}
+
+	if h := Context.dnsFilter.ListHits(id); h != nil {
+		resp.LastHit = h.LastHit.Format(time.RFC3339)
+		resp.Rules = h.TopRules()
+		resp.Total = h.Total
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "json encode: %s", err)
+	}
+}
+
 // Set filtering configuration
 func (f *Filtering) handleFilteringConfig(w http.ResponseWriter, r *http.Request) {
 	req := filteringConfig{}
@@ -423,6 +555,9 @@ type checkHostResp struct {
 	// for FilteredBlockedService:
 	SvcName string `json:"service_name"`
 
+	// for FilteredThreatFeed:
+	ThreatFeed string `json:"threat_feed"`
+
 	// for Rewrite:
 	CanonName string   `json:"cname"`    // CNAME value
 	IPList    []net.IP `json:"ip_addrs"` // list of IP addresses
@@ -453,6 +588,7 @@ func (f *Filtering) handleCheckHost(w http.ResponseWriter, r *http.Request) {
 	resp := checkHostResp{}
 	resp.Reason = result.Reason.String()
 	resp.SvcName = result.ServiceName
+	resp.ThreatFeed = result.ThreatFeed
 	resp.CanonName = result.CanonName
 	resp.IPList = result.IPList
 
@@ -489,6 +625,8 @@ func (f *Filtering) RegisterFilteringHandlers() {
 	httpRegister(http.MethodPost, "/control/filtering/refresh", f.handleFilteringRefresh)
 	httpRegister(http.MethodPost, "/control/filtering/set_rules", f.handleFilteringSetRules)
 	httpRegister(http.MethodGet, "/control/filtering/check_host", f.handleCheckHost)
+	httpRegister(http.MethodGet, "/control/filtering/history", f.handleFilteringHistory)
+	httpRegister(http.MethodGet, "/control/filtering/hits", f.handleFilteringHits)
 }
 
 func checkFiltersUpdateIntervalHours(i uint32) bool {
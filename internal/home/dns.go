@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
@@ -75,6 +76,7 @@ func initDNSServer() (err error) {
 	filterConf.EtcHosts = Context.etcHosts
 	filterConf.ConfigModified = onConfigModified
 	filterConf.HTTPRegister = httpRegister
+	filterConf.HitsFile = filepath.Join(baseDir, filterDir, hitsFileName)
 	Context.dnsFilter = filtering.New(&filterConf, nil)
 
 	p := dnsforward.DNSCreateParams{
@@ -97,6 +99,14 @@ func initDNSServer() (err error) {
 	}
 
 	Context.clients.dnsServer = Context.dnsServer
+
+	err = initBlockPage()
+	if err != nil {
+		closeDNSServer()
+
+		return err
+	}
+
 	var dnsConfig dnsforward.ServerConfig
 	dnsConfig, err = generateServerConfig()
 	if err != nil {
@@ -216,12 +226,18 @@ func generateServerConfig() (newConf dnsforward.ServerConfig, err error) {
 
 	newConf.FilterHandler = applyAdditionalFiltering
 	newConf.GetCustomUpstreamByClient = Context.clients.findUpstreams
+	newConf.IsCacheBypassedByClient = Context.clients.isCacheBypassed
 
 	newConf.ResolveClients = dnsConf.ResolveClients
 	newConf.UsePrivateRDNS = dnsConf.UsePrivateRDNS
 	newConf.LocalPTRResolvers = dnsConf.LocalPTRResolvers
 	newConf.UpstreamTimeout = dnsConf.UpstreamTimeout.Duration
 
+	// Don't assign the nil pointer to the interface.
+	if Context.blockPage != nil {
+		newConf.BlockPage = Context.blockPage
+	}
+
 	return newConf, nil
 }
 
@@ -311,30 +327,10 @@ func applyAdditionalFiltering(clientAddr net.IP, clientID string, setts *filteri
 
 	setts.ClientIP = clientAddr
 
-	c, ok := Context.clients.Find(clientID)
-	if !ok {
-		c, ok = Context.clients.Find(clientAddr.String())
-		if !ok {
-			return
-		}
-	}
-
-	log.Debug("using settings for client %s with ip %s and id %q", c.Name, clientAddr, clientID)
-
-	if c.UseOwnBlockedServices {
-		Context.dnsFilter.ApplyBlockedServices(setts, c.BlockedServices, false)
+	svcs, ownSvcs := Context.clients.filteringSettings(setts, clientAddr, clientID, time.Now())
+	if ownSvcs {
+		Context.dnsFilter.ApplyBlockedServices(setts, svcs, false)
 	}
-
-	setts.ClientName = c.Name
-	setts.ClientTags = c.Tags
-	if !c.UseOwnSettings {
-		return
-	}
-
-	setts.FilteringEnabled = c.FilteringEnabled
-	setts.SafeSearchEnabled = c.SafeSearchEnabled
-	setts.SafeBrowsingEnabled = c.SafeBrowsingEnabled
-	setts.ParentalEnabled = c.ParentalEnabled
 }
 
 func startDNSServer() error {
@@ -359,6 +355,15 @@ func startDNSServer() error {
 	Context.stats.Start()
 	Context.queryLog.Start()
 
+	if Context.blockPage != nil {
+		err = Context.blockPage.Start()
+		if err != nil {
+			// Don't stop the DNS server, since the block page is
+			// optional.
+			log.Error("starting block page: %s", err)
+		}
+	}
+
 	const topClientsNumber = 100 // the number of clients to get
 	for _, ip := range Context.stats.GetTopClientsIP(topClientsNumber) {
 		if config.DNS.ResolveClients && !ip.IsLoopback() {
@@ -423,6 +428,15 @@ func closeDNSServer() {
 		Context.queryLog = nil
 	}
 
+	if Context.blockPage != nil {
+		err := Context.blockPage.Close()
+		if err != nil {
+			log.Error("closing block page: %s", err)
+		}
+
+		Context.blockPage = nil
+	}
+
 	Context.filters.Close()
 
 	log.Debug("Closed all DNS modules")
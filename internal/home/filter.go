@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"hash/crc32"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -29,12 +28,17 @@ type Filtering struct {
 	refreshStatus     uint32 // 0:none; 1:in progress
 	refreshLock       sync.Mutex
 	filterTitleRegexp *regexp.Regexp
+
+	// history stores the previous versions of the filters and the history
+	// of their updates.
+	history *filterHistory
 }
 
 // Init - initialize the module
 func (f *Filtering) Init() {
 	f.filterTitleRegexp = regexp.MustCompile(`^! Title: +(.*)$`)
 	_ = os.MkdirAll(filepath.Join(Context.getDataDir(), filterDir), 0o755)
+	f.history = newFilterHistory(filepath.Join(Context.getDataDir(), filterDir, historyDir))
 	f.loadFilters(config.Filters)
 	f.loadFilters(config.WhitelistFilters)
 	deduplicateFilters()
@@ -50,6 +54,10 @@ func (f *Filtering) Start() {
 	//  but currently we can't wake up the periodic task to do so.
 	// So for now we just start this periodic task from here.
 	go f.periodicallyRefreshFilters()
+
+	if config.DNS.DnsfilterConf.BlockedServicesCatalogue != "" {
+		go periodicallyRefreshServicesCatalogue()
+	}
 }
 
 // Close - close the module
@@ -73,6 +81,14 @@ type filter struct {
 	checksum    uint32    // checksum of the file data
 	white       bool
 
+	// SignatureKey is the base64-encoded Ed25519 public key to verify the
+	// detached signature of the filter contents with.  The signature isn't
+	// checked if it's empty.
+	SignatureKey string `yaml:"signature_key,omitempty"`
+
+	// fetch is the state of fetching the filter contents.
+	fetch filterFetchState
+
 	filtering.Filter `yaml:",inline"`
 }
 
@@ -82,6 +98,7 @@ const (
 	statusURLChanged     = 4
 	statusURLExists      = 8
 	statusUpdateRequired = 0x10
+	statusTagsChanged    = 0x20
 )
 
 // Update properties for a filter specified by its URL
@@ -102,10 +119,15 @@ func (f *Filtering) filterSetProperties(url string, newf filter, whitelist bool)
 			continue
 		}
 
-		log.Debug("filter: set properties: %s: {%s %s %v}",
-			filt.URL, newf.Name, newf.URL, newf.Enabled)
+		log.Debug("filter: set properties: %s: {%s %s %v %q}",
+			filt.URL, newf.Name, newf.URL, newf.Enabled, newf.ClientTags)
 		filt.Name = newf.Name
 
+		if !equalStringSlices(filt.ClientTags, newf.ClientTags) {
+			r |= statusTagsChanged
+			filt.ClientTags = newf.ClientTags
+		}
+
 		if filt.URL != newf.URL {
 			r |= statusURLChanged | statusUpdateRequired
 			if filterExistsNoLock(newf.URL) {
@@ -260,6 +282,12 @@ func (f *Filtering) periodicallyRefreshFilters() {
 			}
 		}
 
+		// Store the hit counters along with the filters' updates so that
+		// they aren't lost on a crash.
+		if Context.dnsFilter != nil {
+			Context.dnsFilter.SaveHits()
+		}
+
 		time.Sleep(time.Duration(intval) * time.Second)
 	}
 }
@@ -304,6 +332,8 @@ func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []f
 		uf.URL = f.URL
 		uf.Name = f.Name
 		uf.checksum = f.checksum
+		uf.SignatureKey = f.SignatureKey
+		uf.fetch = f.fetch
 		updateFilters = append(updateFilters, uf)
 	}
 	config.RUnlock()
@@ -324,6 +354,8 @@ func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []f
 		}
 	}
 
+	setFetchStates(filters, updateFilters)
+
 	if nfail == len(updateFilters) {
 		return 0, nil, nil, true
 	}
@@ -357,6 +389,23 @@ func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []f
 	return updateCount, updateFilters, updateFlags, false
 }
 
+// setFetchStates sets the fetch states of filters from the updated copies of
+// them.
+func setFetchStates(filters *[]filter, updated []filter) {
+	config.Lock()
+	defer config.Unlock()
+
+	for i := range updated {
+		uf := &updated[i]
+		for k := range *filters {
+			f := &(*filters)[k]
+			if f.ID == uf.ID && f.URL == uf.URL {
+				f.fetch = uf.fetch
+			}
+		}
+	}
+}
+
 const (
 	filterRefreshForce      = 1 // ignore last file modification date
 	filterRefreshAllowlists = 2 // update allow-lists
@@ -479,6 +528,20 @@ func (f *Filtering) parseFilterContents(file io.Reader) (int, uint32, string) {
 func (f *Filtering) update(filter *filter) (bool, error) {
 	b, err := f.updateIntl(filter)
 	filter.LastUpdated = time.Now()
+	if err != nil {
+		// Reset the validators to download the whole filter next time
+		// instead of getting a "not modified" response for the contents
+		// that have failed to apply.
+		filter.fetch = filterFetchState{
+			lastFetch: filter.LastUpdated,
+			status:    fetchStatusError,
+			err:       err.Error(),
+		}
+	} else {
+		filter.fetch.lastFetch = filter.LastUpdated
+		filter.fetch.err = ""
+	}
+
 	if !b {
 		e := os.Chtimes(filter.Path(), filter.LastUpdated, filter.LastUpdated)
 		if e != nil {
@@ -536,23 +599,23 @@ func (f *Filtering) read(reader io.Reader, tmpFile *os.File, filter *filter) (in
 	}
 }
 
-// finalizeUpdate closes and gets rid of temporary file f with filter's content
-// according to updated.  It also saves new values of flt's name, rules number
-// and checksum if sucсeeded.
-func finalizeUpdate(
-	f *os.File,
+// finalizeUpdate closes and gets rid of temporary file file with filter's
+// content according to updated.  It also records the update in the history and
+// saves new values of flt's name, rules number and checksum if sucсeeded.
+func (f *Filtering) finalizeUpdate(
+	file *os.File,
 	flt *filter,
 	updated bool,
 	name string,
 	rnum int,
 	cs uint32,
 ) (err error) {
-	tmpFileName := f.Name()
+	tmpFileName := file.Name()
 
 	// Close the file before renaming it because it's required on Windows.
 	//
 	// See https://github.com/adguardTeam/adGuardHome/issues/1553.
-	if err = f.Close(); err != nil {
+	if err = file.Close(); err != nil {
 		return fmt.Errorf("closing temporary file: %w", err)
 	}
 
@@ -562,6 +625,13 @@ func finalizeUpdate(
 		return os.Remove(tmpFileName)
 	}
 
+	if f.history != nil {
+		// Don't fail the update, since the history is only informational.
+		if herr := f.history.record(flt, tmpFileName); herr != nil {
+			log.Error("filter %d: recording history: %s", flt.ID, herr)
+		}
+	}
+
 	log.Printf("saving filter %d contents to: %s", flt.ID, flt.Path())
 
 	if err = os.Rename(tmpFileName, flt.Path()); err != nil {
@@ -611,7 +681,7 @@ func (f *Filtering) updateIntl(flt *filter) (ok bool, err error) {
 		return false, err
 	}
 	defer func() {
-		err = errors.WithDeferred(err, finalizeUpdate(tmpFile, flt, ok, name, rnum, cs))
+		err = errors.WithDeferred(err, f.finalizeUpdate(tmpFile, flt, ok, name, rnum, cs))
 		ok = ok && err == nil
 		if ok {
 			log.Printf("updated filter %d: %d bytes, %d rules", flt.ID, n, rnum)
@@ -626,38 +696,37 @@ func (f *Filtering) updateIntl(flt *filter) (ok bool, err error) {
 		return false, fmt.Errorf("changing file mode: %w", err)
 	}
 
-	var r io.Reader
+	var rc io.ReadCloser
 	if filepath.IsAbs(flt.URL) {
-		var file io.ReadCloser
-		file, err = os.Open(flt.URL)
+		rc, err = os.Open(flt.URL)
 		if err != nil {
 			return false, fmt.Errorf("open file: %w", err)
 		}
-		defer func() { err = errors.WithDeferred(err, file.Close()) }()
 
-		r = file
+		flt.fetch.status = fetchStatusOK
 	} else {
-		var resp *http.Response
-		resp, err = Context.client.Get(flt.URL)
+		rc, err = f.fetchFilter(flt)
 		if err != nil {
-			log.Printf("requesting filter from %s, skip: %s", flt.URL, err)
-
 			return false, err
-		}
-		defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+		} else if rc == nil {
+			log.Tracef("filter #%d from %s is not modified", flt.ID, flt.URL)
 
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("got status code %d from %s, skip", resp.StatusCode, flt.URL)
-
-			return false, fmt.Errorf("got status code != 200: %d", resp.StatusCode)
+			return false, nil
 		}
+	}
+	defer func() { err = errors.WithDeferred(err, rc.Close()) }()
 
-		r = resp.Body
+	name, rnum, cs, n, err = f.processUpdate(rc, tmpFile, flt)
+	if err != nil || cs == flt.checksum {
+		return false, err
 	}
 
-	name, rnum, cs, n, err = f.processUpdate(r, tmpFile, flt)
+	err = verifyFilterFile(flt, tmpFile.Name())
+	if err != nil {
+		return false, fmt.Errorf("verifying filter: %w", err)
+	}
 
-	return cs != flt.checksum, err
+	return true, nil
 }
 
 // loads filter contents from the file in dataDir
@@ -702,6 +771,33 @@ func (filter *filter) Path() string {
 	return filepath.Join(Context.getDataDir(), filterDir, strconv.FormatInt(filter.ID, 10)+".txt")
 }
 
+// validateFilterListIDs returns an error if any of ids isn't an ID of a known
+// filter list.
+func validateFilterListIDs(ids []int64) (err error) {
+	config.RLock()
+	defer config.RUnlock()
+
+	for _, id := range ids {
+		if !filterIDExistsLocked(config.Filters, id) && !filterIDExistsLocked(config.WhitelistFilters, id) {
+			return fmt.Errorf("unknown filter list id %d", id)
+		}
+	}
+
+	return nil
+}
+
+// filterIDExistsLocked returns true if filters contain the filter with id.
+// config is expected to be locked.
+func filterIDExistsLocked(filters []filter, id int64) (ok bool) {
+	for _, f := range filters {
+		if f.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
 func enableFilters(async bool) {
 	config.RLock()
 	defer config.RUnlock()
@@ -710,6 +806,7 @@ func enableFilters(async bool) {
 }
 
 func enableFiltersLocked(async bool) {
+	selected := Context.clients.selectedFilterLists()
 	filters := []filtering.Filter{{
 		ID:   filtering.CustomListID,
 		Data: []byte(strings.Join(config.UserRules, "\n")),
@@ -721,8 +818,10 @@ func enableFiltersLocked(async bool) {
 		}
 
 		filters = append(filters, filtering.Filter{
-			ID:       filter.ID,
-			FilePath: filter.Path(),
+			ID:         filter.ID,
+			FilePath:   filter.Path(),
+			ClientTags: filter.ClientTags,
+			Selected:   selected[filter.ID],
 		})
 	}
 
@@ -733,8 +832,10 @@ func enableFiltersLocked(async bool) {
 		}
 
 		allowFilters = append(allowFilters, filtering.Filter{
-			ID:       filter.ID,
-			FilePath: filter.Path(),
+			ID:         filter.ID,
+			FilePath:   filter.Path(),
+			ClientTags: filter.ClientTags,
+			Selected:   selected[filter.ID],
 		})
 	}
 
@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/stretchr/testify/assert"
@@ -81,7 +82,15 @@ func TestFilters(t *testing.T) {
 		dir, err = os.ReadDir(filepath.Join(Context.getDataDir(), filterDir))
 		require.NoError(t, err)
 
-		assert.Len(t, dir, 1)
+		// Only the filter file is expected besides the history directory.
+		var files []string
+		for _, e := range dir {
+			if !e.IsDir() {
+				files = append(files, e.Name())
+			}
+		}
+
+		assert.Len(t, files, 1)
 
 		require.FileExists(t, f.Path())
 
@@ -102,6 +111,21 @@ func TestFilters(t *testing.T) {
 		t.Cleanup(func() { fltContent = []byte(content) })
 
 		updateAndAssert(t, require.True, 1)
+
+		updates := Context.filters.history.list(0)
+		require.Len(t, updates, 2)
+
+		upd := updates[0]
+		assert.Equal(t, []string{"||example.com^"}, upd.Added)
+		assert.Equal(t, []string{
+			"0.0.0.0 example.com",
+			"||example.com^$third-party",
+			"||example.org^$third-party",
+		}, upd.Removed)
+		assert.Equal(t, 3, upd.RulesCountBefore)
+		assert.Equal(t, 1, upd.RulesCountAfter)
+
+		assert.FileExists(t, Context.filters.history.prevPath(f.ID))
 	})
 
 	t.Run("load_unload", func(t *testing.T) {
@@ -113,3 +137,16 @@ func TestFilters(t *testing.T) {
 
 	require.NoError(t, os.Remove(f.Path()))
 }
+
+func TestValidateFilterListIDs(t *testing.T) {
+	prevConfig := config
+	t.Cleanup(func() { config = prevConfig })
+
+	config = &configuration{
+		Filters:          []filter{{Filter: filtering.Filter{ID: 1}}},
+		WhitelistFilters: []filter{{Filter: filtering.Filter{ID: 2}}},
+	}
+
+	assert.NoError(t, validateFilterListIDs([]int64{1, 2}))
+	testutil.AssertErrorMsg(t, "unknown filter list id 3", validateFilterListIDs([]int64{1, 3}))
+}
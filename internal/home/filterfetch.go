@@ -0,0 +1,526 @@
+package home
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghio"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Filter fetch statuses.
+const (
+	// fetchStatusOK means that the filter has been fully downloaded or read
+	// from the file.
+	fetchStatusOK = "ok"
+
+	// fetchStatusNotModified means that the server has reported that the
+	// filter hasn't been changed.
+	fetchStatusNotModified = "not_modified"
+
+	// fetchStatusPatched means that the filter has been updated with the
+	// incremental patches.
+	fetchStatusPatched = "patched"
+
+	// fetchStatusError means that the latest fetch has failed.
+	fetchStatusError = "error"
+)
+
+const (
+	// maxFilterPatches is the maximum number of the incremental patches
+	// applied to a filter during a single update.
+	maxFilterPatches = 10
+
+	// filterFullUpdateIvl is the interval after which the filter is fully
+	// downloaded even if it supports the incremental patches.
+	filterFullUpdateIvl = 7 * 24 * time.Hour
+
+	// maxFilterSigSize is the maximum size of a filter signature file.
+	maxFilterSigSize = 1024
+
+	// maxFilterPatchSize is the maximum size of an incremental patch.
+	maxFilterPatchSize = 10 * 1024 * 1024
+)
+
+// filterFetchState is the state of fetching a filter.  It's only kept in
+// memory.
+type filterFetchState struct {
+	// lastFetch is the time of the latest fetch.
+	lastFetch time.Time
+
+	// lastFull is the time of the latest full download.
+	lastFull time.Time
+
+	// etag is the value of the ETag header of the latest full download.
+	etag string
+
+	// lastModified is the value of the Last-Modified header of the latest
+	// full download.
+	lastModified string
+
+	// status is the status of the latest fetch, one of the fetchStatus*
+	// constants.
+	status string
+
+	// err is the error of the latest fetch, if any.
+	err string
+}
+
+// fetchFilter returns the new contents of the filter flt downloaded from its
+// URL.  rc is nil if the filter hasn't been changed.  It tries the incremental
+// patches first, if the filter supports them, and uses the conditional request
+// otherwise.
+func (f *Filtering) fetchFilter(flt *filter) (rc io.ReadCloser, err error) {
+	if flt.checksum != 0 && time.Since(flt.fetch.lastFull) < filterFullUpdateIvl {
+		var data []byte
+		var patched bool
+		data, patched, err = f.patchFilter(flt)
+		switch {
+		case errors.Is(err, errNoDiffPath):
+			// Go on and download the whole filter.
+		case err != nil:
+			log.Info("filter %d: warning: applying patches: %s; downloading", flt.ID, err)
+		case patched:
+			flt.fetch.status = fetchStatusPatched
+
+			return io.NopCloser(bytes.NewReader(data)), nil
+		default:
+			flt.fetch.status = fetchStatusNotModified
+
+			return nil, nil
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, flt.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	// Only make conditional requests when there is the current contents
+	// to keep.
+	if flt.checksum != 0 {
+		if flt.fetch.etag != "" {
+			req.Header.Set("If-None-Match", flt.fetch.etag)
+		}
+
+		if flt.fetch.lastModified != "" {
+			req.Header.Set("If-Modified-Since", flt.fetch.lastModified)
+		}
+	}
+
+	resp, err := Context.client.Do(req)
+	if err != nil {
+		log.Printf("requesting filter from %s, skip: %s", flt.URL, err)
+
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		flt.fetch.etag = resp.Header.Get("ETag")
+		flt.fetch.lastModified = resp.Header.Get("Last-Modified")
+		flt.fetch.lastFull = time.Now()
+		flt.fetch.status = fetchStatusOK
+
+		return resp.Body, nil
+	case http.StatusNotModified:
+		flt.fetch.lastFull = time.Now()
+		flt.fetch.status = fetchStatusNotModified
+
+		return nil, resp.Body.Close()
+	default:
+		log.Printf("got status code %d from %s, skip", resp.StatusCode, flt.URL)
+
+		return nil, errors.WithDeferred(
+			fmt.Errorf("got status code != 200: %d", resp.StatusCode),
+			resp.Body.Close(),
+		)
+	}
+}
+
+// errNoDiffPath is returned by patchFilter when the filter doesn't support the
+// incremental patches.
+const errNoDiffPath errors.Error = "no diff path"
+
+// diffPathRe matches the "Diff-Path" header of a filter.
+var diffPathRe = regexp.MustCompile(`(?m)^!\s*Diff-Path:\s*(\S+)\s*$`)
+
+// patchFilter applies the incremental patches to the current contents of flt.
+// patched is false if there are no patches yet.
+//
+// See https://github.com/ameshkov/diffupdates.
+func (f *Filtering) patchFilter(flt *filter) (data []byte, patched bool, err error) {
+	data, err = os.ReadFile(flt.Path())
+	if err != nil {
+		return nil, false, fmt.Errorf("reading current contents: %w", err)
+	}
+
+	listURL, err := url.Parse(flt.URL)
+	if err != nil {
+		return nil, false, fmt.Errorf("parsing url: %w", err)
+	}
+
+	for i := 0; i < maxFilterPatches; i++ {
+		m := diffPathRe.FindSubmatch(data)
+		if m == nil {
+			if i == 0 {
+				return nil, false, errNoDiffPath
+			}
+
+			break
+		}
+
+		var patch []byte
+		var name string
+		patch, name, err = fetchPatch(listURL, string(m[1]))
+		if err != nil {
+			return nil, false, err
+		} else if patch == nil {
+			break
+		}
+
+		data, err = applyFilterPatch(data, patch, name)
+		if err != nil {
+			return nil, false, err
+		}
+
+		patched = true
+	}
+
+	return data, patched, nil
+}
+
+// fetchPatch downloads the patch from diffPath relative to listURL.  name is
+// the name of the list in a batch patch, if any.  patch is nil if the patch
+// isn't published yet.  The patch must be served from the same scheme and host
+// as the list itself.
+func fetchPatch(listURL *url.URL, diffPath string) (patch []byte, name string, err error) {
+	i := strings.IndexByte(diffPath, '#')
+	if i >= 0 {
+		diffPath, name = diffPath[:i], diffPath[i+1:]
+	}
+
+	ref, err := url.Parse(diffPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("parsing diff path: %w", err)
+	}
+
+	patchURL := listURL.ResolveReference(ref)
+	if patchURL.Scheme != listURL.Scheme || patchURL.Host != listURL.Host {
+		return nil, "", fmt.Errorf(
+			"diff path %q: patch url must have the same scheme and host as the list",
+			diffPath,
+		)
+	}
+
+	resp, err := Context.client.Get(patchURL.String())
+	if err != nil {
+		return nil, "", fmt.Errorf("requesting patch: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Go on.
+	case http.StatusNotFound, http.StatusNoContent:
+		return nil, "", nil
+	default:
+		return nil, "", fmt.Errorf("requesting patch: got status code %d", resp.StatusCode)
+	}
+
+	lr, err := aghio.LimitReader(resp.Body, maxFilterPatchSize)
+	if err != nil {
+		// Should not happen, since the limit is positive.
+		return nil, "", err
+	}
+
+	patch, err = io.ReadAll(lr)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading patch: %w", err)
+	} else if len(bytes.TrimSpace(patch)) == 0 {
+		// An empty patch means there are no changes yet.
+		return nil, "", nil
+	}
+
+	return patch, name, nil
+}
+
+// diffHeader is the optional header of a patch.
+type diffHeader struct {
+	// name is the name of the list in a batch patch.
+	name string
+
+	// checksum is the expected SHA1 checksum of the patched list.
+	checksum string
+
+	// lines is the number of the lines of the patch after the header.
+	lines int
+}
+
+// parseDiffHeader parses the header line of a patch, for example:
+//
+//	diff name:list checksum:e3c9ef... lines:12
+func parseDiffHeader(line string) (h *diffHeader, err error) {
+	h = &diffHeader{}
+	for _, f := range strings.Fields(line)[1:] {
+		kv := strings.SplitN(f, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad diff header field %q", f)
+		}
+
+		switch kv[0] {
+		case "name":
+			h.name = kv[1]
+		case "checksum":
+			h.checksum = kv[1]
+		case "lines":
+			h.lines, err = strconv.Atoi(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("bad lines in diff header: %w", err)
+			}
+		default:
+			// Ignore the unknown fields.
+		}
+	}
+
+	return h, nil
+}
+
+// selectDiff returns the lines of the diff for the list named name from the
+// patch lines along with its header.  If name is empty, the whole patch is the
+// diff.
+func selectDiff(patchLines []string, name string) (diff []string, h *diffHeader, err error) {
+	for i := 0; i < len(patchLines); {
+		line := patchLines[i]
+		if !strings.HasPrefix(line, "diff ") {
+			if name != "" {
+				return nil, nil, fmt.Errorf("line %d: expected diff header", i+1)
+			}
+
+			return patchLines, nil, nil
+		}
+
+		h, err = parseDiffHeader(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		end := i + 1 + h.lines
+		if h.lines == 0 {
+			end = len(patchLines)
+		}
+
+		if end > len(patchLines) {
+			return nil, nil, fmt.Errorf("line %d: diff is truncated", i+1)
+		}
+
+		if name == "" || h.name == name {
+			return patchLines[i+1 : end], h, nil
+		}
+
+		i = end
+	}
+
+	return nil, nil, fmt.Errorf("no diff for %q", name)
+}
+
+// applyFilterPatch applies the RCS-style patch to data.  name is the name of
+// the list in a batch patch, if any.
+func applyFilterPatch(data, patch []byte, name string) (patched []byte, err error) {
+	diff, h, err := selectDiff(splitLines(patch), name)
+	if err != nil {
+		return nil, err
+	}
+
+	old := splitLines(data)
+	res := make([]string, 0, len(old))
+
+	// pos is the number of the old lines processed.
+	pos := 0
+	for i := 0; i < len(diff); i++ {
+		cmd := diff[i]
+		if cmd == "" {
+			continue
+		}
+
+		var start, count int
+		_, err = fmt.Sscanf(cmd[1:], "%d %d", &start, &count)
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("diff line %d: bad command %q", i+1, cmd)
+		}
+
+		switch cmd[0] {
+		case 'd':
+			if start < 1 || start-1 < pos || start-1+count > len(old) {
+				return nil, fmt.Errorf("diff line %d: bad range in %q", i+1, cmd)
+			}
+
+			res = append(res, old[pos:start-1]...)
+			pos = start - 1 + count
+		case 'a':
+			if start < pos || start > len(old) || i+1+count > len(diff) {
+				return nil, fmt.Errorf("diff line %d: bad range in %q", i+1, cmd)
+			}
+
+			res = append(res, old[pos:start]...)
+			res = append(res, diff[i+1:i+1+count]...)
+			pos = start
+			i += count
+		default:
+			return nil, fmt.Errorf("diff line %d: bad command %q", i+1, cmd)
+		}
+	}
+
+	res = append(res, old[pos:]...)
+	patched = []byte(strings.Join(res, "\n") + "\n")
+
+	if h != nil && h.checksum != "" {
+		sum := sha1.Sum(patched)
+		if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, h.checksum) {
+			return nil, fmt.Errorf("checksum mismatch: got %s, want %s", got, h.checksum)
+		}
+	}
+
+	return patched, nil
+}
+
+// splitLines splits data into lines without the trailing empty line.
+func splitLines(data []byte) (lines []string) {
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, "\n")
+}
+
+// checksumRe matches the "Checksum" header of a filter.
+var checksumRe = regexp.MustCompile(`(?im)^\s*!\s*checksum[\s\-:]+([\w+/=]+).*(\n|$)`)
+
+// newlinesRe matches the sequences of line breaks.
+var newlinesRe = regexp.MustCompile(`\n+`)
+
+// verifyFilterChecksum checks the Adblock Plus-style "Checksum" header of the
+// filter contents, if there is one.  The checksum is the base64-encoded MD5
+// hash of the contents without the header and with the normalized line
+// breaks.
+func verifyFilterChecksum(data []byte) (err error) {
+	m := checksumRe.FindSubmatchIndex(data)
+	if m == nil {
+		return nil
+	}
+
+	want := strings.TrimRight(string(data[m[2]:m[3]]), "=")
+
+	var stripped []byte
+	stripped = append(stripped, data[:m[0]]...)
+	stripped = append(stripped, data[m[1]:]...)
+	stripped = bytes.ReplaceAll(stripped, []byte("\r"), nil)
+	stripped = newlinesRe.ReplaceAll(stripped, []byte("\n"))
+
+	sum := md5.Sum(stripped)
+	got := strings.TrimRight(base64.StdEncoding.EncodeToString(sum[:]), "=")
+	if got != want {
+		return fmt.Errorf("checksum mismatch: got %s, want %s", got, want)
+	}
+
+	return nil
+}
+
+// verifyFilterSignature checks the detached Ed25519 signature of the filter
+// contents using the base64-encoded public key.  The base64-encoded signature
+// is read from the file or the URL of the filter with the ".sig" suffix.
+func verifyFilterSignature(flt *filter, data []byte) (err error) {
+	key, err := base64.StdEncoding.DecodeString(flt.SignatureKey)
+	if err != nil {
+		return fmt.Errorf("decoding signature key: %w", err)
+	} else if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("bad signature key size %d", len(key))
+	}
+
+	sigData, err := readFilterSig(flt.URL + ".sig")
+	if err != nil {
+		return fmt.Errorf("reading signature: %w", err)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sigData)))
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+
+	if !ed25519.Verify(key, data, sig) {
+		return errors.Error("signature mismatch")
+	}
+
+	return nil
+}
+
+// readFilterSig reads the signature from the file or the URL sigURL.
+func readFilterSig(sigURL string) (data []byte, err error) {
+	var r io.ReadCloser
+	if filepath.IsAbs(sigURL) {
+		r, err = os.Open(sigURL)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var resp *http.Response
+		resp, err = Context.client.Get(sigURL)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.WithDeferred(
+				fmt.Errorf("got status code %d", resp.StatusCode),
+				resp.Body.Close(),
+			)
+		}
+
+		r = resp.Body
+	}
+	defer func() { err = errors.WithDeferred(err, r.Close()) }()
+
+	lr, err := aghio.LimitReader(r, maxFilterSigSize)
+	if err != nil {
+		// Should not happen, since the limit is positive.
+		return nil, err
+	}
+
+	return io.ReadAll(lr)
+}
+
+// verifyFilterFile checks the checksum and the signature of the filter
+// contents in the file at path.
+func verifyFilterFile(flt *filter, path string) (err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	err = verifyFilterChecksum(data)
+	if err != nil {
+		return err
+	}
+
+	if flt.SignatureKey == "" {
+		return nil
+	}
+
+	return verifyFilterSignature(flt, data)
+}
@@ -0,0 +1,247 @@
+package home
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyFilterPatch(t *testing.T) {
+	const (
+		data = "! Title: Test\n" +
+			"||example.org^\n" +
+			"||example.com^\n"
+		want = "! Title: Test\n" +
+			"||example.net^\n" +
+			"||example.com^\n"
+		wantSum = "1515ef397035a4c5eaf32a9045c73d550906e6ec"
+	)
+
+	testCases := []struct {
+		name       string
+		patch      string
+		list       string
+		wantErrMsg string
+	}{{
+		name:       "simple",
+		patch:      "d2 1\na2 1\n||example.net^\n",
+		list:       "",
+		wantErrMsg: "",
+	}, {
+		name: "batch",
+		patch: "diff name:other lines:1\n" +
+			"d1 1\n" +
+			"diff name:list checksum:" + wantSum + " lines:3\n" +
+			"d2 1\n" +
+			"a2 1\n" +
+			"||example.net^\n",
+		list:       "list",
+		wantErrMsg: "",
+	}, {
+		name:       "bad_checksum",
+		patch:      "diff checksum:0000 lines:3\nd2 1\na2 1\n||example.net^\n",
+		list:       "",
+		wantErrMsg: "checksum mismatch: got " + wantSum + ", want 0000",
+	}, {
+		name:       "bad_range",
+		patch:      "d4 1\n",
+		list:       "",
+		wantErrMsg: `diff line 1: bad range in "d4 1"`,
+	}, {
+		name:       "no_list",
+		patch:      "diff name:other lines:1\nd1 1\n",
+		list:       "list",
+		wantErrMsg: `no diff for "list"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patched, err := applyFilterPatch([]byte(data), []byte(tc.patch), tc.list)
+			if tc.wantErrMsg != "" {
+				testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			assert.Equal(t, want, string(patched))
+		})
+	}
+}
+
+func TestVerifyFilterChecksum(t *testing.T) {
+	const body = "[Adblock Plus 2.0]\n! Title: Test\n||example.org^\n"
+
+	testCases := []struct {
+		name       string
+		data       string
+		wantErrMsg string
+	}{{
+		name:       "no_checksum",
+		data:       body,
+		wantErrMsg: "",
+	}, {
+		name:       "valid",
+		data:       "[Adblock Plus 2.0]\n! Checksum: wzpDWHpsdwnjYOmG1BhrjA\n! Title: Test\n||example.org^\n",
+		wantErrMsg: "",
+	}, {
+		name:       "crlf",
+		data:       "[Adblock Plus 2.0]\r\n! Checksum: wzpDWHpsdwnjYOmG1BhrjA\r\n! Title: Test\r\n\r\n||example.org^\r\n",
+		wantErrMsg: "",
+	}, {
+		name: "invalid",
+		data: "[Adblock Plus 2.0]\n! Checksum: wzpDWHpsdwnjYOmG1BhrjA\n! Title: Test\n||example.com^\n",
+		wantErrMsg: "checksum mismatch: got " +
+			"UkmRDEFMpQESMcvQghYxUA, want wzpDWHpsdwnjYOmG1BhrjA",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyFilterChecksum([]byte(tc.data))
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestFiltering_fetchFilter(t *testing.T) {
+	const (
+		etag    = `"v1"`
+		content = "! Title: Test\n" +
+			"! Diff-Path: patches/1.patch\n" +
+			"||example.org^\n"
+	)
+
+	var fullReqs, patchReqs int
+	patch := ""
+	mux := http.NewServeMux()
+	mux.HandleFunc("/filter.txt", func(w http.ResponseWriter, r *http.Request) {
+		fullReqs++
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte(content))
+	})
+	mux.HandleFunc("/patches/1.patch", func(w http.ResponseWriter, _ *http.Request) {
+		patchReqs++
+		_, _ = w.Write([]byte(patch))
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	Context = homeContext{
+		workDir: t.TempDir(),
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+	Context.filters.Init()
+
+	f := &filter{
+		URL: srv.URL + "/filter.txt",
+	}
+
+	t.Run("download", func(t *testing.T) {
+		ok, err := Context.filters.update(f)
+		require.NoError(t, err)
+
+		assert.True(t, ok)
+		assert.Equal(t, 1, f.RulesCount)
+		assert.Equal(t, fetchStatusOK, f.fetch.status)
+		assert.Equal(t, etag, f.fetch.etag)
+		assert.Equal(t, 1, fullReqs)
+	})
+
+	t.Run("no_patch", func(t *testing.T) {
+		ok, err := Context.filters.update(f)
+		require.NoError(t, err)
+
+		assert.False(t, ok)
+		assert.Equal(t, fetchStatusNotModified, f.fetch.status)
+		assert.Equal(t, 1, fullReqs)
+		assert.Equal(t, 1, patchReqs)
+	})
+
+	t.Run("patched", func(t *testing.T) {
+		patch = "d2 1\na3 1\n||example.com^\n"
+
+		ok, err := Context.filters.update(f)
+		require.NoError(t, err)
+
+		assert.True(t, ok)
+		assert.Equal(t, 2, f.RulesCount)
+		assert.Equal(t, fetchStatusPatched, f.fetch.status)
+		assert.Equal(t, 1, fullReqs)
+		assert.Equal(t, 2, patchReqs)
+	})
+
+	t.Run("not_modified", func(t *testing.T) {
+		// Make the filter fully downloaded.
+		f.fetch.lastFull = time.Time{}
+
+		ok, err := Context.filters.update(f)
+		require.NoError(t, err)
+
+		assert.False(t, ok)
+		assert.Equal(t, fetchStatusNotModified, f.fetch.status)
+		assert.Equal(t, 2, fullReqs)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		f.fetch.lastFull = time.Time{}
+		f.URL = srv.URL + "/unknown.txt"
+
+		ok, err := Context.filters.update(f)
+		require.Error(t, err)
+
+		assert.False(t, ok)
+		assert.Equal(t, fetchStatusError, f.fetch.status)
+		assert.Equal(t, "got status code != 200: 404", f.fetch.err)
+		assert.Empty(t, f.fetch.etag)
+	})
+}
+
+func TestFetchPatch_host(t *testing.T) {
+	listURL, err := url.Parse("https://lists.example/filter.txt")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		diffPath string
+		wantErr  string
+	}{{
+		name:     "other_host",
+		diffPath: "https://evil.example/1.patch",
+		wantErr: `diff path "https://evil.example/1.patch": ` +
+			`patch url must have the same scheme and host as the list`,
+	}, {
+		name:     "other_scheme",
+		diffPath: "http://lists.example/1.patch",
+		wantErr: `diff path "http://lists.example/1.patch": ` +
+			`patch url must have the same scheme and host as the list`,
+	}, {
+		name:     "network_path",
+		diffPath: "//evil.example/1.patch#list",
+		wantErr: `diff path "//evil.example/1.patch": ` +
+			`patch url must have the same scheme and host as the list`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patch, _, pErr := fetchPatch(listURL, tc.diffPath)
+			testutil.AssertErrorMsg(t, tc.wantErr, pErr)
+			assert.Nil(t, patch)
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package home
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/google/renameio/maybe"
+)
+
+const (
+	// hitsFileName is the name of the file with the hit counters of the
+	// filters' rules.  It's under filterDir.
+	hitsFileName = "hits.json"
+
+	// historyDir is the directory with the previous versions of the filters
+	// and their updates history.  It's under filterDir.
+	historyDir = "history"
+
+	// historyFileName is the name of the file with the filters' updates
+	// history.  It's under historyDir.
+	historyFileName = "history.json"
+
+	// maxFilterUpdates is the maximum number of the stored updates of a
+	// single filter.
+	maxFilterUpdates = 10
+
+	// maxUpdateRules is the maximum number of the added or removed rules
+	// stored in a single update.  The numbers of the added and removed rules
+	// are recorded regardless.
+	maxUpdateRules = 100
+)
+
+// filterUpdate is a single update of the contents of a filter.
+type filterUpdate struct {
+	// Time is the time of the update.
+	Time time.Time `json:"time"`
+
+	// URL is the URL or the file path the filter was updated from.
+	URL string `json:"url"`
+
+	// Added are the rules added by the update, no more than maxUpdateRules.
+	Added []string `json:"added"`
+
+	// Removed are the rules removed by the update, no more than
+	// maxUpdateRules.
+	Removed []string `json:"removed"`
+
+	// ID is the ID of the filter.
+	ID int64 `json:"id"`
+
+	// RulesCountBefore is the number of unique rules before the update.
+	RulesCountBefore int `json:"rules_count_before"`
+
+	// RulesCountAfter is the number of unique rules after the update.
+	RulesCountAfter int `json:"rules_count_after"`
+
+	// AddedCount is the total number of the added rules.
+	AddedCount int `json:"added_count"`
+
+	// RemovedCount is the total number of the removed rules.
+	RemovedCount int `json:"removed_count"`
+}
+
+// filterHistory stores the previous versions of the filters and the history of
+// their updates.
+type filterHistory struct {
+	// updates are the updates sorted by time in descending order.
+	updates []*filterUpdate
+
+	// dir is the directory of the history.
+	dir string
+
+	// mu protects updates and the files in dir.
+	mu sync.Mutex
+}
+
+// newFilterHistory returns a new filters' history stored in dir.
+func newFilterHistory(dir string) (h *filterHistory) {
+	h = &filterHistory{
+		dir: dir,
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, historyFileName))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Error("filters history: reading: %s", err)
+		}
+
+		return h
+	}
+
+	err = json.Unmarshal(b, &h.updates)
+	if err != nil {
+		log.Error("filters history: decoding: %s", err)
+
+		h.updates = nil
+	}
+
+	return h
+}
+
+// prevPath returns the path to the previous version of the filter with the
+// given ID.
+func (h *filterHistory) prevPath(id int64) (p string) {
+	return filepath.Join(h.dir, strconv.FormatInt(id, 10)+".txt")
+}
+
+// record compares the current contents of flt with the new ones in the file at
+// newPath, records the difference, and keeps the current contents as the
+// previous version of flt.
+func (h *filterHistory) record(flt *filter, newPath string) (err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	oldRules, err := readRulesSet(flt.Path())
+	if err != nil {
+		return fmt.Errorf("reading current rules: %w", err)
+	}
+
+	newRules, err := readRulesSet(newPath)
+	if err != nil {
+		return fmt.Errorf("reading new rules: %w", err)
+	}
+
+	added := rulesDiff(newRules, oldRules)
+	removed := rulesDiff(oldRules, newRules)
+	upd := &filterUpdate{
+		Time:             time.Now(),
+		URL:              flt.URL,
+		Added:            truncateRules(added),
+		Removed:          truncateRules(removed),
+		ID:               flt.ID,
+		RulesCountBefore: oldRules.Len(),
+		RulesCountAfter:  newRules.Len(),
+		AddedCount:       len(added),
+		RemovedCount:     len(removed),
+	}
+
+	err = os.MkdirAll(h.dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating history dir: %w", err)
+	}
+
+	err = keepPrevVersion(flt.Path(), h.prevPath(flt.ID))
+	if err != nil {
+		return fmt.Errorf("keeping previous version: %w", err)
+	}
+
+	h.updates = append([]*filterUpdate{upd}, h.updates...)
+	h.trimLocked(flt.ID)
+
+	return h.writeLocked()
+}
+
+// keepPrevVersion stores the current contents of the filter file at path as
+// the previous version at prevPath.  The file at path itself is left in place,
+// since it's only replaced with the new contents after the update has been
+// recorded.  The file is hard-linked if possible and copied otherwise.
+func keepPrevVersion(path, prevPath string) (err error) {
+	err = os.Remove(prevPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err = os.Link(path, prevPath)
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	log.Debug("filter history: linking %q: %s; copying", path, err)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return maybe.WriteFile(prevPath, data, 0o644)
+}
+
+// remove removes the history and the previous version of the filter with the
+// given ID.
+func (h *filterHistory) remove(id int64) (err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err = os.Remove(h.prevPath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing previous version: %w", err)
+	}
+
+	var updates []*filterUpdate
+	for _, upd := range h.updates {
+		if upd.ID != id {
+			updates = append(updates, upd)
+		}
+	}
+
+	if len(updates) == len(h.updates) {
+		return nil
+	}
+
+	h.updates = updates
+
+	return h.writeLocked()
+}
+
+// list returns the updates of the filter with the given ID or of all filters if
+// id is zero.
+func (h *filterHistory) list(id int64) (updates []*filterUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	updates = []*filterUpdate{}
+	for _, upd := range h.updates {
+		if id == 0 || upd.ID == id {
+			updates = append(updates, upd)
+		}
+	}
+
+	return updates
+}
+
+// trimLocked removes the oldest updates of the filter with the given ID above
+// maxFilterUpdates.  h.mu is expected to be locked.
+func (h *filterHistory) trimLocked(id int64) {
+	n := 0
+	updates := h.updates[:0]
+	for _, upd := range h.updates {
+		if upd.ID == id {
+			n++
+			if n > maxFilterUpdates {
+				continue
+			}
+		}
+
+		updates = append(updates, upd)
+	}
+
+	h.updates = updates
+}
+
+// writeLocked writes the updates to the history file.  h.mu is expected to be
+// locked.
+func (h *filterHistory) writeLocked() (err error) {
+	b, err := json.Marshal(h.updates)
+	if err != nil {
+		return fmt.Errorf("encoding history: %w", err)
+	}
+
+	err = os.MkdirAll(h.dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating history dir: %w", err)
+	}
+
+	err = maybe.WriteFile(filepath.Join(h.dir, historyFileName), b, 0o644)
+	if err != nil {
+		return fmt.Errorf("writing history: %w", err)
+	}
+
+	return nil
+}
+
+// readRulesSet returns the rules from the filter file at path.  The comments
+// and the empty lines are skipped.  The set is empty if there is no file.
+func readRulesSet(path string) (rules *stringutil.Set, err error) {
+	rules = stringutil.NewSet()
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return rules, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	r := bufio.NewReader(f)
+	for {
+		var line string
+		line, err = r.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line != "" && line[0] != '!' && line[0] != '#' {
+			rules.Add(line)
+		}
+
+		if err == io.EOF {
+			return rules, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// rulesDiff returns the sorted rules from a which aren't in b.
+func rulesDiff(a, b *stringutil.Set) (diff []string) {
+	a.Range(func(rule string) (cont bool) {
+		if !b.Has(rule) {
+			diff = append(diff, rule)
+		}
+
+		return true
+	})
+
+	sort.Strings(diff)
+
+	return diff
+}
+
+// truncateRules returns the first maxUpdateRules rules.
+func truncateRules(rules []string) (truncated []string) {
+	if len(rules) > maxUpdateRules {
+		return rules[:maxUpdateRules]
+	}
+
+	return rules
+}
+
+// filterHistoryJSON is the response for getting the filters' updates history.
+type filterHistoryJSON struct {
+	Updates []*filterUpdate `json:"updates"`
+}
+
+// handleFilteringHistory is the handler for the GET /control/filtering/history
+// HTTP API.  The optional "id" query parameter selects a single filter.
+func (f *Filtering) handleFilteringHistory(w http.ResponseWriter, r *http.Request) {
+	var id int64
+	if idStr := r.URL.Query().Get("id"); idStr != "" {
+		var err error
+		id, err = strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "bad id: %s", err)
+
+			return
+		}
+	}
+
+	resp := &filterHistoryJSON{
+		Updates: f.history.list(id),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "json encode: %s", err)
+	}
+}
@@ -0,0 +1,38 @@
+package home
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepPrevVersion(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "1.txt")
+	prevPath := filepath.Join(dir, "1.prev.txt")
+
+	err := os.WriteFile(path, []byte("||example.org^\n"), 0o644)
+	require.NoError(t, err)
+
+	err = os.WriteFile(prevPath, []byte("||old.example^\n"), 0o644)
+	require.NoError(t, err)
+
+	err = keepPrevVersion(path, prevPath)
+	require.NoError(t, err)
+
+	// The current file must stay in place until the new one replaces it.
+	assert.FileExists(t, path)
+
+	data, err := os.ReadFile(prevPath)
+	require.NoError(t, err)
+
+	assert.Equal(t, "||example.org^\n", string(data))
+
+	t.Run("no_file", func(t *testing.T) {
+		err = keepPrevVersion(filepath.Join(dir, "2.txt"), filepath.Join(dir, "2.prev.txt"))
+		assert.NoError(t, err)
+	})
+}
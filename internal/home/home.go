@@ -21,6 +21,7 @@ import (
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/AdGuardHome/internal/blockpage"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
@@ -56,6 +57,7 @@ type homeContext struct {
 	filters    Filtering            // DNS filtering module
 	web        *Web                 // Web (HTTP, HTTPS) module
 	tls        *TLSMod              // TLS module
+	blockPage  *blockpage.Server    // block page module
 	// etcHosts is an IP-hostname pairs set taken from system configuration
 	// (e.g. /etc/hosts) files.
 	etcHosts *aghnet.HostsContainer
@@ -292,7 +294,18 @@ func setupConfig(args options) (err error) {
 		}
 	}
 
-	Context.clients.Init(config.Clients, Context.dhcpServer, Context.etcHosts)
+	// Set the blocked services catalogue before the clients, since those
+	// drop the unknown services.
+	if err = initServicesCatalogue(); err != nil {
+		return err
+	}
+
+	Context.clients.Init(
+		config.Clients,
+		config.ClientGroups,
+		Context.dhcpServer,
+		Context.etcHosts,
+	)
 
 	if args.bindPort != 0 {
 		pm := portsMap{}
@@ -0,0 +1,154 @@
+package home
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// weekdays are the short names of the days of week used in the schedules.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// scheduleRange is a range of time within the days of week.
+type scheduleRange struct {
+	// Days are the short names of the days of week, for example "mon".
+	Days []string `yaml:"days" json:"days"`
+
+	// Start is the start of the range within a day in the "15:04" format.
+	Start string `yaml:"start" json:"start"`
+
+	// End is the exclusive end of the range within a day in the "15:04"
+	// format.  "24:00" is the end of the day.
+	End string `yaml:"end" json:"end"`
+
+	// days are the parsed Days indexed by time.Weekday.
+	days [7]bool
+
+	// start and end are the parsed Start and End.
+	start time.Duration
+	end   time.Duration
+}
+
+// weeklySchedule is a set of the time ranges repeated every week.
+type weeklySchedule struct {
+	// TimeZone is the IANA name of the time zone of the schedule.  The local
+	// time zone is used if it's empty.
+	TimeZone string `yaml:"time_zone" json:"time_zone"`
+
+	// Ranges are the time ranges of the schedule.
+	Ranges []*scheduleRange `yaml:"ranges" json:"ranges"`
+
+	// loc is the parsed TimeZone.
+	loc *time.Location
+}
+
+// parseDayTime parses the time within a day in the "15:04" format.
+func parseDayTime(s string) (d time.Duration, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+
+	h, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("bad hours in %q: %w", s, err)
+	}
+
+	m, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("bad minutes in %q: %w", s, err)
+	}
+
+	d = time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	if h < 0 || m < 0 || m > 59 || d > 24*time.Hour {
+		return 0, fmt.Errorf("time %q is out of range", s)
+	}
+
+	return d, nil
+}
+
+// validate checks the schedule and prepares it for use.
+func (r *scheduleRange) validate() (err error) {
+	if len(r.Days) == 0 {
+		return errors.Error("no days")
+	}
+
+	r.days = [7]bool{}
+	for _, name := range r.Days {
+		wd, ok := weekdays[name]
+		if !ok {
+			return fmt.Errorf("bad day %q", name)
+		}
+
+		r.days[wd] = true
+	}
+
+	r.start, err = parseDayTime(r.Start)
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+
+	r.end, err = parseDayTime(r.End)
+	if err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+
+	if r.start >= r.end {
+		return fmt.Errorf("start %s is not before end %s", r.Start, r.End)
+	}
+
+	return nil
+}
+
+// validate checks the schedule and prepares it for use.
+func (s *weeklySchedule) validate() (err error) {
+	s.loc = time.Local
+	if s.TimeZone != "" {
+		s.loc, err = time.LoadLocation(s.TimeZone)
+		if err != nil {
+			return fmt.Errorf("bad time zone: %w", err)
+		}
+	}
+
+	for i, r := range s.Ranges {
+		if r == nil {
+			return fmt.Errorf("range at index %d: no range", i)
+		}
+
+		err = r.validate()
+		if err != nil {
+			return fmt.Errorf("range at index %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// contains returns true if t is within any of the ranges of s.  s must be
+// validated.
+func (s *weeklySchedule) contains(t time.Time) (ok bool) {
+	t = t.In(s.loc)
+	h, m, sec := t.Clock()
+	sinceMidnight := time.Duration(h)*time.Hour +
+		time.Duration(m)*time.Minute +
+		time.Duration(sec)*time.Second
+	wd := t.Weekday()
+	for _, r := range s.Ranges {
+		if r.days[wd] && sinceMidnight >= r.start && sinceMidnight < r.end {
+			return true
+		}
+	}
+
+	return false
+}
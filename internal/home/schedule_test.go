@@ -0,0 +1,91 @@
+package home
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWeeklySchedule(t *testing.T) {
+	s := &weeklySchedule{
+		TimeZone: "UTC",
+		Ranges: []*scheduleRange{{
+			Days:  []string{"sat", "sun"},
+			Start: "00:00",
+			End:   "24:00",
+		}, {
+			Days:  []string{"mon", "tue", "wed", "thu", "fri"},
+			Start: "18:30",
+			End:   "22:00",
+		}},
+	}
+	require.NoError(t, s.validate())
+
+	testCases := []struct {
+		time time.Time
+		name string
+		want assert.BoolAssertionFunc
+	}{{
+		// Saturday.
+		time: time.Date(2021, 11, 6, 23, 59, 59, 0, time.UTC),
+		name: "weekend",
+		want: assert.True,
+	}, {
+		// Monday.
+		time: time.Date(2021, 11, 1, 18, 30, 0, 0, time.UTC),
+		name: "weekday_start",
+		want: assert.True,
+	}, {
+		time: time.Date(2021, 11, 1, 22, 0, 0, 0, time.UTC),
+		name: "weekday_end",
+		want: assert.False,
+	}, {
+		// 18:00 in UTC.
+		time: time.Date(2021, 11, 1, 21, 0, 0, 0, time.FixedZone("UTC+3", 3*60*60)),
+		name: "other_zone",
+		want: assert.False,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.want(t, s.contains(tc.time))
+		})
+	}
+}
+
+func TestWeeklySchedule_validate(t *testing.T) {
+	testCases := []struct {
+		rng        *scheduleRange
+		name       string
+		wantErrMsg string
+	}{{
+		rng:        &scheduleRange{Days: []string{"mon"}, Start: "08:00", End: "09:00"},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		rng:        &scheduleRange{Days: []string{"monday"}, Start: "08:00", End: "09:00"},
+		name:       "bad_day",
+		wantErrMsg: `range at index 0: bad day "monday"`,
+	}, {
+		rng:        &scheduleRange{Days: []string{"mon"}, Start: "08:60", End: "09:00"},
+		name:       "bad_minutes",
+		wantErrMsg: `range at index 0: start: time "08:60" is out of range`,
+	}, {
+		rng:        &scheduleRange{Days: []string{"mon"}, Start: "09:00", End: "08:00"},
+		name:       "reversed",
+		wantErrMsg: "range at index 0: start 09:00 is not before end 08:00",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &weeklySchedule{
+				Ranges: []*scheduleRange{tc.rng},
+			}
+
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, s.validate())
+		})
+	}
+}
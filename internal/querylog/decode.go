@@ -129,6 +129,16 @@ var logEntryHandlers = map[string]logEntryHandler{
 
 		return nil
 	},
+	"DNSSEC": func(t json.Token, ent *logEntry) error {
+		v, ok := t.(string)
+		if !ok {
+			return nil
+		}
+
+		ent.DNSSECStatus = v
+
+		return nil
+	},
 	"Upstream": func(t json.Token, ent *logEntry) error {
 		v, ok := t.(string)
 		if !ok {
@@ -224,6 +234,26 @@ var resultHandlers = map[string]logEntryHandler{
 
 		return nil
 	},
+	"ThreatFeed": func(t json.Token, ent *logEntry) error {
+		s, ok := t.(string)
+		if !ok {
+			return nil
+		}
+
+		ent.Result.ThreatFeed = s
+
+		return nil
+	},
+	"AnswerPolicy": func(t json.Token, ent *logEntry) error {
+		s, ok := t.(string)
+		if !ok {
+			return nil
+		}
+
+		ent.Result.AnswerPolicy = s
+
+		return nil
+	},
 	"CanonName": func(t json.Token, ent *logEntry) error {
 		s, ok := t.(string)
 		if !ok {
@@ -89,6 +89,14 @@ func (l *queryLog) entryToJSON(entry *logEntry, anonFunc aghnet.IPMutFunc) (json
 		jsonEntry["service_name"] = entry.Result.ServiceName
 	}
 
+	if len(entry.Result.ThreatFeed) != 0 {
+		jsonEntry["threat_feed"] = entry.Result.ThreatFeed
+	}
+
+	if len(entry.Result.AnswerPolicy) != 0 {
+		jsonEntry["answer_policy"] = entry.Result.AnswerPolicy
+	}
+
 	l.setMsgData(entry, jsonEntry)
 	l.setOrigAns(entry, jsonEntry)
 
@@ -112,6 +120,9 @@ func (l *queryLog) setMsgData(entry *logEntry, jsonEntry jobject) {
 	// Old query logs may still keep AD flag value in the message.  Try to get
 	// it from there as well.
 	jsonEntry["answer_dnssec"] = entry.AuthenticatedData || msg.AuthenticatedData
+	if entry.DNSSECStatus != "" {
+		jsonEntry["dnssec_status"] = entry.DNSSECStatus
+	}
 
 	if a := answerToMap(msg); a != nil {
 		jsonEntry["answer"] = a
@@ -96,6 +96,9 @@ type logEntry struct {
 
 	Cached            bool `json:",omitempty"`
 	AuthenticatedData bool `json:"AD,omitempty"`
+
+	// DNSSECStatus is the result of the local DNSSEC validation, if any.
+	DNSSECStatus string `json:"DNSSEC,omitempty"`
 }
 
 func (l *queryLog) Start() {
@@ -187,6 +190,7 @@ func (l *queryLog) Add(params *AddParams) {
 
 		Cached:            params.Cached,
 		AuthenticatedData: params.AuthenticatedData,
+		DNSSECStatus:      params.DNSSECStatus,
 	}
 
 	if params.Answer != nil {
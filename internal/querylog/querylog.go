@@ -104,6 +104,11 @@ type AddParams struct {
 
 	// AuthenticatedData shows if the response had the AD bit set.
 	AuthenticatedData bool
+
+	// DNSSECStatus is the result of the local DNSSEC validation of the
+	// response: "secure", "insecure", or "bogus".  It's empty if the response
+	// hasn't been validated.
+	DNSSECStatus string
 }
 
 // validate returns an error if the parameters aren't valid.
@@ -28,6 +28,8 @@ const (
 	filteringStatusBlockedService      = "blocked_services"     // blocked
 	filteringStatusBlockedSafebrowsing = "blocked_safebrowsing" // blocked by safebrowsing
 	filteringStatusBlockedParental     = "blocked_parental"     // blocked by parental control
+	filteringStatusBlockedThreatFeed   = "blocked_threat_feed"  // blocked by threat feeds
+	filteringStatusBlockedAnswer       = "blocked_answer"       // blocked by answer policies
 	filteringStatusWhitelisted         = "whitelisted"          // whitelisted
 	filteringStatusRewritten           = "rewritten"            // all kinds of rewrites
 	filteringStatusSafeSearch          = "safe_search"          // enforced safe search
@@ -38,6 +40,7 @@ const (
 var filteringStatusValues = []string{
 	filteringStatusAll, filteringStatusFiltered, filteringStatusBlocked,
 	filteringStatusBlockedService, filteringStatusBlockedSafebrowsing, filteringStatusBlockedParental,
+	filteringStatusBlockedThreatFeed, filteringStatusBlockedAnswer,
 	filteringStatusWhitelisted, filteringStatusRewritten, filteringStatusSafeSearch,
 	filteringStatusProcessed,
 }
@@ -182,6 +185,12 @@ func (c *searchCriterion) ctFilteringStatusCase(res filtering.Result) bool {
 	case filteringStatusBlockedSafebrowsing:
 		return res.IsFiltered && res.Reason == filtering.FilteredSafeBrowsing
 
+	case filteringStatusBlockedThreatFeed:
+		return res.IsFiltered && res.Reason == filtering.FilteredThreatFeed
+
+	case filteringStatusBlockedAnswer:
+		return res.IsFiltered && res.Reason == filtering.FilteredAnswerPolicy
+
 	case filteringStatusWhitelisted:
 		return res.Reason == filtering.NotFilteredAllowList
 
@@ -12,7 +12,8 @@ import (
 )
 
 // topAddrs is an alias for the types of the TopFoo fields of statsResponse.
-// The key is either a client's address or a requested address.
+// The key is either a client's address, a requested address, or a threat
+// feed's name.
 type topAddrs = map[string]uint64
 
 // statsResponse is a response for getting statistics.
@@ -24,6 +25,7 @@ type statsResponse struct {
 	NumReplacedSafebrowsing uint64 `json:"num_replaced_safebrowsing"`
 	NumReplacedSafesearch   uint64 `json:"num_replaced_safesearch"`
 	NumReplacedParental     uint64 `json:"num_replaced_parental"`
+	NumReplacedThreatFeeds  uint64 `json:"num_replaced_threat_feeds"`
 
 	AvgProcessingTime float64 `json:"avg_processing_time"`
 
@@ -31,11 +33,14 @@ type statsResponse struct {
 	TopClients []topAddrs `json:"top_clients"`
 	TopBlocked []topAddrs `json:"top_blocked_domains"`
 
+	TopThreatFeeds []topAddrs `json:"top_threat_feeds"`
+
 	DNSQueries []uint64 `json:"dns_queries"`
 
 	BlockedFiltering     []uint64 `json:"blocked_filtering"`
 	ReplacedSafebrowsing []uint64 `json:"replaced_safebrowsing"`
 	ReplacedParental     []uint64 `json:"replaced_parental"`
+	ReplacedThreatFeeds  []uint64 `json:"replaced_threat_feeds"`
 }
 
 // handleStats is a handler for getting statistics.
@@ -51,10 +56,13 @@ func (s *statsCtx) handleStats(w http.ResponseWriter, r *http.Request) {
 			TopClients: []topAddrs{},
 			TopQueried: []topAddrs{},
 
+			TopThreatFeeds: []topAddrs{},
+
 			BlockedFiltering:     []uint64{},
 			DNSQueries:           []uint64{},
 			ReplacedParental:     []uint64{},
 			ReplacedSafebrowsing: []uint64{},
+			ReplacedThreatFeeds:  []uint64{},
 		}
 	} else {
 		var ok bool
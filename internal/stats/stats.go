@@ -72,6 +72,7 @@ const (
 	RSafeBrowsing
 	RSafeSearch
 	RParental
+	RThreatFeed
 	rLast
 )
 
@@ -83,6 +84,11 @@ type Entry struct {
 	Client string
 
 	Domain string
+
+	// ThreatFeed is the name of the threat feed which has blocked the
+	// request.  It is empty unless Result is RThreatFeed.
+	ThreatFeed string
+
 	Result Result
 	Time   uint32 // processing time (msec)
 }
@@ -161,3 +161,80 @@ func TestStatsCollector(t *testing.T) {
 		}
 	})
 }
+
+func TestDeserialize(t *testing.T) {
+	testCases := []struct {
+		name    string
+		nResult []uint64
+	}{{
+		name:    "shorter",
+		nResult: []uint64{0, 1},
+	}, {
+		name:    "same",
+		nResult: make([]uint64, rLast),
+	}, {
+		name:    "longer",
+		nResult: make([]uint64, rLast+2),
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := &unit{nResult: make([]uint64, rLast)}
+			udb := &unitDB{NResult: tc.nResult}
+
+			require.NotPanics(t, func() { deserialize(u, udb) })
+
+			assert.Len(t, u.nResult, int(rLast))
+			assert.Equal(t, tc.nResult[1], u.nResult[1])
+		})
+	}
+}
+
+func TestStats_threatFeeds(t *testing.T) {
+	const id = 1000
+
+	conf := Config{
+		Filename:  "./stats.db",
+		LimitDays: 1,
+		UnitID:    func() (unitID uint32) { return id },
+	}
+	s, err := createObject(conf)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		s.Close()
+
+		return os.Remove(conf.Filename)
+	})
+
+	// Store a unit the way the previous versions did, without the threat
+	// feeds result.
+	tx := s.beginTxn(true)
+	require.NotNil(t, tx)
+
+	require.True(t, s.flushUnitToDB(tx, id-1, &unitDB{
+		NTotal:  1,
+		NResult: []uint64{0, 0, 1, 0, 0, 0},
+	}))
+	s.commitTxn(tx)
+
+	s.Update(Entry{
+		Domain:     "malware.example",
+		Client:     "127.0.0.1",
+		ThreatFeed: "urlhaus",
+		Result:     RThreatFeed,
+		Time:       123456,
+	})
+
+	d, ok := s.getData()
+	require.True(t, ok)
+
+	assert.EqualValues(t, 2, d.NumDNSQueries)
+	assert.EqualValues(t, 1, d.NumBlockedFiltering)
+	assert.EqualValues(t, 1, d.NumReplacedThreatFeeds)
+
+	require.Len(t, d.ReplacedThreatFeeds, 24)
+	assert.EqualValues(t, 1, d.ReplacedThreatFeeds[23])
+
+	require.Len(t, d.TopThreatFeeds, 1)
+	assert.EqualValues(t, 1, d.TopThreatFeeds[0]["urlhaus"])
+}
@@ -47,6 +47,7 @@ type unit struct {
 	domains        map[string]uint64 // number of requests per domain
 	blockedDomains map[string]uint64 // number of blocked requests per domain
 	clients        map[string]uint64 // number of requests per client
+	threatFeeds    map[string]uint64 // number of blocked requests per threat feed
 }
 
 // name-count pair
@@ -63,6 +64,7 @@ type unitDB struct {
 	Domains        []countPair
 	BlockedDomains []countPair
 	Clients        []countPair
+	ThreatFeeds    []countPair
 
 	TimeAvg uint32 // usec
 }
@@ -204,6 +206,7 @@ func (s *statsCtx) initUnit(u *unit, id uint32) {
 	u.domains = make(map[string]uint64)
 	u.blockedDomains = make(map[string]uint64)
 	u.clients = make(map[string]uint64)
+	u.threatFeeds = make(map[string]uint64)
 }
 
 // Open a DB transaction
@@ -361,6 +364,7 @@ func serialize(u *unit) *unitDB {
 	udb.Domains = convertMapToSlice(u.domains, maxDomains)
 	udb.BlockedDomains = convertMapToSlice(u.blockedDomains, maxDomains)
 	udb.Clients = convertMapToSlice(u.clients, maxClients)
+	udb.ThreatFeeds = convertMapToSlice(u.threatFeeds, len(u.threatFeeds))
 
 	return &udb
 }
@@ -369,7 +373,7 @@ func deserialize(u *unit, udb *unitDB) {
 	u.nTotal = udb.NTotal
 
 	n := len(udb.NResult)
-	if n < len(u.nResult) {
+	if n > len(u.nResult) {
 		n = len(u.nResult) // n = min(len(udb.NResult), len(u.nResult))
 	}
 	for i := 1; i < n; i++ {
@@ -379,6 +383,7 @@ func deserialize(u *unit, udb *unitDB) {
 	u.domains = convertSliceToMap(udb.Domains)
 	u.blockedDomains = convertSliceToMap(udb.BlockedDomains)
 	u.clients = convertSliceToMap(udb.Clients)
+	u.threatFeeds = convertSliceToMap(udb.ThreatFeeds)
 	u.timeSum = uint64(udb.TimeAvg) * u.nTotal
 }
 
@@ -426,6 +431,11 @@ func (s *statsCtx) loadUnitFromDB(tx *bolt.Tx, id uint32) *unitDB {
 		return nil
 	}
 
+	// The units stored by the previous versions may have less results.
+	if n := len(udb.NResult); n < int(rLast) {
+		udb.NResult = append(udb.NResult, make([]uint64, int(rLast)-n)...)
+	}
+
 	return &udb
 }
 
@@ -531,6 +541,10 @@ func (s *statsCtx) Update(e Entry) {
 		u.blockedDomains[e.Domain]++
 	}
 
+	if e.Result == RThreatFeed && e.ThreatFeed != "" {
+		u.threatFeeds[e.ThreatFeed]++
+	}
+
 	u.clients[clientID]++
 	u.timeSum += uint64(e.Time)
 	u.nTotal++
@@ -630,12 +644,14 @@ func topsCollector(units []*unitDB, max int, pg pairsGetter) []map[string]uint64
   * blocked/time-unit
   * safebrowsing-blocked/time-unit
   * parental-blocked/time-unit
+  * threat-feed-blocked/time-unit
   If time-unit is an hour, just add values from each unit to an array.
   If time-unit is a day, aggregate per-hour data into days.
  * top counters:
   * queries/domain
   * queries/blocked-domain
   * queries/client
+  * queries/threat-feed
   To get these values we first sum up data for all units into a single map.
   Then we get the pairs with the highest numbers (the values are sorted in descending order)
  * total counters:
@@ -644,6 +660,7 @@ func topsCollector(units []*unitDB, max int, pg pairsGetter) []map[string]uint64
   * safebrowsing-blocked
   * safesearch-blocked
   * parental-blocked
+  * threat-feed-blocked
   These values are just the sum of data for all units.
 */
 func (s *statsCtx) getData() (statsResponse, bool) {
@@ -669,9 +686,11 @@ func (s *statsCtx) getData() (statsResponse, bool) {
 		BlockedFiltering:     statsCollector(units, firstID, timeUnit, func(u *unitDB) (num uint64) { return u.NResult[RFiltered] }),
 		ReplacedSafebrowsing: statsCollector(units, firstID, timeUnit, func(u *unitDB) (num uint64) { return u.NResult[RSafeBrowsing] }),
 		ReplacedParental:     statsCollector(units, firstID, timeUnit, func(u *unitDB) (num uint64) { return u.NResult[RParental] }),
+		ReplacedThreatFeeds:  statsCollector(units, firstID, timeUnit, func(u *unitDB) (num uint64) { return u.NResult[RThreatFeed] }),
 		TopQueried:           topsCollector(units, maxDomains, func(u *unitDB) (pairs []countPair) { return u.Domains }),
 		TopBlocked:           topsCollector(units, maxDomains, func(u *unitDB) (pairs []countPair) { return u.BlockedDomains }),
 		TopClients:           topsCollector(units, maxClients, func(u *unitDB) (pairs []countPair) { return u.Clients }),
+		TopThreatFeeds:       topsCollector(units, maxDomains, func(u *unitDB) (pairs []countPair) { return u.ThreatFeeds }),
 	}
 
 	// Total counters:
@@ -689,6 +708,7 @@ func (s *statsCtx) getData() (statsResponse, bool) {
 		sum.NResult[RSafeBrowsing] += u.NResult[RSafeBrowsing]
 		sum.NResult[RSafeSearch] += u.NResult[RSafeSearch]
 		sum.NResult[RParental] += u.NResult[RParental]
+		sum.NResult[RThreatFeed] += u.NResult[RThreatFeed]
 	}
 
 	data.NumDNSQueries = sum.NTotal
@@ -696,6 +716,7 @@ func (s *statsCtx) getData() (statsResponse, bool) {
 	data.NumReplacedSafebrowsing = sum.NResult[RSafeBrowsing]
 	data.NumReplacedSafesearch = sum.NResult[RSafeSearch]
 	data.NumReplacedParental = sum.NResult[RParental]
+	data.NumReplacedThreatFeeds = sum.NResult[RThreatFeed]
 
 	if timeN != 0 {
 		data.AvgProcessingTime = float64(sum.TimeAvg/uint32(timeN)) / 1000000